@@ -0,0 +1,217 @@
+// Package apierror defines the API's single error envelope, an RFC 7807
+// problem+json document, and the Fiber error handler that renders it.
+package apierror
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// ContentType is the media type of every error response.
+const ContentType = "application/problem+json"
+
+// Error is an error that should reach the client. Message is shown as the
+// problem detail; the cause, if any, is only logged.
+type Error struct {
+	Status  int
+	Code    string
+	Message string
+	Fields  map[string]string      // Per-field validation messages
+	Extra   map[string]interface{} // Extension members, such as the current copy on 412
+	cause   error
+}
+
+func (e *Error) Error() string {
+	if e.cause != nil {
+		return e.Message + ": " + e.cause.Error()
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.cause
+}
+
+// With adds an extension member to the problem document.
+func (e *Error) With(key string, value interface{}) *Error {
+	if e.Extra == nil {
+		e.Extra = map[string]interface{}{}
+	}
+	e.Extra[key] = value
+	return e
+}
+
+// WithCause records the underlying error for the server log.
+func (e *Error) WithCause(err error) *Error {
+	e.cause = err
+	return e
+}
+
+// New returns an error with an explicit status and machine-readable code.
+func New(status int, code, message string) *Error {
+	return &Error{Status: status, Code: code, Message: message}
+}
+
+func BadRequest(message string) *Error {
+	return New(fiber.StatusBadRequest, "bad_request", message)
+}
+
+// InvalidBody is returned when the request body is not the expected JSON.
+func InvalidBody() *Error {
+	return New(fiber.StatusBadRequest, "invalid_body", "Invalid request body")
+}
+
+func Unauthorized(message string) *Error {
+	return New(fiber.StatusUnauthorized, "unauthorized", message)
+}
+
+func Forbidden(message string) *Error {
+	return New(fiber.StatusForbidden, "forbidden", message)
+}
+
+func NotFound(message string) *Error {
+	return New(fiber.StatusNotFound, "not_found", message)
+}
+
+func Conflict(message string) *Error {
+	return New(fiber.StatusConflict, "conflict", message)
+}
+
+func Gone(message string) *Error {
+	return New(fiber.StatusGone, "gone", message)
+}
+
+func PreconditionFailed(message string) *Error {
+	return New(fiber.StatusPreconditionFailed, "precondition_failed", message)
+}
+
+func TooManyRequests(message string) *Error {
+	return New(fiber.StatusTooManyRequests, "too_many_requests", message)
+}
+
+// Internal reports a server-side failure. message must not contain internal
+// details; attach those with WithCause.
+func Internal(message string) *Error {
+	return New(fiber.StatusInternalServerError, "internal_error", message)
+}
+
+// Validation reports invalid request fields, keyed by their JSON names.
+func Validation(fields map[string]string) *Error {
+	err := New(fiber.StatusUnprocessableEntity, "validation_failed", "Request validation failed")
+	err.Fields = fields
+	return err
+}
+
+// Domain errors that handlers may return as-is
+var domainErrors []struct {
+	target error
+	err    Error
+}
+
+// Register maps a domain sentinel error to the response it should produce
+// wherever it is returned. Call it from package init functions.
+func Register(target error, status int, code, message string) {
+	domainErrors = append(domainErrors, struct {
+		target error
+		err    Error
+	}{target, Error{Status: status, Code: code, Message: message}})
+}
+
+func init() {
+	Register(gorm.ErrRecordNotFound, fiber.StatusNotFound, "not_found", "Resource not found")
+}
+
+// domainError returns the registered response for err, if any.
+func domainError(err error) *Error {
+	for _, domain := range domainErrors {
+		if errors.Is(err, domain.target) {
+			mapped := domain.err
+			mapped.cause = err
+			return &mapped
+		}
+	}
+	return nil
+}
+
+// resolve turns any error into the Error to send. A registered domain error
+// wins over the generic 500 it was wrapped in.
+func resolve(err error) *Error {
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		if apiErr.Status >= 500 && apiErr.cause != nil {
+			if mapped := domainError(apiErr.cause); mapped != nil {
+				return mapped
+			}
+		}
+		return apiErr
+	}
+	if mapped := domainError(err); mapped != nil {
+		return mapped
+	}
+	var fiberErr *fiber.Error
+	if errors.As(err, &fiberErr) {
+		return New(fiberErr.Code, codeForStatus(fiberErr.Code), fiberErr.Message)
+	}
+	return Internal("Internal server error").WithCause(err)
+}
+
+func codeForStatus(status int) string {
+	switch status {
+	case fiber.StatusBadRequest:
+		return "bad_request"
+	case fiber.StatusUnauthorized:
+		return "unauthorized"
+	case fiber.StatusForbidden:
+		return "forbidden"
+	case fiber.StatusNotFound:
+		return "not_found"
+	case fiber.StatusMethodNotAllowed:
+		return "method_not_allowed"
+	case fiber.StatusRequestEntityTooLarge:
+		return "payload_too_large"
+	case fiber.StatusUnprocessableEntity:
+		return "unprocessable_entity"
+	case fiber.StatusUpgradeRequired:
+		return "upgrade_required"
+	case fiber.StatusTooManyRequests:
+		return "too_many_requests"
+	}
+	if status >= 500 {
+		return "internal_error"
+	}
+	return "error"
+}
+
+// Handler is the application's Fiber ErrorHandler. Every error a handler or
+// middleware returns is rendered here as application/problem+json.
+func Handler(c *fiber.Ctx, err error) error {
+	apiErr := resolve(err)
+	if apiErr.Status >= 500 {
+		log.Printf("%s %s: %v\n", c.Method(), c.Path(), apiErr)
+	}
+
+	body := fiber.Map{}
+	for key, value := range apiErr.Extra {
+		body[key] = value
+	}
+	body["type"] = "about:blank"
+	body["title"] = http.StatusText(apiErr.Status)
+	body["status"] = apiErr.Status
+	body["detail"] = apiErr.Message
+	body["code"] = apiErr.Code
+	body["instance"] = c.Path()
+	if len(apiErr.Fields) > 0 {
+		body["errors"] = apiErr.Fields
+	}
+
+	c.Status(apiErr.Status)
+	if err := c.JSON(body); err != nil {
+		return err
+	}
+	c.Set(fiber.HeaderContentType, ContentType)
+	return nil
+}
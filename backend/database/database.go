@@ -34,16 +34,42 @@ func Migrate() {
 		&models.Task{},
 		&models.Notebook{},
 		&models.Page{},
+		&models.Invite{},
+		&models.Session{},
+		&models.RefreshToken{},
+		&models.PersonalAccessToken{},
+		&models.RecoveryCode{},
+		&models.AuditEvent{},
+		&models.PasswordResetToken{},
+		&models.UserSettings{},
+		&models.DataExport{},
+		&models.SavedView{},
+		&models.Tag{},
+		&models.TaskTag{},
+		&models.NotebookTag{},
+		&models.PageTag{},
+		&models.ChecklistItem{},
+		&models.TaskDependency{},
+		&models.Reminder{},
+		&models.Notification{},
 	)
 	if err != nil {
 		log.Fatal("Failed to migrate database:", err)
 	}
 
+	backfillTags()
+
 	log.Println("Database migration completed")
 }
 
+// weakDefaultPasswords are passwords that shipped in example configuration
+// and must never guard a real account.
+var weakDefaultPasswords = []string{"Klist123", "change-me"}
+
 // SeedDefaultUser ensures a default user exists when credentials are supplied.
-func SeedDefaultUser() {
+// The password must pass checkPassword, which returns why a password is too
+// weak, and must not be one of the published example values.
+func SeedDefaultUser(checkPassword func(string) string) {
 	defaultEmail := os.Getenv("DEFAULT_USER_EMAIL")
 	defaultPassword := os.Getenv("DEFAULT_USER_PASSWORD")
 	defaultName := os.Getenv("DEFAULT_USER_NAME")
@@ -52,19 +78,35 @@ func SeedDefaultUser() {
 		log.Println("Default user credentials not provided; skipping seed")
 		return
 	}
-	if defaultName == "" {
-		defaultName = "Default User"
-	}
 
 	var user models.User
-	if err := DB.Where("email = ?", defaultEmail).First(&user).Error; err == nil {
+	if err := DB.Where("LOWER(email) = LOWER(?)", defaultEmail).First(&user).Error; err == nil {
+		// The operator-configured account administers invites
+		if user.Role != models.RoleAdmin {
+			DB.Model(&user).Update("role", models.RoleAdmin)
+		}
 		log.Printf("Default user already exists: %s\n", defaultEmail)
+		warnWeakPassword(&user)
 		return
 	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
 		log.Printf("Failed to check default user: %v\n", err)
 		return
 	}
 
+	for _, weak := range weakDefaultPasswords {
+		if defaultPassword == weak {
+			log.Println("DEFAULT_USER_PASSWORD is still the example value; set a strong password. Skipping seed")
+			return
+		}
+	}
+	if problem := checkPassword(defaultPassword); problem != "" {
+		log.Printf("DEFAULT_USER_PASSWORD is too weak (%s); skipping seed\n", problem)
+		return
+	}
+	if defaultName == "" {
+		defaultName = "Default User"
+	}
+
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(defaultPassword), bcrypt.DefaultCost)
 	if err != nil {
 		log.Printf("Failed to hash default user password: %v\n", err)
@@ -75,6 +117,7 @@ func SeedDefaultUser() {
 		Email:    defaultEmail,
 		Password: string(hashedPassword),
 		Name:     defaultName,
+		Role:     models.RoleAdmin,
 	}
 
 	if err := DB.Create(&user).Error; err != nil {
@@ -85,6 +128,17 @@ func SeedDefaultUser() {
 	log.Printf("Default user created successfully: %s\n", defaultEmail)
 }
 
+// warnWeakPassword warns when an existing account still uses a published
+// example password, e.g. one seeded before the example was replaced.
+func warnWeakPassword(user *models.User) {
+	for _, weak := range weakDefaultPasswords {
+		if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(weak)) == nil {
+			log.Printf("WARNING: %s still uses an example password; change it now\n", user.Email)
+			return
+		}
+	}
+}
+
 // NormalizeTaskTypes backfills task_type for existing records so Eisenhower
 // matrix tasks stay isolated from Kanban board items.
 func NormalizeTaskTypes() {
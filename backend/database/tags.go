@@ -0,0 +1,123 @@
+package database
+
+import (
+	"log"
+	"time"
+
+	"tonish/backend/models"
+
+	"gorm.io/gorm"
+)
+
+// Taggable tables
+const (
+	TaggedTasks     = "tasks"
+	TaggedNotebooks = "notebooks"
+	TaggedPages     = "pages"
+)
+
+// TaggedTables lists every table whose rows carry tags.
+var TaggedTables = []string{TaggedTasks, TaggedNotebooks, TaggedPages}
+
+// tagJoin describes how a taggable table links to tags: its join table, the
+// join table's column holding the row id, and how to find each row's owner.
+type tagJoin struct {
+	table, column string
+	from, owner   string
+}
+
+var tagJoins = map[string]tagJoin{
+	TaggedTasks:     {"task_tags", "task_id", "tasks", "tasks.user_id"},
+	TaggedNotebooks: {"notebook_tags", "notebook_id", "notebooks", "notebooks.user_id"},
+	TaggedPages:     {"page_tags", "page_id", "pages JOIN notebooks ON notebooks.id = pages.notebook_id", "notebooks.user_id"},
+}
+
+// SyncTags makes the tag links of one row of a TaggedTables table match its
+// tags string, creating the user's tags as they first appear.
+func SyncTags(tx *gorm.DB, userID uint, table string, id uint, tags string) error {
+	join := tagJoins[table]
+	if err := tx.Exec("DELETE FROM "+join.table+" WHERE "+join.column+" = ?", id).Error; err != nil {
+		return err
+	}
+	for _, name := range models.ParseTags(tags) {
+		tag := models.Tag{UserID: userID, Key: models.TagKey(name)}
+		if err := tx.Where(&tag).Attrs(models.Tag{Name: name}).FirstOrCreate(&tag).Error; err != nil {
+			return err
+		}
+		if err := tx.Exec("INSERT INTO "+join.table+" ("+join.column+", tag_id) VALUES (?, ?)", id, tag.ID).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Retag rewrites the tags string of every row carrying tag with replace and
+// syncs its links. Each rewritten row gets a new version. It returns the ids
+// of the rewritten rows by table.
+func Retag(tx *gorm.DB, tag *models.Tag, replace func(names []string) []string) (map[string][]uint, error) {
+	changed := map[string][]uint{}
+	for _, table := range TaggedTables {
+		join := tagJoins[table]
+		var rows []struct {
+			ID   uint
+			Tags string
+		}
+		if err := tx.Raw("SELECT id, tags FROM "+table+" WHERE id IN (SELECT "+join.column+" FROM "+join.table+" WHERE tag_id = ?)", tag.ID).
+			Scan(&rows).Error; err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			tags := models.FormatTags(row.Tags, replace(models.ParseTags(row.Tags)))
+			if err := tx.Table(table).Where("id = ?", row.ID).Updates(map[string]interface{}{
+				"tags":       tags,
+				"version":    gorm.Expr("version + 1"),
+				"updated_at": time.Now(),
+			}).Error; err != nil {
+				return nil, err
+			}
+			if err := SyncTags(tx, tag.UserID, table, row.ID, tags); err != nil {
+				return nil, err
+			}
+			changed[table] = append(changed[table], row.ID)
+		}
+	}
+	return changed, nil
+}
+
+// DeleteTagLinks removes every link of the given rows of table.
+func DeleteTagLinks(tx *gorm.DB, table string, ids interface{}) error {
+	join := tagJoins[table]
+	return tx.Exec("DELETE FROM "+join.table+" WHERE "+join.column+" IN (?)", ids).Error
+}
+
+// backfillTags links tags for rows whose tags string predates the tags
+// table. Rows that already have links are left alone, so it is safe to run
+// on every start.
+func backfillTags() {
+	for _, table := range TaggedTables {
+		join := tagJoins[table]
+		var rows []struct {
+			ID     uint
+			UserID uint
+			Tags   string
+		}
+		if err := DB.Raw("SELECT " + table + ".id AS id, " + join.owner + " AS user_id, " + table + ".tags AS tags FROM " + join.from +
+			" WHERE COALESCE(" + table + ".tags, '') NOT IN ('', '[]')" +
+			" AND NOT EXISTS (SELECT 1 FROM " + join.table + " WHERE " + join.table + "." + join.column + " = " + table + ".id)").
+			Scan(&rows).Error; err != nil {
+			log.Fatal("Failed to migrate tags:", err)
+		}
+
+		err := DB.Transaction(func(tx *gorm.DB) error {
+			for _, row := range rows {
+				if err := SyncTags(tx, row.UserID, table, row.ID, row.Tags); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			log.Fatal("Failed to migrate tags:", err)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"time"
+
+	"tonish/backend/apierror"
+	"tonish/backend/database"
+	"tonish/backend/models"
+	ws "tonish/backend/websocket"
+
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+const (
+	// accountDeletionTTL bounds the time between confirming the password and
+	// actually deleting the account.
+	accountDeletionTTL     = 10 * time.Minute
+	accountDeletionPurpose = "account_deletion"
+)
+
+type RequestAccountDeletionRequest struct {
+	Password     string `json:"password" validate:"required"`
+	Code         string `json:"code" validate:"max=10"`
+	RecoveryCode string `json:"recovery_code" validate:"max=64"`
+}
+
+type DeleteAccountRequest struct {
+	ConfirmationToken string `json:"confirmation_token" validate:"required"`
+}
+
+// deleteUserData hard-deletes a user and every row they own, including
+// soft-deleted tasks. Audit events are kept. Run it inside a transaction.
+func deleteUserData(tx *gorm.DB, userID uint) error {
+	notebookIDs := tx.Model(&models.Notebook{}).Select("id").Where("user_id = ?", userID)
+	if err := tx.Where("notebook_id IN (?)", notebookIDs).Delete(&models.Page{}).Error; err != nil {
+		return err
+	}
+
+	sessionIDs := tx.Model(&models.Session{}).Select("id").Where("user_id = ?", userID)
+	if err := tx.Where("session_id IN (?)", sessionIDs).Delete(&models.RefreshToken{}).Error; err != nil {
+		return err
+	}
+
+	tagIDs := tx.Model(&models.Tag{}).Select("id").Where("user_id = ?", userID)
+	for _, link := range []interface{}{&models.TaskTag{}, &models.NotebookTag{}, &models.PageTag{}} {
+		if err := tx.Where("tag_id IN (?)", tagIDs).Delete(link).Error; err != nil {
+			return err
+		}
+	}
+
+	taskIDs := tx.Unscoped().Model(&models.Task{}).Select("id").Where("user_id = ?", userID)
+	if err := tx.Where("task_id IN (?)", taskIDs).Delete(&models.ChecklistItem{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("task_id IN (?) OR blocker_id IN (?)", taskIDs, taskIDs).Delete(&models.TaskDependency{}).Error; err != nil {
+		return err
+	}
+
+	if err := tx.Unscoped().Where("user_id = ?", userID).Delete(&models.Task{}).Error; err != nil {
+		return err
+	}
+
+	// Rows that simply carry the owner's user_id
+	owned := []interface{}{
+		&models.Notebook{},
+		&models.Session{},
+		&models.PersonalAccessToken{},
+		&models.RecoveryCode{},
+		&models.PasswordResetToken{},
+		&models.UserSettings{},
+		&models.DataExport{},
+		&models.SavedView{},
+		&models.Tag{},
+		&models.Reminder{},
+		&models.Notification{},
+	}
+	for _, model := range owned {
+		if err := tx.Where("user_id = ?", userID).Delete(model).Error; err != nil {
+			return err
+		}
+	}
+
+	// Unused invites die with their creator; used ones record who joined
+	if err := tx.Where("created_by_id = ? AND used_at IS NULL", userID).Delete(&models.Invite{}).Error; err != nil {
+		return err
+	}
+
+	return tx.Delete(&models.User{}, userID).Error
+}
+
+// RequestAccountDeletion is the confirmation step of deleting an account:
+// the password (and a second factor when 2FA is on) is exchanged for a
+// short-lived token that DeleteAccount requires.
+func RequestAccountDeletion(c *fiber.Ctx) error {
+	req := new(RequestAccountDeletionRequest)
+	if err := parseBody(c, req); err != nil {
+		return err
+	}
+
+	var user models.User
+	if err := database.DB.First(&user, currentUserID(c)).Error; err != nil {
+		return apierror.NotFound("User not found")
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
+		return apierror.Unauthorized("Invalid credentials")
+	}
+	if user.TOTPEnabled && !checkSecondFactor(&user, req.Code, req.RecoveryCode) {
+		return apierror.Unauthorized("Invalid code")
+	}
+
+	token, err := signPurposeToken(user.ID, accountDeletionPurpose, accountDeletionTTL)
+	if err != nil {
+		return apierror.Internal("Failed to confirm deletion").WithCause(err)
+	}
+
+	return c.JSON(fiber.Map{
+		"confirmation_token": token,
+		"expires_in":         int(accountDeletionTTL.Seconds()),
+	})
+}
+
+// DeleteAccount permanently deletes the current user and everything they own
+// in a single transaction.
+func DeleteAccount(c *fiber.Ctx) error {
+	req := new(DeleteAccountRequest)
+	if err := parseBody(c, req); err != nil {
+		return err
+	}
+
+	userID := currentUserID(c)
+	if confirmedID, ok := parsePurposeToken(req.ConfirmationToken, accountDeletionPurpose); !ok || confirmedID != userID {
+		return apierror.Forbidden("Confirmation token is invalid or has expired")
+	}
+
+	var user models.User
+	if err := database.DB.First(&user, userID).Error; err != nil {
+		return apierror.NotFound("User not found")
+	}
+
+	// Someone has to be able to manage the instance afterwards
+	if user.Role == models.RoleAdmin {
+		var admins int64
+		database.DB.Model(&models.User{}).Where("role = ? AND disabled_at IS NULL", models.RoleAdmin).Count(&admins)
+		if admins <= 1 {
+			return apierror.Conflict("Promote another admin before deleting the last admin account")
+		}
+	}
+
+	if err := database.DB.Transaction(func(tx *gorm.DB) error {
+		return deleteUserData(tx, userID)
+	}); err != nil {
+		return apierror.Internal("Failed to delete account").WithCause(err)
+	}
+	recordChange(c, models.AuditAccountDelete, "user", userID, user, nil)
+
+	if ws.GlobalHub != nil {
+		ws.GlobalHub.DisconnectUser(userID)
+	}
+
+	return c.Status(204).SendString("")
+}
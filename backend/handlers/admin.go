@@ -0,0 +1,265 @@
+package handlers
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	"tonish/backend/apierror"
+	"tonish/backend/database"
+	"tonish/backend/models"
+	ws "tonish/backend/websocket"
+
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+)
+
+type AdminCreateUserRequest struct {
+	Email    string `json:"email" validate:"required,email,max=254"`
+	Password string `json:"password" validate:"required,password,max=72"`
+	Name     string `json:"name" validate:"max=100"`
+	Role     string `json:"role" validate:"oneof=user admin"` // Defaults to user
+}
+
+type AdminUpdateUserRequest struct {
+	Name *string `json:"name" validate:"max=100"`
+	Role *string `json:"role" validate:"notblank,oneof=user admin"`
+}
+
+// pageParams reads ?limit= and ?offset= for offset-paginated admin lists.
+func pageParams(c *fiber.Ctx) (int, int) {
+	limit := c.QueryInt("limit", defaultPageSize)
+	if limit <= 0 || limit > maxPageSize {
+		limit = defaultPageSize
+	}
+	offset := c.QueryInt("offset", 0)
+	if offset < 0 {
+		offset = 0
+	}
+	return limit, offset
+}
+
+// findManagedUser loads the user named by an admin endpoint's :id parameter.
+func findManagedUser(c *fiber.Ctx, user *models.User) error {
+	return database.DB.First(user, "id = ?", c.Params("id")).Error
+}
+
+// signOutUser revokes every session of a user and drops their WebSocket
+// clients, including any opened before sessions were tracked.
+func signOutUser(userID uint) {
+	if err := revokeUserSessions(userID, 0); err != nil {
+		log.Printf("Failed to revoke sessions for user %d: %v\n", userID, err)
+	}
+	if ws.GlobalHub != nil {
+		ws.GlobalHub.DisconnectUser(userID)
+	}
+}
+
+// AdminListUsers lists accounts, optionally filtered by ?q= on email or name,
+// paginated with ?limit= and ?offset=
+func AdminListUsers(c *fiber.Ctx) error {
+	limit, offset := pageParams(c)
+
+	query := database.DB.Model(&models.User{})
+	if q := strings.TrimSpace(c.Query("q")); q != "" {
+		pattern := "%" + strings.ToLower(q) + "%"
+		query = query.Where("LOWER(email) LIKE ? OR LOWER(name) LIKE ?", pattern, pattern)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return apierror.Internal("Failed to load users").WithCause(err)
+	}
+
+	var users []models.User
+	if err := query.Order("id").Limit(limit).Offset(offset).Find(&users).Error; err != nil {
+		return apierror.Internal("Failed to load users").WithCause(err)
+	}
+
+	return c.JSON(fiber.Map{
+		"users": users,
+		"total": total,
+	})
+}
+
+// AdminCreateUser creates an account directly, bypassing registration
+func AdminCreateUser(c *fiber.Ctx) error {
+	req := new(AdminCreateUserRequest)
+	if err := parseBody(c, req); err != nil {
+		return err
+	}
+
+	req.Email = strings.TrimSpace(req.Email)
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Role == "" {
+		req.Role = models.RoleUser
+	}
+
+	var existing int64
+	database.DB.Model(&models.User{}).Where("LOWER(email) = LOWER(?)", req.Email).Count(&existing)
+	if existing > 0 {
+		return apierror.Conflict("Email is already registered")
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return apierror.Internal("Failed to create user").WithCause(err)
+	}
+
+	user := models.User{
+		Email:    req.Email,
+		Password: string(hashedPassword),
+		Name:     req.Name,
+		Role:     req.Role,
+	}
+	if err := database.DB.Create(&user).Error; err != nil {
+		return apierror.Internal("Failed to create user").WithCause(err)
+	}
+	recordChange(c, models.AuditUserCreate, "user", user.ID, nil, user)
+
+	return c.Status(201).JSON(user)
+}
+
+// AdminUpdateUser changes a user's name or role
+func AdminUpdateUser(c *fiber.Ctx) error {
+	var user models.User
+	if err := findManagedUser(c, &user); err != nil {
+		return apierror.NotFound("User not found")
+	}
+
+	before := user
+
+	req := new(AdminUpdateUserRequest)
+	if err := parseBody(c, req); err != nil {
+		return err
+	}
+
+	if req.Name != nil {
+		user.Name = strings.TrimSpace(*req.Name)
+	}
+	if req.Role != nil {
+		// Admins cannot demote themselves, so there is always an admin left
+		if user.ID == currentUserID(c) && *req.Role != models.RoleAdmin {
+			return apierror.Conflict("You cannot remove your own admin role")
+		}
+		user.Role = *req.Role
+	}
+
+	if err := database.DB.Model(&user).Updates(map[string]interface{}{
+		"name": user.Name,
+		"role": user.Role,
+	}).Error; err != nil {
+		return apierror.Internal("Failed to update user").WithCause(err)
+	}
+	recordChange(c, models.AuditUserUpdate, "user", user.ID, before, user)
+
+	return c.JSON(user)
+}
+
+// AdminDisableUser blocks an account: its sessions are revoked, its tokens
+// stop working and its WebSocket clients are disconnected
+func AdminDisableUser(c *fiber.Ctx) error {
+	var user models.User
+	if err := findManagedUser(c, &user); err != nil {
+		return apierror.NotFound("User not found")
+	}
+	// Disabling yourself would lock you out with no admin to undo it
+	if user.ID == currentUserID(c) {
+		return apierror.Conflict("You cannot disable your own account")
+	}
+
+	before := user
+	if user.DisabledAt == nil {
+		now := time.Now()
+		if err := database.DB.Model(&user).Update("disabled_at", now).Error; err != nil {
+			return apierror.Internal("Failed to disable user").WithCause(err)
+		}
+		user.DisabledAt = &now
+	}
+
+	signOutUser(user.ID)
+	recordChange(c, models.AuditUserDisable, "user", user.ID, before, user)
+
+	return c.JSON(user)
+}
+
+// AdminEnableUser re-enables a disabled account
+func AdminEnableUser(c *fiber.Ctx) error {
+	var user models.User
+	if err := findManagedUser(c, &user); err != nil {
+		return apierror.NotFound("User not found")
+	}
+
+	before := user
+	if err := database.DB.Model(&user).Update("disabled_at", nil).Error; err != nil {
+		return apierror.Internal("Failed to enable user").WithCause(err)
+	}
+	user.DisabledAt = nil
+	recordChange(c, models.AuditUserEnable, "user", user.ID, before, user)
+
+	return c.JSON(user)
+}
+
+// AdminForcePasswordReset invalidates a user's password and sessions and
+// mails them a reset link; they cannot sign in again until they use it
+func AdminForcePasswordReset(c *fiber.Ctx) error {
+	var user models.User
+	if err := findManagedUser(c, &user); err != nil {
+		return apierror.NotFound("User not found")
+	}
+
+	// Replace the password with an unguessable one nobody knows
+	placeholder, _, err := generateSecret("")
+	if err != nil {
+		return apierror.Internal("Failed to reset password").WithCause(err)
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(placeholder), bcrypt.DefaultCost)
+	if err != nil {
+		return apierror.Internal("Failed to reset password").WithCause(err)
+	}
+	if err := database.DB.Model(&user).Update("password", string(hashedPassword)).Error; err != nil {
+		return apierror.Internal("Failed to reset password").WithCause(err)
+	}
+
+	signOutUser(user.ID)
+
+	if err := sendPasswordReset(&user); err != nil {
+		return apierror.Internal("Failed to send password reset").WithCause(err)
+	}
+	recordChange(c, models.AuditUserResetForced, "user", user.ID, nil, nil)
+
+	return c.Status(202).JSON(fiber.Map{
+		"message": "Password reset sent to " + user.Email,
+	})
+}
+
+// AdminDeleteUser permanently deletes an account and everything it owns
+func AdminDeleteUser(c *fiber.Ctx) error {
+	var user models.User
+	if err := findManagedUser(c, &user); err != nil {
+		return apierror.NotFound("User not found")
+	}
+	// An admin deleting their own account could leave no admin at all
+	if user.ID == currentUserID(c) {
+		return apierror.Conflict("You cannot delete your own account here")
+	}
+
+	if err := database.DB.Transaction(func(tx *gorm.DB) error {
+		return deleteUserData(tx, user.ID)
+	}); err != nil {
+		return apierror.Internal("Failed to delete user").WithCause(err)
+	}
+	recordChange(c, models.AuditUserDelete, "user", user.ID, user, nil)
+
+	if ws.GlobalHub != nil {
+		ws.GlobalHub.DisconnectUser(user.ID)
+	}
+
+	return c.Status(204).SendString("")
+}
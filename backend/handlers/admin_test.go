@@ -0,0 +1,140 @@
+package handlers_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"tonish/backend/database"
+	"tonish/backend/models"
+)
+
+func TestAdminRoutesRequireAdmin(t *testing.T) {
+	app := newTestApp(t)
+	admin, adminToken := createAdmin(t, app, "admin@example.com")
+	_, userToken := createUser(t, app, "alice@example.com")
+	adminPath := fmt.Sprintf("/api/admin/users/%d", admin.ID)
+
+	for _, route := range []struct{ method, path string }{
+		{"GET", "/api/admin/users"},
+		{"POST", adminPath + "/disable"},
+		{"DELETE", adminPath},
+		{"GET", "/api/admin/audit"},
+		{"GET", "/api/invites"},
+	} {
+		if status := doJSON(t, app, route.method, route.path, userToken, nil, nil); status != 403 {
+			t.Errorf("%s %s as a user: status %d, want 403", route.method, route.path, status)
+		}
+	}
+
+	// Personal access tokens never reach admin routes, even an admin's
+	pat, _ := createToken(t, app, adminToken, models.TokenScopes...)
+	if status := doJSON(t, app, "GET", "/api/admin/users", pat, nil, nil); status != 403 {
+		t.Fatalf("admin's personal access token: status %d, want 403", status)
+	}
+
+	if status := doJSON(t, app, "GET", "/api/admin/users", adminToken, nil, nil); status != 200 {
+		t.Fatalf("admin: status %d", status)
+	}
+
+	// Demotion takes effect on the next request, not when the token expires
+	database.DB.Model(&admin).Update("role", models.RoleUser)
+	if status := doJSON(t, app, "GET", "/api/admin/users", adminToken, nil, nil); status != 403 {
+		t.Fatalf("demoted admin: status %d, want 403", status)
+	}
+}
+
+func TestAdminDisableUser(t *testing.T) {
+	app := newTestApp(t)
+	_, adminToken := createAdmin(t, app, "admin@example.com")
+	alice, aliceToken := createUser(t, app, "alice@example.com")
+	pat, _ := createToken(t, app, aliceToken, models.ScopeTasksRead)
+	path := fmt.Sprintf("/api/admin/users/%d", alice.ID)
+
+	if status := doJSON(t, app, "POST", path+"/disable", adminToken, nil, nil); status != 200 {
+		t.Fatalf("disable: status %d", status)
+	}
+	if status := doJSON(t, app, "GET", "/api/user/me", aliceToken, nil, nil); status != 401 {
+		t.Fatalf("disabled user's session: status %d, want 401", status)
+	}
+	if status := doJSON(t, app, "GET", "/api/tasks", pat, nil, nil); status != 401 {
+		t.Fatalf("disabled user's personal access token: status %d, want 401", status)
+	}
+	if status := loginStatus(t, app, "alice@example.com", testPassword); status != 403 {
+		t.Fatalf("disabled user's login: status %d, want 403", status)
+	}
+
+	if status := doJSON(t, app, "POST", path+"/enable", adminToken, nil, nil); status != 200 {
+		t.Fatalf("enable: status %d", status)
+	}
+	if status := doJSON(t, app, "GET", "/api/tasks", pat, nil, nil); status != 200 {
+		t.Fatalf("re-enabled user's personal access token: status %d", status)
+	}
+	if status := loginStatus(t, app, "alice@example.com", testPassword); status != 200 {
+		t.Fatalf("re-enabled user's login: status %d", status)
+	}
+}
+
+func TestDisabledUserTokensStopWorking(t *testing.T) {
+	app := newTestApp(t)
+	alice, token := createUser(t, app, "alice@example.com")
+
+	// Disabling revokes sessions too; this checks that a token whose session
+	// somehow survived is still refused
+	database.DB.Model(&alice).Update("disabled_at", time.Now())
+
+	if status := doJSON(t, app, "GET", "/api/user/me", token, nil, nil); status != 401 {
+		t.Fatalf("disabled user's token: status %d, want 401", status)
+	}
+	var sessions int64
+	database.DB.Model(&models.Session{}).Where("user_id = ? AND revoked_at IS NULL", alice.ID).Count(&sessions)
+	if sessions != 1 {
+		t.Fatalf("%d active sessions, want the untouched one", sessions)
+	}
+}
+
+func TestAdminCannotActOnThemselves(t *testing.T) {
+	app := newTestApp(t)
+	admin, token := createAdmin(t, app, "admin@example.com")
+	path := fmt.Sprintf("/api/admin/users/%d", admin.ID)
+
+	if status := doJSON(t, app, "POST", path+"/disable", token, nil, nil); status != 409 {
+		t.Fatalf("disable self: status %d, want 409", status)
+	}
+	if status := doJSON(t, app, "PATCH", path, token, map[string]string{"role": models.RoleUser}, nil); status != 409 {
+		t.Fatalf("demote self: status %d, want 409", status)
+	}
+	if status := doJSON(t, app, "DELETE", path, token, nil, nil); status != 409 {
+		t.Fatalf("delete self: status %d, want 409", status)
+	}
+
+	var stored models.User
+	database.DB.First(&stored, admin.ID)
+	if stored.Role != models.RoleAdmin || stored.DisabledAt != nil {
+		t.Fatalf("admin changed by refused requests: %+v", stored)
+	}
+
+	// Renaming yourself is fine
+	if status := doJSON(t, app, "PATCH", path, token, map[string]string{"name": "Root", "role": models.RoleAdmin}, &stored); status != 200 {
+		t.Fatalf("rename self: status %d", status)
+	}
+	if stored.Name != "Root" {
+		t.Fatalf("name %q, want Root", stored.Name)
+	}
+
+	// The guards are about the caller: other admins can be demoted and deleted
+	other, _ := createAdmin(t, app, "other@example.com")
+	otherPath := fmt.Sprintf("/api/admin/users/%d", other.ID)
+	if status := doJSON(t, app, "PATCH", otherPath, token, map[string]string{"role": models.RoleUser}, &stored); status != 200 {
+		t.Fatalf("demote other admin: status %d", status)
+	}
+	if stored.Role != models.RoleUser {
+		t.Fatalf("other admin's role %q", stored.Role)
+	}
+	if status := doJSON(t, app, "DELETE", otherPath, token, nil, nil); status != 204 {
+		t.Fatalf("delete other admin: status %d", status)
+	}
+	if err := database.DB.First(&models.User{}, other.ID).Error; err == nil {
+		t.Fatal("deleted user still exists")
+	}
+}
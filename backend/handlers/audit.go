@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"tonish/backend/apierror"
+	"tonish/backend/database"
+	"tonish/backend/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// defaultAuditRetentionDays applies when AUDIT_RETENTION_DAYS is unset.
+const defaultAuditRetentionDays = 365
+
+// recordAudit appends an audit event for the current request. Failing to
+// write the audit trail is logged but never fails the request itself.
+func recordAudit(c *fiber.Ctx, userID *uint, action string, details fiber.Map) {
+	saveAudit(c, &models.AuditEvent{UserID: userID, Action: action}, details)
+}
+
+// recordChange audits an action the current user took on a resource, with
+// JSON snapshots of it before and after. Either snapshot may be nil.
+func recordChange(c *fiber.Ctx, action, resourceType string, resourceID uint, before, after interface{}) {
+	userID := currentUserID(c)
+	saveAudit(c, &models.AuditEvent{
+		UserID:       &userID,
+		Action:       action,
+		ResourceType: resourceType,
+		ResourceID:   &resourceID,
+		Before:       auditSnapshot(action, before),
+		After:        auditSnapshot(action, after),
+	}, nil)
+}
+
+func saveAudit(c *fiber.Ctx, event *models.AuditEvent, details fiber.Map) {
+	event.IP = c.IP()
+	event.UserAgent = c.Get("User-Agent")
+
+	if details != nil {
+		event.Details = auditSnapshot(event.Action, details)
+	}
+
+	if err := database.DB.Create(event).Error; err != nil {
+		log.Printf("Failed to record audit event %s: %v\n", event.Action, err)
+	}
+}
+
+func auditSnapshot(action string, v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("Failed to encode audit snapshot for %s: %v\n", action, err)
+		return ""
+	}
+	return string(data)
+}
+
+// auditRetention reads AUDIT_RETENTION_DAYS. Zero or less keeps events
+// forever.
+func auditRetention() time.Duration {
+	days := defaultAuditRetentionDays
+	if value := os.Getenv("AUDIT_RETENTION_DAYS"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil {
+			log.Printf("Invalid AUDIT_RETENTION_DAYS %q, using %d\n", value, defaultAuditRetentionDays)
+		} else {
+			days = parsed
+		}
+	}
+	if days <= 0 {
+		return 0
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// pruneAuditEvents deletes events older than the retention period. It is the
+// only way audit events are ever removed.
+func pruneAuditEvents(now time.Time) {
+	retention := auditRetention()
+	if retention == 0 {
+		return
+	}
+	result := database.DB.Where("created_at < ?", now.Add(-retention)).Delete(&models.AuditEvent{})
+	if result.Error != nil {
+		log.Printf("Failed to prune audit events: %v\n", result.Error)
+	} else if result.RowsAffected > 0 {
+		log.Printf("Pruned %d audit events\n", result.RowsAffected)
+	}
+}
+
+// StartAuditRetention prunes expired audit events now and once a day.
+func StartAuditRetention() {
+	go func() {
+		pruneAuditEvents(time.Now())
+		for now := range time.Tick(24 * time.Hour) {
+			pruneAuditEvents(now)
+		}
+	}()
+}
+
+// parseAuditTime accepts RFC 3339 timestamps or plain YYYY-MM-DD dates (UTC).
+func parseAuditTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Parse(dateLayout, value)
+}
+
+// GetAuditEvents lists audit events, newest first, filtered by ?user_id=,
+// ?action=, ?resource_type=, ?resource_id= and a ?from= / ?to= time range
+func GetAuditEvents(c *fiber.Ctx) error {
+	limit, offset := pageParams(c)
+	query := database.DB.Model(&models.AuditEvent{})
+
+	if userID := c.Query("user_id"); userID != "" {
+		id, err := strconv.ParseUint(userID, 10, 64)
+		if err != nil {
+			return apierror.BadRequest("user_id must be a number")
+		}
+		query = query.Where("user_id = ?", id)
+	}
+	if action := c.Query("action"); action != "" {
+		query = query.Where("action = ?", action)
+	}
+	if resourceType := c.Query("resource_type"); resourceType != "" {
+		query = query.Where("resource_type = ?", resourceType)
+	}
+	if resourceID := c.Query("resource_id"); resourceID != "" {
+		id, err := strconv.ParseUint(resourceID, 10, 64)
+		if err != nil {
+			return apierror.BadRequest("resource_id must be a number")
+		}
+		query = query.Where("resource_id = ?", id)
+	}
+	if from := c.Query("from"); from != "" {
+		t, err := parseAuditTime(from)
+		if err != nil {
+			return apierror.BadRequest("from must be an RFC 3339 time or YYYY-MM-DD")
+		}
+		query = query.Where("created_at >= ?", t.UTC())
+	}
+	if to := c.Query("to"); to != "" {
+		t, err := parseAuditTime(to)
+		if err != nil {
+			return apierror.BadRequest("to must be an RFC 3339 time or YYYY-MM-DD")
+		}
+		query = query.Where("created_at < ?", t.UTC())
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return apierror.Internal("Failed to load audit events").WithCause(err)
+	}
+
+	events := []models.AuditEvent{}
+	if err := query.Order("created_at DESC, id DESC").Limit(limit).Offset(offset).Find(&events).Error; err != nil {
+		return apierror.Internal("Failed to load audit events").WithCause(err)
+	}
+
+	return c.JSON(fiber.Map{
+		"events": events,
+		"total":  total,
+	})
+}
@@ -1,14 +1,21 @@
 package handlers
 
 import (
+	"errors"
+	"net/mail"
 	"os"
+	"strings"
 	"time"
+	"unicode"
+	"tonish/backend/apierror"
 	"tonish/backend/database"
+	"tonish/backend/middleware"
 	"tonish/backend/models"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/golang-jwt/jwt/v5"
 	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
 )
 
 func getJWTSecret() []byte {
@@ -20,87 +27,376 @@ func getJWTSecret() []byte {
 }
 
 type LoginRequest struct {
-	Email    string `json:"email"`
-	Password string `json:"password"`
+	Email    string `json:"email" validate:"required"`
+	Password string `json:"password" validate:"required"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" validate:"required"`
 }
 
 type RegisterRequest struct {
-	Email    string `json:"email"`
-	Password string `json:"password"`
-	Name     string `json:"name"`
+	Email      string `json:"email" validate:"required,email,max=254"`
+	Password   string `json:"password" validate:"required,password,max=72"`
+	Name       string `json:"name" validate:"max=100"`
+	InviteCode string `json:"invite_code"`
+}
+
+// Registration modes, selected with the REGISTRATION_MODE environment variable
+const (
+	RegistrationOpen   = "open"
+	RegistrationInvite = "invite"
+	RegistrationClosed = "closed"
+)
+
+const (
+	accessTokenTTL  = 15 * time.Minute
+	refreshTokenTTL = 30 * 24 * time.Hour
+)
+
+var (
+	// errInviteUsed aborts a registration whose invite was claimed concurrently.
+	errInviteUsed = errors.New("invite already used")
+	// errRefreshTokenReused aborts a refresh whose token was already exchanged.
+	errRefreshTokenReused = errors.New("refresh token reused")
+)
+
+func init() {
+	apierror.Register(errInviteUsed, fiber.StatusForbidden, "forbidden", "Invalid or expired invite code")
+}
+
+// registrationMode returns the configured registration mode. Registration is
+// invite-only unless configured otherwise, and unknown values close it.
+func registrationMode() string {
+	switch mode := strings.ToLower(os.Getenv("REGISTRATION_MODE")); mode {
+	case "":
+		return RegistrationInvite
+	case RegistrationOpen, RegistrationInvite, RegistrationClosed:
+		return mode
+	default:
+		return RegistrationClosed
+	}
+}
+
+// validateEmail checks that email is a bare address such as "me@example.com".
+func validateEmail(email string) bool {
+	addr, err := mail.ParseAddress(email)
+	return err == nil && addr.Address == email
+}
+
+// ValidatePassword returns a description of why password is too weak, or an
+// empty string when it is acceptable. It also vets the seeded default user's
+// password.
+func ValidatePassword(password string) string {
+	if len(password) < 8 {
+		return "Password must be at least 8 characters"
+	}
+
+	var hasLetter, hasDigit bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLetter(r):
+			hasLetter = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		}
+	}
+	if !hasLetter || !hasDigit {
+		return "Password must contain both letters and digits"
+	}
+
+	return ""
+}
+
+// signAccessToken signs a short-lived JWT bound to session.
+func signAccessToken(user *models.User, session *models.Session) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"user_id": user.ID,
+		"email":   user.Email,
+		"sid":     session.ID,
+		"exp":     time.Now().Add(accessTokenTTL).Unix(),
+	})
+
+	return token.SignedString(getJWTSecret())
 }
 
-// Register creates a new user account
+// createRefreshToken adds a new link to the session's refresh token chain and
+// returns the plain token.
+func createRefreshToken(tx *gorm.DB, session *models.Session) (string, error) {
+	refreshToken, refreshHash, err := generateSecret("")
+	if err != nil {
+		return "", err
+	}
+
+	if err := tx.Create(&models.RefreshToken{
+		SessionID: session.ID,
+		TokenHash: refreshHash,
+	}).Error; err != nil {
+		return "", err
+	}
+
+	return refreshToken, nil
+}
+
+// tokenResponse builds the response shared by login, registration and refresh.
+func tokenResponse(user *models.User, accessToken, refreshToken string) fiber.Map {
+	return fiber.Map{
+		"token":         accessToken,
+		"refresh_token": refreshToken,
+		"expires_in":    int(accessTokenTTL.Seconds()),
+		"user": fiber.Map{
+			"id":    user.ID,
+			"email": user.Email,
+			"name":  user.Name,
+		},
+	}
+}
+
+// startSession opens a new session for user on the requesting device and
+// issues its first access and refresh tokens.
+func startSession(c *fiber.Ctx, user *models.User) (fiber.Map, error) {
+	var accessToken, refreshToken string
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		session := models.Session{
+			UserID:     user.ID,
+			UserAgent:  c.Get("User-Agent"),
+			IP:         c.IP(),
+			LastSeenAt: &now,
+			ExpiresAt:  now.Add(refreshTokenTTL),
+		}
+		if err := tx.Create(&session).Error; err != nil {
+			return err
+		}
+
+		var err error
+		if refreshToken, err = createRefreshToken(tx, &session); err != nil {
+			return err
+		}
+		accessToken, err = signAccessToken(user, &session)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return tokenResponse(user, accessToken, refreshToken), nil
+}
+
+// Register creates a new user account. Depending on the registration mode it
+// is open to anyone, requires a valid invite code, or is disabled.
 func Register(c *fiber.Ctx) error {
-	// Registration is currently disabled
-	return c.Status(403).JSON(fiber.Map{
-		"error": "User registration is currently disabled",
+	mode := registrationMode()
+	if mode == RegistrationClosed {
+		return apierror.Forbidden("User registration is currently disabled")
+	}
+
+	req := new(RegisterRequest)
+	if err := parseBody(c, req); err != nil {
+		return err
+	}
+
+	req.Email = strings.TrimSpace(req.Email)
+	req.Name = strings.TrimSpace(req.Name)
+
+	var invite models.Invite
+	if mode == RegistrationInvite {
+		if req.InviteCode == "" {
+			return apierror.Forbidden("An invite code is required")
+		}
+
+		err := database.DB.Where("code_hash = ?", middleware.HashSecret(req.InviteCode)).First(&invite).Error
+		if err != nil || invite.UsedAt != nil || time.Now().After(invite.ExpiresAt) ||
+			(invite.Email != "" && !strings.EqualFold(invite.Email, req.Email)) {
+			return apierror.Forbidden("Invalid or expired invite code")
+		}
+	}
+
+	var existing int64
+	database.DB.Model(&models.User{}).Where("LOWER(email) = LOWER(?)", req.Email).Count(&existing)
+	if existing > 0 {
+		return apierror.Conflict("Email is already registered")
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return apierror.Internal("Failed to create account").WithCause(err)
+	}
+
+	user := models.User{
+		Email:    req.Email,
+		Password: string(hashedPassword),
+		Name:     req.Name,
+		Role:     models.RoleUser,
+	}
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+		if invite.ID == 0 {
+			return nil
+		}
+
+		// Claim the invite only if nobody else used it in the meantime
+		now := time.Now()
+		result := tx.Model(&models.Invite{}).
+			Where("id = ? AND used_at IS NULL", invite.ID).
+			Updates(map[string]interface{}{"used_at": now, "used_by_id": user.ID})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errInviteUsed
+		}
+		return nil
 	})
+	if err != nil {
+		return apierror.Internal("Failed to create account").WithCause(err)
+	}
+
+	response, err := startSession(c, &user)
+	if err != nil {
+		return apierror.Internal("Failed to generate token").WithCause(err)
+	}
+
+	return c.Status(201).JSON(response)
 }
 
-// Login authenticates a user and returns a JWT token
+// Login authenticates a user and opens a new session with a short-lived
+// access token and a refresh token
 func Login(c *fiber.Ctx) error {
 	req := new(LoginRequest)
 	
-	if err := c.BodyParser(req); err != nil {
-		return c.Status(400).JSON(fiber.Map{
-			"error": "Invalid request body",
-		})
+	if err := parseBody(c, req); err != nil {
+		return err
+	}
+	
+	account := accountKey(req.Email)
+	if wait := loginRetryAfter(c, account); wait > 0 {
+		return tooManyAttempts(c, wait)
 	}
 	
 	// Find user
 	var user models.User
-	if err := database.DB.Where("email = ?", req.Email).First(&user).Error; err != nil {
-		return c.Status(401).JSON(fiber.Map{
-			"error": "Invalid credentials",
-		})
+	if err := database.DB.Where("LOWER(email) = LOWER(?)", strings.TrimSpace(req.Email)).First(&user).Error; err != nil {
+		recordLoginFailure(c, account, nil, "unknown_account")
+		return apierror.Unauthorized("Invalid credentials")
 	}
 	
 	// Check password
 	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
-		return c.Status(401).JSON(fiber.Map{
-			"error": "Invalid credentials",
+		recordLoginFailure(c, account, &user.ID, "bad_password")
+		return apierror.Unauthorized("Invalid credentials")
+	}
+	
+	if user.DisabledAt != nil {
+		recordAudit(c, &user.ID, models.AuditLoginFailed, fiber.Map{
+			"account": account,
+			"reason":  "disabled",
 		})
+		return apierror.Forbidden("Account is disabled")
 	}
 	
-	// Create JWT token
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"user_id": user.ID,
-		"email":   user.Email,
-		"exp":     time.Now().Add(time.Hour * 24 * 7).Unix(), // 7 days
-	})
+	// With two-factor enabled the password only earns a challenge, which is
+	// exchanged for a session by VerifyLogin
+	if user.TOTPEnabled {
+		challenge, err := signLoginChallenge(&user)
+		if err != nil {
+			return apierror.Internal("Failed to generate token").WithCause(err)
+		}
+		return c.JSON(fiber.Map{
+			"two_factor_required": true,
+			"challenge_token":     challenge,
+		})
+	}
+	
+	recordLoginSuccess(account)
+	recordAudit(c, &user.ID, models.AuditLogin, fiber.Map{"method": "password"})
 	
-	tokenString, err := token.SignedString(getJWTSecret())
+	response, err := startSession(c, &user)
 	if err != nil {
-		return c.Status(500).JSON(fiber.Map{
-			"error": "Failed to generate token",
-		})
+		return apierror.Internal("Failed to generate token").WithCause(err)
 	}
 	
-	return c.JSON(fiber.Map{
-		"token": tokenString,
-		"user": fiber.Map{
-			"id":    user.ID,
-			"email": user.Email,
-			"name":  user.Name,
-		},
-	})
+	return c.JSON(response)
 }
 
-// GetCurrentUser retrieves the current user's information
-func GetCurrentUser(c *fiber.Ctx) error {
-	userID := c.Locals("user_id")
-	
+// Refresh exchanges a refresh token for a new access token and a new refresh
+// token. Each refresh token is single-use; replaying one revokes its session.
+func Refresh(c *fiber.Ctx) error {
+	req := new(RefreshRequest)
+
+	if err := parseBody(c, req); err != nil {
+		return err
+	}
+
+	var current models.RefreshToken
+	if err := database.DB.Where("token_hash = ?", middleware.HashSecret(req.RefreshToken)).First(&current).Error; err != nil {
+		return apierror.Unauthorized("Invalid refresh token")
+	}
+
+	var session models.Session
+	if err := database.DB.First(&session, current.SessionID).Error; err != nil ||
+		session.RevokedAt != nil || time.Now().After(session.ExpiresAt) {
+		return apierror.Unauthorized("Session has ended")
+	}
+
 	var user models.User
-	if err := database.DB.First(&user, userID).Error; err != nil {
-		return c.Status(404).JSON(fiber.Map{
-			"error": "User not found",
-		})
+	if err := database.DB.First(&user, session.UserID).Error; err != nil || user.DisabledAt != nil {
+		return apierror.Unauthorized("Session has ended")
 	}
-	
-	return c.JSON(fiber.Map{
-		"id":    user.ID,
-		"email": user.Email,
-		"name":  user.Name,
+
+	var accessToken, refreshToken string
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		// Claim the token; losing the race means it was already exchanged
+		result := tx.Model(&models.RefreshToken{}).
+			Where("id = ? AND used_at IS NULL", current.ID).
+			Update("used_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errRefreshTokenReused
+		}
+
+		now := time.Now()
+		if err := tx.Model(&session).Updates(map[string]interface{}{
+			"expires_at":   now.Add(refreshTokenTTL),
+			"last_seen_at": now,
+			"ip":           c.IP(),
+		}).Error; err != nil {
+			return err
+		}
+
+		var err error
+		if refreshToken, err = createRefreshToken(tx, &session); err != nil {
+			return err
+		}
+		accessToken, err = signAccessToken(&user, &session)
+		return err
 	})
+	if errors.Is(err, errRefreshTokenReused) {
+		revokeSession(session.ID)
+		return apierror.Unauthorized("Refresh token reuse detected; session revoked")
+	}
+	if err != nil {
+		return apierror.Internal("Failed to refresh session").WithCause(err)
+	}
+
+	return c.JSON(tokenResponse(&user, accessToken, refreshToken))
+}
+
+// Logout revokes the session of the token used to call it
+func Logout(c *fiber.Ctx) error {
+	sessionID, _ := c.Locals("session_id").(uint)
+
+	if err := revokeSession(sessionID); err != nil {
+		return apierror.Internal("Failed to log out").WithCause(err)
+	}
+	userID := currentUserID(c)
+	recordAudit(c, &userID, models.AuditLogout, fiber.Map{"session_id": sessionID})
+
+	return c.Status(204).SendString("")
 }
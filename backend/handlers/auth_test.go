@@ -0,0 +1,87 @@
+package handlers_test
+
+import (
+	"testing"
+)
+
+func TestRefreshRotatesTokens(t *testing.T) {
+	app := newTestApp(t)
+	createUser(t, app, "alice@example.com")
+	first := login(t, app, "alice@example.com")
+
+	var second tokenPair
+	if status := doJSON(t, app, "POST", "/api/auth/refresh", "", map[string]string{
+		"refresh_token": first.RefreshToken,
+	}, &second); status != 200 {
+		t.Fatalf("refresh: status %d", status)
+	}
+	if second.RefreshToken == "" || second.RefreshToken == first.RefreshToken {
+		t.Fatalf("refresh token was not rotated: %q", second.RefreshToken)
+	}
+	if status := doJSON(t, app, "GET", "/api/user/me", second.Token, nil, nil); status != 200 {
+		t.Fatalf("new access token: status %d", status)
+	}
+
+	// The rotated token can be exchanged once more
+	var third tokenPair
+	if status := doJSON(t, app, "POST", "/api/auth/refresh", "", map[string]string{
+		"refresh_token": second.RefreshToken,
+	}, &third); status != 200 {
+		t.Fatalf("second refresh: status %d", status)
+	}
+}
+
+func TestRefreshTokenReuseRevokesSession(t *testing.T) {
+	app := newTestApp(t)
+	createUser(t, app, "alice@example.com")
+	first := login(t, app, "alice@example.com")
+	other := login(t, app, "alice@example.com")
+
+	var second tokenPair
+	if status := doJSON(t, app, "POST", "/api/auth/refresh", "", map[string]string{
+		"refresh_token": first.RefreshToken,
+	}, &second); status != 200 {
+		t.Fatalf("refresh: status %d", status)
+	}
+
+	// Presenting the rotated token again looks like a stolen copy
+	if status := doJSON(t, app, "POST", "/api/auth/refresh", "", map[string]string{
+		"refresh_token": first.RefreshToken,
+	}, nil); status != 401 {
+		t.Fatalf("reused refresh token: status %d, want 401", status)
+	}
+
+	// The whole session is gone, including the legitimate latest tokens
+	if status := doJSON(t, app, "POST", "/api/auth/refresh", "", map[string]string{
+		"refresh_token": second.RefreshToken,
+	}, nil); status != 401 {
+		t.Fatalf("refresh after reuse: status %d, want 401", status)
+	}
+	for _, token := range []string{first.Token, second.Token} {
+		if status := doJSON(t, app, "GET", "/api/user/me", token, nil, nil); status != 401 {
+			t.Fatalf("access token of the revoked session: status %d, want 401", status)
+		}
+	}
+
+	// Other sessions of the user are untouched
+	if status := doJSON(t, app, "GET", "/api/user/me", other.Token, nil, nil); status != 200 {
+		t.Fatalf("other session: status %d", status)
+	}
+}
+
+func TestRefreshRejectsUnknownToken(t *testing.T) {
+	app := newTestApp(t)
+	if status := doJSON(t, app, "POST", "/api/auth/refresh", "", map[string]string{
+		"refresh_token": "not-a-token",
+	}, nil); status != 401 {
+		t.Fatalf("unknown refresh token: status %d, want 401", status)
+	}
+}
+
+func TestLoginIgnoresEmailCase(t *testing.T) {
+	app := newTestApp(t)
+	createUser(t, app, "carol@example.com")
+	if status := loginStatus(t, app, " Carol@Example.COM", testPassword); status != 200 {
+		t.Fatalf("login with a differently cased email: status %d", status)
+	}
+}
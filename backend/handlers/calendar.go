@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"time"
+
+	"tonish/backend/apierror"
+	"tonish/backend/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const dateLayout = "2006-01-02"
+
+// startOfDay returns midnight at the start of t's day in loc.
+func startOfDay(t time.Time, loc *time.Location) time.Time {
+	t = t.In(loc)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+}
+
+// startOfWeek returns midnight at the start of the week containing t, for
+// weeks beginning on weekStart.
+func startOfWeek(t time.Time, loc *time.Location, weekStart time.Weekday) time.Time {
+	day := startOfDay(t, loc)
+	offset := (int(day.Weekday()) - int(weekStart) + 7) % 7
+	return day.AddDate(0, 0, -offset)
+}
+
+// normalizeDueDate stores due dates in UTC. SQLite compares timestamps as
+// text, so range queries are only correct when every row uses one offset.
+func normalizeDueDate(task *models.Task) {
+	if task.DueDate != nil {
+		due := task.DueDate.UTC()
+		task.DueDate = &due
+	}
+}
+
+// GetTodayTasks retrieves the tasks due today in the user's timezone
+func GetTodayTasks(c *fiber.Ctx) error {
+	settings := loadUserSettings(currentUserID(c))
+	start := startOfDay(time.Now(), settings.Location())
+	end := start.AddDate(0, 0, 1)
+
+	var tasks []models.Task
+	if err := userTasks(c).
+		Where("is_archived = ? AND due_date >= ? AND due_date < ?", false, start.UTC(), end.UTC()).
+		Order("due_date").
+		Find(&tasks).Error; err != nil {
+		return apierror.Internal("Failed to load tasks").WithCause(err)
+	}
+	attachBlockers(tasks)
+
+	return c.JSON(tasks)
+}
+
+// GetOverdueTasks retrieves unfinished tasks that were due before today in
+// the user's timezone
+func GetOverdueTasks(c *fiber.Ctx) error {
+	settings := loadUserSettings(currentUserID(c))
+	start := startOfDay(time.Now(), settings.Location())
+
+	var tasks []models.Task
+	if err := userTasks(c).
+		Where("is_archived = ? AND status != ? AND due_date < ?", false, "done", start.UTC()).
+		Order("due_date").
+		Find(&tasks).Error; err != nil {
+		return apierror.Internal("Failed to load tasks").WithCause(err)
+	}
+	attachBlockers(tasks)
+
+	return c.JSON(tasks)
+}
+
+// calendarRange reads the range of a calendar request as [start, end).
+func calendarRange(c *fiber.Ctx, settings models.UserSettings) (time.Time, time.Time, error) {
+	loc := settings.Location()
+	if month := c.Query("month"); month != "" {
+		first, err := time.ParseInLocation("2006-01", month, loc)
+		if err != nil {
+			return time.Time{}, time.Time{}, apierror.BadRequest("month must be formatted YYYY-MM")
+		}
+		last := first.AddDate(0, 1, -1)
+		start := startOfWeek(first, loc, time.Weekday(settings.WeekStart))
+		end := startOfWeek(last, loc, time.Weekday(settings.WeekStart)).AddDate(0, 0, 7)
+		return start, end, nil
+	}
+
+	start, err := time.ParseInLocation(dateLayout, c.Query("start"), loc)
+	if err != nil {
+		return time.Time{}, time.Time{}, apierror.BadRequest("Provide month=YYYY-MM or start and end as YYYY-MM-DD")
+	}
+	end, err := time.ParseInLocation(dateLayout, c.Query("end"), loc)
+	if err != nil {
+		return time.Time{}, time.Time{}, apierror.BadRequest("Provide month=YYYY-MM or start and end as YYYY-MM-DD")
+	}
+	end = end.AddDate(0, 0, 1)
+	if !end.After(start) {
+		return time.Time{}, time.Time{}, apierror.BadRequest("end must not be before start")
+	}
+	return start, end, nil
+}
+
+// GetCalendarTasks retrieves the tasks due within a calendar range. With
+// ?month=YYYY-MM the range is the month's grid, padded to whole weeks using
+// the user's week start; ?start=YYYY-MM-DD&end=YYYY-MM-DD gives an explicit,
+// inclusive range. Dates are interpreted in the user's timezone.
+func GetCalendarTasks(c *fiber.Ctx) error {
+	settings := loadUserSettings(currentUserID(c))
+	loc := settings.Location()
+
+	start, end, err := calendarRange(c, settings)
+	if err != nil {
+		return err
+	}
+
+	var tasks []models.Task
+	if err := userTasks(c).
+		Where("is_archived = ? AND due_date >= ? AND due_date < ?", false, start.UTC(), end.UTC()).
+		Order("due_date").
+		Find(&tasks).Error; err != nil {
+		return apierror.Internal("Failed to load tasks").WithCause(err)
+	}
+	attachBlockers(tasks)
+
+	return c.JSON(fiber.Map{
+		"start":    start.Format(dateLayout),
+		"end":      end.AddDate(0, 0, -1).Format(dateLayout),
+		"timezone": loc.String(),
+		"tasks":    tasks,
+	})
+}
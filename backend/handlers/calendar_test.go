@@ -0,0 +1,176 @@
+package handlers_test
+
+import (
+	"testing"
+	"time"
+
+	"tonish/backend/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// calendarTZ is far enough from UTC that the user's day and the UTC day
+// differ for most of every day.
+const calendarTZ = "Pacific/Kiritimati" // UTC+14, no daylight saving
+
+type profile struct {
+	Settings models.UserSettings `json:"settings"`
+}
+
+type calendarPage struct {
+	Start    string        `json:"start"`
+	End      string        `json:"end"`
+	Timezone string        `json:"timezone"`
+	Tasks    []models.Task `json:"tasks"`
+}
+
+func taskTitles(tasks []models.Task) map[string]bool {
+	titles := make(map[string]bool, len(tasks))
+	for _, task := range tasks {
+		titles[task.Title] = true
+	}
+	return titles
+}
+
+func expectTitles(t *testing.T, what string, tasks []models.Task, want ...string) {
+	t.Helper()
+	got := taskTitles(tasks)
+	if len(got) != len(want) {
+		t.Fatalf("%s: got %v, want %v", what, got, want)
+	}
+	for _, title := range want {
+		if !got[title] {
+			t.Fatalf("%s: got %v, want %v", what, got, want)
+		}
+	}
+}
+
+func dueTask(t *testing.T, app *fiber.App, token, title string, due time.Time, status string) {
+	t.Helper()
+	createTask(t, app, token, map[string]interface{}{
+		"title":    title,
+		"due_date": due.Format(time.RFC3339),
+		"status":   status,
+	})
+}
+
+func TestUserSettings(t *testing.T) {
+	app := newTestApp(t)
+	_, token := createUser(t, app, "alice@example.com")
+
+	var me profile
+	if status := doJSON(t, app, "GET", "/api/user/me", token, nil, &me); status != 200 {
+		t.Fatalf("get profile: status %d", status)
+	}
+	if me.Settings.Timezone != "UTC" || me.Settings.WeekStart != int(time.Monday) {
+		t.Fatalf("default settings: %+v", me.Settings)
+	}
+
+	if status := doJSON(t, app, "PATCH", "/api/user/me", token, map[string]interface{}{
+		"timezone":         calendarTZ,
+		"week_start":       0,
+		"default_currency": "eur",
+	}, &me); status != 200 {
+		t.Fatalf("update settings: status %d", status)
+	}
+	doJSON(t, app, "GET", "/api/user/me", token, nil, &me)
+	if me.Settings.Timezone != calendarTZ || me.Settings.WeekStart != 0 || me.Settings.DefaultCurrency != "EUR" {
+		t.Fatalf("stored settings: %+v", me.Settings)
+	}
+	if me.Settings.Locale != "en-US" {
+		t.Fatalf("untouched locale changed to %q", me.Settings.Locale)
+	}
+
+	var resp validationError
+	if status := doJSON(t, app, "PATCH", "/api/user/me", token, map[string]interface{}{
+		"timezone":   "Mars/Olympus_Mons",
+		"week_start": 7,
+	}, &resp); status != 422 {
+		t.Fatalf("invalid settings: status %d, want 422", status)
+	}
+	if resp.Errors["timezone"] == "" || resp.Errors["week_start"] == "" {
+		t.Fatalf("errors: %v", resp.Errors)
+	}
+	if status := doJSON(t, app, "PATCH", "/api/user/me", token, map[string]interface{}{"timezone": "Local"}, nil); status != 422 {
+		t.Fatalf("server-local timezone: status %d, want 422", status)
+	}
+}
+
+func TestTodayAndOverdueUseTheUserTimezone(t *testing.T) {
+	app := newTestApp(t)
+	_, token := createUser(t, app, "alice@example.com")
+	if status := doJSON(t, app, "PATCH", "/api/user/me", token, map[string]interface{}{"timezone": calendarTZ}, nil); status != 200 {
+		t.Fatalf("set timezone: status %d", status)
+	}
+
+	loc, err := time.LoadLocation(calendarTZ)
+	if err != nil {
+		t.Fatalf("load timezone: %v", err)
+	}
+	now := time.Now().In(loc)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+
+	// Sent as UTC, as clients usually do; only the user's timezone tells
+	// which day they fall on
+	dueTask(t, app, token, "first thing", today.Add(time.Minute).UTC(), "todo")
+	dueTask(t, app, token, "last thing", today.Add(24*time.Hour-time.Minute).UTC(), "todo")
+	dueTask(t, app, token, "last night", today.Add(-time.Minute).UTC(), "todo")
+	dueTask(t, app, token, "done last night", today.Add(-time.Minute).UTC(), "done")
+	dueTask(t, app, token, "tomorrow", today.Add(24*time.Hour).UTC(), "todo")
+
+	var tasks []models.Task
+	if status := doJSON(t, app, "GET", "/api/tasks/today", token, nil, &tasks); status != 200 {
+		t.Fatalf("today: status %d", status)
+	}
+	expectTitles(t, "today", tasks, "first thing", "last thing")
+
+	if status := doJSON(t, app, "GET", "/api/tasks/overdue", token, nil, &tasks); status != 200 {
+		t.Fatalf("overdue: status %d", status)
+	}
+	expectTitles(t, "overdue", tasks, "last night")
+}
+
+func TestCalendarUsesTheUserTimezone(t *testing.T) {
+	app := newTestApp(t)
+	_, token := createUser(t, app, "alice@example.com")
+	if status := doJSON(t, app, "PATCH", "/api/user/me", token, map[string]interface{}{
+		"timezone":   calendarTZ,
+		"week_start": int(time.Monday),
+	}, nil); status != 200 {
+		t.Fatalf("set timezone: status %d", status)
+	}
+
+	// The first two are both on 31 March in UTC, but 10:30 UTC is already
+	// 00:30 on 1 April in Kiritimati
+	dueTask(t, app, token, "april fools", time.Date(2026, 3, 31, 10, 30, 0, 0, time.UTC), "todo")
+	dueTask(t, app, token, "end of march", time.Date(2026, 3, 31, 9, 30, 0, 0, time.UTC), "todo")
+	dueTask(t, app, token, "end of april", time.Date(2026, 4, 30, 9, 59, 0, 0, time.UTC), "todo")
+	dueTask(t, app, token, "first of may", time.Date(2026, 4, 30, 10, 0, 0, 0, time.UTC), "todo")
+
+	var page calendarPage
+	if status := doJSON(t, app, "GET", "/api/tasks/calendar?start=2026-04-01&end=2026-04-30", token, nil, &page); status != 200 {
+		t.Fatalf("calendar range: status %d", status)
+	}
+	if page.Timezone != calendarTZ || page.Start != "2026-04-01" || page.End != "2026-04-30" {
+		t.Fatalf("range %s..%s in %s", page.Start, page.End, page.Timezone)
+	}
+	expectTitles(t, "April", page.Tasks, "april fools", "end of april")
+
+	if status := doJSON(t, app, "GET", "/api/tasks/calendar?start=2026-03-31&end=2026-03-31", token, nil, &page); status != 200 {
+		t.Fatalf("calendar day: status %d", status)
+	}
+	expectTitles(t, "31 March", page.Tasks, "end of march")
+
+	// The month grid is padded to whole weeks starting on Monday
+	if status := doJSON(t, app, "GET", "/api/tasks/calendar?month=2026-04", token, nil, &page); status != 200 {
+		t.Fatalf("calendar month: status %d", status)
+	}
+	if page.Start != "2026-03-30" || page.End != "2026-05-03" {
+		t.Fatalf("April grid %s..%s, want 2026-03-30..2026-05-03", page.Start, page.End)
+	}
+	expectTitles(t, "April grid", page.Tasks, "april fools", "end of march", "end of april", "first of may")
+
+	if status := doJSON(t, app, "GET", "/api/tasks/calendar?start=2026-04-30&end=2026-04-01", token, nil, nil); status != 400 {
+		t.Fatalf("reversed range: status %d, want 400", status)
+	}
+}
@@ -0,0 +1,319 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"tonish/backend/apierror"
+	"tonish/backend/database"
+	"tonish/backend/models"
+	ws "tonish/backend/websocket"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// maxChecklistItems bounds the checklist of one task.
+const maxChecklistItems = 200
+
+// errChecklistFull rejects an item beyond maxChecklistItems.
+var errChecklistFull = errors.New("checklist full")
+
+func init() {
+	apierror.Register(errChecklistFull, fiber.StatusConflict, "conflict",
+		fmt.Sprintf("A task can have at most %d checklist items", maxChecklistItems))
+}
+
+type CreateChecklistItemRequest struct {
+	Title    string `json:"title" validate:"required,max=500"`
+	Done     bool   `json:"done"`
+	Position *int   `json:"position" validate:"min=0"` // Insert before the item at this index; appends by default
+}
+
+// UpdateChecklistItemRequest renames or toggles an item.
+type UpdateChecklistItemRequest struct {
+	Title *string `json:"title" validate:"notblank,max=500"`
+	Done  *bool   `json:"done"`
+}
+
+type ReorderChecklistRequest struct {
+	IDs []uint `json:"ids" validate:"required"` // Every item of the task, in the new order
+}
+
+// checklistItems loads a task's items in order.
+func checklistItems(tx *gorm.DB, taskID uint) ([]models.ChecklistItem, error) {
+	items := []models.ChecklistItem{}
+	err := tx.Where("task_id = ?", taskID).Order("position, id").Find(&items).Error
+	return items, err
+}
+
+// refreshProgress recounts a task's checklist into its progress. The task's
+// representation changes, so its version is bumped.
+func refreshProgress(tx *gorm.DB, taskID uint) error {
+	return tx.Model(&models.Task{}).Where("id = ?", taskID).Updates(map[string]interface{}{
+		"checklist_done":  tx.Model(&models.ChecklistItem{}).Select("COUNT(*)").Where("task_id = ? AND done = ?", taskID, true),
+		"checklist_total": tx.Model(&models.ChecklistItem{}).Select("COUNT(*)").Where("task_id = ?", taskID),
+		"version":         gorm.Expr("version + 1"),
+		"updated_at":      time.Now(),
+	}).Error
+}
+
+// completeChecklist ticks every open item of a task. The caller saves the
+// task with its progress.
+func completeChecklist(tx *gorm.DB, task *models.Task) error {
+	if err := tx.Model(&models.ChecklistItem{}).Where("task_id = ? AND done = ?", task.ID, false).
+		Updates(map[string]interface{}{"done": true, "completed_at": time.Now()}).Error; err != nil {
+		return err
+	}
+	task.Progress.Done = task.Progress.Total
+	return nil
+}
+
+// cascadeCompletion reports whether a write completing a task asked for its
+// checklist to be completed too, with ?complete_checklist=true.
+func cascadeCompletion(c *fiber.Ctx, task *models.Task, previouslyCompleted bool) bool {
+	return c.QueryBool("complete_checklist") && task.Status == "done" && !previouslyCompleted &&
+		task.Progress.Done < task.Progress.Total
+}
+
+// broadcastChecklist sends a task's new progress and its items to the owner's
+// clients.
+func broadcastChecklist(taskID uint) {
+	if ws.GlobalHub == nil {
+		return
+	}
+	var task models.Task
+	if err := database.DB.First(&task, taskID).Error; err != nil {
+		return
+	}
+	attachTaskBlockers(&task)
+	ws.GlobalHub.BroadcastToUser(task.UserID, ws.MessageTypeTaskUpdate, task)
+	broadcastChecklistItems(task.UserID, task.ID)
+}
+
+// broadcastChecklistItems sends a task's items to the owner's clients.
+func broadcastChecklistItems(userID, taskID uint) {
+	if ws.GlobalHub == nil {
+		return
+	}
+	items, err := checklistItems(database.DB, taskID)
+	if err != nil {
+		return
+	}
+	ws.GlobalHub.BroadcastToUser(userID, ws.MessageTypeChecklistUpdate, fiber.Map{"task_id": taskID, "items": items})
+}
+
+// findChecklistTask loads the task named by :id for a checklist endpoint.
+func findChecklistTask(c *fiber.Ctx, task *models.Task) error {
+	if err := findUserTask(c, c.Params("id"), task, false); err != nil {
+		return apierror.NotFound("Task not found")
+	}
+	return nil
+}
+
+// findChecklistItem loads the item named by :itemId on task.
+func findChecklistItem(c *fiber.Ctx, task *models.Task, item *models.ChecklistItem) error {
+	if err := database.DB.Where("id = ? AND task_id = ?", c.Params("itemId"), task.ID).First(item).Error; err != nil {
+		return apierror.NotFound("Checklist item not found")
+	}
+	return nil
+}
+
+// GetChecklist lists a task's checklist items in order
+func GetChecklist(c *fiber.Ctx) error {
+	var task models.Task
+	if err := findChecklistTask(c, &task); err != nil {
+		return err
+	}
+	items, err := checklistItems(database.DB, task.ID)
+	if err != nil {
+		return apierror.Internal("Failed to load checklist").WithCause(err)
+	}
+	return c.JSON(items)
+}
+
+// AddChecklistItem adds an item to a task's checklist, at the end unless a
+// position is given
+func AddChecklistItem(c *fiber.Ctx) error {
+	var task models.Task
+	if err := findChecklistTask(c, &task); err != nil {
+		return err
+	}
+
+	req := new(CreateChecklistItemRequest)
+	if err := parseBody(c, req); err != nil {
+		return err
+	}
+
+	item := models.ChecklistItem{TaskID: task.ID, Title: strings.TrimSpace(req.Title), Done: req.Done}
+	if item.Done {
+		now := time.Now()
+		item.CompletedAt = &now
+	}
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&models.ChecklistItem{}).Where("task_id = ?", task.ID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count >= maxChecklistItems {
+			return errChecklistFull
+		}
+
+		var last struct{ Position *int }
+		if err := tx.Model(&models.ChecklistItem{}).Select("MAX(position) AS position").
+			Where("task_id = ?", task.ID).Scan(&last).Error; err != nil {
+			return err
+		}
+		item.Position = 0
+		if last.Position != nil {
+			item.Position = *last.Position + 1
+		}
+		if req.Position != nil && *req.Position < item.Position {
+			item.Position = *req.Position
+			if err := tx.Model(&models.ChecklistItem{}).Where("task_id = ? AND position >= ?", task.ID, item.Position).
+				Update("position", gorm.Expr("position + 1")).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Create(&item).Error; err != nil {
+			return err
+		}
+		return refreshProgress(tx, task.ID)
+	})
+	if err != nil {
+		return apierror.Internal("Failed to add checklist item").WithCause(err)
+	}
+
+	broadcastChecklist(task.ID)
+
+	return c.Status(201).JSON(item)
+}
+
+// UpdateChecklistItem renames an item or ticks it off
+func UpdateChecklistItem(c *fiber.Ctx) error {
+	var task models.Task
+	if err := findChecklistTask(c, &task); err != nil {
+		return err
+	}
+	var item models.ChecklistItem
+	if err := findChecklistItem(c, &task, &item); err != nil {
+		return err
+	}
+
+	req := new(UpdateChecklistItemRequest)
+	if err := parseBody(c, req); err != nil {
+		return err
+	}
+
+	if req.Title != nil {
+		item.Title = strings.TrimSpace(*req.Title)
+	}
+	if req.Done != nil && *req.Done != item.Done {
+		item.Done = *req.Done
+		item.CompletedAt = nil
+		if item.Done {
+			now := time.Now()
+			item.CompletedAt = &now
+		}
+	}
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&item).Select("title", "done", "completed_at").Updates(&item).Error; err != nil {
+			return err
+		}
+		return refreshProgress(tx, task.ID)
+	})
+	if err != nil {
+		return apierror.Internal("Failed to update checklist item").WithCause(err)
+	}
+
+	broadcastChecklist(task.ID)
+
+	return c.JSON(item)
+}
+
+// ReorderChecklist puts a task's items in the given order
+func ReorderChecklist(c *fiber.Ctx) error {
+	var task models.Task
+	if err := findChecklistTask(c, &task); err != nil {
+		return err
+	}
+
+	req := new(ReorderChecklistRequest)
+	if err := parseBody(c, req); err != nil {
+		return err
+	}
+
+	current, err := checklistItems(database.DB, task.ID)
+	if err != nil {
+		return apierror.Internal("Failed to reorder checklist").WithCause(err)
+	}
+
+	// The order must name every item exactly once
+	positions := map[uint]int{}
+	for i, id := range req.IDs {
+		positions[id] = i
+	}
+	complete := len(req.IDs) == len(current) && len(positions) == len(current)
+	for _, item := range current {
+		if _, ok := positions[item.ID]; !ok {
+			complete = false
+		}
+	}
+	if !complete {
+		return apierror.Validation(map[string]string{"ids": "must list every item of the task exactly once"})
+	}
+
+	var items []models.ChecklistItem
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		for _, item := range current {
+			if err := tx.Model(&item).Update("position", positions[item.ID]).Error; err != nil {
+				return err
+			}
+		}
+		items, err = checklistItems(tx, task.ID)
+		return err
+	})
+	if err != nil {
+		return apierror.Internal("Failed to reorder checklist").WithCause(err)
+	}
+
+	broadcastChecklist(task.ID)
+
+	return c.JSON(items)
+}
+
+// DeleteChecklistItem removes an item from a task's checklist
+func DeleteChecklistItem(c *fiber.Ctx) error {
+	var task models.Task
+	if err := findChecklistTask(c, &task); err != nil {
+		return err
+	}
+	var item models.ChecklistItem
+	if err := findChecklistItem(c, &task, &item); err != nil {
+		return err
+	}
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&item).Error; err != nil {
+			return err
+		}
+		// Close the gap so positions stay 0..n-1
+		if err := tx.Model(&models.ChecklistItem{}).Where("task_id = ? AND position > ?", task.ID, item.Position).
+			Update("position", gorm.Expr("position - 1")).Error; err != nil {
+			return err
+		}
+		return refreshProgress(tx, task.ID)
+	})
+	if err != nil {
+		return apierror.Internal("Failed to delete checklist item").WithCause(err)
+	}
+	recordChange(c, models.AuditChecklistDelete, "checklist_item", item.ID, item, nil)
+
+	broadcastChecklist(task.ID)
+
+	return c.Status(204).SendString("")
+}
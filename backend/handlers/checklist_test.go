@@ -0,0 +1,233 @@
+package handlers_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"tonish/backend/database"
+	"tonish/backend/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func addItem(t *testing.T, app *fiber.App, token string, taskID uint, body map[string]interface{}) models.ChecklistItem {
+	t.Helper()
+	var item models.ChecklistItem
+	if status := doJSON(t, app, "POST", fmt.Sprintf("/api/tasks/%d/items", taskID), token, body, &item); status != 201 {
+		t.Fatalf("add item %v: status %d", body, status)
+	}
+	return item
+}
+
+// checklist returns the titles of a task's items in order, checking that
+// positions run 0..n-1.
+func checklist(t *testing.T, app *fiber.App, token string, taskID uint) []string {
+	t.Helper()
+	var items []models.ChecklistItem
+	if status := doJSON(t, app, "GET", fmt.Sprintf("/api/tasks/%d/items", taskID), token, nil, &items); status != 200 {
+		t.Fatalf("get checklist: status %d", status)
+	}
+	titles := make([]string, len(items))
+	for i, item := range items {
+		if item.Position != i {
+			t.Fatalf("item %q at position %d, want %d", item.Title, item.Position, i)
+		}
+		titles[i] = item.Title
+	}
+	return titles
+}
+
+func expectProgress(t *testing.T, app *fiber.App, token string, taskID uint, done, total int) models.Task {
+	t.Helper()
+	var task models.Task
+	doJSON(t, app, "GET", fmt.Sprintf("/api/tasks/%d", taskID), token, nil, &task)
+	if task.Progress.Done != done || task.Progress.Total != total {
+		t.Fatalf("progress %d/%d, want %d/%d", task.Progress.Done, task.Progress.Total, done, total)
+	}
+	return task
+}
+
+func TestChecklistAdd(t *testing.T) {
+	app := newTestApp(t)
+	_, token := createUser(t, app, "alice@example.com")
+	task := createTask(t, app, token, map[string]interface{}{"title": "Prepare tax return"})
+
+	addItem(t, app, token, task.ID, map[string]interface{}{"title": "Collect receipts"})
+	addItem(t, app, token, task.ID, map[string]interface{}{"title": "Fill in forms"})
+	addItem(t, app, token, task.ID, map[string]interface{}{"title": "Find last year's return", "position": 0})
+	addItem(t, app, token, task.ID, map[string]interface{}{"title": "Submit", "position": 99, "done": true})
+
+	got := strings.Join(checklist(t, app, token, task.ID), ", ")
+	if want := "Find last year's return, Collect receipts, Fill in forms, Submit"; got != want {
+		t.Fatalf("checklist %s, want %s", got, want)
+	}
+	// Every change to the checklist changes the task's representation
+	if stored := expectProgress(t, app, token, task.ID, 1, 4); stored.Version != task.Version+4 {
+		t.Fatalf("version %d, want %d", stored.Version, task.Version+4)
+	}
+
+	var resp validationError
+	if status := doJSON(t, app, "POST", fmt.Sprintf("/api/tasks/%d/items", task.ID), token, map[string]interface{}{"title": "", "position": -1}, &resp); status != 422 {
+		t.Fatalf("invalid item: status %d, want 422", status)
+	}
+	if resp.Errors["title"] == "" || resp.Errors["position"] == "" {
+		t.Fatalf("errors %v", resp.Errors)
+	}
+
+	_, bobToken := createUser(t, app, "bob@example.com")
+	if status := doJSON(t, app, "POST", fmt.Sprintf("/api/tasks/%d/items", task.ID), bobToken, map[string]interface{}{"title": "Sneak in"}, nil); status != 404 {
+		t.Fatalf("add to another user's task: status %d, want 404", status)
+	}
+}
+
+func TestChecklistLimit(t *testing.T) {
+	app := newTestApp(t)
+	_, token := createUser(t, app, "alice@example.com")
+	task := createTask(t, app, token, map[string]interface{}{"title": "Pack for the move"})
+
+	items := make([]models.ChecklistItem, 200)
+	for i := range items {
+		items[i] = models.ChecklistItem{TaskID: task.ID, Title: fmt.Sprintf("Box %d", i), Position: i}
+	}
+	database.DB.Create(&items)
+
+	var resp struct {
+		Detail string `json:"detail"`
+	}
+	if status := doJSON(t, app, "POST", fmt.Sprintf("/api/tasks/%d/items", task.ID), token, map[string]interface{}{"title": "One more box"}, &resp); status != 409 {
+		t.Fatalf("item beyond the limit: status %d, want 409", status)
+	}
+	if resp.Detail != "A task can have at most 200 checklist items" {
+		t.Fatalf("detail %q", resp.Detail)
+	}
+}
+
+func TestChecklistToggle(t *testing.T) {
+	app := newTestApp(t)
+	_, token := createUser(t, app, "alice@example.com")
+	task := createTask(t, app, token, map[string]interface{}{"title": "Prepare tax return"})
+	item := addItem(t, app, token, task.ID, map[string]interface{}{"title": "Collect receipts"})
+	addItem(t, app, token, task.ID, map[string]interface{}{"title": "Fill in forms"})
+	path := fmt.Sprintf("/api/tasks/%d/items/%d", task.ID, item.ID)
+
+	var updated models.ChecklistItem
+	if status := doJSON(t, app, "PATCH", path, token, map[string]interface{}{"done": true}, &updated); status != 200 {
+		t.Fatalf("tick: status %d", status)
+	}
+	if !updated.Done || updated.CompletedAt == nil || updated.Title != "Collect receipts" {
+		t.Fatalf("ticked item %+v", updated)
+	}
+	expectProgress(t, app, token, task.ID, 1, 2)
+
+	if status := doJSON(t, app, "PATCH", path, token, map[string]interface{}{"done": false, "title": "Collect all receipts"}, &updated); status != 200 {
+		t.Fatalf("untick: status %d", status)
+	}
+	if updated.Done || updated.CompletedAt != nil || updated.Title != "Collect all receipts" {
+		t.Fatalf("unticked item %+v", updated)
+	}
+	expectProgress(t, app, token, task.ID, 0, 2)
+
+	if status := doJSON(t, app, "PATCH", path, token, map[string]interface{}{"title": ""}, nil); status != 422 {
+		t.Fatalf("blank title: status %d, want 422", status)
+	}
+
+	// Items are only reachable through their own task
+	other := createTask(t, app, token, map[string]interface{}{"title": "Other"})
+	if status := doJSON(t, app, "PATCH", fmt.Sprintf("/api/tasks/%d/items/%d", other.ID, item.ID), token, map[string]interface{}{"done": true}, nil); status != 404 {
+		t.Fatalf("item through another task: status %d, want 404", status)
+	}
+}
+
+func TestChecklistReorder(t *testing.T) {
+	app := newTestApp(t)
+	_, token := createUser(t, app, "alice@example.com")
+	task := createTask(t, app, token, map[string]interface{}{"title": "Prepare tax return"})
+	a := addItem(t, app, token, task.ID, map[string]interface{}{"title": "a"})
+	b := addItem(t, app, token, task.ID, map[string]interface{}{"title": "b"})
+	c := addItem(t, app, token, task.ID, map[string]interface{}{"title": "c"})
+	foreign := addItem(t, app, token, createTask(t, app, token, map[string]interface{}{"title": "Other"}).ID, map[string]interface{}{"title": "x"})
+	path := fmt.Sprintf("/api/tasks/%d/items/order", task.ID)
+
+	bad := map[string][]uint{
+		"missing an item":     {c.ID, a.ID},
+		"an item twice":       {c.ID, a.ID, a.ID},
+		"too many ids":        {c.ID, a.ID, a.ID, b.ID},
+		"another task's item": {c.ID, a.ID, foreign.ID},
+		"empty":               {},
+	}
+	for name, ids := range bad {
+		if status := doJSON(t, app, "PUT", path, token, map[string]interface{}{"ids": ids}, nil); status != 422 {
+			t.Errorf("order with %s: status %d, want 422", name, status)
+		}
+	}
+	if got := strings.Join(checklist(t, app, token, task.ID), ""); got != "abc" {
+		t.Fatalf("checklist after rejected orders %s, want abc", got)
+	}
+
+	var items []models.ChecklistItem
+	if status := doJSON(t, app, "PUT", path, token, map[string]interface{}{"ids": []uint{c.ID, a.ID, b.ID}}, &items); status != 200 || len(items) != 3 {
+		t.Fatalf("reorder: status %d, %d items", status, len(items))
+	}
+	if got := strings.Join(checklist(t, app, token, task.ID), ""); got != "cab" {
+		t.Fatalf("checklist %s, want cab", got)
+	}
+}
+
+func TestChecklistDelete(t *testing.T) {
+	app := newTestApp(t)
+	_, token := createUser(t, app, "alice@example.com")
+	task := createTask(t, app, token, map[string]interface{}{"title": "Prepare tax return"})
+	addItem(t, app, token, task.ID, map[string]interface{}{"title": "a", "done": true})
+	b := addItem(t, app, token, task.ID, map[string]interface{}{"title": "b", "done": true})
+	addItem(t, app, token, task.ID, map[string]interface{}{"title": "c"})
+	path := fmt.Sprintf("/api/tasks/%d/items/%d", task.ID, b.ID)
+
+	if status := doJSON(t, app, "DELETE", path, token, nil, nil); status != 204 {
+		t.Fatalf("delete: status %d", status)
+	}
+	// The gap closes, so positions stay 0..n-1
+	if got := strings.Join(checklist(t, app, token, task.ID), ""); got != "ac" {
+		t.Fatalf("checklist %s, want ac", got)
+	}
+	expectProgress(t, app, token, task.ID, 1, 2)
+
+	if status := doJSON(t, app, "DELETE", path, token, nil, nil); status != 404 {
+		t.Fatalf("delete again: status %d, want 404", status)
+	}
+}
+
+func TestCompleteChecklistWithTask(t *testing.T) {
+	app := newTestApp(t)
+	_, token := createUser(t, app, "alice@example.com")
+	task := createTask(t, app, token, map[string]interface{}{"title": "Prepare tax return"})
+	addItem(t, app, token, task.ID, map[string]interface{}{"title": "a", "done": true})
+	addItem(t, app, token, task.ID, map[string]interface{}{"title": "b"})
+	other := createTask(t, app, token, map[string]interface{}{"title": "Pack"})
+	addItem(t, app, token, other.ID, map[string]interface{}{"title": "c"})
+
+	// Completing a task leaves its checklist alone unless asked
+	var done models.Task
+	if status := doJSON(t, app, "PATCH", fmt.Sprintf("/api/tasks/%d", other.ID), token, map[string]interface{}{"status": "done"}, &done); status != 200 {
+		t.Fatalf("complete: status %d", status)
+	}
+	if done.Progress.Done != 0 || done.Progress.Total != 1 {
+		t.Fatalf("progress without the cascade %d/%d", done.Progress.Done, done.Progress.Total)
+	}
+
+	if status := doJSON(t, app, "PATCH", fmt.Sprintf("/api/tasks/%d?complete_checklist=true", task.ID), token, map[string]interface{}{"status": "done"}, &done); status != 200 {
+		t.Fatalf("complete with the checklist: status %d", status)
+	}
+	if done.Progress.Done != 2 || done.Progress.Total != 2 {
+		t.Fatalf("response progress %d/%d, want 2/2", done.Progress.Done, done.Progress.Total)
+	}
+	expectProgress(t, app, token, task.ID, 2, 2)
+
+	var items []models.ChecklistItem
+	doJSON(t, app, "GET", fmt.Sprintf("/api/tasks/%d/items", task.ID), token, nil, &items)
+	for _, item := range items {
+		if !item.Done || item.CompletedAt == nil {
+			t.Fatalf("item left open: %+v", item)
+		}
+	}
+}
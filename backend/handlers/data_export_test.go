@@ -0,0 +1,239 @@
+package handlers_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"tonish/backend/database"
+	"tonish/backend/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// download fetches path and returns the status, content type and body.
+func download(t *testing.T, app *fiber.App, path, token string) (int, string, []byte) {
+	t.Helper()
+	req := httptest.NewRequest("GET", path, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("GET %s: %v", path, err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, resp.Header.Get("Content-Type"), body
+}
+
+// awaitExport polls an export until its background job has finished.
+func awaitExport(t *testing.T, app *fiber.App, token string, id uint) models.DataExport {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var export models.DataExport
+		if status := doJSON(t, app, "GET", fmt.Sprintf("/api/user/exports/%d", id), token, nil, &export); status != 200 {
+			t.Fatalf("get export: status %d", status)
+		}
+		if export.Status == models.ExportReady || export.Status == models.ExportFailed {
+			return export
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("export still %s", export.Status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestDataExportRoundTrip(t *testing.T) {
+	app := newTestApp(t)
+	_, token := createUser(t, app, "alice@example.com")
+	_, bobToken := createUser(t, app, "bob@example.com")
+	createTask(t, app, token, map[string]interface{}{"title": "Water plants"})
+	if status := doJSON(t, app, "POST", "/api/notebooks", token, map[string]string{"name": "Garden diary"}, nil); status != 201 {
+		t.Fatalf("create notebook: status %d", status)
+	}
+
+	var export models.DataExport
+	if status := doJSON(t, app, "POST", "/api/user/exports", token, nil, &export); status != 202 {
+		t.Fatalf("start export: status %d", status)
+	}
+	export = awaitExport(t, app, token, export.ID)
+	if export.Status != models.ExportReady || export.Size == 0 || export.ExpiresAt == nil {
+		t.Fatalf("finished export: %+v", export)
+	}
+
+	path := fmt.Sprintf("/api/user/exports/%d/download", export.ID)
+	if status, _, _ := download(t, app, path, bobToken); status != 404 {
+		t.Fatalf("download by another user: status %d, want 404", status)
+	}
+	status, contentType, body := download(t, app, path, token)
+	if status != 200 || contentType != "application/zip" || len(body) != export.Size {
+		t.Fatalf("download: status %d, type %q, %d bytes of %d", status, contentType, len(body), export.Size)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatalf("open archive: %v", err)
+	}
+	files := make(map[string]*zip.File)
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+	if len(files) != 2 || files["export.json"] == nil {
+		t.Fatalf("archive files: %v", files)
+	}
+
+	rc, err := files["export.json"].Open()
+	if err != nil {
+		t.Fatalf("open export.json: %v", err)
+	}
+	defer rc.Close()
+	var archive struct {
+		User  models.User   `json:"user"`
+		Tasks []models.Task `json:"tasks"`
+	}
+	if err := json.NewDecoder(rc).Decode(&archive); err != nil {
+		t.Fatalf("decode export.json: %v", err)
+	}
+	if archive.User.Email != "alice@example.com" || len(archive.Tasks) != 1 || archive.Tasks[0].Title != "Water plants" {
+		t.Fatalf("export.json: user %q, tasks %+v", archive.User.Email, archive.Tasks)
+	}
+	var notebook bool
+	for name := range files {
+		notebook = notebook || strings.HasSuffix(name, "-Garden-diary.md")
+	}
+	if !notebook {
+		t.Fatalf("no notebook file in %v", files)
+	}
+}
+
+func TestDataExportStates(t *testing.T) {
+	app := newTestApp(t)
+	alice, token := createUser(t, app, "alice@example.com")
+
+	pending := models.DataExport{UserID: alice.ID, Status: models.ExportPending}
+	database.DB.Create(&pending)
+
+	if status := doJSON(t, app, "POST", "/api/user/exports", token, nil, nil); status != 409 {
+		t.Fatalf("second export while one is pending: status %d, want 409", status)
+	}
+	var resp struct {
+		ExportStatus string `json:"export_status"`
+	}
+	if status := doJSON(t, app, "GET", fmt.Sprintf("/api/user/exports/%d/download", pending.ID), token, nil, &resp); status != 409 || resp.ExportStatus != models.ExportPending {
+		t.Fatalf("download of a pending export: status %d, %+v", status, resp)
+	}
+
+	// Expired archives cannot be downloaded and are cleared by the next export
+	expired := time.Now().Add(-time.Hour)
+	database.DB.Model(&pending).Updates(map[string]interface{}{
+		"status":     models.ExportReady,
+		"archive":    []byte("zip"),
+		"expires_at": expired,
+	})
+	if status := doJSON(t, app, "GET", fmt.Sprintf("/api/user/exports/%d/download", pending.ID), token, nil, nil); status != 410 {
+		t.Fatalf("download of an expired export: status %d, want 410", status)
+	}
+
+	var next models.DataExport
+	if status := doJSON(t, app, "POST", "/api/user/exports", token, nil, &next); status != 202 {
+		t.Fatalf("start export: status %d", status)
+	}
+	awaitExport(t, app, token, next.ID)
+	var exports []models.DataExport
+	doJSON(t, app, "GET", "/api/user/exports", token, nil, &exports)
+	if len(exports) != 1 || exports[0].ID != next.ID {
+		t.Fatalf("exports after cleanup: %+v", exports)
+	}
+
+	// Exports are only available to signed-in sessions
+	pat, _ := createToken(t, app, token, models.TokenScopes...)
+	if status := doJSON(t, app, "POST", "/api/user/exports", pat, nil, nil); status != 403 {
+		t.Fatalf("export with a personal access token: status %d, want 403", status)
+	}
+}
+
+// confirmDeletion exchanges the password for an account deletion token.
+func confirmDeletion(t *testing.T, app *fiber.App, token string) string {
+	t.Helper()
+	var resp struct {
+		ConfirmationToken string `json:"confirmation_token"`
+	}
+	if status := doJSON(t, app, "POST", "/api/user/delete", token, map[string]string{"password": testPassword}, &resp); status != 200 {
+		t.Fatalf("confirm deletion: status %d", status)
+	}
+	return resp.ConfirmationToken
+}
+
+func TestDeleteAccount(t *testing.T) {
+	app := newTestApp(t)
+	alice, token := createUser(t, app, "alice@example.com")
+	_, bobToken := createUser(t, app, "bob@example.com")
+	task := createTask(t, app, token, map[string]interface{}{"title": "Water plants"})
+	bobTask := createTask(t, app, bobToken, map[string]interface{}{"title": "Feed cat"})
+
+	if status := doJSON(t, app, "POST", "/api/user/delete", token, map[string]string{"password": "wrong-password-1"}, nil); status != 401 {
+		t.Fatalf("confirm with a wrong password: status %d, want 401", status)
+	}
+	if status := doJSON(t, app, "DELETE", "/api/user/me", token, map[string]string{}, nil); status != 422 {
+		t.Fatalf("delete without confirmation: status %d, want 422", status)
+	}
+
+	// A confirmation is bound to the account that asked for it
+	bobConfirmation := confirmDeletion(t, app, bobToken)
+	if status := doJSON(t, app, "DELETE", "/api/user/me", token, map[string]string{"confirmation_token": bobConfirmation}, nil); status != 403 {
+		t.Fatalf("delete with another user's confirmation: status %d, want 403", status)
+	}
+	// and is not an access token
+	if status := doJSON(t, app, "GET", "/api/user/me", bobConfirmation, nil, nil); status != 401 {
+		t.Fatalf("confirmation used as an access token: status %d, want 401", status)
+	}
+
+	confirmation := confirmDeletion(t, app, token)
+	if status := doJSON(t, app, "DELETE", "/api/user/me", token, map[string]string{"confirmation_token": confirmation}, nil); status != 204 {
+		t.Fatalf("delete account: status %d", status)
+	}
+
+	if status := doJSON(t, app, "GET", "/api/user/me", token, nil, nil); status != 401 {
+		t.Fatalf("deleted user's token: status %d, want 401", status)
+	}
+	if status := loginStatus(t, app, "alice@example.com", testPassword); status != 401 {
+		t.Fatalf("deleted user's login: status %d, want 401", status)
+	}
+	var left int64
+	database.DB.Unscoped().Model(&models.Task{}).Where("id = ?", task.ID).Count(&left)
+	if left != 0 {
+		t.Fatal("deleted user's task survived")
+	}
+	database.DB.Model(&models.Session{}).Where("user_id = ?", alice.ID).Count(&left)
+	if left != 0 {
+		t.Fatal("deleted user's sessions survived")
+	}
+	if status := doJSON(t, app, "GET", fmt.Sprintf("/api/tasks/%d", bobTask.ID), bobToken, nil, nil); status != 200 {
+		t.Fatalf("other user's task: status %d", status)
+	}
+}
+
+func TestDeleteLastAdminAccount(t *testing.T) {
+	app := newTestApp(t)
+	_, token := createAdmin(t, app, "admin@example.com")
+
+	if status := doJSON(t, app, "DELETE", "/api/user/me", token, map[string]string{
+		"confirmation_token": confirmDeletion(t, app, token),
+	}, nil); status != 409 {
+		t.Fatalf("delete the last admin: status %d, want 409", status)
+	}
+
+	createAdmin(t, app, "other@example.com")
+	if status := doJSON(t, app, "DELETE", "/api/user/me", token, map[string]string{
+		"confirmation_token": confirmDeletion(t, app, token),
+	}, nil); status != 204 {
+		t.Fatalf("delete an admin with another left: status %d", status)
+	}
+}
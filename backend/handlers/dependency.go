@@ -0,0 +1,219 @@
+package handlers
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"tonish/backend/apierror"
+	"tonish/backend/database"
+	"tonish/backend/models"
+	ws "tonish/backend/websocket"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// errDependencyCycle rejects a link that would make a task wait for itself.
+var errDependencyCycle = errors.New("dependency cycle")
+
+func init() {
+	apierror.Register(errDependencyCycle, fiber.StatusConflict, "dependency_cycle", "The blocker already waits for this task")
+}
+
+type LinkTaskRequest struct {
+	BlockerID uint `json:"blocker_id" validate:"required"` // The task that must be done first
+}
+
+// attachBlockers fills in the blockers of tasks and whether each is blocked.
+// Deleted blockers are left out.
+func attachBlockers(tasks []models.Task) {
+	if len(tasks) == 0 {
+		return
+	}
+	ids := make([]uint, len(tasks))
+	for i, task := range tasks {
+		ids[i] = task.ID
+	}
+
+	var rows []struct {
+		TaskID uint
+		models.TaskBlocker
+	}
+	database.DB.Table("task_dependencies").
+		Select("task_dependencies.task_id, tasks.id, tasks.title, tasks.status").
+		Joins("JOIN tasks ON tasks.id = task_dependencies.blocker_id AND tasks.deleted_at IS NULL").
+		Where("task_dependencies.task_id IN ?", ids).
+		Order("tasks.id").Scan(&rows)
+
+	blockers := map[uint][]models.TaskBlocker{}
+	for _, row := range rows {
+		blockers[row.TaskID] = append(blockers[row.TaskID], row.TaskBlocker)
+	}
+	for i := range tasks {
+		tasks[i].Blockers = []models.TaskBlocker{}
+		tasks[i].Blocked = false
+		for _, blocker := range blockers[tasks[i].ID] {
+			tasks[i].Blockers = append(tasks[i].Blockers, blocker)
+			if blocker.Status != "done" {
+				tasks[i].Blocked = true
+			}
+		}
+	}
+}
+
+// attachTaskBlockers fills in the blockers of a single task.
+func attachTaskBlockers(task *models.Task) {
+	tasks := []models.Task{*task}
+	attachBlockers(tasks)
+	task.Blockers, task.Blocked = tasks[0].Blockers, tasks[0].Blocked
+}
+
+// checkStartable refuses to move a blocked task to in-progress unless the
+// request passes ?force=true.
+func checkStartable(c *fiber.Ctx, task *models.Task, previousStatus string) error {
+	if task.Status != "in-progress" || previousStatus == "in-progress" || !task.Blocked || c.QueryBool("force") {
+		return nil
+	}
+	open := []models.TaskBlocker{}
+	for _, blocker := range task.Blockers {
+		if blocker.Status != "done" {
+			open = append(open, blocker)
+		}
+	}
+	return apierror.New(fiber.StatusConflict, "task_blocked", "The task waits for unfinished tasks; pass force=true to start it anyway").
+		With("blockers", open)
+}
+
+// dependentsOf lists the ids of the tasks waiting for taskID.
+func dependentsOf(taskID uint) []uint {
+	var ids []uint
+	database.DB.Model(&models.TaskDependency{}).Where("blocker_id = ?", taskID).Pluck("task_id", &ids)
+	return ids
+}
+
+// broadcastTaskUpdates sends the current state of the given tasks to the
+// owner's clients, typically dependents whose blocked flag changed.
+func broadcastTaskUpdates(userID uint, ids []uint) {
+	if ws.GlobalHub == nil || len(ids) == 0 {
+		return
+	}
+	var tasks []models.Task
+	if err := database.DB.Where("id IN ? AND user_id = ?", ids, userID).Find(&tasks).Error; err != nil {
+		return
+	}
+	attachBlockers(tasks)
+	for _, task := range tasks {
+		ws.GlobalHub.BroadcastToUser(userID, ws.MessageTypeTaskUpdate, task)
+	}
+}
+
+// waitsFor reports whether taskID already waits for blockerID, directly or
+// through other tasks.
+func waitsFor(tx *gorm.DB, taskID, blockerID uint) (bool, error) {
+	var count int64
+	err := tx.Raw(`WITH RECURSIVE chain(id) AS (
+			SELECT blocker_id FROM task_dependencies WHERE task_id = ?
+			UNION
+			SELECT task_dependencies.blocker_id FROM task_dependencies JOIN chain ON task_dependencies.task_id = chain.id
+		)
+		SELECT COUNT(*) FROM chain WHERE id = ?`, taskID, blockerID).Scan(&count).Error
+	return count > 0, err
+}
+
+// touchTask bumps a task's version after its blockers changed.
+func touchTask(tx *gorm.DB, taskID uint) error {
+	return tx.Model(&models.Task{}).Where("id = ?", taskID).Updates(map[string]interface{}{
+		"version":    gorm.Expr("version + 1"),
+		"updated_at": time.Now(),
+	}).Error
+}
+
+// respondWithTask reloads a task after a change to its blockers, broadcasts
+// it and returns it.
+func respondWithTask(c *fiber.Ctx, taskID uint) error {
+	var task models.Task
+	if err := findUserTask(c, strconv.FormatUint(uint64(taskID), 10), &task, false); err != nil {
+		return apierror.NotFound("Task not found")
+	}
+
+	if ws.GlobalHub != nil {
+		ws.GlobalHub.BroadcastToUser(task.UserID, ws.MessageTypeTaskUpdate, task)
+	}
+
+	setETag(c, task.Version)
+	return c.JSON(task)
+}
+
+// LinkTask makes a task wait for another one
+func LinkTask(c *fiber.Ctx) error {
+	var task models.Task
+	if err := findUserTask(c, c.Params("id"), &task, false); err != nil {
+		return apierror.NotFound("Task not found")
+	}
+
+	req := new(LinkTaskRequest)
+	if err := parseBody(c, req); err != nil {
+		return err
+	}
+	if req.BlockerID == task.ID {
+		return apierror.Validation(map[string]string{"blocker_id": "a task cannot block itself"})
+	}
+	var blocker models.Task
+	if err := findUserTask(c, strconv.FormatUint(uint64(req.BlockerID), 10), &blocker, false); err != nil {
+		return apierror.Validation(map[string]string{"blocker_id": "must be one of your tasks"})
+	}
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&models.TaskDependency{}).Where("task_id = ? AND blocker_id = ?", task.ID, blocker.ID).
+			Count(&count).Error; err != nil || count > 0 {
+			return err
+		}
+		cycle, err := waitsFor(tx, blocker.ID, task.ID)
+		if err != nil {
+			return err
+		}
+		if cycle {
+			return errDependencyCycle
+		}
+		if err := tx.Create(&models.TaskDependency{TaskID: task.ID, BlockerID: blocker.ID}).Error; err != nil {
+			return err
+		}
+		return touchTask(tx, task.ID)
+	})
+	if errors.Is(err, errDependencyCycle) {
+		return err
+	}
+	if err != nil {
+		return apierror.Internal("Failed to link tasks").WithCause(err)
+	}
+
+	return respondWithTask(c, task.ID)
+}
+
+// UnlinkTask stops a task waiting for one of its blockers
+func UnlinkTask(c *fiber.Ctx) error {
+	var task models.Task
+	if err := findUserTask(c, c.Params("id"), &task, false); err != nil {
+		return apierror.NotFound("Task not found")
+	}
+
+	var removed int64
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("task_id = ? AND blocker_id = ?", task.ID, c.Params("blockerId")).Delete(&models.TaskDependency{})
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		removed = result.RowsAffected
+		return touchTask(tx, task.ID)
+	})
+	if err != nil {
+		return apierror.Internal("Failed to unlink tasks").WithCause(err)
+	}
+	if removed == 0 {
+		return apierror.NotFound("The task does not wait for that task")
+	}
+
+	return respondWithTask(c, task.ID)
+}
@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"tonish/backend/apierror"
+	"tonish/backend/models"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// errVersionConflict means the row changed after it was read.
+var errVersionConflict = errors.New("version conflict")
+
+// etag formats a resource version as a strong entity tag.
+func etag(version uint) string {
+	return `"` + strconv.FormatUint(uint64(version), 10) + `"`
+}
+
+// setETag advertises the version the response body represents.
+func setETag(c *fiber.Ctx, version uint) {
+	c.Set(fiber.HeaderETag, etag(version))
+}
+
+// ifMatchFails reports whether the request carries an If-Match header that
+// does not match version. Requests without If-Match always proceed.
+func ifMatchFails(c *fiber.Ctx, version uint) bool {
+	header := strings.TrimSpace(c.Get(fiber.HeaderIfMatch))
+	if header == "" || header == "*" {
+		return false
+	}
+	want := etag(version)
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == want {
+			return false
+		}
+	}
+	return true
+}
+
+// preconditionFailed answers a stale write with 412, the current server copy
+// and its ETag, so the client can merge and retry.
+func preconditionFailed(c *fiber.Ctx, version uint, current interface{}) error {
+	setETag(c, version)
+	return apierror.PreconditionFailed("The resource was changed by someone else").With("current", current)
+}
+
+// saveVersioned writes model through query only if the row still has the
+// version that was read, bumping *version. query must already select the
+// columns to write. It returns errVersionConflict when another write won.
+func saveVersioned(query *gorm.DB, model interface{}, version *uint) error {
+	previous := *version
+	*version = previous + 1
+
+	result := query.Where("version = ?", previous).Updates(model)
+	if result.Error == nil && result.RowsAffected == 0 {
+		result.Error = errVersionConflict
+	}
+	if result.Error != nil {
+		*version = previous
+	}
+	return result.Error
+}
+
+// deleteVersioned deletes model through query only if it still has version.
+func deleteVersioned(query *gorm.DB, model interface{}, version uint) error {
+	result := query.Where("version = ?", version).Delete(model)
+	if result.Error == nil && result.RowsAffected == 0 {
+		return errVersionConflict
+	}
+	return result.Error
+}
+
+// staleTask answers a write that lost a race with the task's current state.
+func staleTask(c *fiber.Ctx, id string) error {
+	var current models.Task
+	if err := findUserTask(c, id, &current, true); err != nil {
+		return apierror.NotFound("Task not found")
+	}
+	return preconditionFailed(c, current.Version, current)
+}
+
+// staleNotebook answers a write that lost a race with the notebook's
+// current state.
+func staleNotebook(c *fiber.Ctx, id string) error {
+	var current models.Notebook
+	if err := findUserNotebook(c, id, &current); err != nil {
+		return apierror.NotFound("Notebook not found")
+	}
+	return preconditionFailed(c, current.Version, current)
+}
+
+// stalePage answers a write that lost a race with the page's current state.
+func stalePage(c *fiber.Ctx, id string) error {
+	var current models.Page
+	if err := findUserPage(c, id, &current); err != nil {
+		return apierror.NotFound("Page not found")
+	}
+	return preconditionFailed(c, current.Version, current)
+}
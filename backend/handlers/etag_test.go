@@ -0,0 +1,120 @@
+package handlers_test
+
+import (
+	"fmt"
+	"testing"
+
+	"tonish/backend/models"
+)
+
+func TestTaskIfMatch(t *testing.T) {
+	app := newTestApp(t)
+	_, token := createUser(t, app, "alice@example.com")
+	task := createTask(t, app, token, map[string]interface{}{"title": "Water plants"})
+	path := fmt.Sprintf("/api/tasks/%d", task.ID)
+
+	status, tag := doConditional(t, app, "GET", path, token, "", nil, nil)
+	if status != 200 || tag != `"1"` {
+		t.Fatalf("get: status %d, ETag %s", status, tag)
+	}
+
+	// Someone else's edit makes the copy read above stale
+	if status := doJSON(t, app, "PATCH", path, token, map[string]string{"title": "Water the plants"}, nil); status != 200 {
+		t.Fatalf("concurrent edit: status %d", status)
+	}
+
+	var conflict struct {
+		Code    string      `json:"code"`
+		Current models.Task `json:"current"`
+	}
+	status, current := doConditional(t, app, "PATCH", path, token, tag, map[string]string{"title": "Water all plants"}, &conflict)
+	if status != 412 || conflict.Code != "precondition_failed" {
+		t.Fatalf("stale If-Match: status %d, code %q, want 412", status, conflict.Code)
+	}
+	if conflict.Current.Title != "Water the plants" || conflict.Current.Version != 2 || current != `"2"` {
+		t.Fatalf("412 carries %q at version %d, ETag %s", conflict.Current.Title, conflict.Current.Version, current)
+	}
+
+	var updated models.Task
+	status, tag = doConditional(t, app, "PATCH", path, token, current, map[string]string{"title": "Water all plants"}, &updated)
+	if status != 200 || updated.Title != "Water all plants" {
+		t.Fatalf("matching If-Match: status %d, title %q", status, updated.Title)
+	}
+	if updated.Version != 3 || tag != `"3"` {
+		t.Fatalf("after the write: version %d, ETag %s, want 3", updated.Version, tag)
+	}
+
+	// A stale delete leaves the task in place
+	if status, _ := doConditional(t, app, "DELETE", path, token, current, nil, nil); status != 412 {
+		t.Fatalf("stale delete: status %d, want 412", status)
+	}
+	if status := doJSON(t, app, "GET", path, token, nil, nil); status != 200 {
+		t.Fatalf("task after a stale delete: status %d", status)
+	}
+}
+
+func TestArchiveAndRestoreETags(t *testing.T) {
+	app := newTestApp(t)
+	_, token := createUser(t, app, "alice@example.com")
+	task := createTask(t, app, token, map[string]interface{}{"title": "Water plants"})
+	path := fmt.Sprintf("/api/tasks/%d", task.ID)
+
+	// Each response's ETag is good for the next conditional write
+	status, tag := doConditional(t, app, "POST", path+"/archive", token, `"1"`, nil, nil)
+	if status != 200 || tag != `"2"` {
+		t.Fatalf("archive: status %d, ETag %s, want 2", status, tag)
+	}
+	status, tag = doConditional(t, app, "POST", path+"/restore", token, tag, nil, nil)
+	if status != 200 || tag != `"3"` {
+		t.Fatalf("restore: status %d, ETag %s, want 3", status, tag)
+	}
+	if status, _ := doConditional(t, app, "POST", path+"/archive", token, `"2"`, nil, nil); status != 412 {
+		t.Fatalf("archive with a stale ETag: status %d, want 412", status)
+	}
+}
+
+func TestPageIfMatch(t *testing.T) {
+	app := newTestApp(t)
+	_, token := createUser(t, app, "alice@example.com")
+	var notebook models.Notebook
+	if status := doJSON(t, app, "POST", "/api/notebooks", token, map[string]string{"name": "Garden"}, &notebook); status != 201 {
+		t.Fatalf("create notebook: status %d", status)
+	}
+	var page models.Page
+	status, tag := doConditional(t, app, "POST", "/api/pages", token, "", map[string]interface{}{
+		"notebook_id": notebook.ID,
+		"title":       "Seeds",
+		"content":     "Tomatoes",
+	}, &page)
+	if status != 201 || tag != `"1"` {
+		t.Fatalf("create page: status %d, ETag %s", status, tag)
+	}
+	path := fmt.Sprintf("/api/pages/%d", page.ID)
+
+	edit := func(content string) map[string]interface{} {
+		return map[string]interface{}{"notebook_id": notebook.ID, "title": "Seeds", "content": content}
+	}
+	if status := doJSON(t, app, "PUT", path, token, edit("Tomatoes, basil"), nil); status != 200 {
+		t.Fatalf("concurrent edit: status %d", status)
+	}
+
+	var conflict struct {
+		Current models.Page `json:"current"`
+	}
+	status, current := doConditional(t, app, "PUT", path, token, tag, edit("Tomatoes, peppers"), &conflict)
+	if status != 412 {
+		t.Fatalf("stale If-Match: status %d, want 412", status)
+	}
+	if conflict.Current.Content != "Tomatoes, basil" || current != `"2"` {
+		t.Fatalf("412 carries %q, ETag %s", conflict.Current.Content, current)
+	}
+
+	var updated models.Page
+	status, tag = doConditional(t, app, "PUT", path, token, current, edit("Tomatoes, basil, peppers"), &updated)
+	if status != 200 || updated.Content != "Tomatoes, basil, peppers" {
+		t.Fatalf("matching If-Match: status %d, content %q", status, updated.Content)
+	}
+	if updated.Version != 3 || tag != `"3"` {
+		t.Fatalf("after the write: version %d, ETag %s, want 3", updated.Version, tag)
+	}
+}
@@ -0,0 +1,255 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"tonish/backend/apierror"
+	"tonish/backend/database"
+	"tonish/backend/models"
+	ws "tonish/backend/websocket"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// exportTTL is how long a finished archive can be downloaded.
+const exportTTL = 7 * 24 * time.Hour
+
+var unsafeFileChars = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+// exportArchive is the content of export.json.
+type exportArchive struct {
+	ExportedAt    time.Time               `json:"exported_at"`
+	User          models.User             `json:"user"`
+	Settings      models.UserSettings     `json:"settings"`
+	Tasks         []models.Task           `json:"tasks"`
+	Checklists    []models.ChecklistItem  `json:"checklist_items"`
+	Dependencies  []models.TaskDependency `json:"dependencies"`
+	Reminders     []models.Reminder       `json:"reminders"`
+	Notifications []models.Notification   `json:"notifications"`
+	Notebooks     []models.Notebook       `json:"notebooks"`
+	Views         []models.SavedView      `json:"views"`
+}
+
+// StartDataExport queues a background job that archives all of the current
+// user's data. Poll the returned export or wait for an export_update message.
+func StartDataExport(c *fiber.Ctx) error {
+	userID := currentUserID(c)
+
+	var active int64
+	database.DB.Model(&models.DataExport{}).
+		Where("user_id = ? AND status IN ?", userID, []string{models.ExportPending, models.ExportRunning}).
+		Count(&active)
+	if active > 0 {
+		return apierror.Conflict("An export is already in progress")
+	}
+
+	// Old archives are only kept until they expire. Failing to clear them
+	// should not stop a new export; the next one tries again.
+	if err := database.DB.Where("user_id = ? AND expires_at < ?", userID, time.Now()).
+		Delete(&models.DataExport{}).Error; err != nil {
+		log.Printf("Failed to delete expired exports of user %d: %v\n", userID, err)
+	}
+
+	export := models.DataExport{UserID: userID, Status: models.ExportPending}
+	if err := database.DB.Create(&export).Error; err != nil {
+		return apierror.Internal("Failed to start export").WithCause(err)
+	}
+
+	go runDataExport(export.ID)
+
+	return c.Status(202).JSON(export)
+}
+
+// GetDataExports lists the current user's exports, newest first
+func GetDataExports(c *fiber.Ctx) error {
+	var exports []models.DataExport
+	if err := database.DB.Omit("Archive").Where("user_id = ?", currentUserID(c)).
+		Order("created_at DESC").Find(&exports).Error; err != nil {
+		return apierror.Internal("Failed to load exports").WithCause(err)
+	}
+	return c.JSON(exports)
+}
+
+// GetDataExport returns the status of one export
+func GetDataExport(c *fiber.Ctx) error {
+	var export models.DataExport
+	if err := database.DB.Omit("Archive").Where("id = ? AND user_id = ?", c.Params("id"), currentUserID(c)).
+		First(&export).Error; err != nil {
+		return apierror.NotFound("Export not found")
+	}
+	return c.JSON(export)
+}
+
+// DownloadDataExport sends a finished archive as a zip file
+func DownloadDataExport(c *fiber.Ctx) error {
+	var export models.DataExport
+	if err := database.DB.Where("id = ? AND user_id = ?", c.Params("id"), currentUserID(c)).
+		First(&export).Error; err != nil {
+		return apierror.NotFound("Export not found")
+	}
+	if export.Status != models.ExportReady {
+		return apierror.Conflict("Export is not ready").With("export_status", export.Status)
+	}
+	if export.ExpiresAt != nil && time.Now().After(*export.ExpiresAt) {
+		return apierror.Gone("Export has expired")
+	}
+
+	c.Set(fiber.HeaderContentType, "application/zip")
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="tonish-export-%d.zip"`, export.ID))
+	return c.Send(export.Archive)
+}
+
+// ResumeDataExports restarts exports that were interrupted by a shutdown.
+func ResumeDataExports() {
+	var ids []uint
+	database.DB.Model(&models.DataExport{}).
+		Where("status IN ?", []string{models.ExportPending, models.ExportRunning}).
+		Pluck("id", &ids)
+	for _, id := range ids {
+		go runDataExport(id)
+	}
+}
+
+// runDataExport builds the archive for one export and stores the outcome.
+func runDataExport(exportID uint) {
+	var export models.DataExport
+	if err := database.DB.Omit("Archive").First(&export, exportID).Error; err != nil {
+		log.Printf("Data export %d disappeared: %v\n", exportID, err)
+		return
+	}
+	if err := database.DB.Model(&export).Update("status", models.ExportRunning).Error; err != nil {
+		// Leave it pending; ResumeDataExports picks it up on the next start
+		log.Printf("Failed to start data export %d: %v\n", exportID, err)
+		return
+	}
+
+	archive, err := buildDataExport(export.UserID)
+
+	now := time.Now()
+	updates := map[string]interface{}{"completed_at": now}
+	if err != nil {
+		log.Printf("Data export %d failed: %v\n", exportID, err)
+		updates["status"] = models.ExportFailed
+		updates["error"] = "Failed to build archive"
+	} else {
+		updates["status"] = models.ExportReady
+		updates["archive"] = archive
+		updates["size"] = len(archive)
+		updates["expires_at"] = now.Add(exportTTL)
+	}
+	if err := database.DB.Model(&export).Updates(updates).Error; err != nil {
+		log.Printf("Failed to save data export %d: %v\n", exportID, err)
+		return
+	}
+
+	if ws.GlobalHub != nil {
+		database.DB.Omit("Archive").First(&export, exportID)
+		ws.GlobalHub.BroadcastToUser(export.UserID, ws.MessageTypeExportUpdate, export)
+	}
+}
+
+// buildDataExport zips export.json with everything the user owns, including
+// archived and deleted tasks, plus one Markdown file per notebook.
+func buildDataExport(userID uint) ([]byte, error) {
+	data := exportArchive{ExportedAt: time.Now().UTC()}
+	if err := database.DB.First(&data.User, userID).Error; err != nil {
+		return nil, err
+	}
+	data.Settings = loadUserSettings(userID)
+	if err := database.DB.Unscoped().Where("user_id = ?", userID).Order("id").Find(&data.Tasks).Error; err != nil {
+		return nil, err
+	}
+	if err := database.DB.Where("task_id IN (?)", database.DB.Unscoped().Model(&models.Task{}).Select("id").Where("user_id = ?", userID)).
+		Order("task_id, position").Find(&data.Checklists).Error; err != nil {
+		return nil, err
+	}
+	if err := database.DB.Where("task_id IN (?)", database.DB.Unscoped().Model(&models.Task{}).Select("id").Where("user_id = ?", userID)).
+		Order("task_id, blocker_id").Find(&data.Dependencies).Error; err != nil {
+		return nil, err
+	}
+	if err := database.DB.Where("user_id = ?", userID).Order("task_id, id").Find(&data.Reminders).Error; err != nil {
+		return nil, err
+	}
+	if err := database.DB.Where("user_id = ?", userID).Order("id").Find(&data.Notifications).Error; err != nil {
+		return nil, err
+	}
+	attachBlockers(data.Tasks)
+	if err := database.DB.Preload("Pages", func(db *gorm.DB) *gorm.DB {
+		return db.Order("id")
+	}).Where("user_id = ?", userID).Order("id").Find(&data.Notebooks).Error; err != nil {
+		return nil, err
+	}
+	if err := database.DB.Where("user_id = ?", userID).Order("id").Find(&data.Views).Error; err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+
+	w, err := zw.Create("export.json")
+	if err != nil {
+		return nil, err
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(data); err != nil {
+		return nil, err
+	}
+
+	for _, notebook := range data.Notebooks {
+		name := fmt.Sprintf("notebooks/%d-%s.md", notebook.ID, safeFileName(notebook.Name))
+		w, err := zw.Create(name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write([]byte(notebookMarkdown(&notebook))); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// notebookMarkdown renders a notebook the same way the notebook page's
+// Markdown export does. Page content is already Markdown.
+func notebookMarkdown(notebook *models.Notebook) string {
+	var md strings.Builder
+	fmt.Fprintf(&md, "# %s\n\n", notebook.Name)
+	if notebook.Tags != "" {
+		fmt.Fprintf(&md, "**Tags:** %s\n\n", notebook.Tags)
+	}
+	plural := "s"
+	if len(notebook.Pages) == 1 {
+		plural = ""
+	}
+	fmt.Fprintf(&md, "*%d page%s*\n\n---\n\n", len(notebook.Pages), plural)
+
+	for _, page := range notebook.Pages {
+		fmt.Fprintf(&md, "## %s\n\n%s\n\n", page.Title, page.Content)
+		if page.Tags != "" {
+			fmt.Fprintf(&md, "> **Tags:** %s\n\n", page.Tags)
+		}
+		fmt.Fprintf(&md, "<sub>Created: %s · Updated: %s</sub>\n\n---\n\n",
+			page.CreatedAt.UTC().Format(time.RFC3339), page.UpdatedAt.UTC().Format(time.RFC3339))
+	}
+	return md.String()
+}
+
+func safeFileName(name string) string {
+	name = strings.Trim(unsafeFileChars.ReplaceAllString(name, "-"), "-")
+	if name == "" {
+		return "untitled"
+	}
+	return name
+}
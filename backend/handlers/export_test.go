@@ -0,0 +1,10 @@
+package handlers
+
+import "time"
+
+// ResetLoginThrottles forgets all failed attempts, so that tests sharing the
+// package-level throttles do not slow each other down.
+func ResetLoginThrottles() {
+	accountThrottle = newLoginThrottle(time.Now, accountThrottle.maxFailures)
+	ipThrottle = newLoginThrottle(time.Now, ipThrottle.maxFailures)
+}
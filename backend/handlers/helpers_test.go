@@ -0,0 +1,139 @@
+package handlers_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"tonish/backend/apierror"
+	"tonish/backend/database"
+	"tonish/backend/handlers"
+	"tonish/backend/models"
+	"tonish/backend/routes"
+
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const testPassword = "correct-horse-battery"
+
+// newTestApp wires the real routes onto a fresh in-memory database.
+func newTestApp(t *testing.T) *fiber.App {
+	t.Helper()
+	t.Setenv("JWT_SECRET", "test-secret")
+
+	dsn := fmt.Sprintf("file:%s?mode=memory&cache=shared", strings.ReplaceAll(t.Name(), "/", "_"))
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	sqlDB, _ := db.DB()
+	t.Cleanup(func() { sqlDB.Close() })
+
+	database.DB = db
+	database.Migrate()
+	handlers.ResetLoginThrottles()
+
+	app := fiber.New(fiber.Config{ErrorHandler: apierror.Handler})
+	routes.Setup(app)
+	return app
+}
+
+// createUser inserts a user and returns it together with a login token.
+func createUser(t *testing.T, app *fiber.App, email string) (models.User, string) {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte(testPassword), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("hash password: %v", err)
+	}
+	user := models.User{Email: email, Password: string(hash), Name: email}
+	if err := database.DB.Create(&user).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+
+	return user, login(t, app, email).Token
+}
+
+// createAdmin is createUser for a user with the admin role.
+func createAdmin(t *testing.T, app *fiber.App, email string) (models.User, string) {
+	t.Helper()
+	user, token := createUser(t, app, email)
+	if err := database.DB.Model(&user).Update("role", models.RoleAdmin).Error; err != nil {
+		t.Fatalf("promote user: %v", err)
+	}
+	return user, token
+}
+
+// tokenPair is the response of login, registration and refresh.
+type tokenPair struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// login opens a new session for email with the test password.
+func login(t *testing.T, app *fiber.App, email string) tokenPair {
+	t.Helper()
+	var tokens tokenPair
+	status := doJSON(t, app, "POST", "/api/auth/login", "", map[string]string{
+		"email":    email,
+		"password": testPassword,
+	}, &tokens)
+	if status != 200 || tokens.Token == "" {
+		t.Fatalf("login %s: status %d", email, status)
+	}
+	return tokens
+}
+
+// doJSON performs a request against app and decodes the JSON response into
+// out when it is non-nil. It returns the response status code.
+func doJSON(t *testing.T, app *fiber.App, method, path, token string, body interface{}, out interface{}) int {
+	t.Helper()
+	status, _ := doConditional(t, app, method, path, token, "", body, out)
+	return status
+}
+
+// doConditional is doJSON with an If-Match header, sent when ifMatch is
+// non-empty. It also returns the response's ETag.
+func doConditional(t *testing.T, app *fiber.App, method, path, token, ifMatch string, body interface{}, out interface{}) (int, string) {
+	t.Helper()
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			t.Fatalf("marshal body: %v", err)
+		}
+		reader = bytes.NewReader(payload)
+	}
+
+	req := httptest.NewRequest(method, path, reader)
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if ifMatch != "" {
+		req.Header.Set("If-Match", ifMatch)
+	}
+
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	if out != nil {
+		data, _ := io.ReadAll(resp.Body)
+		if len(data) > 0 {
+			if err := json.Unmarshal(data, out); err != nil {
+				t.Fatalf("%s %s: decode %q: %v", method, path, data, err)
+			}
+		}
+	}
+	return resp.StatusCode, resp.Header.Get("ETag")
+}
@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"strings"
+	"time"
+
+	"tonish/backend/apierror"
+	"tonish/backend/database"
+	"tonish/backend/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	defaultInviteTTL = 72 * time.Hour
+	maxInviteTTL     = 30 * 24 * time.Hour // Keep in sync with the max on ExpiresInHours
+)
+
+type CreateInviteRequest struct {
+	Email          string `json:"email" validate:"email,max=254"`
+	ExpiresInHours int    `json:"expires_in_hours" validate:"min=0,max=720"` // 0 uses the default
+}
+
+// GetInvites lists all invites, newest first
+func GetInvites(c *fiber.Ctx) error {
+	var invites []models.Invite
+
+	if err := database.DB.Order("created_at DESC").Find(&invites).Error; err != nil {
+		return apierror.Internal("Failed to load invites").WithCause(err)
+	}
+
+	return c.JSON(invites)
+}
+
+// CreateInvite issues a new single-use invite code. The plain code is only
+// returned in this response.
+func CreateInvite(c *fiber.Ctx) error {
+	req := new(CreateInviteRequest)
+
+	if err := parseBody(c, req); err != nil {
+		return err
+	}
+
+	req.Email = strings.TrimSpace(req.Email)
+
+	ttl := defaultInviteTTL
+	if req.ExpiresInHours > 0 {
+		ttl = min(time.Duration(req.ExpiresInHours)*time.Hour, maxInviteTTL)
+	}
+
+	code, codeHash, err := generateSecret("")
+	if err != nil {
+		return apierror.Internal("Failed to create invite").WithCause(err)
+	}
+
+	invite := models.Invite{
+		CodeHash:    codeHash,
+		Email:       req.Email,
+		CreatedByID: currentUserID(c),
+		ExpiresAt:   time.Now().Add(ttl),
+	}
+
+	if err := database.DB.Create(&invite).Error; err != nil {
+		return apierror.Internal("Failed to create invite").WithCause(err)
+	}
+
+	return c.Status(201).JSON(fiber.Map{
+		"invite": invite,
+		"code":   code,
+	})
+}
+
+// DeleteInvite revokes an invite
+func DeleteInvite(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	result := database.DB.Where("id = ?", id).Delete(&models.Invite{})
+	if result.Error != nil {
+		return apierror.Internal("Failed to delete invite").WithCause(result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return apierror.NotFound("Invite not found")
+	}
+
+	return c.Status(204).SendString("")
+}
@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"tonish/backend/apierror"
+	"tonish/backend/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// loginThrottle tracks failed authentication attempts per key (an IP address
+// or an account) and slows them down: after freeAttempts failures every
+// further attempt must wait an exponentially growing delay, and after
+// maxFailures the key is locked out for lockoutDuration.
+type loginThrottle struct {
+	mu      sync.Mutex
+	now     func() time.Time
+	entries map[string]*throttleEntry
+
+	freeAttempts    int
+	maxFailures     int
+	baseDelay       time.Duration
+	maxDelay        time.Duration
+	lockoutDuration time.Duration
+	// resetAfter forgets a key's failures once it has been quiet this long.
+	resetAfter time.Duration
+}
+
+// maxThrottleEntries bounds memory use; past it, stale entries are pruned.
+const maxThrottleEntries = 10000
+
+type throttleEntry struct {
+	failures     int
+	lastFailure  time.Time
+	blockedUntil time.Time
+}
+
+// newLoginThrottle creates a throttle that locks a key out after maxFailures
+// failures. now is the clock, injectable so tests can step through windows.
+func newLoginThrottle(now func() time.Time, maxFailures int) *loginThrottle {
+	return &loginThrottle{
+		now:             now,
+		entries:         make(map[string]*throttleEntry),
+		freeAttempts:    3,
+		maxFailures:     maxFailures,
+		baseDelay:       time.Second,
+		maxDelay:        time.Minute,
+		lockoutDuration: 15 * time.Minute,
+		resetAfter:      time.Hour,
+	}
+}
+
+// Throttles for the auth endpoints. IP addresses get a higher limit since
+// several people may share one.
+var (
+	accountThrottle = newLoginThrottle(time.Now, 10)
+	ipThrottle      = newLoginThrottle(time.Now, 30)
+)
+
+// accountKey normalizes an email address into a throttle key.
+func accountKey(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// loginRetryAfter returns how long the client must wait before it may try to
+// authenticate as account, considering both its IP and the account.
+func loginRetryAfter(c *fiber.Ctx, account string) time.Duration {
+	wait := ipThrottle.retryAfter(c.IP())
+	if accountWait := accountThrottle.retryAfter(account); accountWait > wait {
+		wait = accountWait
+	}
+	return wait
+}
+
+// tooManyAttempts rejects a throttled request with 429 and Retry-After.
+func tooManyAttempts(c *fiber.Ctx, wait time.Duration) error {
+	c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	return apierror.TooManyRequests("Too many failed attempts, try again later")
+}
+
+// recordLoginFailure audits a failed attempt, counts it against the client IP
+// and the account, and audits any lockout it triggers. userID is nil when
+// the account does not exist.
+func recordLoginFailure(c *fiber.Ctx, account string, userID *uint, reason string) {
+	recordAudit(c, userID, models.AuditLoginFailed, fiber.Map{
+		"account": account,
+		"reason":  reason,
+	})
+
+	if locked, until := ipThrottle.fail(c.IP()); locked {
+		recordAudit(c, nil, models.AuditAuthLockout, fiber.Map{
+			"scope":        "ip",
+			"locked_until": until,
+		})
+	}
+	if locked, until := accountThrottle.fail(account); locked {
+		recordAudit(c, userID, models.AuditAuthLockout, fiber.Map{
+			"scope":        "account",
+			"account":      account,
+			"locked_until": until,
+		})
+	}
+}
+
+// recordLoginSuccess clears the account's failures. The IP's failures are
+// kept, so one valid login cannot launder guesses against other accounts.
+func recordLoginSuccess(account string) {
+	accountThrottle.succeed(account)
+}
+
+// retryAfter returns how long key must wait before its next attempt, or 0
+// when it may try now.
+func (t *loginThrottle) retryAfter(key string) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	entry := t.entry(key)
+	if entry == nil {
+		return 0
+	}
+	if wait := entry.blockedUntil.Sub(t.now()); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+// fail records a failed attempt for key. It reports whether this failure
+// locked the key out, and until when.
+func (t *loginThrottle) fail(key string) (bool, time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	entry := t.entry(key)
+	if entry == nil {
+		if len(t.entries) >= maxThrottleEntries {
+			t.prune()
+		}
+		entry = &throttleEntry{}
+		t.entries[key] = entry
+	}
+
+	entry.failures++
+	entry.lastFailure = now
+
+	if entry.failures >= t.maxFailures {
+		entry.blockedUntil = now.Add(t.lockoutDuration)
+		// Start over after the lockout, rather than locking out again on the
+		// first failure
+		entry.failures = 0
+		return true, entry.blockedUntil
+	}
+
+	if entry.failures > t.freeAttempts {
+		delay := t.baseDelay << uint(entry.failures-t.freeAttempts-1)
+		if delay > t.maxDelay || delay <= 0 {
+			delay = t.maxDelay
+		}
+		entry.blockedUntil = now.Add(delay)
+	}
+
+	return false, time.Time{}
+}
+
+// succeed clears the failures recorded for key.
+func (t *loginThrottle) succeed(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.entries, key)
+}
+
+// entry returns the live entry for key, dropping it if it has gone stale.
+// The caller must hold t.mu.
+func (t *loginThrottle) entry(key string) *throttleEntry {
+	entry, ok := t.entries[key]
+	if !ok {
+		return nil
+	}
+
+	now := t.now()
+	if now.After(entry.blockedUntil) && now.Sub(entry.lastFailure) > t.resetAfter {
+		delete(t.entries, key)
+		return nil
+	}
+	return entry
+}
+
+// prune drops every stale entry. The caller must hold t.mu.
+func (t *loginThrottle) prune() {
+	for key := range t.entries {
+		t.entry(key)
+	}
+}
@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+)
+
+type fakeClock struct{ now time.Time }
+
+func (f *fakeClock) Now() time.Time          { return f.now }
+func (f *fakeClock) Advance(d time.Duration) { f.now = f.now.Add(d) }
+
+func TestLoginThrottleBackoff(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	throttle := newLoginThrottle(clock.Now, 10)
+
+	for i := 0; i < throttle.freeAttempts; i++ {
+		throttle.fail("a@example.com")
+		if wait := throttle.retryAfter("a@example.com"); wait != 0 {
+			t.Fatalf("failure %d: wait %v, want none", i+1, wait)
+		}
+	}
+
+	// Every further failure doubles the delay
+	want := throttle.baseDelay
+	for i := throttle.freeAttempts; i < 6; i++ {
+		throttle.fail("a@example.com")
+		if wait := throttle.retryAfter("a@example.com"); wait != want {
+			t.Fatalf("failure %d: wait %v, want %v", i+1, wait, want)
+		}
+		clock.Advance(want)
+		if wait := throttle.retryAfter("a@example.com"); wait != 0 {
+			t.Fatalf("failure %d: still blocked after delay: %v", i+1, wait)
+		}
+		want *= 2
+	}
+
+	if wait := throttle.retryAfter("b@example.com"); wait != 0 {
+		t.Fatalf("unrelated key blocked for %v", wait)
+	}
+}
+
+func TestLoginThrottleLockout(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	throttle := newLoginThrottle(clock.Now, 5)
+
+	var locked bool
+	var until time.Time
+	for i := 0; i < 5; i++ {
+		locked, until = throttle.fail("1.2.3.4")
+	}
+	if !locked {
+		t.Fatal("expected lockout after max failures")
+	}
+	if want := clock.now.Add(throttle.lockoutDuration); !until.Equal(want) {
+		t.Fatalf("locked until %v, want %v", until, want)
+	}
+	if wait := throttle.retryAfter("1.2.3.4"); wait != throttle.lockoutDuration {
+		t.Fatalf("wait %v, want %v", wait, throttle.lockoutDuration)
+	}
+
+	clock.Advance(throttle.lockoutDuration)
+	if wait := throttle.retryAfter("1.2.3.4"); wait != 0 {
+		t.Fatalf("still locked after lockout expired: %v", wait)
+	}
+}
+
+func TestLoginThrottleResetsOnSuccessAndWhenQuiet(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	throttle := newLoginThrottle(clock.Now, 10)
+
+	for i := 0; i < throttle.freeAttempts+1; i++ {
+		throttle.fail("a@example.com")
+	}
+	throttle.succeed("a@example.com")
+	if wait := throttle.retryAfter("a@example.com"); wait != 0 {
+		t.Fatalf("blocked after success: %v", wait)
+	}
+
+	for i := 0; i < throttle.freeAttempts; i++ {
+		throttle.fail("a@example.com")
+	}
+	clock.Advance(throttle.resetAfter + time.Second)
+	throttle.fail("a@example.com")
+	if wait := throttle.retryAfter("a@example.com"); wait != 0 {
+		t.Fatalf("old failures still counted: wait %v", wait)
+	}
+}
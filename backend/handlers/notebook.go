@@ -1,18 +1,55 @@
 package handlers
 
 import (
+	"errors"
+
+	"tonish/backend/apierror"
 	"tonish/backend/database"
 	"tonish/backend/models"
 	ws "tonish/backend/websocket"
 
 	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
 )
 
-// GetAllNotebooks retrieves all notebooks
+// NotebookRequest is the body of POST /notebooks and PUT /notebooks/:id.
+// Pages are managed through their own endpoints.
+type NotebookRequest struct {
+	Name     string `json:"name" validate:"required,max=200"`
+	Tags     string `json:"tags" validate:"max=2000"`
+	IsPinned bool   `json:"is_pinned"`
+}
+
+func (req *NotebookRequest) apply(notebook *models.Notebook) {
+	notebook.Name = req.Name
+	notebook.Tags = req.Tags
+	notebook.IsPinned = req.IsPinned
+}
+
+// PageRequest is the body of POST /pages and PUT /pages/:id.
+type PageRequest struct {
+	NotebookID uint   `json:"notebook_id" validate:"required"`
+	Title      string `json:"title" validate:"required,max=500"`
+	Content    string `json:"content" validate:"max=1000000"`
+	Tags       string `json:"tags" validate:"max=2000"`
+	IsPinned   bool   `json:"is_pinned"`
+}
+
+func (req *PageRequest) apply(page *models.Page) {
+	page.NotebookID = req.NotebookID
+	page.Title = req.Title
+	page.Content = req.Content
+	page.Tags = req.Tags
+	page.IsPinned = req.IsPinned
+}
+
+// GetAllNotebooks retrieves all notebooks owned by the current user
 func GetAllNotebooks(c *fiber.Ctx) error {
 	var notebooks []models.Notebook
 	
-	database.DB.Preload("Pages").Find(&notebooks)
+	if err := userNotebooks(c).Preload("Pages").Find(&notebooks).Error; err != nil {
+		return apierror.Internal("Failed to load notebooks").WithCause(err)
+	}
 	
 	return c.JSON(notebooks)
 }
@@ -22,32 +59,39 @@ func GetNotebook(c *fiber.Ctx) error {
 	id := c.Params("id")
 	var notebook models.Notebook
 	
-	if err := database.DB.Preload("Pages").First(&notebook, id).Error; err != nil {
-		return c.Status(404).JSON(fiber.Map{
-			"error": "Notebook not found",
-		})
+	if err := userNotebooks(c).Preload("Pages").Where("notebooks.id = ?", id).First(&notebook).Error; err != nil {
+		return apierror.NotFound("Notebook not found")
 	}
 	
+	setETag(c, notebook.Version)
 	return c.JSON(notebook)
 }
 
 // CreateNotebook creates a new notebook
 func CreateNotebook(c *fiber.Ctx) error {
-	notebook := new(models.Notebook)
-	
-	if err := c.BodyParser(notebook); err != nil {
-		return c.Status(400).JSON(fiber.Map{
-			"error": "Invalid request body",
-		})
+	req := new(NotebookRequest)
+	if err := parseBody(c, req); err != nil {
+		return err
 	}
 	
-	database.DB.Create(&notebook)
+	notebook := &models.Notebook{UserID: currentUserID(c), Version: 1}
+	req.apply(notebook)
+	
+	if err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Pages").Create(notebook).Error; err != nil {
+			return err
+		}
+		return database.SyncTags(tx, notebook.UserID, database.TaggedNotebooks, notebook.ID, notebook.Tags)
+	}); err != nil {
+		return apierror.Internal("Failed to create notebook").WithCause(err)
+	}
 	
-	// Broadcast notebook creation to all connected clients
+	// Broadcast notebook creation to the owner's connected clients
 	if ws.GlobalHub != nil {
-		ws.GlobalHub.BroadcastToUser(0, ws.MessageTypeNotebookCreate, notebook)
+		ws.GlobalHub.BroadcastToUser(notebook.UserID, ws.MessageTypeNotebookCreate, notebook)
 	}
 	
+	setETag(c, notebook.Version)
 	return c.Status(201).JSON(notebook)
 }
 
@@ -56,25 +100,39 @@ func UpdateNotebook(c *fiber.Ctx) error {
 	id := c.Params("id")
 	var notebook models.Notebook
 	
-	if err := database.DB.First(&notebook, id).Error; err != nil {
-		return c.Status(404).JSON(fiber.Map{
-			"error": "Notebook not found",
-		})
+	if err := findUserNotebook(c, id, &notebook); err != nil {
+		return apierror.NotFound("Notebook not found")
 	}
 	
-	if err := c.BodyParser(&notebook); err != nil {
-		return c.Status(400).JSON(fiber.Map{
-			"error": "Invalid request body",
-		})
+	if ifMatchFails(c, notebook.Version) {
+		return preconditionFailed(c, notebook.Version, notebook)
 	}
 	
-	database.DB.Save(&notebook)
+	req := &NotebookRequest{Name: notebook.Name, Tags: notebook.Tags, IsPinned: notebook.IsPinned}
+	if err := parseBody(c, req); err != nil {
+		return err
+	}
+	req.apply(&notebook)
+	
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := saveVersioned(tx.Model(&notebook).Select("*").Omit("Pages"), &notebook, &notebook.Version); err != nil {
+			return err
+		}
+		return database.SyncTags(tx, notebook.UserID, database.TaggedNotebooks, notebook.ID, notebook.Tags)
+	})
+	if errors.Is(err, errVersionConflict) {
+		return staleNotebook(c, id)
+	}
+	if err != nil {
+		return apierror.Internal("Failed to update notebook").WithCause(err)
+	}
 	
-	// Broadcast notebook update to all connected clients
+	// Broadcast notebook update to the owner's connected clients
 	if ws.GlobalHub != nil {
-		ws.GlobalHub.BroadcastToUser(0, ws.MessageTypeNotebookUpdate, notebook)
+		ws.GlobalHub.BroadcastToUser(notebook.UserID, ws.MessageTypeNotebookUpdate, notebook)
 	}
 	
+	setETag(c, notebook.Version)
 	return c.JSON(notebook)
 }
 
@@ -83,21 +141,45 @@ func DeleteNotebook(c *fiber.Ctx) error {
 	id := c.Params("id")
 	var notebook models.Notebook
 	
-	if err := database.DB.First(&notebook, id).Error; err != nil {
-		return c.Status(404).JSON(fiber.Map{
-			"error": "Notebook not found",
-		})
+	if err := findUserNotebook(c, id, &notebook); err != nil {
+		return apierror.NotFound("Notebook not found")
 	}
 	
-	// Delete all pages first
-	database.DB.Where("notebook_id = ?", id).Delete(&models.Page{})
+	if ifMatchFails(c, notebook.Version) {
+		return preconditionFailed(c, notebook.Version, notebook)
+	}
 	
-	// Delete the notebook
-	database.DB.Delete(&notebook)
+	// Keep the pages in the audit snapshot
+	database.DB.Where("notebook_id = ?", notebook.ID).Find(&notebook.Pages)
+	
+	// Delete the notebook, unless it changed meanwhile, then its pages
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := deleteVersioned(tx, &notebook, notebook.Version); err != nil {
+			return err
+		}
+		if err := database.DeleteTagLinks(tx, database.TaggedNotebooks, []uint{notebook.ID}); err != nil {
+			return err
+		}
+		pageIDs := []uint{}
+		for _, page := range notebook.Pages {
+			pageIDs = append(pageIDs, page.ID)
+		}
+		if err := database.DeleteTagLinks(tx, database.TaggedPages, pageIDs); err != nil {
+			return err
+		}
+		return tx.Where("notebook_id = ?", notebook.ID).Delete(&models.Page{}).Error
+	})
+	if errors.Is(err, errVersionConflict) {
+		return staleNotebook(c, id)
+	}
+	if err != nil {
+		return apierror.Internal("Failed to delete notebook").WithCause(err)
+	}
+	recordChange(c, models.AuditNotebookDelete, "notebook", notebook.ID, notebook, nil)
 	
-	// Broadcast notebook deletion to all connected clients
+	// Broadcast notebook deletion to the owner's connected clients
 	if ws.GlobalHub != nil {
-		ws.GlobalHub.BroadcastToUser(0, ws.MessageTypeNotebookDelete, fiber.Map{"id": id})
+		ws.GlobalHub.BroadcastToUser(notebook.UserID, ws.MessageTypeNotebookDelete, fiber.Map{"id": id})
 	}
 	
 	return c.Status(204).SendString("")
@@ -108,32 +190,43 @@ func GetPage(c *fiber.Ctx) error {
 	id := c.Params("id")
 	var page models.Page
 	
-	if err := database.DB.First(&page, id).Error; err != nil {
-		return c.Status(404).JSON(fiber.Map{
-			"error": "Page not found",
-		})
+	if err := findUserPage(c, id, &page); err != nil {
+		return apierror.NotFound("Page not found")
 	}
 	
+	setETag(c, page.Version)
 	return c.JSON(page)
 }
 
 // CreatePage creates a new page in a notebook
 func CreatePage(c *fiber.Ctx) error {
-	page := new(models.Page)
+	req := new(PageRequest)
+	if err := parseBody(c, req); err != nil {
+		return err
+	}
 	
-	if err := c.BodyParser(page); err != nil {
-		return c.Status(400).JSON(fiber.Map{
-			"error": "Invalid request body",
-		})
+	// Pages can only be added to the current user's notebooks
+	if !userOwnsNotebook(c, req.NotebookID) {
+		return apierror.NotFound("Notebook not found")
 	}
 	
-	database.DB.Create(&page)
+	page := &models.Page{Version: 1}
+	req.apply(page)
+	if err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(page).Error; err != nil {
+			return err
+		}
+		return database.SyncTags(tx, currentUserID(c), database.TaggedPages, page.ID, page.Tags)
+	}); err != nil {
+		return apierror.Internal("Failed to create page").WithCause(err)
+	}
 	
 	// Broadcast page creation (triggers notebook update)
 	if ws.GlobalHub != nil {
-		ws.GlobalHub.BroadcastToUser(0, ws.MessageTypeNotebookUpdate, page)
+		ws.GlobalHub.BroadcastToUser(currentUserID(c), ws.MessageTypeNotebookUpdate, page)
 	}
 	
+	setETag(c, page.Version)
 	return c.Status(201).JSON(page)
 }
 
@@ -142,25 +235,50 @@ func UpdatePage(c *fiber.Ctx) error {
 	id := c.Params("id")
 	var page models.Page
 	
-	if err := database.DB.First(&page, id).Error; err != nil {
-		return c.Status(404).JSON(fiber.Map{
-			"error": "Page not found",
-		})
+	if err := findUserPage(c, id, &page); err != nil {
+		return apierror.NotFound("Page not found")
 	}
 	
-	if err := c.BodyParser(&page); err != nil {
-		return c.Status(400).JSON(fiber.Map{
-			"error": "Invalid request body",
-		})
+	if ifMatchFails(c, page.Version) {
+		return preconditionFailed(c, page.Version, page)
 	}
 	
-	database.DB.Save(&page)
+	req := &PageRequest{
+		NotebookID: page.NotebookID,
+		Title:      page.Title,
+		Content:    page.Content,
+		Tags:       page.Tags,
+		IsPinned:   page.IsPinned,
+	}
+	if err := parseBody(c, req); err != nil {
+		return err
+	}
+	
+	// Moving a page is only allowed into another notebook the user owns
+	if !userOwnsNotebook(c, req.NotebookID) {
+		return apierror.NotFound("Notebook not found")
+	}
+	req.apply(&page)
+	
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := saveVersioned(tx.Model(&page).Select("*"), &page, &page.Version); err != nil {
+			return err
+		}
+		return database.SyncTags(tx, currentUserID(c), database.TaggedPages, page.ID, page.Tags)
+	})
+	if errors.Is(err, errVersionConflict) {
+		return stalePage(c, id)
+	}
+	if err != nil {
+		return apierror.Internal("Failed to update page").WithCause(err)
+	}
 	
 	// Broadcast page update (triggers notebook update)
 	if ws.GlobalHub != nil {
-		ws.GlobalHub.BroadcastToUser(0, ws.MessageTypeNotebookUpdate, page)
+		ws.GlobalHub.BroadcastToUser(currentUserID(c), ws.MessageTypeNotebookUpdate, page)
 	}
 	
+	setETag(c, page.Version)
 	return c.JSON(page)
 }
 
@@ -169,31 +287,48 @@ func DeletePage(c *fiber.Ctx) error {
 	id := c.Params("id")
 	var page models.Page
 	
-	if err := database.DB.First(&page, id).Error; err != nil {
-		return c.Status(404).JSON(fiber.Map{
-			"error": "Page not found",
-		})
+	if err := findUserPage(c, id, &page); err != nil {
+		return apierror.NotFound("Page not found")
+	}
+	
+	if ifMatchFails(c, page.Version) {
+		return preconditionFailed(c, page.Version, page)
 	}
 	
-	database.DB.Delete(&page)
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := deleteVersioned(tx, &page, page.Version); err != nil {
+			return err
+		}
+		return database.DeleteTagLinks(tx, database.TaggedPages, []uint{page.ID})
+	})
+	if errors.Is(err, errVersionConflict) {
+		return stalePage(c, id)
+	}
+	if err != nil {
+		return apierror.Internal("Failed to delete page").WithCause(err)
+	}
+	recordChange(c, models.AuditPageDelete, "page", page.ID, page, nil)
 	
 	// Broadcast page deletion (triggers notebook update)
 	if ws.GlobalHub != nil {
-		ws.GlobalHub.BroadcastToUser(0, ws.MessageTypeNotebookUpdate, fiber.Map{"page_id": id})
+		ws.GlobalHub.BroadcastToUser(currentUserID(c), ws.MessageTypeNotebookUpdate, fiber.Map{"page_id": id})
 	}
 	
 	return c.Status(204).SendString("")
 }
 
-// SearchPages searches for pages by title or content
+// SearchPages searches the current user's pages by title or content
 func SearchPages(c *fiber.Ctx) error {
 	query := c.Query("q")
 	var pages []models.Page
 	
+	scope := userPages(c).Select("pages.*")
+	
 	if query != "" {
-		database.DB.Where("title LIKE ? OR content LIKE ?", "%"+query+"%", "%"+query+"%").Find(&pages)
-	} else {
-		database.DB.Find(&pages)
+		scope = scope.Where("pages.title LIKE ? OR pages.content LIKE ?", "%"+query+"%", "%"+query+"%")
+	}
+	if err := scope.Find(&pages).Error; err != nil {
+		return apierror.Internal("Failed to search pages").WithCause(err)
 	}
 	
 	return c.JSON(pages)
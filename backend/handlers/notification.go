@@ -0,0 +1,241 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"tonish/backend/apierror"
+	"tonish/backend/database"
+	"tonish/backend/models"
+	ws "tonish/backend/websocket"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// paymentNoticeLead is how long before its due date an unpaid payment shows
+// up in the inbox.
+const paymentNoticeLead = 3 * 24 * time.Hour
+
+// unreadNotifications counts the notifications a user has not read.
+func unreadNotifications(userID uint) (int64, error) {
+	var count int64
+	err := database.DB.Model(&models.Notification{}).Where("user_id = ? AND read_at IS NULL", userID).Count(&count).Error
+	return count, err
+}
+
+// broadcastUnreadCount sends the user's unread count to their clients,
+// after a notification arrived, was read or was deleted.
+func broadcastUnreadCount(userID uint) {
+	if ws.GlobalHub == nil {
+		return
+	}
+	count, err := unreadNotifications(userID)
+	if err != nil {
+		return
+	}
+	ws.GlobalHub.BroadcastToUser(userID, ws.MessageTypeNotificationCount, fiber.Map{"unread": count})
+}
+
+// pushNotification sends a new notification about task to the owner's
+// clients and updates their unread count. Reminders keep their own message
+// type; every other notification is sent as notification.
+func pushNotification(notification *models.Notification, task *models.Task) {
+	if ws.GlobalHub != nil {
+		messageType := ws.MessageTypeNotification
+		if notification.Type == models.NotificationReminder {
+			messageType = ws.MessageTypeReminder
+		}
+		attachTaskBlockers(task)
+		ws.GlobalHub.BroadcastToUser(notification.UserID, messageType, fiber.Map{"notification": notification, "task": task})
+	}
+	broadcastUnreadCount(notification.UserID)
+}
+
+// notifyDue adds an overdue notification for each open task whose due day
+// has ended in its owner's timezone, and a payment notification for each
+// unpaid payment due within paymentNoticeLead. A task gets each kind once
+// per due date. It returns how many notifications it created.
+func (s *reminderScheduler) notifyDue() int {
+	now := s.now().UTC()
+	created := 0
+
+	overdue, err := awaitingNotice(models.NotificationOverdue, database.DB.Where("due_date < ?", now))
+	if err != nil {
+		log.Printf("Failed to load overdue tasks: %v\n", err)
+	}
+	for i := range overdue {
+		task := &overdue[i]
+		// Overdue by the owner's calendar, as GET /tasks/overdue counts it
+		settings := loadUserSettings(task.UserID)
+		if !task.DueDate.Before(startOfDay(now, settings.Location())) {
+			continue
+		}
+		if notifyTask(task, models.NotificationOverdue, "Was due "+dueTime(task)) {
+			created++
+		}
+	}
+
+	payments, err := awaitingNotice(models.NotificationPaymentDue, database.DB.
+		Where("is_payment = ? AND is_paid = ? AND due_date >= ? AND due_date < ?", true, false, now, now.Add(paymentNoticeLead)))
+	if err != nil {
+		log.Printf("Failed to load upcoming payments: %v\n", err)
+	}
+	for i := range payments {
+		if notifyTask(&payments[i], models.NotificationPaymentDue, paymentBody(&payments[i])) {
+			created++
+		}
+	}
+	return created
+}
+
+// awaitingNotice loads the open tasks query matches that have no
+// notification of kind for their current due date, earliest due first.
+func awaitingNotice(kind string, query *gorm.DB) ([]models.Task, error) {
+	var tasks []models.Task
+	err := query.Where("is_archived = ? AND status != ? AND due_date IS NOT NULL", false, "done").
+		Where(`NOT EXISTS (SELECT 1 FROM notifications WHERE notifications.task_id = tasks.id
+			AND notifications.type = ? AND notifications.due_date = tasks.due_date)`, kind).
+		Order("due_date, id").Limit(reminderBatch).Find(&tasks).Error
+	return tasks, err
+}
+
+// notifyTask stores a notification of kind about task's due date and pushes
+// it. It reports whether the notification is new; the unique index on task,
+// type and due date keeps out a second one.
+func notifyTask(task *models.Task, kind, body string) bool {
+	notification := models.Notification{
+		UserID:  task.UserID,
+		Type:    kind,
+		Title:   task.Title,
+		Body:    body,
+		TaskID:  &task.ID,
+		DueDate: task.DueDate,
+	}
+	result := database.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&notification)
+	if result.Error != nil {
+		log.Printf("Failed to notify about task %d: %v\n", task.ID, result.Error)
+		return false
+	}
+	if result.RowsAffected == 0 {
+		return false
+	}
+	pushNotification(&notification, task)
+	return true
+}
+
+// paymentBody says how much is due when.
+func paymentBody(task *models.Task) string {
+	if task.Amount == 0 {
+		return "Payment due " + dueTime(task)
+	}
+	return fmt.Sprintf("Payment of %.2f %s due %s", task.Amount, task.Currency, dueTime(task))
+}
+
+// findNotification loads the current user's notification named by :id.
+func findNotification(c *fiber.Ctx, notification *models.Notification) error {
+	if err := database.DB.Where("id = ? AND user_id = ?", c.Params("id"), currentUserID(c)).First(notification).Error; err != nil {
+		return apierror.NotFound("Notification not found")
+	}
+	return nil
+}
+
+// GetNotifications lists the current user's notifications, newest first,
+// with ?unread=true for unread ones only and ?limit= and ?offset= pages
+func GetNotifications(c *fiber.Ctx) error {
+	userID := currentUserID(c)
+	limit, offset := pageParams(c)
+	query := database.DB.Model(&models.Notification{}).Where("user_id = ?", userID)
+	if c.QueryBool("unread") {
+		query = query.Where("read_at IS NULL")
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return apierror.Internal("Failed to load notifications").WithCause(err)
+	}
+
+	notifications := []models.Notification{}
+	if err := query.Order("created_at DESC, id DESC").Limit(limit).Offset(offset).Find(&notifications).Error; err != nil {
+		return apierror.Internal("Failed to load notifications").WithCause(err)
+	}
+
+	unread, err := unreadNotifications(userID)
+	if err != nil {
+		return apierror.Internal("Failed to load notifications").WithCause(err)
+	}
+
+	return c.JSON(fiber.Map{
+		"notifications": notifications,
+		"total":         total,
+		"unread":        unread,
+	})
+}
+
+// GetUnreadNotificationCount returns how many notifications are unread
+func GetUnreadNotificationCount(c *fiber.Ctx) error {
+	unread, err := unreadNotifications(currentUserID(c))
+	if err != nil {
+		return apierror.Internal("Failed to count notifications").WithCause(err)
+	}
+	return c.JSON(fiber.Map{"unread": unread})
+}
+
+// MarkNotificationRead marks one notification as read; reading it again
+// keeps the first read time
+func MarkNotificationRead(c *fiber.Ctx) error {
+	var notification models.Notification
+	if err := findNotification(c, &notification); err != nil {
+		return err
+	}
+	if notification.ReadAt != nil {
+		return c.JSON(notification)
+	}
+
+	now := time.Now()
+	notification.ReadAt = &now
+	if err := database.DB.Model(&notification).Update("read_at", now).Error; err != nil {
+		return apierror.Internal("Failed to mark notification as read").WithCause(err)
+	}
+
+	broadcastUnreadCount(notification.UserID)
+
+	return c.JSON(notification)
+}
+
+// MarkAllNotificationsRead marks every unread notification of the current
+// user as read
+func MarkAllNotificationsRead(c *fiber.Ctx) error {
+	userID := currentUserID(c)
+	result := database.DB.Model(&models.Notification{}).Where("user_id = ? AND read_at IS NULL", userID).
+		Update("read_at", time.Now())
+	if result.Error != nil {
+		return apierror.Internal("Failed to mark notifications as read").WithCause(result.Error)
+	}
+
+	if result.RowsAffected > 0 {
+		broadcastUnreadCount(userID)
+	}
+
+	return c.JSON(fiber.Map{"marked": result.RowsAffected})
+}
+
+// DeleteNotification removes a notification from the inbox
+func DeleteNotification(c *fiber.Ctx) error {
+	var notification models.Notification
+	if err := findNotification(c, &notification); err != nil {
+		return err
+	}
+
+	if err := database.DB.Delete(&notification).Error; err != nil {
+		return apierror.Internal("Failed to delete notification").WithCause(err)
+	}
+
+	if notification.ReadAt == nil {
+		broadcastUnreadCount(notification.UserID)
+	}
+
+	return c.Status(204).SendString("")
+}
@@ -0,0 +1,194 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"tonish/backend/database"
+	"tonish/backend/models"
+	ws "tonish/backend/websocket"
+)
+
+// inbox is the response of GET /api/notifications.
+type inbox struct {
+	Notifications []models.Notification `json:"notifications"`
+	Total         int64                 `json:"total"`
+	Unread        int64                 `json:"unread"`
+}
+
+// seedNotifications stores n notifications for userID, a minute apart with
+// the newest last, and returns them in that order.
+func seedNotifications(t *testing.T, userID uint, n int) []models.Notification {
+	t.Helper()
+	start := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	list := make([]models.Notification, n)
+	for i := range list {
+		list[i] = models.Notification{
+			UserID:    userID,
+			Type:      models.NotificationReminder,
+			Title:     fmt.Sprintf("Reminder %d", i),
+			CreatedAt: start.Add(time.Duration(i) * time.Minute),
+		}
+		if err := database.DB.Create(&list[i]).Error; err != nil {
+			t.Fatalf("create notification: %v", err)
+		}
+	}
+	return list
+}
+
+// expectUnread waits for a notification_count message and checks its count.
+func expectUnread(t *testing.T, client *ws.Client, want int64) {
+	t.Helper()
+	select {
+	case data := <-client.Send:
+		var message struct {
+			Type string `json:"type"`
+			Data struct {
+				Unread int64 `json:"unread"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(data, &message); err != nil {
+			t.Fatalf("decode message: %v", err)
+		}
+		if message.Type != ws.MessageTypeNotificationCount || message.Data.Unread != want {
+			t.Fatalf("got %s, want an unread count of %d", data, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no unread count message")
+	}
+}
+
+// expectQuiet checks that no message is waiting for client.
+func expectQuiet(t *testing.T, client *ws.Client) {
+	t.Helper()
+	select {
+	case data := <-client.Send:
+		t.Fatalf("unexpected message %s", data)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestListNotifications(t *testing.T) {
+	app := newTestApp(t)
+	alice, token := createUser(t, app, "alice@example.com")
+	bob, _ := createUser(t, app, "bob@example.com")
+	seeded := seedNotifications(t, alice.ID, 5)
+	seedNotifications(t, bob.ID, 2)
+	now := time.Now()
+	database.DB.Model(&seeded[4]).Update("read_at", now)
+	database.DB.Model(&seeded[1]).Update("read_at", now)
+
+	// Newest first, a page at a time
+	var first, second inbox
+	if status := doJSON(t, app, "GET", "/api/notifications?limit=2", token, nil, &first); status != 200 {
+		t.Fatalf("list: status %d", status)
+	}
+	doJSON(t, app, "GET", "/api/notifications?limit=2&offset=2", token, nil, &second)
+	if first.Total != 5 || first.Unread != 3 || second.Total != 5 {
+		t.Fatalf("total %d, unread %d; want 5 and 3", first.Total, first.Unread)
+	}
+	got := append(first.Notifications, second.Notifications...)
+	want := []uint{seeded[4].ID, seeded[3].ID, seeded[2].ID, seeded[1].ID}
+	if len(got) != len(want) {
+		t.Fatalf("got %d notifications over two pages, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i].ID != want[i] {
+			t.Fatalf("page order %v, want %v", got, want)
+		}
+	}
+
+	var unread inbox
+	doJSON(t, app, "GET", "/api/notifications?unread=true", token, nil, &unread)
+	if unread.Total != 3 || len(unread.Notifications) != 3 {
+		t.Fatalf("unread filter: %d of %d", len(unread.Notifications), unread.Total)
+	}
+	for _, notification := range unread.Notifications {
+		if notification.ReadAt != nil {
+			t.Fatalf("read notification %d in the unread filter", notification.ID)
+		}
+	}
+
+	var count struct {
+		Unread int64 `json:"unread"`
+	}
+	if status := doJSON(t, app, "GET", "/api/notifications/unread-count", token, nil, &count); status != 200 || count.Unread != 3 {
+		t.Fatalf("unread count: status %d, %d", status, count.Unread)
+	}
+}
+
+func TestNotificationChangesPushUnreadCount(t *testing.T) {
+	app := newTestApp(t)
+	alice, token := createUser(t, app, "alice@example.com")
+	bob, bobToken := createUser(t, app, "bob@example.com")
+	seeded := seedNotifications(t, alice.ID, 4)
+	bobs := seedNotifications(t, bob.ID, 1)
+
+	hub := ws.NewHub()
+	go hub.Run()
+	ws.GlobalHub = hub
+	t.Cleanup(func() { ws.GlobalHub = nil })
+	client := connectClient(hub, alice.ID, 0)
+
+	path := func(n models.Notification, action string) string {
+		return fmt.Sprintf("/api/notifications/%d%s", n.ID, action)
+	}
+
+	var read models.Notification
+	if status := doJSON(t, app, "POST", path(seeded[0], "/read"), token, nil, &read); status != 200 || read.ReadAt == nil {
+		t.Fatalf("mark read: status %d, read_at %v", status, read.ReadAt)
+	}
+	expectUnread(t, client, 3)
+
+	// Reading it again changes nothing and keeps the first read time
+	var again models.Notification
+	doJSON(t, app, "POST", path(seeded[0], "/read"), token, nil, &again)
+	if again.ReadAt == nil || !again.ReadAt.Equal(*read.ReadAt) {
+		t.Fatalf("read time moved from %v to %v", read.ReadAt, again.ReadAt)
+	}
+	expectQuiet(t, client)
+
+	// Deleting an unread notification lowers the count; a read one does not
+	if status := doJSON(t, app, "DELETE", path(seeded[1], ""), token, nil, nil); status != 204 {
+		t.Fatalf("delete: status %d", status)
+	}
+	expectUnread(t, client, 2)
+	if status := doJSON(t, app, "DELETE", path(seeded[0], ""), token, nil, nil); status != 204 {
+		t.Fatalf("delete read notification: status %d", status)
+	}
+	expectQuiet(t, client)
+
+	// Other users' notifications are out of reach
+	if status := doJSON(t, app, "POST", path(bobs[0], "/read"), token, nil, nil); status != 404 {
+		t.Fatalf("read another user's notification: status %d, want 404", status)
+	}
+	if status := doJSON(t, app, "DELETE", path(bobs[0], ""), token, nil, nil); status != 404 {
+		t.Fatalf("delete another user's notification: status %d, want 404", status)
+	}
+
+	var marked struct {
+		Marked int64 `json:"marked"`
+	}
+	if status := doJSON(t, app, "POST", "/api/notifications/read-all", token, nil, &marked); status != 200 || marked.Marked != 2 {
+		t.Fatalf("read all: status %d, marked %d", status, marked.Marked)
+	}
+	expectUnread(t, client, 0)
+	doJSON(t, app, "POST", "/api/notifications/read-all", token, nil, &marked)
+	if marked.Marked != 0 {
+		t.Fatalf("second read all marked %d", marked.Marked)
+	}
+	expectQuiet(t, client)
+
+	var left inbox
+	doJSON(t, app, "GET", "/api/notifications", token, nil, &left)
+	if left.Total != 2 || left.Unread != 0 {
+		t.Fatalf("inbox afterwards: total %d, unread %d", left.Total, left.Unread)
+	}
+	var bobInbox inbox
+	doJSON(t, app, "GET", "/api/notifications", bobToken, nil, &bobInbox)
+	if bobInbox.Unread != 1 {
+		t.Fatalf("bob's unread count %d, want 1", bobInbox.Unread)
+	}
+}
@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"tonish/backend/database"
+	"tonish/backend/models"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// currentUserID returns the ID of the authenticated user set by
+// middleware.AuthRequired, or 0 when the request is anonymous.
+func currentUserID(c *fiber.Ctx) uint {
+	if id, ok := c.Locals("user_id").(uint); ok {
+		return id
+	}
+	return 0
+}
+
+// userTasks scopes a task query to the rows owned by the current user.
+func userTasks(c *fiber.Ctx) *gorm.DB {
+	return database.DB.Where("tasks.user_id = ?", currentUserID(c))
+}
+
+// userNotebooks scopes a notebook query to the rows owned by the current user.
+func userNotebooks(c *fiber.Ctx) *gorm.DB {
+	return database.DB.Where("notebooks.user_id = ?", currentUserID(c))
+}
+
+// userPages scopes a page query to pages whose notebook belongs to the
+// current user. Pages carry no owner of their own.
+func userPages(c *fiber.Ctx) *gorm.DB {
+	return database.DB.
+		Joins("JOIN notebooks ON notebooks.id = pages.notebook_id").
+		Where("notebooks.user_id = ?", currentUserID(c))
+}
+
+// findUserTask loads a task owned by the current user, with its blockers.
+// Unscoped lookups also match soft-deleted rows.
+func findUserTask(c *fiber.Ctx, id string, task *models.Task, unscoped bool) error {
+	query := userTasks(c)
+	if unscoped {
+		query = query.Unscoped()
+	}
+	if err := query.Where("tasks.id = ?", id).First(task).Error; err != nil {
+		return err
+	}
+	attachTaskBlockers(task)
+	return nil
+}
+
+// findUserNotebook loads a notebook owned by the current user.
+func findUserNotebook(c *fiber.Ctx, id string, notebook *models.Notebook) error {
+	return userNotebooks(c).Where("notebooks.id = ?", id).First(notebook).Error
+}
+
+// findUserPage loads a page that lives in one of the current user's notebooks.
+func findUserPage(c *fiber.Ctx, id string, page *models.Page) error {
+	return userPages(c).Select("pages.*").Where("pages.id = ?", id).First(page).Error
+}
+
+// userOwnsNotebook reports whether the notebook exists and belongs to the
+// current user.
+func userOwnsNotebook(c *fiber.Ctx, notebookID uint) bool {
+	var count int64
+	userNotebooks(c).Model(&models.Notebook{}).Where("notebooks.id = ?", notebookID).Count(&count)
+	return count > 0
+}
+
+// findUserView loads a saved view owned by the current user.
+func findUserView(c *fiber.Ctx, id string, view *models.SavedView) error {
+	return database.DB.Where("id = ? AND user_id = ?", id, currentUserID(c)).First(view).Error
+}
@@ -0,0 +1,166 @@
+package handlers_test
+
+import (
+	"fmt"
+	"testing"
+
+	"tonish/backend/database"
+	"tonish/backend/models"
+)
+
+// taskPage is the response of the task listing endpoints.
+type taskPage struct {
+	Tasks      []models.Task `json:"tasks"`
+	NextCursor *string       `json:"next_cursor"`
+	Total      int64         `json:"total"`
+}
+
+func TestTaskIsolation(t *testing.T) {
+	app := newTestApp(t)
+	alice, aliceToken := createUser(t, app, "alice@example.com")
+	_, bobToken := createUser(t, app, "bob@example.com")
+
+	var task models.Task
+	if status := doJSON(t, app, "POST", "/api/tasks", aliceToken, map[string]interface{}{
+		"title":   "Prepare tax return",
+		"user_id": 9999,
+	}, &task); status != 201 {
+		t.Fatalf("create task: status %d", status)
+	}
+	if task.UserID != alice.ID {
+		t.Fatalf("task owner = %d, want %d", task.UserID, alice.ID)
+	}
+
+	var bobTasks taskPage
+	doJSON(t, app, "GET", "/api/tasks", bobToken, nil, &bobTasks)
+	if len(bobTasks.Tasks) != 0 || bobTasks.Total != 0 {
+		t.Fatalf("bob sees %d of alice's tasks", bobTasks.Total)
+	}
+
+	path := fmt.Sprintf("/api/tasks/%d", task.ID)
+	cases := []struct {
+		method, path string
+		body         interface{}
+	}{
+		{"GET", path, nil},
+		{"PUT", path, map[string]string{"title": "hijacked"}},
+		{"POST", path + "/archive", nil},
+		{"POST", path + "/restore", nil},
+		{"DELETE", path, nil},
+		{"DELETE", path + "/permanent", nil},
+	}
+	for _, tc := range cases {
+		if status := doJSON(t, app, tc.method, tc.path, bobToken, tc.body, nil); status != 404 {
+			t.Errorf("bob %s %s: status %d, want 404", tc.method, tc.path, status)
+		}
+	}
+
+	for _, listPath := range []string{"/api/tasks?archived=true", "/api/tasks/archived", "/api/tasks/status"} {
+		var listed taskPage
+		doJSON(t, app, "GET", listPath, bobToken, nil, &listed)
+		if len(listed.Tasks) != 0 || listed.Total != 0 {
+			t.Errorf("bob GET %s: saw %d tasks", listPath, listed.Total)
+		}
+	}
+	var listed []models.Task
+	doJSON(t, app, "GET", "/api/tasks/quadrant/urgent-important", bobToken, nil, &listed)
+	if len(listed) != 0 {
+		t.Errorf("bob GET /api/tasks/quadrant/urgent-important: saw %d tasks", len(listed))
+	}
+
+	var stored models.Task
+	if err := database.DB.Unscoped().First(&stored, task.ID).Error; err != nil {
+		t.Fatalf("alice's task was deleted: %v", err)
+	}
+	if stored.Title != "Prepare tax return" || stored.IsArchived || stored.DeletedAt.Valid {
+		t.Fatalf("alice's task was modified: %+v", stored)
+	}
+
+	if status := doJSON(t, app, "PUT", path, aliceToken, map[string]interface{}{
+		"title":   "Prepare tax return",
+		"user_id": 9999,
+	}, &stored); status != 200 || stored.UserID != alice.ID {
+		t.Fatalf("owner update: status %d, owner %d", status, stored.UserID)
+	}
+}
+
+func TestNotebookAndPageIsolation(t *testing.T) {
+	app := newTestApp(t)
+	_, aliceToken := createUser(t, app, "alice@example.com")
+	_, bobToken := createUser(t, app, "bob@example.com")
+
+	var notebook models.Notebook
+	if status := doJSON(t, app, "POST", "/api/notebooks", aliceToken, map[string]string{"name": "Journal"}, &notebook); status != 201 {
+		t.Fatalf("create notebook: status %d", status)
+	}
+	var page models.Page
+	if status := doJSON(t, app, "POST", "/api/pages", aliceToken, map[string]interface{}{
+		"notebook_id": notebook.ID,
+		"title":       "Secret plans",
+		"content":     "needle",
+	}, &page); status != 201 {
+		t.Fatalf("create page: status %d", status)
+	}
+
+	var bobNotebook models.Notebook
+	doJSON(t, app, "POST", "/api/notebooks", bobToken, map[string]string{"name": "Bob's"}, &bobNotebook)
+
+	var listed []models.Notebook
+	doJSON(t, app, "GET", "/api/notebooks", bobToken, nil, &listed)
+	if len(listed) != 1 || listed[0].ID != bobNotebook.ID {
+		t.Fatalf("bob's notebook list = %+v", listed)
+	}
+
+	var found []models.Page
+	doJSON(t, app, "GET", "/api/pages/search?q=needle", bobToken, nil, &found)
+	if len(found) != 0 {
+		t.Fatalf("bob found %d of alice's pages", len(found))
+	}
+	doJSON(t, app, "GET", "/api/pages/search?q=needle", aliceToken, nil, &found)
+	if len(found) != 1 {
+		t.Fatalf("alice found %d pages, want 1", len(found))
+	}
+
+	notebookPath := fmt.Sprintf("/api/notebooks/%d", notebook.ID)
+	pagePath := fmt.Sprintf("/api/pages/%d", page.ID)
+	cases := []struct {
+		method, path string
+		body         interface{}
+	}{
+		{"GET", notebookPath, nil},
+		{"PUT", notebookPath, map[string]string{"name": "hijacked"}},
+		{"DELETE", notebookPath, nil},
+		{"GET", pagePath, nil},
+		{"PUT", pagePath, map[string]string{"title": "hijacked"}},
+		{"DELETE", pagePath, nil},
+		{"POST", "/api/pages", map[string]interface{}{"notebook_id": notebook.ID, "title": "intruder"}},
+	}
+	for _, tc := range cases {
+		if status := doJSON(t, app, tc.method, tc.path, bobToken, tc.body, nil); status != 404 {
+			t.Errorf("bob %s %s: status %d, want 404", tc.method, tc.path, status)
+		}
+	}
+
+	// Alice cannot move her page into Bob's notebook either
+	if status := doJSON(t, app, "PUT", pagePath, aliceToken, map[string]interface{}{
+		"notebook_id": bobNotebook.ID,
+	}, nil); status != 404 {
+		t.Errorf("move page into foreign notebook: status %d, want 404", status)
+	}
+
+	var storedPage models.Page
+	if err := database.DB.First(&storedPage, page.ID).Error; err != nil {
+		t.Fatalf("alice's page was deleted: %v", err)
+	}
+	if storedPage.Title != "Secret plans" || storedPage.NotebookID != notebook.ID {
+		t.Fatalf("alice's page was modified: %+v", storedPage)
+	}
+}
+
+func TestAuthRequired(t *testing.T) {
+	app := newTestApp(t)
+
+	if status := doJSON(t, app, "GET", "/api/tasks", "", nil, nil); status != 401 {
+		t.Fatalf("anonymous GET /api/tasks: status %d, want 401", status)
+	}
+}
@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"tonish/backend/apierror"
+	"tonish/backend/database"
+	"tonish/backend/mailer"
+	"tonish/backend/middleware"
+	"tonish/backend/models"
+
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+const (
+	passwordResetTTL = time.Hour
+	// passwordResetInterval limits how often reset mail goes to one account.
+	passwordResetInterval = time.Minute
+)
+
+// errResetTokenUsed aborts a reset whose token was used concurrently.
+var errResetTokenUsed = errors.New("reset token already used")
+
+func init() {
+	apierror.Register(errResetTokenUsed, fiber.StatusBadRequest, "bad_request", "Invalid or expired reset token")
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" validate:"required"`
+	NewPassword     string `json:"new_password" validate:"required,password,max=72"`
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" validate:"required,max=254"`
+}
+
+type ResetPasswordRequest struct {
+	Token       string `json:"token" validate:"required"`
+	NewPassword string `json:"new_password" validate:"required,password,max=72"`
+}
+
+// ChangePassword sets a new password after checking the current one. Every
+// other session is signed out; the caller's stays signed in.
+func ChangePassword(c *fiber.Ctx) error {
+	req := new(ChangePasswordRequest)
+	if err := parseBody(c, req); err != nil {
+		return err
+	}
+
+	var user models.User
+	if err := database.DB.First(&user, currentUserID(c)).Error; err != nil {
+		return apierror.NotFound("User not found")
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.CurrentPassword)); err != nil {
+		return apierror.Unauthorized("Current password is incorrect")
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return apierror.Internal("Failed to change password").WithCause(err)
+	}
+	if err := database.DB.Model(&user).Update("password", string(hashedPassword)).Error; err != nil {
+		return apierror.Internal("Failed to change password").WithCause(err)
+	}
+
+	sessionID, _ := c.Locals("session_id").(uint)
+	if err := revokeUserSessions(user.ID, sessionID); err != nil {
+		log.Printf("Failed to revoke sessions after password change for user %d: %v\n", user.ID, err)
+	}
+	recordAudit(c, &user.ID, models.AuditPasswordChange, nil)
+
+	return c.Status(204).SendString("")
+}
+
+// ForgotPassword mails a reset token to the account, if it exists. The
+// response is the same either way so it cannot be used to probe for accounts.
+func ForgotPassword(c *fiber.Ctx) error {
+	req := new(ForgotPasswordRequest)
+	if err := parseBody(c, req); err != nil {
+		return err
+	}
+
+	accepted := fiber.Map{
+		"message": "If the account exists, a reset link has been sent",
+	}
+
+	var user models.User
+	if err := database.DB.Where("LOWER(email) = LOWER(?)", strings.TrimSpace(req.Email)).First(&user).Error; err != nil {
+		return c.Status(202).JSON(accepted)
+	}
+
+	var recent int64
+	database.DB.Model(&models.PasswordResetToken{}).
+		Where("user_id = ? AND created_at > ?", user.ID, time.Now().Add(-passwordResetInterval)).
+		Count(&recent)
+	if recent > 0 {
+		return c.Status(202).JSON(accepted)
+	}
+
+	if err := sendPasswordReset(&user); err != nil {
+		return apierror.Internal("Failed to start password reset").WithCause(err)
+	}
+
+	return c.Status(202).JSON(accepted)
+}
+
+// sendPasswordReset creates a reset token for user and mails it. A failed
+// delivery is only logged, since the caller cannot do anything about it.
+func sendPasswordReset(user *models.User) error {
+	token, tokenHash, err := generateSecret("")
+	if err != nil {
+		return err
+	}
+
+	reset := models.PasswordResetToken{
+		UserID:    user.ID,
+		TokenHash: tokenHash,
+		ExpiresAt: time.Now().Add(passwordResetTTL),
+	}
+	if err := database.DB.Create(&reset).Error; err != nil {
+		return err
+	}
+
+	if err := mailer.Default.Send(passwordResetMessage(user, token)); err != nil {
+		log.Printf("Failed to send password reset mail to user %d: %v\n", user.ID, err)
+	}
+
+	return nil
+}
+
+// passwordResetMessage builds the reset email. When APP_URL is set it
+// contains a link to the frontend; the token is always included.
+func passwordResetMessage(user *models.User, token string) mailer.Message {
+	var body strings.Builder
+	body.WriteString("Someone asked to reset the password for your Tonish account.\n\n")
+	if appURL := strings.TrimRight(os.Getenv("APP_URL"), "/"); appURL != "" {
+		body.WriteString("Reset it here: " + appURL + "/reset-password?token=" + url.QueryEscape(token) + "\n\n")
+	}
+	body.WriteString("Reset token: " + token + "\n\n")
+	body.WriteString("The token expires in one hour. If you did not ask for this, you can ignore this email.\n")
+
+	return mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your Tonish password",
+		Body:    body.String(),
+	}
+}
+
+// ResetPassword sets a new password using a mailed reset token and signs out
+// every session of the account
+func ResetPassword(c *fiber.Ctx) error {
+	req := new(ResetPasswordRequest)
+	if err := parseBody(c, req); err != nil {
+		return err
+	}
+
+	var reset models.PasswordResetToken
+	if err := database.DB.Where("token_hash = ?", middleware.HashSecret(req.Token)).First(&reset).Error; err != nil ||
+		reset.UsedAt != nil || time.Now().After(reset.ExpiresAt) {
+		return apierror.BadRequest("Invalid or expired reset token")
+	}
+
+	var user models.User
+	if err := database.DB.First(&user, reset.UserID).Error; err != nil {
+		return apierror.BadRequest("Invalid or expired reset token")
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return apierror.Internal("Failed to reset password").WithCause(err)
+	}
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		// Claim the token; losing the race means it was already used
+		result := tx.Model(&models.PasswordResetToken{}).
+			Where("id = ? AND used_at IS NULL", reset.ID).
+			Update("used_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errResetTokenUsed
+		}
+
+		// Any other outstanding reset tokens are void once one is used
+		if err := tx.Where("user_id = ? AND used_at IS NULL", user.ID).Delete(&models.PasswordResetToken{}).Error; err != nil {
+			return err
+		}
+		return tx.Model(&user).Update("password", string(hashedPassword)).Error
+	})
+	if err != nil {
+		return apierror.Internal("Failed to reset password").WithCause(err)
+	}
+
+	if err := revokeUserSessions(user.ID, 0); err != nil {
+		log.Printf("Failed to revoke sessions after password reset for user %d: %v\n", user.ID, err)
+	}
+	recordLoginSuccess(accountKey(user.Email))
+	recordAudit(c, &user.ID, models.AuditPasswordReset, nil)
+
+	return c.Status(204).SendString("")
+}
@@ -0,0 +1,190 @@
+package handlers_test
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"tonish/backend/database"
+	"tonish/backend/mailer"
+	"tonish/backend/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// captureMailer records messages instead of delivering them.
+type captureMailer struct {
+	mu       sync.Mutex
+	messages []mailer.Message
+}
+
+func (m *captureMailer) Send(msg mailer.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+func (m *captureMailer) sent() []mailer.Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]mailer.Message(nil), m.messages...)
+}
+
+// captureMail swaps mailer.Default for a captureMailer for the rest of the
+// test.
+func captureMail(t *testing.T) *captureMailer {
+	t.Helper()
+	saved := mailer.Default
+	capture := &captureMailer{}
+	mailer.Default = capture
+	t.Cleanup(func() { mailer.Default = saved })
+	return capture
+}
+
+// resetToken extracts the token from a password reset message.
+func resetToken(t *testing.T, msg mailer.Message) string {
+	t.Helper()
+	for _, line := range strings.Split(msg.Body, "\n") {
+		if token := strings.TrimPrefix(line, "Reset token: "); token != line {
+			return token
+		}
+	}
+	t.Fatalf("no reset token in %q", msg.Body)
+	return ""
+}
+
+// forgotPassword asks for a reset mail for email and returns its token.
+func forgotPassword(t *testing.T, app *fiber.App, mail *captureMailer, email string) string {
+	t.Helper()
+	before := len(mail.sent())
+	if status := doJSON(t, app, "POST", "/api/auth/password/forgot", "", map[string]string{"email": email}, nil); status != 202 {
+		t.Fatalf("forgot password: status %d", status)
+	}
+	sent := mail.sent()
+	if len(sent) != before+1 {
+		t.Fatalf("%d reset mails sent, want 1", len(sent)-before)
+	}
+	return resetToken(t, sent[len(sent)-1])
+}
+
+func loginStatus(t *testing.T, app *fiber.App, email, password string) int {
+	t.Helper()
+	return doJSON(t, app, "POST", "/api/auth/login", "", map[string]string{
+		"email":    email,
+		"password": password,
+	}, nil)
+}
+
+func TestPasswordResetRoundTrip(t *testing.T) {
+	app := newTestApp(t)
+	mail := captureMail(t)
+	_, session := createUser(t, app, "reset@example.com")
+
+	// Unknown accounts get the same answer and no mail
+	if status := doJSON(t, app, "POST", "/api/auth/password/forgot", "", map[string]string{"email": "nobody@example.com"}, nil); status != 202 {
+		t.Fatalf("forgot for unknown account: status %d", status)
+	}
+	if n := len(mail.sent()); n != 0 {
+		t.Fatalf("%d mails for an unknown account", n)
+	}
+
+	token := forgotPassword(t, app, mail, "Reset@Example.com")
+	if to := mail.sent()[0].To; to != "reset@example.com" {
+		t.Fatalf("reset mail went to %q", to)
+	}
+
+	if status := doJSON(t, app, "POST", "/api/auth/password/reset", "", map[string]string{
+		"token":        token,
+		"new_password": "staple-battery-42",
+	}, nil); status != 204 {
+		t.Fatalf("reset: status %d", status)
+	}
+
+	if status := doJSON(t, app, "GET", "/api/user/me", session, nil, nil); status != 401 {
+		t.Fatalf("session from before the reset: status %d, want 401", status)
+	}
+	if status := loginStatus(t, app, "reset@example.com", testPassword); status != 401 {
+		t.Fatalf("login with the old password: status %d, want 401", status)
+	}
+	if status := loginStatus(t, app, "reset@example.com", "staple-battery-42"); status != 200 {
+		t.Fatalf("login with the new password: status %d", status)
+	}
+
+	// A used token cannot be replayed
+	if status := doJSON(t, app, "POST", "/api/auth/password/reset", "", map[string]string{
+		"token":        token,
+		"new_password": "another-pass-99",
+	}, nil); status != 400 {
+		t.Fatalf("reused token: status %d, want 400", status)
+	}
+}
+
+func TestPasswordResetExpiredToken(t *testing.T) {
+	app := newTestApp(t)
+	mail := captureMail(t)
+	user, _ := createUser(t, app, "expired@example.com")
+
+	token := forgotPassword(t, app, mail, "expired@example.com")
+	database.DB.Model(&models.PasswordResetToken{}).Where("user_id = ?", user.ID).
+		Update("expires_at", time.Now().Add(-time.Minute))
+
+	if status := doJSON(t, app, "POST", "/api/auth/password/reset", "", map[string]string{
+		"token":        token,
+		"new_password": "staple-battery-42",
+	}, nil); status != 400 {
+		t.Fatalf("expired token: status %d, want 400", status)
+	}
+	if status := loginStatus(t, app, "expired@example.com", testPassword); status != 200 {
+		t.Fatalf("password changed by an expired token: status %d", status)
+	}
+
+	// Asking again straight away is accepted but sends nothing
+	if status := doJSON(t, app, "POST", "/api/auth/password/forgot", "", map[string]string{"email": "expired@example.com"}, nil); status != 202 {
+		t.Fatalf("second forgot: status %d", status)
+	}
+	if n := len(mail.sent()); n != 1 {
+		t.Fatalf("%d reset mails within the interval, want 1", n)
+	}
+}
+
+func TestChangePassword(t *testing.T) {
+	app := newTestApp(t)
+	createUser(t, app, "change@example.com")
+	laptop := login(t, app, "change@example.com")
+	phone := login(t, app, "change@example.com")
+
+	if status := doJSON(t, app, "POST", "/api/user/password", laptop.Token, map[string]string{
+		"current_password": "not-my-password1",
+		"new_password":     "staple-battery-42",
+	}, nil); status != 401 {
+		t.Fatalf("wrong current password: status %d, want 401", status)
+	}
+	if status := doJSON(t, app, "GET", "/api/user/me", phone.Token, nil, nil); status != 200 {
+		t.Fatalf("other session after a failed change: status %d", status)
+	}
+
+	if status := doJSON(t, app, "POST", "/api/user/password", laptop.Token, map[string]string{
+		"current_password": testPassword,
+		"new_password":     "staple-battery-42",
+	}, nil); status != 204 {
+		t.Fatalf("change password: status %d", status)
+	}
+
+	// The caller stays signed in; every other session is revoked
+	if status := doJSON(t, app, "GET", "/api/user/me", laptop.Token, nil, nil); status != 200 {
+		t.Fatalf("current session: status %d", status)
+	}
+	if status := doJSON(t, app, "GET", "/api/user/me", phone.Token, nil, nil); status != 401 {
+		t.Fatalf("other session: status %d, want 401", status)
+	}
+	if status := doJSON(t, app, "POST", "/api/auth/refresh", "", map[string]string{
+		"refresh_token": phone.RefreshToken,
+	}, nil); status != 401 {
+		t.Fatalf("other session's refresh token: status %d, want 401", status)
+	}
+	if status := loginStatus(t, app, "change@example.com", "staple-battery-42"); status != 200 {
+		t.Fatalf("login with the new password: status %d", status)
+	}
+}
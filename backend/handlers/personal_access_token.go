@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"strings"
+	"time"
+
+	"tonish/backend/apierror"
+	"tonish/backend/database"
+	"tonish/backend/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type PersonalAccessTokenRequest struct {
+	Name          string   `json:"name" validate:"required,max=100"`
+	Scopes        []string `json:"scopes" validate:"required,scopes"`
+	ExpiresInDays int      `json:"expires_in_days" validate:"min=0,max=3650"` // 0 means the token never expires
+}
+
+func isTokenScope(scope string) bool {
+	for _, known := range models.TokenScopes {
+		if scope == known {
+			return true
+		}
+	}
+	return false
+}
+
+// findUserToken loads a personal access token owned by the current user.
+func findUserToken(c *fiber.Ctx, id string, token *models.PersonalAccessToken) error {
+	return database.DB.Where("id = ? AND user_id = ?", id, currentUserID(c)).First(token).Error
+}
+
+// GetPersonalAccessTokens lists the current user's personal access tokens
+func GetPersonalAccessTokens(c *fiber.Ctx) error {
+	var tokens []models.PersonalAccessToken
+
+	if err := database.DB.Where("user_id = ?", currentUserID(c)).
+		Order("created_at DESC").
+		Find(&tokens).Error; err != nil {
+		return apierror.Internal("Failed to load tokens").WithCause(err)
+	}
+
+	return c.JSON(tokens)
+}
+
+// CreatePersonalAccessToken issues a new token. The token itself is only
+// returned in this response.
+func CreatePersonalAccessToken(c *fiber.Ctx) error {
+	req := new(PersonalAccessTokenRequest)
+
+	if err := parseBody(c, req); err != nil {
+		return err
+	}
+	req.Name = strings.TrimSpace(req.Name)
+
+	secret, secretHash, err := generateSecret(models.PersonalAccessTokenPrefix)
+	if err != nil {
+		return apierror.Internal("Failed to create token").WithCause(err)
+	}
+
+	token := models.PersonalAccessToken{
+		UserID:    currentUserID(c),
+		Name:      req.Name,
+		TokenHash: secretHash,
+		Hint:      secret[len(secret)-4:],
+		Scopes:    req.Scopes,
+	}
+	if req.ExpiresInDays > 0 {
+		expiresAt := time.Now().AddDate(0, 0, req.ExpiresInDays)
+		token.ExpiresAt = &expiresAt
+	}
+
+	if err := database.DB.Create(&token).Error; err != nil {
+		return apierror.Internal("Failed to create token").WithCause(err)
+	}
+	recordChange(c, models.AuditTokenCreate, "personal_access_token", token.ID, nil, token)
+
+	return c.Status(201).JSON(fiber.Map{
+		"token":                 secret,
+		"personal_access_token": token,
+	})
+}
+
+// UpdatePersonalAccessToken renames a token or changes its scopes. The secret
+// and expiry cannot be changed; issue a new token instead.
+func UpdatePersonalAccessToken(c *fiber.Ctx) error {
+	id := c.Params("id")
+	var token models.PersonalAccessToken
+
+	if err := findUserToken(c, id, &token); err != nil {
+		return apierror.NotFound("Token not found")
+	}
+
+	req := new(PersonalAccessTokenRequest)
+	if err := parseBody(c, req); err != nil {
+		return err
+	}
+	req.Name = strings.TrimSpace(req.Name)
+
+	token.Name = req.Name
+	token.Scopes = req.Scopes
+
+	if err := database.DB.Save(&token).Error; err != nil {
+		return apierror.Internal("Failed to update token").WithCause(err)
+	}
+
+	return c.JSON(token)
+}
+
+// DeletePersonalAccessToken revokes a token
+func DeletePersonalAccessToken(c *fiber.Ctx) error {
+	id := c.Params("id")
+	var token models.PersonalAccessToken
+
+	if err := findUserToken(c, id, &token); err != nil {
+		return apierror.NotFound("Token not found")
+	}
+
+	if err := database.DB.Delete(&token).Error; err != nil {
+		return apierror.Internal("Failed to delete token").WithCause(err)
+	}
+	recordChange(c, models.AuditTokenRevoke, "personal_access_token", token.ID, token, nil)
+
+	return c.Status(204).SendString("")
+}
@@ -0,0 +1,103 @@
+package handlers_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"tonish/backend/database"
+	"tonish/backend/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// createToken issues a personal access token with scopes and returns its
+// secret and id.
+func createToken(t *testing.T, app *fiber.App, sessionToken string, scopes ...string) (string, uint) {
+	t.Helper()
+	var created struct {
+		Token string                     `json:"token"`
+		PAT   models.PersonalAccessToken `json:"personal_access_token"`
+	}
+	if status := doJSON(t, app, "POST", "/api/tokens", sessionToken, map[string]interface{}{
+		"name":   "script",
+		"scopes": scopes,
+	}, &created); status != 201 {
+		t.Fatalf("create token: status %d", status)
+	}
+	return created.Token, created.PAT.ID
+}
+
+func TestPersonalAccessTokenScopes(t *testing.T) {
+	app := newTestApp(t)
+	_, session := createUser(t, app, "alice@example.com")
+	readOnly, _ := createToken(t, app, session, models.ScopeTasksRead)
+
+	var task models.Task
+	if status := doJSON(t, app, "POST", "/api/tasks", session, map[string]string{"title": "Water plants"}, &task); status != 201 {
+		t.Fatalf("create task: status %d", status)
+	}
+	path := fmt.Sprintf("/api/tasks/%d", task.ID)
+
+	if status := doJSON(t, app, "GET", "/api/tasks", readOnly, nil, nil); status != 200 {
+		t.Fatalf("read with tasks:read: status %d", status)
+	}
+	if status := doJSON(t, app, "GET", path, readOnly, nil, nil); status != 200 {
+		t.Fatalf("read one with tasks:read: status %d", status)
+	}
+
+	writes := []struct {
+		method, path string
+		body         interface{}
+	}{
+		{"POST", "/api/tasks", map[string]string{"title": "Sneaky"}},
+		{"PUT", path, map[string]string{"title": "Sneaky"}},
+		{"PATCH", path, map[string]string{"title": "Sneaky"}},
+		{"DELETE", path, nil},
+	}
+	for _, w := range writes {
+		if status := doJSON(t, app, w.method, w.path, readOnly, w.body, nil); status != 403 {
+			t.Errorf("%s %s with tasks:read: status %d, want 403", w.method, w.path, status)
+		}
+	}
+
+	// Other resources and account management are out of reach entirely
+	if status := doJSON(t, app, "GET", "/api/notebooks", readOnly, nil, nil); status != 403 {
+		t.Errorf("notebooks with tasks:read: status %d, want 403", status)
+	}
+	if status := doJSON(t, app, "GET", "/api/tokens", readOnly, nil, nil); status != 403 {
+		t.Errorf("token management with a token: status %d, want 403", status)
+	}
+
+	writer, _ := createToken(t, app, session, models.ScopeTasksWrite)
+	if status := doJSON(t, app, "PATCH", path, writer, map[string]string{"title": "Water all plants"}, nil); status != 200 {
+		t.Fatalf("write with tasks:write: status %d", status)
+	}
+}
+
+func TestPersonalAccessTokenRevocationAndExpiry(t *testing.T) {
+	app := newTestApp(t)
+	_, session := createUser(t, app, "alice@example.com")
+
+	revoked, revokedID := createToken(t, app, session, models.ScopeTasksRead)
+	if status := doJSON(t, app, "GET", "/api/tasks", revoked, nil, nil); status != 200 {
+		t.Fatalf("before revoking: status %d", status)
+	}
+	if status := doJSON(t, app, "DELETE", fmt.Sprintf("/api/tokens/%d", revokedID), session, nil, nil); status != 204 {
+		t.Fatalf("revoke token: status %d", status)
+	}
+	if status := doJSON(t, app, "GET", "/api/tasks", revoked, nil, nil); status != 401 {
+		t.Fatalf("revoked token: status %d, want 401", status)
+	}
+
+	expired, expiredID := createToken(t, app, session, models.ScopeTasksRead)
+	database.DB.Model(&models.PersonalAccessToken{}).Where("id = ?", expiredID).
+		Update("expires_at", time.Now().Add(-time.Minute))
+	if status := doJSON(t, app, "GET", "/api/tasks", expired, nil, nil); status != 401 {
+		t.Fatalf("expired token: status %d, want 401", status)
+	}
+
+	if status := doJSON(t, app, "GET", "/api/tasks", models.PersonalAccessTokenPrefix+"made-up", nil, nil); status != 401 {
+		t.Fatalf("unknown token: status %d, want 401", status)
+	}
+}
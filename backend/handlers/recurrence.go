@@ -0,0 +1,402 @@
+package handlers
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"tonish/backend/apierror"
+	"tonish/backend/database"
+	"tonish/backend/models"
+	"tonish/backend/recurrence"
+	ws "tonish/backend/websocket"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// Edit scopes of a write to a recurring task, chosen with ?scope=
+const (
+	scopeAll       = "all"
+	scopeThis      = "this"
+	scopeFollowing = "following"
+)
+
+// maxOccurrenceRange bounds the range GET /tasks/occurrences expands.
+const maxOccurrenceRange = 366 * 24 * time.Hour
+
+// taskOccurrence is an occurrence of a recurring task that is not stored
+// as a task of its own. ID is the task holding the rule.
+type taskOccurrence struct {
+	models.Task
+	Occurrence string `json:"occurrence"` // YYYY-MM-DD
+}
+
+// occurrenceEdit is a write to one occurrence of a recurring task, or to it
+// and the ones after it.
+type occurrenceEdit struct {
+	scope string
+	date  time.Time // The occurrence, in the user's timezone
+	index int       // Its index in the series; 0 for the task itself
+}
+
+// parseDateList reads comma-separated YYYY-MM-DD dates.
+func parseDateList(s string) ([]string, error) {
+	var dates []string
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if _, err := time.Parse(dateLayout, part); err != nil {
+			return nil, err
+		}
+		dates = append(dates, part)
+	}
+	return dates, nil
+}
+
+// exceptionSet returns the dates of a task's recurrence exceptions.
+func exceptionSet(exceptions string) map[string]bool {
+	dates, _ := parseDateList(exceptions)
+	set := map[string]bool{}
+	for _, date := range dates {
+		set[date] = true
+	}
+	return set
+}
+
+// formatExceptions writes the dates of set that keep returns true for,
+// sorted and without repeats.
+func formatExceptions(set map[string]bool, keep func(date string) bool) string {
+	var dates []string
+	for date := range set {
+		if keep(date) {
+			dates = append(dates, date)
+		}
+	}
+	sort.Strings(dates)
+	return strings.Join(dates, ",")
+}
+
+// taskRule parses the recurrence of task, or returns nil when it does not
+// recur.
+func taskRule(task *models.Task) *recurrence.Rule {
+	if task.Recurrence == "" || task.DueDate == nil {
+		return nil
+	}
+	rule, err := recurrence.Parse(task.Recurrence)
+	if err != nil {
+		return nil
+	}
+	return rule
+}
+
+// checkRecurrence puts a validated recurrence in its canonical form. A rule
+// needs a due date to start from.
+func checkRecurrence(task *models.Task) error {
+	if task.Recurrence == "" {
+		return nil
+	}
+	if task.DueDate == nil {
+		return apierror.Validation(map[string]string{"recurrence": "needs a due date"})
+	}
+	rule, err := recurrence.Parse(task.Recurrence)
+	if err != nil {
+		return apierror.Validation(map[string]string{"recurrence": err.Error()})
+	}
+	task.Recurrence = rule.String()
+	task.RecurrenceExceptions = formatExceptions(exceptionSet(task.RecurrenceExceptions), func(string) bool { return true })
+	if task.SeriesID == nil && task.ID != 0 {
+		task.SeriesID = &task.ID
+	}
+	return nil
+}
+
+// parseOccurrenceEdit reads ?scope= and ?occurrence= for a write to task.
+// It returns nil for writes to the whole series. An occurrence left out is
+// the task's own.
+func parseOccurrenceEdit(c *fiber.Ctx, task *models.Task, loc *time.Location) (*occurrenceEdit, error) {
+	scope := c.Query("scope", scopeAll)
+	switch scope {
+	case scopeAll:
+		return nil, nil
+	case scopeThis, scopeFollowing:
+	default:
+		return nil, apierror.BadRequest("scope must be this, following or all")
+	}
+	rule := taskRule(task)
+	if rule == nil {
+		return nil, apierror.BadRequest("scope only applies to recurring tasks")
+	}
+
+	edit := &occurrenceEdit{scope: scope, date: task.DueDate.In(loc)}
+	day := c.Query("occurrence")
+	if day == "" || day == edit.date.Format(dateLayout) {
+		return edit, nil
+	}
+	if _, err := time.Parse(dateLayout, day); err != nil {
+		return nil, apierror.BadRequest("occurrence must be formatted YYYY-MM-DD")
+	}
+
+	skip := exceptionSet(task.RecurrenceExceptions)
+	found := false
+	rule.Iterate(edit.date, func(t time.Time, n int) bool {
+		date := t.Format(dateLayout)
+		if date == day && !skip[date] {
+			edit.date, edit.index, found = t, n, true
+		}
+		return date < day
+	})
+	if !found {
+		return nil, apierror.BadRequest("occurrence is not a date of this series")
+	}
+	return edit, nil
+}
+
+// seriesInstance copies task into a new open task due at the occurrence
+// with index n of rule, taking the rule over from there.
+func seriesInstance(task *models.Task, due time.Time, rule recurrence.Rule, n int) *models.Task {
+	instance := *task
+	instance.ID = 0
+	instance.Version = 1
+	instance.Status = "todo"
+	instance.CompletedAt = nil
+	instance.IsPaid = false
+	instance.PaidAt = nil
+	instance.IsArchived = false
+	instance.DeletedAt = gorm.DeletedAt{}
+	instance.CreatedAt = time.Time{}
+	instance.UpdatedAt = time.Time{}
+	instance.Progress = models.TaskProgress{}
+	instance.Blocked = false
+	instance.Blockers = []models.TaskBlocker{}
+
+	day := due.Format(dateLayout)
+	due = due.UTC()
+	instance.DueDate = &due
+	if rule.Count > 0 {
+		rule.Count -= n
+	}
+	instance.Recurrence = rule.String()
+	instance.RecurrenceExceptions = formatExceptions(exceptionSet(task.RecurrenceExceptions), func(date string) bool {
+		return date > day
+	})
+	return &instance
+}
+
+// nextInstance returns the task for the first occurrence of task's series
+// after its own, or nil when the series ends with it.
+func nextInstance(task *models.Task, loc *time.Location) *models.Task {
+	rule := taskRule(task)
+	if rule == nil {
+		return nil
+	}
+	skip := exceptionSet(task.RecurrenceExceptions)
+	var next *models.Task
+	rule.Iterate(task.DueDate.In(loc), func(t time.Time, n int) bool {
+		if n == 0 || skip[t.Format(dateLayout)] {
+			return true
+		}
+		next = seriesInstance(task, t, *rule, n)
+		return false
+	})
+	return next
+}
+
+// endSeriesBefore makes task's rule stop before date.
+func endSeriesBefore(task *models.Task, date time.Time) {
+	rule := taskRule(task)
+	if rule == nil {
+		return
+	}
+	y, m, d := date.AddDate(0, 0, -1).Date()
+	rule.Count = 0
+	rule.Until, rule.UntilDate = time.Date(y, m, d, 0, 0, 0, 0, time.UTC), true
+	task.Recurrence = rule.String()
+
+	day := date.Format(dateLayout)
+	task.RecurrenceExceptions = formatExceptions(exceptionSet(task.RecurrenceExceptions), func(date string) bool {
+		return date < day
+	})
+}
+
+// splitSeries moves the rule of a recurring task on to a new task due at
+// the next occurrence, when the task is done or is edited on its own with
+// scope "this". task holds the write and original the task as it was read.
+// The new task is returned for the caller to store with the write.
+func splitSeries(task, original *models.Task, edit *occurrenceEdit, previouslyCompleted bool, loc *time.Location) *models.Task {
+	var next *models.Task
+	switch {
+	case edit != nil && edit.scope == scopeThis:
+		next = nextInstance(original, loc)
+	case task.Status == "done" && !previouslyCompleted && task.Recurrence != "":
+		next = nextInstance(task, loc)
+	default:
+		return nil
+	}
+	task.Recurrence, task.RecurrenceExceptions = "", ""
+	return next
+}
+
+// createInstance stores a task split off the series task fromID, together
+// with that task's relative reminders.
+func createInstance(tx *gorm.DB, task *models.Task, fromID uint) error {
+	if err := tx.Create(task).Error; err != nil {
+		return err
+	}
+	if err := copyReminders(tx, fromID, task); err != nil {
+		return err
+	}
+	return database.SyncTags(tx, task.UserID, database.TaggedTasks, task.ID, task.Tags)
+}
+
+// broadcastInstance announces a task split off a series and has the
+// scheduler pick up its reminders.
+func broadcastInstance(task *models.Task) {
+	if task == nil {
+		return
+	}
+	reminders.Wake()
+	if ws.GlobalHub != nil {
+		ws.GlobalHub.BroadcastToUser(task.UserID, ws.MessageTypeTaskCreate, task)
+	}
+}
+
+// updateOccurrence applies an edit to an occurrence after the task's own.
+// With scope "this" the occurrence becomes a task of its own and the series
+// skips its date; with "following" the series ends before it and a new one
+// starts with the edit. The response is the task holding the edited
+// occurrence, as for any other edit; its ID is the new task's.
+func updateOccurrence(c *fiber.Ctx, task *models.Task, edit *occurrenceEdit, apply func(t *models.Task) error) error {
+	rule := taskRule(task)
+	occurrence := seriesInstance(task, edit.date, *rule, edit.index)
+	due, rest := *occurrence.DueDate, occurrence.Recurrence
+	if err := apply(occurrence); err != nil {
+		return err
+	}
+	// A due date or rule sent back as the series has them, as a full PUT
+	// body does, keeps the occurrence's own
+	if occurrence.DueDate != nil && occurrence.DueDate.Equal(*task.DueDate) {
+		occurrence.DueDate = &due
+	}
+	if occurrence.Recurrence == task.Recurrence {
+		occurrence.Recurrence = rest
+	}
+
+	day := edit.date.Format(dateLayout)
+	if edit.scope == scopeThis {
+		occurrence.Recurrence, occurrence.RecurrenceExceptions = "", ""
+		skip := exceptionSet(task.RecurrenceExceptions)
+		skip[day] = true
+		task.RecurrenceExceptions = formatExceptions(skip, func(string) bool { return true })
+	} else {
+		endSeriesBefore(task, edit.date)
+	}
+	if err := checkRecurrence(occurrence); err != nil {
+		return err
+	}
+	normalizeDueDate(occurrence)
+	setCompletionTimestamp(occurrence, false)
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := saveVersioned(tx.Model(task).Select("recurrence", "recurrence_exceptions", "version"), task, &task.Version); err != nil {
+			return err
+		}
+		return createInstance(tx, occurrence, task.ID)
+	})
+	if errors.Is(err, errVersionConflict) {
+		return staleTask(c, c.Params("id"))
+	}
+	if err != nil {
+		return apierror.Internal("Failed to update task").WithCause(err)
+	}
+
+	if ws.GlobalHub != nil {
+		ws.GlobalHub.BroadcastToUser(task.UserID, ws.MessageTypeTaskUpdate, task)
+	}
+	broadcastInstance(occurrence)
+
+	setETag(c, occurrence.Version)
+	return c.JSON(occurrence)
+}
+
+// deleteOccurrence removes an occurrence after the task's own, or it and
+// every later one.
+func deleteOccurrence(c *fiber.Ctx, task *models.Task, edit *occurrenceEdit) error {
+	if edit.scope == scopeThis {
+		skip := exceptionSet(task.RecurrenceExceptions)
+		skip[edit.date.Format(dateLayout)] = true
+		task.RecurrenceExceptions = formatExceptions(skip, func(string) bool { return true })
+	} else {
+		endSeriesBefore(task, edit.date)
+	}
+
+	if err := saveVersioned(database.DB.Model(task).Select("recurrence", "recurrence_exceptions", "version"), task, &task.Version); err != nil {
+		if errors.Is(err, errVersionConflict) {
+			return staleTask(c, c.Params("id"))
+		}
+		return apierror.Internal("Failed to delete task").WithCause(err)
+	}
+
+	if ws.GlobalHub != nil {
+		ws.GlobalHub.BroadcastToUser(task.UserID, ws.MessageTypeTaskUpdate, task)
+	}
+
+	return c.Status(204).SendString("")
+}
+
+// GetTaskOccurrences expands the current user's recurring tasks over a date
+// range, taking the same month or start and end parameters as the
+// calendar. Occurrences stored as tasks, including each series' next one,
+// are left to the calendar.
+func GetTaskOccurrences(c *fiber.Ctx) error {
+	settings := loadUserSettings(currentUserID(c))
+	loc := settings.Location()
+
+	start, end, err := calendarRange(c, settings)
+	if err != nil {
+		return err
+	}
+	if end.Sub(start) > maxOccurrenceRange {
+		return apierror.BadRequest("The range can span at most 366 days")
+	}
+
+	var tasks []models.Task
+	if err := userTasks(c).
+		Where("is_archived = ? AND recurrence <> '' AND due_date IS NOT NULL AND due_date < ?", false, end.UTC()).
+		Find(&tasks).Error; err != nil {
+		return apierror.Internal("Failed to load tasks").WithCause(err)
+	}
+	attachBlockers(tasks)
+
+	occurrences := []taskOccurrence{}
+	for _, task := range tasks {
+		rule := taskRule(&task)
+		if rule == nil {
+			continue
+		}
+		skip := exceptionSet(task.RecurrenceExceptions)
+		for _, t := range rule.Between(task.DueDate.In(loc), start, end) {
+			day := t.Format(dateLayout)
+			if skip[day] || t.Equal(*task.DueDate) {
+				continue
+			}
+			occurrence := taskOccurrence{Task: task, Occurrence: day}
+			due := t.UTC()
+			occurrence.DueDate = &due
+			occurrences = append(occurrences, occurrence)
+		}
+	}
+	sort.SliceStable(occurrences, func(i, j int) bool {
+		return occurrences[i].DueDate.Before(*occurrences[j].DueDate)
+	})
+
+	return c.JSON(fiber.Map{
+		"start":       start.Format(dateLayout),
+		"end":         end.AddDate(0, 0, -1).Format(dateLayout),
+		"timezone":    loc.String(),
+		"occurrences": occurrences,
+	})
+}
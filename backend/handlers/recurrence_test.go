@@ -0,0 +1,258 @@
+package handlers_test
+
+import (
+	"fmt"
+	"testing"
+
+	"tonish/backend/database"
+	"tonish/backend/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// weekly creates a task repeating every Monday from 7 January 2030.
+func weekly(t *testing.T, app *fiber.App, token string, extra map[string]interface{}) models.Task {
+	t.Helper()
+	body := map[string]interface{}{
+		"title":      "Team meeting",
+		"due_date":   "2030-01-07T09:00:00Z",
+		"recurrence": "FREQ=WEEKLY",
+	}
+	for k, v := range extra {
+		body[k] = v
+	}
+	return createTask(t, app, token, body)
+}
+
+func loadTask(t *testing.T, id uint) models.Task {
+	t.Helper()
+	var task models.Task
+	if err := database.DB.Unscoped().First(&task, id).Error; err != nil {
+		t.Fatalf("load task %d: %v", id, err)
+	}
+	return task
+}
+
+func day(task models.Task) string {
+	if task.DueDate == nil {
+		return ""
+	}
+	return task.DueDate.UTC().Format("2006-01-02")
+}
+
+// occurrenceDays lists the unstored occurrences GET /tasks/occurrences
+// returns for query.
+func occurrenceDays(t *testing.T, app *fiber.App, token, query string) []string {
+	t.Helper()
+	var resp struct {
+		Occurrences []struct {
+			models.Task
+			Occurrence string `json:"occurrence"`
+		} `json:"occurrences"`
+	}
+	if status := doJSON(t, app, "GET", "/api/tasks/occurrences?"+query, token, nil, &resp); status != 200 {
+		t.Fatalf("occurrences: status %d", status)
+	}
+	days := make([]string, len(resp.Occurrences))
+	for i, o := range resp.Occurrences {
+		if day(o.Task) != o.Occurrence {
+			t.Fatalf("occurrence %s due %s", o.Occurrence, day(o.Task))
+		}
+		days[i] = o.Occurrence
+	}
+	return days
+}
+
+func expectDays(t *testing.T, what string, got []string, want ...string) {
+	t.Helper()
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("%s: %v, want %v", what, got, want)
+	}
+}
+
+func TestCompletingARecurringTaskMovesTheRuleOn(t *testing.T) {
+	app := newTestApp(t)
+	_, token := createUser(t, app, "alice@example.com")
+	task := weekly(t, app, token, map[string]interface{}{"recurrence": "FREQ=WEEKLY;COUNT=3", "recurrence_exceptions": "2030-01-14"})
+
+	var done models.Task
+	if status := doJSON(t, app, "PATCH", fmt.Sprintf("/api/tasks/%d", task.ID), token, map[string]string{"status": "done"}, &done); status != 200 {
+		t.Fatalf("complete: status %d", status)
+	}
+	if done.Recurrence != "" || done.RecurrenceExceptions != "" {
+		t.Fatalf("completed task kept its rule: %q %q", done.Recurrence, done.RecurrenceExceptions)
+	}
+
+	// The skipped 14th is passed over, and the count goes down by two
+	var next models.Task
+	if err := database.DB.Where("series_id = ? AND id <> ?", task.ID, task.ID).First(&next).Error; err != nil {
+		t.Fatalf("no next task: %v", err)
+	}
+	if day(next) != "2030-01-21" || next.Recurrence != "FREQ=WEEKLY;COUNT=1" || next.Status != "todo" || next.RecurrenceExceptions != "" {
+		t.Fatalf("next task due %s, rule %q, exceptions %q, status %s", day(next), next.Recurrence, next.RecurrenceExceptions, next.Status)
+	}
+}
+
+func TestEditThisOccurrence(t *testing.T) {
+	app := newTestApp(t)
+	_, token := createUser(t, app, "alice@example.com")
+	task := weekly(t, app, token, nil)
+
+	var edited models.Task
+	status, tag := doConditional(t, app, "PATCH", fmt.Sprintf("/api/tasks/%d?scope=this&occurrence=2030-01-21", task.ID), token, "",
+		map[string]string{"title": "Team meeting with guests"}, &edited)
+	if status != 200 {
+		t.Fatalf("edit occurrence: status %d, want 200", status)
+	}
+	if edited.ID == task.ID || tag != `"1"` {
+		t.Fatalf("edited occurrence has id %d, ETag %s", edited.ID, tag)
+	}
+	if day(edited) != "2030-01-21" || edited.Title != "Team meeting with guests" || edited.Recurrence != "" {
+		t.Fatalf("edited occurrence due %s, title %q, rule %q", day(edited), edited.Title, edited.Recurrence)
+	}
+	if edited.SeriesID == nil || *edited.SeriesID != task.ID {
+		t.Fatalf("edited occurrence's series %v, want %d", edited.SeriesID, task.ID)
+	}
+
+	// The series skips the date and is otherwise unchanged
+	series := loadTask(t, task.ID)
+	if series.Title != "Team meeting" || series.Recurrence != "FREQ=WEEKLY" || series.RecurrenceExceptions != "2030-01-21" {
+		t.Fatalf("series: title %q, rule %q, exceptions %q", series.Title, series.Recurrence, series.RecurrenceExceptions)
+	}
+	expectDays(t, "January", occurrenceDays(t, app, token, "start=2030-01-01&end=2030-01-31"), "2030-01-14", "2030-01-28")
+
+	// A skipped date is no longer an occurrence of the series
+	if status := doJSON(t, app, "PATCH", fmt.Sprintf("/api/tasks/%d?scope=this&occurrence=2030-01-21", task.ID), token, map[string]string{"title": "Again"}, nil); status != 400 {
+		t.Fatalf("edit a skipped date: status %d, want 400", status)
+	}
+}
+
+func TestEditThisOwnOccurrence(t *testing.T) {
+	app := newTestApp(t)
+	_, token := createUser(t, app, "alice@example.com")
+	task := weekly(t, app, token, map[string]interface{}{"recurrence_exceptions": "2030-01-14"})
+
+	// Editing the stored occurrence on its own hands the rule to the next one
+	var edited models.Task
+	if status := doJSON(t, app, "PATCH", fmt.Sprintf("/api/tasks/%d?scope=this", task.ID), token, map[string]string{"title": "Kick-off"}, &edited); status != 200 {
+		t.Fatalf("edit own occurrence: status %d", status)
+	}
+	if edited.ID != task.ID || edited.Title != "Kick-off" || edited.Recurrence != "" {
+		t.Fatalf("edited task %d, title %q, rule %q", edited.ID, edited.Title, edited.Recurrence)
+	}
+	var next models.Task
+	database.DB.Where("series_id = ? AND id <> ?", task.ID, task.ID).First(&next)
+	if day(next) != "2030-01-21" || next.Title != "Team meeting" || next.Recurrence != "FREQ=WEEKLY" {
+		t.Fatalf("next task due %s, title %q, rule %q", day(next), next.Title, next.Recurrence)
+	}
+}
+
+func TestEditFollowingOccurrences(t *testing.T) {
+	app := newTestApp(t)
+	_, token := createUser(t, app, "alice@example.com")
+	task := weekly(t, app, token, map[string]interface{}{"recurrence_exceptions": "2030-01-14,2030-02-04"})
+
+	var edited models.Task
+	if status := doJSON(t, app, "PUT", fmt.Sprintf("/api/tasks/%d?scope=following&occurrence=2030-01-21", task.ID), token, map[string]interface{}{
+		"title":      "Team meeting",
+		"priority":   "high",
+		"due_date":   "2030-01-07T09:00:00Z",
+		"recurrence": "FREQ=WEEKLY",
+	}, &edited); status != 200 {
+		t.Fatalf("edit following: status %d, want 200", status)
+	}
+
+	// A full body repeating the series' due date and rule keeps the
+	// occurrence's own; the later exception moves with it
+	if edited.ID == task.ID || day(edited) != "2030-01-21" || edited.Priority != "high" {
+		t.Fatalf("new series %d due %s, priority %s", edited.ID, day(edited), edited.Priority)
+	}
+	if edited.Recurrence != "FREQ=WEEKLY" || edited.RecurrenceExceptions != "2030-02-04" {
+		t.Fatalf("new series rule %q, exceptions %q", edited.Recurrence, edited.RecurrenceExceptions)
+	}
+
+	// The old series ends the day before
+	series := loadTask(t, task.ID)
+	if series.Recurrence != "FREQ=WEEKLY;UNTIL=20300120" || series.RecurrenceExceptions != "2030-01-14" || series.Priority == "high" {
+		t.Fatalf("old series rule %q, exceptions %q, priority %s", series.Recurrence, series.RecurrenceExceptions, series.Priority)
+	}
+	expectDays(t, "January and February", occurrenceDays(t, app, token, "start=2030-01-01&end=2030-02-28"),
+		"2030-01-28", "2030-02-11", "2030-02-18", "2030-02-25")
+}
+
+func TestDeleteOccurrences(t *testing.T) {
+	app := newTestApp(t)
+	_, token := createUser(t, app, "alice@example.com")
+	task := weekly(t, app, token, map[string]interface{}{"recurrence_exceptions": "2030-02-11"})
+	path := fmt.Sprintf("/api/tasks/%d", task.ID)
+
+	if status := doJSON(t, app, "DELETE", path+"?scope=this&occurrence=2030-01-14", token, nil, nil); status != 204 {
+		t.Fatalf("delete one occurrence: status %d", status)
+	}
+	if series := loadTask(t, task.ID); series.RecurrenceExceptions != "2030-01-14,2030-02-11" {
+		t.Fatalf("exceptions %q", series.RecurrenceExceptions)
+	}
+
+	if status := doJSON(t, app, "DELETE", path+"?scope=following&occurrence=2030-01-28", token, nil, nil); status != 204 {
+		t.Fatalf("delete following: status %d", status)
+	}
+	series := loadTask(t, task.ID)
+	if series.Recurrence != "FREQ=WEEKLY;UNTIL=20300127" || series.RecurrenceExceptions != "2030-01-14" || series.DeletedAt.Valid {
+		t.Fatalf("series rule %q, exceptions %q, deleted %v", series.Recurrence, series.RecurrenceExceptions, series.DeletedAt.Valid)
+	}
+	expectDays(t, "January and February", occurrenceDays(t, app, token, "start=2030-01-01&end=2030-02-28"), "2030-01-21")
+}
+
+func TestOccurrenceEditErrors(t *testing.T) {
+	app := newTestApp(t)
+	_, token := createUser(t, app, "alice@example.com")
+	task := weekly(t, app, token, nil)
+	plain := createTask(t, app, token, map[string]interface{}{"title": "Water plants", "due_date": "2030-01-07T09:00:00Z"})
+	path := fmt.Sprintf("/api/tasks/%d", task.ID)
+
+	for _, query := range []string{
+		"?scope=some",
+		"?scope=this&occurrence=2030-01-08",
+		"?scope=this&occurrence=2030-01-01",
+		"?scope=this&occurrence=21-01-2030",
+	} {
+		if status := doJSON(t, app, "PATCH", path+query, token, map[string]string{"title": "x"}, nil); status != 400 {
+			t.Errorf("PATCH %s: status %d, want 400", query, status)
+		}
+	}
+	if status := doJSON(t, app, "DELETE", fmt.Sprintf("/api/tasks/%d?scope=this", plain.ID), token, nil, nil); status != 400 {
+		t.Fatalf("scope on a task that does not recur: status %d, want 400", status)
+	}
+	if series := loadTask(t, task.ID); series.Title != "Team meeting" || series.RecurrenceExceptions != "" {
+		t.Fatalf("series changed by rejected edits: %+v", series)
+	}
+}
+
+func TestGetTaskOccurrences(t *testing.T) {
+	app := newTestApp(t)
+	_, token := createUser(t, app, "alice@example.com")
+	_, bobToken := createUser(t, app, "bob@example.com")
+	weekly(t, app, token, map[string]interface{}{"recurrence": "FREQ=WEEKLY;COUNT=3"})
+	createTask(t, app, token, map[string]interface{}{
+		"title":      "Pay rent",
+		"due_date":   "2030-01-01T08:00:00Z",
+		"recurrence": "FREQ=MONTHLY",
+	})
+	archived := weekly(t, app, token, nil)
+	doJSON(t, app, "POST", fmt.Sprintf("/api/tasks/%d/archive", archived.ID), token, nil, nil)
+	weekly(t, app, bobToken, nil)
+
+	// Sorted across series; the stored first occurrences are the calendar's
+	expectDays(t, "January and February", occurrenceDays(t, app, token, "start=2030-01-01&end=2030-02-28"),
+		"2030-01-14", "2030-01-21", "2030-02-01")
+
+	// A month covers its whole calendar grid, weeks starting on Monday
+	expectDays(t, "February grid", occurrenceDays(t, app, token, "month=2030-02"), "2030-02-01", "2030-03-01")
+
+	if status := doJSON(t, app, "GET", "/api/tasks/occurrences?start=2030-01-01&end=2031-01-02", token, nil, nil); status != 400 {
+		t.Fatalf("range over 366 days: status %d, want 400", status)
+	}
+	if status := doJSON(t, app, "GET", "/api/tasks/occurrences", token, nil, nil); status != 400 {
+		t.Fatalf("no range: status %d, want 400", status)
+	}
+}
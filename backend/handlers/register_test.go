@@ -0,0 +1,189 @@
+package handlers_test
+
+import (
+	"testing"
+	"time"
+
+	"tonish/backend/database"
+	"tonish/backend/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// createInvite has an admin issue an invite and returns its plain code.
+func createInvite(t *testing.T, app *fiber.App, adminToken string, body map[string]interface{}) (models.Invite, string) {
+	t.Helper()
+	var resp struct {
+		Invite models.Invite `json:"invite"`
+		Code   string        `json:"code"`
+	}
+	if status := doJSON(t, app, "POST", "/api/invites", adminToken, body, &resp); status != 201 {
+		t.Fatalf("create invite: status %d", status)
+	}
+	return resp.Invite, resp.Code
+}
+
+// newPassword satisfies the password policy for new accounts, which
+// testPassword predates.
+const newPassword = "correct-horse-42"
+
+func register(t *testing.T, app *fiber.App, body map[string]string) int {
+	t.Helper()
+	return doJSON(t, app, "POST", "/api/auth/register", "", body, nil)
+}
+
+func TestRegisterOpen(t *testing.T) {
+	t.Setenv("REGISTRATION_MODE", "open")
+	app := newTestApp(t)
+
+	var tokens tokenPair
+	if status := doJSON(t, app, "POST", "/api/auth/register", "", map[string]string{
+		"email":    "alice@example.com",
+		"password": newPassword,
+	}, &tokens); status != 201 {
+		t.Fatalf("register: status %d", status)
+	}
+	var me models.User
+	if status := doJSON(t, app, "GET", "/api/user/me", tokens.Token, nil, &me); status != 200 {
+		t.Fatalf("new account's token: status %d", status)
+	}
+	if me.Email != "alice@example.com" || me.Role != models.RoleUser {
+		t.Fatalf("registered user: %+v", me)
+	}
+
+	// Addresses are unique regardless of case
+	if status := register(t, app, map[string]string{
+		"email":    "Alice@Example.com",
+		"password": newPassword,
+	}); status != 409 {
+		t.Fatalf("duplicate email: status %d, want 409", status)
+	}
+}
+
+func TestRegisterClosed(t *testing.T) {
+	for _, mode := range []string{"closed", "something-else"} {
+		t.Run(mode, func(t *testing.T) {
+			t.Setenv("REGISTRATION_MODE", mode)
+			app := newTestApp(t)
+			if status := register(t, app, map[string]string{
+				"email":    "alice@example.com",
+				"password": newPassword,
+			}); status != 403 {
+				t.Fatalf("register: status %d, want 403", status)
+			}
+		})
+	}
+}
+
+func TestRegisterRejectsWeakPasswords(t *testing.T) {
+	t.Setenv("REGISTRATION_MODE", "open")
+	app := newTestApp(t)
+
+	for _, password := range []string{"short1", "no-digits-at-all", "1234567890"} {
+		var resp validationError
+		if status := doJSON(t, app, "POST", "/api/auth/register", "", map[string]string{
+			"email":    "alice@example.com",
+			"password": password,
+		}, &resp); status != 422 {
+			t.Fatalf("password %q: status %d, want 422", password, status)
+		}
+		if resp.Errors["password"] == "" {
+			t.Fatalf("password %q: no password error in %v", password, resp.Errors)
+		}
+	}
+
+	var count int64
+	database.DB.Model(&models.User{}).Count(&count)
+	if count != 0 {
+		t.Fatalf("%d users created with weak passwords", count)
+	}
+}
+
+func TestRegisterWithInvite(t *testing.T) {
+	t.Setenv("REGISTRATION_MODE", "invite")
+	app := newTestApp(t)
+	_, admin := createAdmin(t, app, "admin@example.com")
+	invite, code := createInvite(t, app, admin, map[string]interface{}{})
+
+	if status := register(t, app, map[string]string{
+		"email":    "alice@example.com",
+		"password": newPassword,
+	}); status != 403 {
+		t.Fatalf("register without a code: status %d, want 403", status)
+	}
+	if status := register(t, app, map[string]string{
+		"email":       "alice@example.com",
+		"password":    newPassword,
+		"invite_code": "not-a-code",
+	}); status != 403 {
+		t.Fatalf("register with an unknown code: status %d, want 403", status)
+	}
+
+	if status := register(t, app, map[string]string{
+		"email":       "alice@example.com",
+		"password":    newPassword,
+		"invite_code": code,
+	}); status != 201 {
+		t.Fatalf("register with the code: status %d", status)
+	}
+	var used models.Invite
+	database.DB.First(&used, invite.ID)
+	if used.UsedAt == nil || used.UsedByID == nil {
+		t.Fatalf("invite not marked used: %+v", used)
+	}
+
+	// Codes are single-use
+	if status := register(t, app, map[string]string{
+		"email":       "bob@example.com",
+		"password":    newPassword,
+		"invite_code": code,
+	}); status != 403 {
+		t.Fatalf("reused code: status %d, want 403", status)
+	}
+}
+
+func TestRegisterInviteRestrictions(t *testing.T) {
+	t.Setenv("REGISTRATION_MODE", "invite")
+	app := newTestApp(t)
+	_, admin := createAdmin(t, app, "admin@example.com")
+
+	// An invite for one address cannot be used for another
+	_, code := createInvite(t, app, admin, map[string]interface{}{"email": "carol@example.com"})
+	if status := register(t, app, map[string]string{
+		"email":       "mallory@example.com",
+		"password":    newPassword,
+		"invite_code": code,
+	}); status != 403 {
+		t.Fatalf("code for another address: status %d, want 403", status)
+	}
+	if status := register(t, app, map[string]string{
+		"email":       "Carol@Example.com",
+		"password":    newPassword,
+		"invite_code": code,
+	}); status != 201 {
+		t.Fatalf("code for this address: status %d", status)
+	}
+
+	// Expired codes are refused
+	invite, code := createInvite(t, app, admin, map[string]interface{}{"expires_in_hours": 1})
+	if until := time.Until(invite.ExpiresAt); until <= 0 || until > time.Hour {
+		t.Fatalf("invite for one hour expires in %v", until)
+	}
+	database.DB.Model(&invite).Update("expires_at", time.Now().Add(-time.Minute))
+	if status := register(t, app, map[string]string{
+		"email":       "dave@example.com",
+		"password":    newPassword,
+		"invite_code": code,
+	}); status != 403 {
+		t.Fatalf("expired code: status %d, want 403", status)
+	}
+
+	// Only admins issue invites
+	_, user := createUser(t, app, "user@example.com")
+	if status := doJSON(t, app, "POST", "/api/invites", user, map[string]interface{}{}, nil); status != 403 {
+		t.Fatalf("invite by a non-admin: status %d, want 403", status)
+	}
+	if status := doJSON(t, app, "POST", "/api/invites", admin, map[string]interface{}{"expires_in_hours": 721}, nil); status != 422 {
+		t.Fatalf("invite beyond the maximum lifetime: status %d, want 422", status)
+	}
+}
@@ -0,0 +1,319 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"tonish/backend/apierror"
+	"tonish/backend/database"
+	"tonish/backend/models"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// maxReminders bounds the reminders of one task.
+const maxReminders = 10
+
+// reminderPollInterval is the longest the scheduler sleeps, so it also
+// notices reminders it was not woken for, and tasks that became overdue or
+// payments that came close.
+const reminderPollInterval = time.Minute
+
+// reminderBatch is how many due reminders the scheduler fires per pass.
+const reminderBatch = 100
+
+type CreateReminderRequest struct {
+	BeforeMinutes *int       `json:"before_minutes" validate:"min=0,max=525600"` // Minutes before the due date, up to a year
+	At            *time.Time `json:"at"`                                         // Or a fixed time
+}
+
+// reminderScheduler fires due reminders. Claiming a reminder and storing its
+// notification happen in one transaction, so after a restart a reminder has
+// either fired, with its notification in the inbox, or is still pending.
+type reminderScheduler struct {
+	now  func() time.Time
+	wake chan struct{}
+}
+
+func newReminderScheduler(now func() time.Time) *reminderScheduler {
+	return &reminderScheduler{now: now, wake: make(chan struct{}, 1)}
+}
+
+var reminders = newReminderScheduler(time.Now)
+
+// StartReminderScheduler fires reminders missed while the server was down
+// and then each one as it comes due. Each pass also notifies about overdue
+// tasks and upcoming payments.
+func StartReminderScheduler() {
+	go reminders.run()
+}
+
+// Wake makes the scheduler look at the reminders again, after they changed.
+func (s *reminderScheduler) Wake() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *reminderScheduler) run() {
+	for {
+		fired := s.fireDue()
+		noticed := s.notifyDue()
+
+		wait := reminderPollInterval
+		if fired == reminderBatch || noticed >= reminderBatch {
+			wait = 0
+		} else if next := s.nextFireAt(); next != nil {
+			if until := next.Sub(s.now()); until < wait {
+				wait = until
+			}
+		}
+		if wait < 0 {
+			wait = 0
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-s.wake:
+			timer.Stop()
+		}
+	}
+}
+
+// nextFireAt returns the time of the earliest pending reminder, if any.
+func (s *reminderScheduler) nextFireAt() *time.Time {
+	var reminder models.Reminder
+	if err := database.DB.Where("fired_at IS NULL AND fire_at IS NOT NULL").
+		Order("fire_at").First(&reminder).Error; err != nil {
+		return nil
+	}
+	return reminder.FireAt
+}
+
+// fireDue fires the reminders that are due and returns how many it looked
+// at. Reminders of tasks that are done, archived or deleted are used up
+// without a notification.
+func (s *reminderScheduler) fireDue() int {
+	var due []models.Reminder
+	if err := database.DB.Where("fired_at IS NULL AND fire_at <= ?", s.now().UTC()).
+		Order("fire_at, id").Limit(reminderBatch).Find(&due).Error; err != nil {
+		log.Printf("Failed to load due reminders: %v\n", err)
+		return 0
+	}
+	for _, reminder := range due {
+		s.fire(reminder)
+	}
+	return len(due)
+}
+
+// fire claims one reminder and delivers it. The claim only succeeds while
+// the reminder is unfired, so a reminder is never delivered twice.
+func (s *reminderScheduler) fire(reminder models.Reminder) {
+	now := s.now().UTC()
+	var task models.Task
+	var notification *models.Notification
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.Reminder{}).Where("id = ? AND fired_at IS NULL", reminder.ID).Update("fired_at", now)
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		if err := tx.First(&task, reminder.TaskID).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				return nil
+			}
+			return err
+		}
+		if task.Status == "done" || task.IsArchived {
+			return nil
+		}
+		notification = &models.Notification{
+			UserID: task.UserID,
+			Type:   models.NotificationReminder,
+			Title:  task.Title,
+			Body:   reminderBody(&task),
+			TaskID: &task.ID,
+		}
+		return tx.Create(notification).Error
+	})
+	if err != nil {
+		log.Printf("Failed to fire reminder %d: %v\n", reminder.ID, err)
+		return
+	}
+
+	if notification != nil {
+		pushNotification(notification, &task)
+	}
+}
+
+// reminderBody says when the task is due, in the owner's timezone.
+func reminderBody(task *models.Task) string {
+	if task.DueDate == nil {
+		return ""
+	}
+	return "Due " + dueTime(task)
+}
+
+// dueTime formats a task's due date in its owner's timezone.
+func dueTime(task *models.Task) string {
+	settings := loadUserSettings(task.UserID)
+	return task.DueDate.In(settings.Location()).Format("Mon 2 Jan 2006, 15:04")
+}
+
+// reminderTime works out when a reminder of task fires, or nil when it is
+// relative and the task has no due date.
+func reminderTime(reminder *models.Reminder, task *models.Task) *time.Time {
+	var at time.Time
+	switch {
+	case reminder.At != nil:
+		at = *reminder.At
+	case reminder.BeforeMinutes != nil && task.DueDate != nil:
+		at = task.DueDate.Add(-time.Duration(*reminder.BeforeMinutes) * time.Minute)
+	default:
+		return nil
+	}
+	at = at.UTC()
+	return &at
+}
+
+// scheduleReminder sets a reminder's fire time. A time that has already
+// passed is not worth a notification, so the reminder counts as fired.
+func scheduleReminder(reminder *models.Reminder, task *models.Task, now time.Time) {
+	reminder.FireAt = reminderTime(reminder, task)
+	reminder.FiredAt = nil
+	if reminder.FireAt != nil && !reminder.FireAt.After(now) {
+		fired := now.UTC()
+		reminder.FiredAt = &fired
+	}
+}
+
+// rescheduleReminders moves the relative reminders of task after its due
+// date changed. A reminder moved into the future fires again.
+func rescheduleReminders(tx *gorm.DB, task *models.Task) error {
+	var list []models.Reminder
+	if err := tx.Where("task_id = ? AND before_minutes IS NOT NULL", task.ID).Find(&list).Error; err != nil {
+		return err
+	}
+	now := reminders.now()
+	for i := range list {
+		scheduleReminder(&list[i], task, now)
+		if err := tx.Model(&list[i]).Select("fire_at", "fired_at").Updates(&list[i]).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyReminders gives a task split off a series the relative reminders of
+// the task it came from. Fixed-time reminders stay behind.
+func copyReminders(tx *gorm.DB, fromID uint, task *models.Task) error {
+	var list []models.Reminder
+	if err := tx.Where("task_id = ? AND before_minutes IS NOT NULL", fromID).Order("id").Find(&list).Error; err != nil {
+		return err
+	}
+	now := reminders.now()
+	for _, reminder := range list {
+		copied := models.Reminder{UserID: task.UserID, TaskID: task.ID, BeforeMinutes: reminder.BeforeMinutes}
+		scheduleReminder(&copied, task, now)
+		if err := tx.Create(&copied).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addDefaultReminder reminds at the due time of a new task with the
+// reminder calendar subtype.
+func addDefaultReminder(tx *gorm.DB, task *models.Task) error {
+	if task.CalendarSubtype != "reminder" || task.DueDate == nil {
+		return nil
+	}
+	atDue := 0
+	reminder := models.Reminder{UserID: task.UserID, TaskID: task.ID, BeforeMinutes: &atDue}
+	scheduleReminder(&reminder, task, reminders.now())
+	return tx.Create(&reminder).Error
+}
+
+// findReminderTask loads the task named by :id for a reminder endpoint.
+func findReminderTask(c *fiber.Ctx, task *models.Task) error {
+	if err := findUserTask(c, c.Params("id"), task, false); err != nil {
+		return apierror.NotFound("Task not found")
+	}
+	return nil
+}
+
+// GetReminders lists a task's reminders, soonest first
+func GetReminders(c *fiber.Ctx) error {
+	var task models.Task
+	if err := findReminderTask(c, &task); err != nil {
+		return err
+	}
+	list := []models.Reminder{}
+	if err := database.DB.Where("task_id = ?", task.ID).Order("fire_at IS NULL, fire_at, id").Find(&list).Error; err != nil {
+		return apierror.Internal("Failed to load reminders").WithCause(err)
+	}
+	return c.JSON(list)
+}
+
+// CreateReminder adds a reminder to a task, either before_minutes before its
+// due date or at a fixed time
+func CreateReminder(c *fiber.Ctx) error {
+	var task models.Task
+	if err := findReminderTask(c, &task); err != nil {
+		return err
+	}
+
+	req := new(CreateReminderRequest)
+	if err := parseBody(c, req); err != nil {
+		return err
+	}
+	if (req.BeforeMinutes == nil) == (req.At == nil) {
+		return apierror.Validation(map[string]string{"before_minutes": "give either before_minutes or at"})
+	}
+	if req.BeforeMinutes != nil && task.DueDate == nil {
+		return apierror.Validation(map[string]string{"before_minutes": "the task has no due date"})
+	}
+
+	var count int64
+	if err := database.DB.Model(&models.Reminder{}).Where("task_id = ?", task.ID).Count(&count).Error; err != nil {
+		return apierror.Internal("Failed to add reminder").WithCause(err)
+	}
+	if count >= maxReminders {
+		return apierror.Conflict(fmt.Sprintf("A task can have at most %d reminders", maxReminders))
+	}
+
+	reminder := models.Reminder{UserID: task.UserID, TaskID: task.ID, BeforeMinutes: req.BeforeMinutes}
+	if req.At != nil {
+		at := req.At.UTC()
+		reminder.At = &at
+	}
+	scheduleReminder(&reminder, &task, reminders.now())
+	if err := database.DB.Create(&reminder).Error; err != nil {
+		return apierror.Internal("Failed to add reminder").WithCause(err)
+	}
+	reminders.Wake()
+
+	return c.Status(201).JSON(reminder)
+}
+
+// DeleteReminder removes one of a task's reminders
+func DeleteReminder(c *fiber.Ctx) error {
+	var task models.Task
+	if err := findReminderTask(c, &task); err != nil {
+		return err
+	}
+
+	result := database.DB.Where("id = ? AND task_id = ?", c.Params("reminderId"), task.ID).Delete(&models.Reminder{})
+	if result.Error != nil {
+		return apierror.Internal("Failed to delete reminder").WithCause(result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return apierror.NotFound("Reminder not found")
+	}
+
+	return c.Status(204).SendString("")
+}
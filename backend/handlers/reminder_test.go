@@ -0,0 +1,281 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"tonish/backend/database"
+	"tonish/backend/models"
+	ws "tonish/backend/websocket"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newReminderTest points the database at a fresh in-memory one and the
+// scheduler at clock.
+func newReminderTest(t *testing.T, clock *fakeClock) {
+	t.Helper()
+	dsn := fmt.Sprintf("file:%s?mode=memory&cache=shared", strings.ReplaceAll(t.Name(), "/", "_"))
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	sqlDB, _ := db.DB()
+	t.Cleanup(func() { sqlDB.Close() })
+	database.DB = db
+	database.Migrate()
+
+	saved := reminders
+	reminders = newReminderScheduler(clock.Now)
+	t.Cleanup(func() { reminders = saved })
+}
+
+// reminderTask stores a task due at due with a reminder before minutes
+// ahead of it.
+func reminderTask(t *testing.T, due time.Time, before int) models.Task {
+	t.Helper()
+	task := models.Task{Title: "Pay rent", UserID: 1, Version: 1, DueDate: &due}
+	if err := database.DB.Create(&task).Error; err != nil {
+		t.Fatalf("create task: %v", err)
+	}
+	reminder := models.Reminder{UserID: 1, TaskID: task.ID, BeforeMinutes: &before}
+	scheduleReminder(&reminder, &task, reminders.now())
+	if err := database.DB.Create(&reminder).Error; err != nil {
+		t.Fatalf("create reminder: %v", err)
+	}
+	return task
+}
+
+func countNotifications(t *testing.T) int64 {
+	t.Helper()
+	var count int64
+	database.DB.Model(&models.Notification{}).Count(&count)
+	return count
+}
+
+func TestReminderFiresOnce(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)}
+	newReminderTest(t, clock)
+
+	hub := ws.NewHub()
+	go hub.Run()
+	client := &ws.Client{UserID: 1, Send: make(chan []byte, 8)}
+	hub.Register(client)
+	ws.GlobalHub = hub
+	t.Cleanup(func() { ws.GlobalHub = nil })
+
+	// Due on the 3rd at 09:00, reminded a day ahead
+	task := reminderTask(t, clock.now.Add(48*time.Hour), 24*60)
+
+	if next := reminders.nextFireAt(); next == nil || !next.Equal(clock.now.Add(24*time.Hour)) {
+		t.Fatalf("next fire time %v, want %v", next, clock.now.Add(24*time.Hour))
+	}
+
+	clock.Advance(24*time.Hour - time.Second)
+	reminders.fireDue()
+	if n := countNotifications(t); n != 0 {
+		t.Fatalf("%d notifications a second early, want none", n)
+	}
+
+	clock.Advance(time.Second)
+	reminders.fireDue()
+	if n := countNotifications(t); n != 1 {
+		t.Fatalf("%d notifications when due, want 1", n)
+	}
+
+	select {
+	case data := <-client.Send:
+		var message struct {
+			Type string `json:"type"`
+			Data struct {
+				Notification models.Notification `json:"notification"`
+				Task         models.Task         `json:"task"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(data, &message); err != nil {
+			t.Fatalf("decode message: %v", err)
+		}
+		if message.Type != ws.MessageTypeReminder || message.Data.Task.ID != task.ID ||
+			message.Data.Notification.Type != models.NotificationReminder {
+			t.Fatalf("unexpected message %s", data)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no reminder message")
+	}
+
+	// The new notification raises the unread count
+	select {
+	case data := <-client.Send:
+		if want := `{"type":"notification_count","data":{"unread":1},"user_id":1}`; string(data) != want {
+			t.Fatalf("got %s, want %s", data, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no unread count message")
+	}
+
+	// Later passes, and a scheduler started after a restart, leave it alone
+	clock.Advance(time.Hour)
+	reminders.fireDue()
+	reminders = newReminderScheduler(clock.Now)
+	reminders.fireDue()
+	if n := countNotifications(t); n != 1 {
+		t.Fatalf("%d notifications after refiring, want 1", n)
+	}
+	if next := reminders.nextFireAt(); next != nil {
+		t.Fatalf("next fire time %v, want none", next)
+	}
+}
+
+func TestReminderMissedWhileDown(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)}
+	newReminderTest(t, clock)
+
+	reminderTask(t, clock.now.Add(2*time.Hour), 60)
+	done := reminderTask(t, clock.now.Add(2*time.Hour), 60)
+	database.DB.Model(&done).Update("status", "done")
+
+	// The server was down when both came due; the open task's reminder is
+	// delivered late and the done task's is used up
+	clock.Advance(3 * time.Hour)
+	if n := reminders.fireDue(); n != 2 {
+		t.Fatalf("fired %d reminders, want 2", n)
+	}
+	var notifications []models.Notification
+	database.DB.Find(&notifications)
+	if len(notifications) != 1 || *notifications[0].TaskID == done.ID {
+		t.Fatalf("notifications %+v, want one for the open task", notifications)
+	}
+	if n := reminders.fireDue(); n != 0 {
+		t.Fatalf("fired %d reminders again", n)
+	}
+}
+
+func TestRescheduleReminders(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)}
+	newReminderTest(t, clock)
+
+	task := reminderTask(t, clock.now.Add(time.Hour), 30)
+	clock.Advance(45 * time.Minute)
+	reminders.fireDue()
+
+	// Moving the due date a day on arms the fired reminder again
+	due := task.DueDate.Add(24 * time.Hour)
+	task.DueDate = &due
+	if err := rescheduleReminders(database.DB, &task); err != nil {
+		t.Fatal(err)
+	}
+	var reminder models.Reminder
+	database.DB.Where("task_id = ?", task.ID).First(&reminder)
+	if reminder.FiredAt != nil || !reminder.FireAt.Equal(due.Add(-30*time.Minute)) {
+		t.Fatalf("reminder fires at %v (fired %v), want %v", reminder.FireAt, reminder.FiredAt, due.Add(-30*time.Minute))
+	}
+
+	clock.Advance(24 * time.Hour)
+	reminders.fireDue()
+	if n := countNotifications(t); n != 2 {
+		t.Fatalf("%d notifications, want 2", n)
+	}
+}
+
+func TestOverdueAndPaymentNotifications(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC)}
+	newReminderTest(t, clock)
+
+	hub := ws.NewHub()
+	go hub.Run()
+	client := &ws.Client{UserID: 1, Send: make(chan []byte, 16)}
+	hub.Register(client)
+	ws.GlobalHub = hub
+	t.Cleanup(func() { ws.GlobalHub = nil })
+
+	at := func(day, hour int) *time.Time {
+		due := time.Date(2024, 1, day, hour, 0, 0, 0, time.UTC)
+		return &due
+	}
+	tasks := []models.Task{
+		{Title: "Overdue", DueDate: at(9, 10)},
+		{Title: "Due this morning", DueDate: at(10, 8)},
+		{Title: "Done late", DueDate: at(8, 10), Status: "done"},
+		{Title: "Rent", DueDate: at(12, 9), IsPayment: true, Amount: 900, Currency: "EUR"},
+		{Title: "Paid", DueDate: at(11, 9), IsPayment: true, IsPaid: true},
+		{Title: "Far off", DueDate: at(20, 9), IsPayment: true},
+	}
+	for i := range tasks {
+		tasks[i].UserID, tasks[i].Version = 1, 1
+		if tasks[i].Status == "" {
+			tasks[i].Status = "todo"
+		}
+		if err := database.DB.Create(&tasks[i]).Error; err != nil {
+			t.Fatalf("create task: %v", err)
+		}
+	}
+
+	if n := reminders.notifyDue(); n != 2 {
+		t.Fatalf("created %d notifications, want 2", n)
+	}
+	var notifications []models.Notification
+	database.DB.Order("id").Find(&notifications)
+	if len(notifications) != 2 ||
+		notifications[0].Type != models.NotificationOverdue || *notifications[0].TaskID != tasks[0].ID ||
+		notifications[1].Type != models.NotificationPaymentDue || *notifications[1].TaskID != tasks[3].ID {
+		t.Fatalf("notifications %+v, want overdue and payment due", notifications)
+	}
+	if body := notifications[1].Body; body != "Payment of 900.00 EUR due Fri 12 Jan 2024, 09:00" {
+		t.Fatalf("payment body %q", body)
+	}
+
+	// Each goes out like a reminder: the notification, then the new count
+	for unread := 1; unread <= 2; unread++ {
+		select {
+		case data := <-client.Send:
+			var message struct {
+				Type string `json:"type"`
+				Data struct {
+					Notification models.Notification `json:"notification"`
+				} `json:"data"`
+			}
+			json.Unmarshal(data, &message)
+			if message.Type != ws.MessageTypeNotification || message.Data.Notification.ID != notifications[unread-1].ID {
+				t.Fatalf("unexpected message %s", data)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("no notification message")
+		}
+		select {
+		case data := <-client.Send:
+			if want := fmt.Sprintf(`{"type":"notification_count","data":{"unread":%d},"user_id":1}`, unread); string(data) != want {
+				t.Fatalf("got %s, want %s", data, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("no unread count message")
+		}
+	}
+
+	// Later passes, also after a restart, add nothing for the same due dates
+	clock.Advance(time.Hour)
+	reminders.notifyDue()
+	reminders = newReminderScheduler(clock.Now)
+	if n := reminders.notifyDue(); n != 0 {
+		t.Fatalf("created %d notifications on a later pass, want none", n)
+	}
+
+	// The morning task is overdue once its day is over
+	clock.Advance(24 * time.Hour)
+	if n := reminders.notifyDue(); n != 1 {
+		t.Fatalf("created %d notifications the next day, want 1", n)
+	}
+
+	// A new due date is worth a new notification
+	database.DB.Model(&tasks[0]).Update("due_date", at(10, 18))
+	if n := reminders.notifyDue(); n != 1 {
+		t.Fatalf("created %d notifications after the due date moved, want 1", n)
+	}
+	if n := countNotifications(t); n != 4 {
+		t.Fatalf("%d notifications in all, want 4", n)
+	}
+}
@@ -0,0 +1,219 @@
+package handlers
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"tonish/backend/apierror"
+	"tonish/backend/database"
+	"tonish/backend/models"
+	"tonish/backend/taskquery"
+	ws "tonish/backend/websocket"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// SavedViewRequest is the body of POST /views and PUT /views/:id.
+type SavedViewRequest struct {
+	Name        string `json:"name" validate:"required,max=100"`
+	Query       string `json:"query" validate:"max=1000"`
+	Sort        string `json:"sort"`
+	DisplayMode string `json:"display_mode" validate:"oneof=kanban matrix list calendar"` // Defaults to kanban
+	IsPinned    bool   `json:"is_pinned"`
+}
+
+// parseSavedViewRequest reads and validates the body, including the query
+// and sort, which the tags cannot check.
+func parseSavedViewRequest(c *fiber.Ctx, req *SavedViewRequest) error {
+	if err := c.BodyParser(req); err != nil {
+		return apierror.InvalidBody()
+	}
+	errs := validateStruct(req)
+	var parseErr *taskquery.ParseError
+	if _, err := taskquery.Parse(req.Query); errors.As(err, &parseErr) {
+		errs["query"] = parseErr.Error()
+	}
+	if req.Sort != "" && !validTaskSort(req.Sort) {
+		errs["sort"] = taskSortError
+	}
+	if len(errs) > 0 {
+		return apierror.Validation(errs)
+	}
+	return nil
+}
+
+func (req *SavedViewRequest) apply(view *models.SavedView) {
+	view.Name = strings.TrimSpace(req.Name)
+	view.Query = strings.TrimSpace(req.Query)
+	view.Sort = req.Sort
+	view.DisplayMode = req.DisplayMode
+	if view.DisplayMode == "" {
+		view.DisplayMode = models.TaskViewKanban
+	}
+	view.IsPinned = req.IsPinned
+}
+
+// GetSavedViews lists the current user's views, pinned ones first
+func GetSavedViews(c *fiber.Ctx) error {
+	views := []models.SavedView{}
+	if err := database.DB.Where("user_id = ?", currentUserID(c)).
+		Order("is_pinned DESC, name, id").Find(&views).Error; err != nil {
+		return apierror.Internal("Failed to load views").WithCause(err)
+	}
+	return c.JSON(views)
+}
+
+// GetSavedView retrieves a single view
+func GetSavedView(c *fiber.Ctx) error {
+	var view models.SavedView
+	if err := findUserView(c, c.Params("id"), &view); err != nil {
+		return apierror.NotFound("View not found")
+	}
+	return c.JSON(view)
+}
+
+// CreateSavedView saves a new view
+func CreateSavedView(c *fiber.Ctx) error {
+	req := new(SavedViewRequest)
+	if err := parseSavedViewRequest(c, req); err != nil {
+		return err
+	}
+
+	view := &models.SavedView{UserID: currentUserID(c)}
+	req.apply(view)
+	if err := database.DB.Create(view).Error; err != nil {
+		return apierror.Internal("Failed to create view").WithCause(err)
+	}
+
+	if ws.GlobalHub != nil {
+		ws.GlobalHub.BroadcastToUser(view.UserID, ws.MessageTypeViewCreate, view)
+	}
+
+	return c.Status(201).JSON(view)
+}
+
+// UpdateSavedView changes a view; fields missing from the body keep their
+// current values, so {"is_pinned": true} pins it
+func UpdateSavedView(c *fiber.Ctx) error {
+	var view models.SavedView
+	if err := findUserView(c, c.Params("id"), &view); err != nil {
+		return apierror.NotFound("View not found")
+	}
+
+	req := &SavedViewRequest{
+		Name:        view.Name,
+		Query:       view.Query,
+		Sort:        view.Sort,
+		DisplayMode: view.DisplayMode,
+		IsPinned:    view.IsPinned,
+	}
+	if err := parseSavedViewRequest(c, req); err != nil {
+		return err
+	}
+	req.apply(&view)
+
+	if err := database.DB.Model(&view).Select("*").Updates(&view).Error; err != nil {
+		return apierror.Internal("Failed to update view").WithCause(err)
+	}
+
+	if ws.GlobalHub != nil {
+		ws.GlobalHub.BroadcastToUser(view.UserID, ws.MessageTypeViewUpdate, view)
+	}
+
+	return c.JSON(view)
+}
+
+// DeleteSavedView deletes a view; its tasks are untouched
+func DeleteSavedView(c *fiber.Ctx) error {
+	var view models.SavedView
+	if err := findUserView(c, c.Params("id"), &view); err != nil {
+		return apierror.NotFound("View not found")
+	}
+
+	if err := database.DB.Delete(&view).Error; err != nil {
+		return apierror.Internal("Failed to delete view").WithCause(err)
+	}
+	recordChange(c, models.AuditViewDelete, "saved_view", view.ID, view, nil)
+
+	if ws.GlobalHub != nil {
+		ws.GlobalHub.BroadcastToUser(view.UserID, ws.MessageTypeViewDelete, fiber.Map{"id": view.ID})
+	}
+
+	return c.Status(204).SendString("")
+}
+
+// GetSavedViewTasks evaluates a view: a page of its tasks, in its sort, as
+// GET /tasks returns them, plus counts over every task it matches. The
+// filter parameters of GET /tasks narrow it further.
+func GetSavedViewTasks(c *fiber.Ctx) error {
+	var view models.SavedView
+	if err := findUserView(c, c.Params("id"), &view); err != nil {
+		return apierror.NotFound("View not found")
+	}
+
+	query, sort, err := taskListing{search: view.Query, sort: view.Sort}.query(c)
+	if err != nil {
+		return err
+	}
+	counts, err := taskCounts(c, query)
+	if err != nil {
+		return err
+	}
+	page, err := taskPage(c, query, sort)
+	if err != nil {
+		return err
+	}
+
+	page["view"] = view
+	page["counts"] = counts
+	return c.JSON(page)
+}
+
+// taskCounts breaks the tasks query matches down by status, priority and
+// quadrant, and counts the overdue ones. Every known value is present, so
+// clients can show zeros.
+func taskCounts(c *fiber.Ctx, query *gorm.DB) (fiber.Map, error) {
+	counts := fiber.Map{}
+	groups := []struct {
+		name, column string
+		values       []string
+	}{
+		{"status", "tasks.status", models.TaskStatuses},
+		{"priority", "tasks.priority", models.TaskPriorities},
+		{"quadrant", "tasks.quadrant", models.TaskQuadrants},
+	}
+	for _, group := range groups {
+		var rows []struct {
+			Value string
+			Count int64
+		}
+		if err := query.Session(&gorm.Session{}).
+			Select(group.column + " AS value, COUNT(*) AS count").
+			Group(group.column).Scan(&rows).Error; err != nil {
+			return nil, apierror.Internal("Failed to count tasks").WithCause(err)
+		}
+		byValue := map[string]int64{}
+		for _, value := range group.values {
+			byValue[value] = 0
+		}
+		for _, row := range rows {
+			if row.Value != "" {
+				byValue[row.Value] = row.Count
+			}
+		}
+		counts[group.name] = byValue
+	}
+
+	settings := loadUserSettings(currentUserID(c))
+	today := startOfDay(time.Now(), settings.Location())
+	var overdue int64
+	if err := query.Session(&gorm.Session{}).
+		Where("tasks.status != ? AND tasks.due_date < ?", "done", today.UTC()).
+		Count(&overdue).Error; err != nil {
+		return nil, apierror.Internal("Failed to count tasks").WithCause(err)
+	}
+	counts["overdue"] = overdue
+	return counts, nil
+}
@@ -0,0 +1,185 @@
+package handlers_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"tonish/backend/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// viewPage is the response of GET /views/:id/tasks.
+type viewPage struct {
+	taskPage
+	View   models.SavedView `json:"view"`
+	Counts struct {
+		Status   map[string]int64 `json:"status"`
+		Priority map[string]int64 `json:"priority"`
+		Quadrant map[string]int64 `json:"quadrant"`
+		Overdue  int64            `json:"overdue"`
+	} `json:"counts"`
+}
+
+func createView(t *testing.T, app *fiber.App, token string, body map[string]interface{}) models.SavedView {
+	t.Helper()
+	var view models.SavedView
+	if status := doJSON(t, app, "POST", "/api/views", token, body, &view); status != 201 {
+		t.Fatalf("create view: status %d", status)
+	}
+	return view
+}
+
+func titles(tasks []models.Task) []string {
+	out := make([]string, len(tasks))
+	for i, task := range tasks {
+		out[i] = task.Title
+	}
+	return out
+}
+
+func TestSavedViewTasks(t *testing.T) {
+	app := newTestApp(t)
+	_, token := createUser(t, app, "alice@example.com")
+	_, bobToken := createUser(t, app, "bob@example.com")
+	yesterday := time.Now().Add(-24 * time.Hour).UTC().Format(time.RFC3339)
+
+	createTask(t, app, token, map[string]interface{}{"title": "Pay rent", "priority": "high", "quadrant": "urgent-important", "due_date": yesterday})
+	createTask(t, app, token, map[string]interface{}{"title": "File taxes", "priority": "high", "status": "done", "due_date": yesterday})
+	createTask(t, app, token, map[string]interface{}{"title": "Water plants", "priority": "low"})
+	createTask(t, app, bobToken, map[string]interface{}{"title": "Bob's bills", "priority": "high"})
+
+	view := createView(t, app, token, map[string]interface{}{
+		"name":  "Important",
+		"query": "priority:high",
+		"sort":  "title",
+	})
+	path := fmt.Sprintf("/api/views/%d/tasks", view.ID)
+
+	var page viewPage
+	if status := doJSON(t, app, "GET", path, token, nil, &page); status != 200 {
+		t.Fatalf("evaluate view: status %d", status)
+	}
+	if got := titles(page.Tasks); len(got) != 2 || got[0] != "File taxes" || got[1] != "Pay rent" {
+		t.Fatalf("view tasks %v, want File taxes, Pay rent", got)
+	}
+	if page.Total != 2 || page.View.ID != view.ID {
+		t.Fatalf("total %d, view %d", page.Total, page.View.ID)
+	}
+
+	// Counts cover every task the view matches, with zeros for the rest
+	counts := page.Counts
+	if counts.Status["todo"] != 1 || counts.Status["done"] != 1 || counts.Status["in-progress"] != 0 {
+		t.Fatalf("status counts %v", counts.Status)
+	}
+	if counts.Priority["high"] != 2 || counts.Priority["low"] != 0 || len(counts.Priority) != len(models.TaskPriorities) {
+		t.Fatalf("priority counts %v", counts.Priority)
+	}
+	if counts.Quadrant["urgent-important"] != 1 || len(counts.Quadrant) != len(models.TaskQuadrants) {
+		t.Fatalf("quadrant counts %v", counts.Quadrant)
+	}
+	if counts.Overdue != 1 {
+		t.Fatalf("overdue %d, want 1; done tasks are never overdue", counts.Overdue)
+	}
+
+	// GET /tasks filters narrow the view further, counts included
+	if status := doJSON(t, app, "GET", path+"?status=todo", token, nil, &page); status != 200 {
+		t.Fatalf("narrowed view: status %d", status)
+	}
+	if got := titles(page.Tasks); len(got) != 1 || got[0] != "Pay rent" || page.Counts.Status["done"] != 0 {
+		t.Fatalf("narrowed view %v, counts %v", got, page.Counts.Status)
+	}
+
+	// The query is evaluated afresh, so new tasks show up
+	createTask(t, app, token, map[string]interface{}{"title": "Book dentist", "priority": "high"})
+	doJSON(t, app, "GET", path, token, nil, &page)
+	if got := titles(page.Tasks); len(got) != 3 || got[0] != "Book dentist" {
+		t.Fatalf("view after a new task %v", got)
+	}
+}
+
+func TestSavedViewValidation(t *testing.T) {
+	app := newTestApp(t)
+	_, token := createUser(t, app, "alice@example.com")
+
+	var resp validationError
+	if status := doJSON(t, app, "POST", "/api/views", token, map[string]interface{}{
+		"name":         "Broken",
+		"query":        "priority:urgent",
+		"sort":         "colour",
+		"display_mode": "gallery",
+	}, &resp); status != 422 {
+		t.Fatalf("invalid view: status %d, want 422", status)
+	}
+	for _, field := range []string{"query", "sort", "display_mode"} {
+		if resp.Errors[field] == "" {
+			t.Errorf("no error for %s: %v", field, resp.Errors)
+		}
+	}
+
+	view := createView(t, app, token, map[string]interface{}{"name": "Everything"})
+	if view.DisplayMode != models.TaskViewKanban {
+		t.Fatalf("default display mode %q", view.DisplayMode)
+	}
+}
+
+func TestSavedViewPinning(t *testing.T) {
+	app := newTestApp(t)
+	_, token := createUser(t, app, "alice@example.com")
+	createView(t, app, token, map[string]interface{}{"name": "Alpha"})
+	beta := createView(t, app, token, map[string]interface{}{"name": "Beta", "query": "status:todo", "sort": "-due_date"})
+
+	// A partial update pins the view and leaves the rest alone
+	var pinned models.SavedView
+	if status := doJSON(t, app, "PUT", fmt.Sprintf("/api/views/%d", beta.ID), token, map[string]interface{}{"is_pinned": true}, &pinned); status != 200 {
+		t.Fatalf("pin: status %d", status)
+	}
+	if !pinned.IsPinned || pinned.Name != "Beta" || pinned.Query != "status:todo" || pinned.Sort != "-due_date" {
+		t.Fatalf("pinned view %+v", pinned)
+	}
+
+	var views []models.SavedView
+	doJSON(t, app, "GET", "/api/views", token, nil, &views)
+	if len(views) != 2 || views[0].Name != "Beta" || views[1].Name != "Alpha" {
+		t.Fatalf("views %v, want the pinned Beta first", views)
+	}
+}
+
+func TestSavedViewOwnership(t *testing.T) {
+	app := newTestApp(t)
+	_, token := createUser(t, app, "alice@example.com")
+	_, bobToken := createUser(t, app, "bob@example.com")
+	view := createView(t, app, token, map[string]interface{}{"name": "Mine"})
+	path := fmt.Sprintf("/api/views/%d", view.ID)
+
+	for _, route := range []struct {
+		method, path string
+		body         interface{}
+	}{
+		{"GET", path, nil},
+		{"GET", path + "/tasks", nil},
+		{"PUT", path, map[string]interface{}{"name": "Taken"}},
+		{"DELETE", path, nil},
+	} {
+		if status := doJSON(t, app, route.method, route.path, bobToken, route.body, nil); status != 404 {
+			t.Errorf("%s %s by another user: status %d, want 404", route.method, route.path, status)
+		}
+	}
+	var views []models.SavedView
+	doJSON(t, app, "GET", "/api/views", bobToken, nil, &views)
+	if len(views) != 0 {
+		t.Fatalf("another user lists %v", views)
+	}
+
+	var stored models.SavedView
+	if status := doJSON(t, app, "GET", path, token, nil, &stored); status != 200 || stored.Name != "Mine" {
+		t.Fatalf("owner's view after the attempts: status %d, %+v", status, stored)
+	}
+	if status := doJSON(t, app, "DELETE", path, token, nil, nil); status != 204 {
+		t.Fatalf("delete: status %d", status)
+	}
+	if status := doJSON(t, app, "GET", path, token, nil, nil); status != 404 {
+		t.Fatalf("deleted view: status %d, want 404", status)
+	}
+}
@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"tonish/backend/middleware"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// generateSecret returns a random URL-safe secret, starting with prefix, and
+// the hash to store for it. Invite codes and other bearer secrets are never
+// persisted in plain text.
+func generateSecret(prefix string) (string, string, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", err
+	}
+	secret := prefix + hex.EncodeToString(buf)
+	return secret, middleware.HashSecret(secret), nil
+}
+
+// signPurposeToken issues a short-lived JWT that proves one step of a
+// multi-step flow. The purpose claim keeps it from being accepted as an
+// access token or for any other flow.
+func signPurposeToken(userID uint, purpose string, ttl time.Duration) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"user_id": userID,
+		"purpose": purpose,
+		"exp":     time.Now().Add(ttl).Unix(),
+	})
+
+	return token.SignedString(getJWTSecret())
+}
+
+// parsePurposeToken returns the user ID a purpose token was issued for.
+func parsePurposeToken(tokenString, purpose string) (uint, bool) {
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fiber.ErrUnauthorized
+		}
+		return getJWTSecret(), nil
+	})
+	if err != nil || !token.Valid {
+		return 0, false
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || claims["purpose"] != purpose {
+		return 0, false
+	}
+	userID, ok := claims["user_id"].(float64)
+	if !ok || userID <= 0 {
+		return 0, false
+	}
+
+	return uint(userID), true
+}
@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"time"
+
+	"tonish/backend/apierror"
+	"tonish/backend/database"
+	"tonish/backend/models"
+	ws "tonish/backend/websocket"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// SessionResponse is a session as shown to its owner
+type SessionResponse struct {
+	models.Session
+	Current bool `json:"current"`
+}
+
+// revokeSession marks a session as revoked. Access tokens issued for it stop
+// working immediately, its refresh tokens can no longer be exchanged and its
+// WebSocket connections are closed.
+func revokeSession(sessionID uint) error {
+	if err := database.DB.Model(&models.Session{}).
+		Where("id = ? AND revoked_at IS NULL", sessionID).
+		Update("revoked_at", time.Now()).Error; err != nil {
+		return err
+	}
+
+	if ws.GlobalHub != nil {
+		ws.GlobalHub.DisconnectSession(sessionID)
+	}
+
+	return nil
+}
+
+// revokeUserSessions revokes every active session of a user except exceptID,
+// which may be 0 to revoke them all.
+func revokeUserSessions(userID uint, exceptID uint) error {
+	var sessionIDs []uint
+	if err := database.DB.Model(&models.Session{}).
+		Where("user_id = ? AND id != ? AND revoked_at IS NULL", userID, exceptID).
+		Pluck("id", &sessionIDs).Error; err != nil {
+		return err
+	}
+
+	for _, sessionID := range sessionIDs {
+		if err := revokeSession(sessionID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetSessions lists the current user's active sessions, most recently used first
+func GetSessions(c *fiber.Ctx) error {
+	var sessions []models.Session
+
+	if err := database.DB.
+		Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", currentUserID(c), time.Now()).
+		Order("last_seen_at DESC").
+		Find(&sessions).Error; err != nil {
+		return apierror.Internal("Failed to load sessions").WithCause(err)
+	}
+
+	currentID, _ := c.Locals("session_id").(uint)
+	response := make([]SessionResponse, 0, len(sessions))
+	for _, session := range sessions {
+		response = append(response, SessionResponse{
+			Session: session,
+			Current: session.ID == currentID,
+		})
+	}
+
+	return c.JSON(response)
+}
+
+// RevokeSession signs out one of the current user's sessions
+func RevokeSession(c *fiber.Ctx) error {
+	id := c.Params("id")
+	var session models.Session
+
+	if err := database.DB.
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", id, currentUserID(c)).
+		First(&session).Error; err != nil {
+		return apierror.NotFound("Session not found")
+	}
+
+	if err := revokeSession(session.ID); err != nil {
+		return apierror.Internal("Failed to revoke session").WithCause(err)
+	}
+
+	return c.Status(204).SendString("")
+}
+
+// RevokeOtherSessions signs out every session of the current user except the
+// one making the request
+func RevokeOtherSessions(c *fiber.Ctx) error {
+	currentID, _ := c.Locals("session_id").(uint)
+
+	if err := revokeUserSessions(currentUserID(c), currentID); err != nil {
+		return apierror.Internal("Failed to revoke sessions").WithCause(err)
+	}
+
+	return c.Status(204).SendString("")
+}
@@ -0,0 +1,137 @@
+package handlers_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	ws "tonish/backend/websocket"
+)
+
+// sessionList is the response of GET /api/sessions.
+type sessionList []struct {
+	ID      uint `json:"id"`
+	Current bool `json:"current"`
+}
+
+// currentSession returns the id of the session marked as current.
+func currentSession(t *testing.T, sessions sessionList) uint {
+	t.Helper()
+	for _, session := range sessions {
+		if session.Current {
+			return session.ID
+		}
+	}
+	t.Fatal("no current session")
+	return 0
+}
+
+// connectClient registers a WebSocket client of session on a running hub.
+// Registering a second client waits until the hub has added the first.
+func connectClient(hub *ws.Hub, userID, sessionID uint) *ws.Client {
+	client := &ws.Client{UserID: userID, SessionID: sessionID, Send: make(chan []byte, 8)}
+	hub.Register(client)
+	hub.Register(&ws.Client{Send: make(chan []byte, 1)})
+	return client
+}
+
+// expectClosed waits for the hub to close client's send channel.
+func expectClosed(t *testing.T, client *ws.Client) {
+	t.Helper()
+	for {
+		select {
+		case _, open := <-client.Send:
+			if !open {
+				return
+			}
+		case <-time.After(time.Second):
+			t.Fatal("WebSocket client was not disconnected")
+		}
+	}
+}
+
+func TestRevokeSession(t *testing.T) {
+	app := newTestApp(t)
+	user, _ := createUser(t, app, "alice@example.com")
+	laptop := login(t, app, "alice@example.com")
+	phone := login(t, app, "alice@example.com")
+
+	hub := ws.NewHub()
+	go hub.Run()
+	ws.GlobalHub = hub
+	t.Cleanup(func() { ws.GlobalHub = nil })
+
+	var phoneSessions sessionList
+	doJSON(t, app, "GET", "/api/sessions", phone.Token, nil, &phoneSessions)
+	phoneID := currentSession(t, phoneSessions)
+	phoneClient := connectClient(hub, user.ID, phoneID)
+
+	if status := doJSON(t, app, "DELETE", fmt.Sprintf("/api/sessions/%d", phoneID), laptop.Token, nil, nil); status != 204 {
+		t.Fatalf("revoke session: status %d", status)
+	}
+
+	if status := doJSON(t, app, "GET", "/api/user/me", phone.Token, nil, nil); status != 401 {
+		t.Fatalf("revoked session's access token: status %d, want 401", status)
+	}
+	if status := doJSON(t, app, "POST", "/api/auth/refresh", "", map[string]string{
+		"refresh_token": phone.RefreshToken,
+	}, nil); status != 401 {
+		t.Fatalf("revoked session's refresh token: status %d, want 401", status)
+	}
+	expectClosed(t, phoneClient)
+
+	if status := doJSON(t, app, "GET", "/api/user/me", laptop.Token, nil, nil); status != 200 {
+		t.Fatalf("revoking session: status %d", status)
+	}
+	if status := doJSON(t, app, "DELETE", fmt.Sprintf("/api/sessions/%d", phoneID), laptop.Token, nil, nil); status != 404 {
+		t.Fatalf("revoking twice: status %d, want 404", status)
+	}
+}
+
+func TestRevokeOtherSessions(t *testing.T) {
+	app := newTestApp(t)
+	user, _ := createUser(t, app, "alice@example.com")
+	laptop := login(t, app, "alice@example.com")
+	phone := login(t, app, "alice@example.com")
+	_, bobToken := createUser(t, app, "bob@example.com")
+
+	hub := ws.NewHub()
+	go hub.Run()
+	ws.GlobalHub = hub
+	t.Cleanup(func() { ws.GlobalHub = nil })
+
+	var laptopSessions, phoneSessions sessionList
+	doJSON(t, app, "GET", "/api/sessions", laptop.Token, nil, &laptopSessions)
+	doJSON(t, app, "GET", "/api/sessions", phone.Token, nil, &phoneSessions)
+	laptopClient := connectClient(hub, user.ID, currentSession(t, laptopSessions))
+	phoneClient := connectClient(hub, user.ID, currentSession(t, phoneSessions))
+
+	if status := doJSON(t, app, "DELETE", "/api/sessions", laptop.Token, nil, nil); status != 204 {
+		t.Fatalf("revoke other sessions: status %d", status)
+	}
+
+	if status := doJSON(t, app, "GET", "/api/user/me", phone.Token, nil, nil); status != 401 {
+		t.Fatalf("other session's access token: status %d, want 401", status)
+	}
+	expectClosed(t, phoneClient)
+
+	if status := doJSON(t, app, "GET", "/api/user/me", laptop.Token, nil, nil); status != 200 {
+		t.Fatalf("current session: status %d", status)
+	}
+	select {
+	case _, open := <-laptopClient.Send:
+		if !open {
+			t.Fatal("current session's client was disconnected")
+		}
+	default:
+	}
+	if status := doJSON(t, app, "GET", "/api/user/me", bobToken, nil, nil); status != 200 {
+		t.Fatalf("another user's session: status %d", status)
+	}
+
+	var remaining sessionList
+	doJSON(t, app, "GET", "/api/sessions", laptop.Token, nil, &remaining)
+	if len(remaining) != 1 || !remaining[0].Current {
+		t.Fatalf("sessions after revoking others: %+v", remaining)
+	}
+}
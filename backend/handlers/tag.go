@@ -0,0 +1,272 @@
+package handlers
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+
+	"tonish/backend/apierror"
+	"tonish/backend/database"
+	"tonish/backend/models"
+	ws "tonish/backend/websocket"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+var colorPattern = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+// errTagExists rejects a tag name another of the user's tags already has.
+var errTagExists = errors.New("tag exists")
+
+func init() {
+	apierror.Register(errTagExists, fiber.StatusConflict, "tag_exists", "A tag with that name already exists; merge the tags instead")
+}
+
+type CreateTagRequest struct {
+	Name  string `json:"name" validate:"required,tagname,max=50"`
+	Color string `json:"color" validate:"color"`
+}
+
+// UpdateTagRequest renames a tag or changes its color; "" clears the color.
+type UpdateTagRequest struct {
+	Name  *string `json:"name" validate:"notblank,tagname,max=50"`
+	Color *string `json:"color" validate:"color"`
+}
+
+type MergeTagRequest struct {
+	Into uint `json:"into" validate:"required"` // The tag that is kept
+}
+
+// tagUsage is a tag with the number of live tasks, notebooks and pages
+// carrying it.
+type tagUsage struct {
+	models.Tag
+	TaskCount     int64 `json:"task_count"`
+	NotebookCount int64 `json:"notebook_count"`
+	PageCount     int64 `json:"page_count"`
+}
+
+// findUserTag loads a tag owned by the current user.
+func findUserTag(c *fiber.Ctx, id interface{}, tag *models.Tag) error {
+	return database.DB.Where("id = ? AND user_id = ?", id, currentUserID(c)).First(tag).Error
+}
+
+// tagNameTaken reports whether another of the user's tags has name.
+func tagNameTaken(tx *gorm.DB, userID uint, name string, except uint) bool {
+	var count int64
+	tx.Model(&models.Tag{}).Where("user_id = ? AND key = ? AND id <> ?", userID, models.TagKey(name), except).Count(&count)
+	return count > 0
+}
+
+// GetTags lists the current user's tags by name, with usage counts
+func GetTags(c *fiber.Ctx) error {
+	tags := []tagUsage{}
+	if err := database.DB.Model(&models.Tag{}).
+		Select(`tags.*,
+			(SELECT COUNT(*) FROM task_tags JOIN tasks ON tasks.id = task_tags.task_id
+				WHERE task_tags.tag_id = tags.id AND tasks.deleted_at IS NULL) AS task_count,
+			(SELECT COUNT(*) FROM notebook_tags WHERE notebook_tags.tag_id = tags.id) AS notebook_count,
+			(SELECT COUNT(*) FROM page_tags WHERE page_tags.tag_id = tags.id) AS page_count`).
+		Where("tags.user_id = ?", currentUserID(c)).
+		Order("tags.key").Scan(&tags).Error; err != nil {
+		return apierror.Internal("Failed to load tags").WithCause(err)
+	}
+	return c.JSON(tags)
+}
+
+// CreateTag adds a tag before anything carries it, typically to give it a
+// color
+func CreateTag(c *fiber.Ctx) error {
+	req := new(CreateTagRequest)
+	if err := parseBody(c, req); err != nil {
+		return err
+	}
+
+	name := strings.TrimSpace(req.Name)
+	tag := models.Tag{UserID: currentUserID(c), Name: name, Key: models.TagKey(name), Color: strings.ToLower(req.Color)}
+	if tagNameTaken(database.DB, tag.UserID, name, 0) {
+		return errTagExists
+	}
+	if err := database.DB.Create(&tag).Error; err != nil {
+		return apierror.Internal("Failed to create tag").WithCause(err)
+	}
+
+	if ws.GlobalHub != nil {
+		ws.GlobalHub.BroadcastToUser(tag.UserID, ws.MessageTypeTagCreate, tag)
+	}
+
+	return c.Status(201).JSON(tag)
+}
+
+// UpdateTag changes a tag's color or renames it on every task, notebook and
+// page carrying it
+func UpdateTag(c *fiber.Ctx) error {
+	var tag models.Tag
+	if err := findUserTag(c, c.Params("id"), &tag); err != nil {
+		return apierror.NotFound("Tag not found")
+	}
+
+	req := new(UpdateTagRequest)
+	if err := parseBody(c, req); err != nil {
+		return err
+	}
+
+	before := tag
+	if req.Name != nil {
+		tag.Name = strings.TrimSpace(*req.Name)
+		tag.Key = models.TagKey(tag.Name)
+	}
+	if req.Color != nil {
+		tag.Color = strings.ToLower(*req.Color)
+	}
+
+	var changed map[string][]uint
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if tagNameTaken(tx, tag.UserID, tag.Name, tag.ID) {
+			return errTagExists
+		}
+		if err := tx.Model(&tag).Select("name", "key", "color").Updates(&tag).Error; err != nil {
+			return err
+		}
+		if tag.Name == before.Name {
+			return nil
+		}
+		var err error
+		changed, err = database.Retag(tx, &tag, func(names []string) []string {
+			return replaceTag(names, before.Key, tag.Name)
+		})
+		return err
+	})
+	if errors.Is(err, errTagExists) {
+		return err
+	}
+	if err != nil {
+		return apierror.Internal("Failed to update tag").WithCause(err)
+	}
+
+	broadcastRetagged(tag.UserID, changed)
+	if ws.GlobalHub != nil {
+		ws.GlobalHub.BroadcastToUser(tag.UserID, ws.MessageTypeTagUpdate, tag)
+	}
+
+	return c.JSON(tag)
+}
+
+// MergeTag folds a tag into another: everything carrying it carries the
+// other tag instead, and the merged tag is deleted
+func MergeTag(c *fiber.Ctx) error {
+	var source models.Tag
+	if err := findUserTag(c, c.Params("id"), &source); err != nil {
+		return apierror.NotFound("Tag not found")
+	}
+
+	req := new(MergeTagRequest)
+	if err := parseBody(c, req); err != nil {
+		return err
+	}
+	if req.Into == source.ID {
+		return apierror.Validation(map[string]string{"into": "must be another tag"})
+	}
+	var target models.Tag
+	if err := findUserTag(c, req.Into, &target); err != nil {
+		return apierror.NotFound("Tag not found")
+	}
+
+	var changed map[string][]uint
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		changed, err = database.Retag(tx, &source, func(names []string) []string {
+			return replaceTag(names, source.Key, target.Name)
+		})
+		if err != nil {
+			return err
+		}
+		return tx.Delete(&source).Error
+	})
+	if err != nil {
+		return apierror.Internal("Failed to merge tags").WithCause(err)
+	}
+	recordChange(c, models.AuditTagMerge, "tag", source.ID, source, target)
+
+	broadcastRetagged(source.UserID, changed)
+	if ws.GlobalHub != nil {
+		ws.GlobalHub.BroadcastToUser(source.UserID, ws.MessageTypeTagDelete, fiber.Map{"id": source.ID, "merged_into": target.ID})
+	}
+
+	return c.JSON(target)
+}
+
+// DeleteTag removes a tag from every task, notebook and page, then deletes it
+func DeleteTag(c *fiber.Ctx) error {
+	var tag models.Tag
+	if err := findUserTag(c, c.Params("id"), &tag); err != nil {
+		return apierror.NotFound("Tag not found")
+	}
+
+	var changed map[string][]uint
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		changed, err = database.Retag(tx, &tag, func(names []string) []string {
+			return replaceTag(names, tag.Key, "")
+		})
+		if err != nil {
+			return err
+		}
+		return tx.Delete(&tag).Error
+	})
+	if err != nil {
+		return apierror.Internal("Failed to delete tag").WithCause(err)
+	}
+	recordChange(c, models.AuditTagDelete, "tag", tag.ID, tag, nil)
+
+	broadcastRetagged(tag.UserID, changed)
+	if ws.GlobalHub != nil {
+		ws.GlobalHub.BroadcastToUser(tag.UserID, ws.MessageTypeTagDelete, fiber.Map{"id": tag.ID})
+	}
+
+	return c.Status(204).SendString("")
+}
+
+// replaceTag swaps the name with key for name, or drops it when name is "".
+func replaceTag(names []string, key, name string) []string {
+	var replaced []string
+	for _, n := range names {
+		if models.TagKey(n) != key {
+			replaced = append(replaced, n)
+		} else if name != "" {
+			replaced = append(replaced, name)
+		}
+	}
+	return replaced
+}
+
+// broadcastRetagged sends the rows Retag rewrote to the owner's clients, as
+// their own update messages.
+func broadcastRetagged(userID uint, changed map[string][]uint) {
+	if ws.GlobalHub == nil {
+		return
+	}
+	var tasks []models.Task
+	if ids := changed[database.TaggedTasks]; len(ids) > 0 {
+		database.DB.Unscoped().Where("id IN ?", ids).Find(&tasks)
+	}
+	attachBlockers(tasks)
+	for _, task := range tasks {
+		ws.GlobalHub.BroadcastToUser(userID, ws.MessageTypeTaskUpdate, task)
+	}
+	var notebooks []models.Notebook
+	if ids := changed[database.TaggedNotebooks]; len(ids) > 0 {
+		database.DB.Where("id IN ?", ids).Find(&notebooks)
+	}
+	for _, notebook := range notebooks {
+		ws.GlobalHub.BroadcastToUser(userID, ws.MessageTypeNotebookUpdate, notebook)
+	}
+	var pages []models.Page
+	if ids := changed[database.TaggedPages]; len(ids) > 0 {
+		database.DB.Where("id IN ?", ids).Find(&pages)
+	}
+	for _, page := range pages {
+		ws.GlobalHub.BroadcastToUser(userID, ws.MessageTypeNotebookUpdate, page)
+	}
+}
@@ -0,0 +1,167 @@
+package handlers_test
+
+import (
+	"fmt"
+	"testing"
+
+	"tonish/backend/database"
+	"tonish/backend/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// tagUsage is one entry of GET /api/tags.
+type tagUsage struct {
+	ID            uint   `json:"id"`
+	Name          string `json:"name"`
+	TaskCount     int64  `json:"task_count"`
+	NotebookCount int64  `json:"notebook_count"`
+	PageCount     int64  `json:"page_count"`
+}
+
+// listTags returns the user's tags keyed by name.
+func listTags(t *testing.T, app *fiber.App, token string) map[string]tagUsage {
+	t.Helper()
+	var tags []tagUsage
+	if status := doJSON(t, app, "GET", "/api/tags", token, nil, &tags); status != 200 {
+		t.Fatalf("list tags: status %d", status)
+	}
+	byName := map[string]tagUsage{}
+	for _, tag := range tags {
+		byName[tag.Name] = tag
+	}
+	return byName
+}
+
+// tagged is a task, notebook and page sharing tags, in different styles.
+type tagged struct {
+	task     models.Task
+	notebook models.Notebook
+	page     models.Page
+}
+
+func createTagged(t *testing.T, app *fiber.App, token string) tagged {
+	t.Helper()
+	var items tagged
+	items.task = createTask(t, app, token, map[string]interface{}{"title": "Mow", "tags": "work, home"})
+	if status := doJSON(t, app, "POST", "/api/notebooks", token, map[string]string{"name": "Jobs", "tags": `["work"]`}, &items.notebook); status != 201 {
+		t.Fatalf("create notebook: status %d", status)
+	}
+	if status := doJSON(t, app, "POST", "/api/pages", token, map[string]interface{}{
+		"notebook_id": items.notebook.ID,
+		"title":       "Plan",
+		"tags":        "Work",
+	}, &items.page); status != 201 {
+		t.Fatalf("create page: status %d", status)
+	}
+	return items
+}
+
+// expectTags checks the stored tags strings and that each item's version
+// went up by bumps.
+func expectTags(t *testing.T, app *fiber.App, token string, before tagged, bumps uint, task, notebook, page string) {
+	t.Helper()
+	var after tagged
+	doJSON(t, app, "GET", fmt.Sprintf("/api/tasks/%d", before.task.ID), token, nil, &after.task)
+	doJSON(t, app, "GET", fmt.Sprintf("/api/notebooks/%d", before.notebook.ID), token, nil, &after.notebook)
+	doJSON(t, app, "GET", fmt.Sprintf("/api/pages/%d", before.page.ID), token, nil, &after.page)
+
+	if after.task.Tags != task || after.notebook.Tags != notebook || after.page.Tags != page {
+		t.Fatalf("tags %q, %q, %q; want %q, %q, %q", after.task.Tags, after.notebook.Tags, after.page.Tags, task, notebook, page)
+	}
+	if after.task.Version != before.task.Version+bumps ||
+		after.notebook.Version != before.notebook.Version+bumps ||
+		after.page.Version != before.page.Version+bumps {
+		t.Fatalf("versions %d, %d, %d; want each up by %d from %d, %d, %d", after.task.Version, after.notebook.Version, after.page.Version,
+			bumps, before.task.Version, before.notebook.Version, before.page.Version)
+	}
+}
+
+func TestRenameTag(t *testing.T) {
+	app := newTestApp(t)
+	_, token := createUser(t, app, "alice@example.com")
+	items := createTagged(t, app, token)
+
+	work := listTags(t, app, token)["work"]
+	if work.TaskCount != 1 || work.NotebookCount != 1 || work.PageCount != 1 {
+		t.Fatalf("work before the rename: %+v", work)
+	}
+	if status := doJSON(t, app, "PATCH", fmt.Sprintf("/api/tags/%d", work.ID), token, map[string]string{"name": "job"}, nil); status != 200 {
+		t.Fatalf("rename: status %d", status)
+	}
+	expectTags(t, app, token, items, 1, "job, home", `["job"]`, "job")
+
+	// Renaming onto another tag's name is a merge, which must be explicit
+	if status := doJSON(t, app, "PATCH", fmt.Sprintf("/api/tags/%d", work.ID), token, map[string]string{"name": "Home"}, nil); status != 409 {
+		t.Fatalf("rename onto an existing tag: status %d, want 409", status)
+	}
+}
+
+func TestMergeTag(t *testing.T) {
+	app := newTestApp(t)
+	_, token := createUser(t, app, "alice@example.com")
+	items := createTagged(t, app, token)
+	tags := listTags(t, app, token)
+
+	if status := doJSON(t, app, "POST", fmt.Sprintf("/api/tags/%d/merge", tags["work"].ID), token, map[string]uint{"into": tags["home"].ID}, nil); status != 200 {
+		t.Fatalf("merge: status %d", status)
+	}
+	// The task carried both and keeps one copy
+	expectTags(t, app, token, items, 1, "home", `["home"]`, "home")
+
+	after := listTags(t, app, token)
+	if _, ok := after["work"]; ok {
+		t.Fatal("merged tag still exists")
+	}
+	if home := after["home"]; home.TaskCount != 1 || home.NotebookCount != 1 || home.PageCount != 1 {
+		t.Fatalf("home after the merge: %+v", home)
+	}
+}
+
+func TestDeleteTag(t *testing.T) {
+	app := newTestApp(t)
+	_, token := createUser(t, app, "alice@example.com")
+	items := createTagged(t, app, token)
+
+	if status := doJSON(t, app, "DELETE", fmt.Sprintf("/api/tags/%d", listTags(t, app, token)["work"].ID), token, nil, nil); status != 204 {
+		t.Fatalf("delete: status %d", status)
+	}
+	expectTags(t, app, token, items, 1, "home", "[]", "")
+
+	if _, ok := listTags(t, app, token)["work"]; ok {
+		t.Fatal("deleted tag still exists")
+	}
+}
+
+func TestBackfillLegacyTags(t *testing.T) {
+	app := newTestApp(t)
+	user, token := createUser(t, app, "alice@example.com")
+
+	// Rows written before the tags table existed only have the string
+	legacy := models.Task{UserID: user.ID, Title: "Shop", Tags: "Errands, errands ,home,", Version: 1}
+	database.DB.Create(&legacy)
+	notebook := models.Notebook{UserID: user.ID, Name: "Lists", Tags: "errands", Version: 1}
+	database.DB.Create(&notebook)
+	database.DB.Create(&models.Page{NotebookID: notebook.ID, Title: "Groceries", Tags: `["Home"]`, Version: 1})
+
+	database.Migrate()
+	database.Migrate()
+
+	tags := listTags(t, app, token)
+	if len(tags) != 2 {
+		t.Fatalf("tags after backfill: %+v", tags)
+	}
+	// The first spelling names the tag
+	if errands := tags["Errands"]; errands.TaskCount != 1 || errands.NotebookCount != 1 || errands.PageCount != 0 {
+		t.Fatalf("Errands after backfill: %+v", errands)
+	}
+	if home := tags["home"]; home.TaskCount != 1 || home.NotebookCount != 0 || home.PageCount != 1 {
+		t.Fatalf("home after backfill: %+v", home)
+	}
+
+	var filtered taskPage
+	doJSON(t, app, "GET", "/api/tasks?tags=errands", token, nil, &filtered)
+	if len(filtered.Tasks) != 1 || filtered.Tasks[0].ID != legacy.ID {
+		t.Fatalf("filtering by a backfilled tag: %+v", filtered.Tasks)
+	}
+}
@@ -1,8 +1,11 @@
 package handlers
 
 import (
+	"errors"
+	"strings"
 	"time"
 
+	"tonish/backend/apierror"
 	"tonish/backend/database"
 	"tonish/backend/models"
 	ws "tonish/backend/websocket"
@@ -11,6 +14,92 @@ import (
 	"gorm.io/gorm"
 )
 
+// TaskRequest is the body of POST /tasks and PUT /tasks/:id. Server-managed
+// fields such as the owner, version and archive flag are not part of it.
+type TaskRequest struct {
+	Title                string     `json:"title" validate:"required,max=500"`
+	Description          string     `json:"description" validate:"max=50000"`
+	Priority             string     `json:"priority" validate:"oneof=low medium high"`
+	Status               string     `json:"status" validate:"oneof=todo in-progress done"`
+	Tags                 string     `json:"tags" validate:"max=2000"`
+	DueDate              *time.Time `json:"due_date"`
+	IsQuickTask          bool       `json:"is_quick_task"`
+	Quadrant             string     `json:"quadrant" validate:"oneof=urgent-important not-urgent-important urgent-not-important not-urgent-not-important"`
+	TaskType             string     `json:"task_type" validate:"oneof=kanban matrix calendar"`
+	IsPayment            bool       `json:"is_payment"`
+	Amount               float64    `json:"amount" validate:"min=0"`
+	Currency             string     `json:"currency" validate:"currency"`
+	IsPaid               bool       `json:"is_paid"`
+	PaidAt               *time.Time `json:"paid_at"`
+	PaymentNotes         string     `json:"payment_notes" validate:"max=5000"`
+	CalendarSubtype      string     `json:"calendar_subtype" validate:"oneof=regular payment reminder event"`
+	Recurrence           string     `json:"recurrence" validate:"max=500,rrule"`
+	RecurrenceExceptions string     `json:"recurrence_exceptions" validate:"max=5000,datelist"`
+}
+
+// newTaskRequest starts a request from task, so that fields missing from a
+// PUT body keep their current values. Times are copied, since decoding the
+// body writes through the pointers.
+func newTaskRequest(task *models.Task) *TaskRequest {
+	return &TaskRequest{
+		Title:                task.Title,
+		Description:          task.Description,
+		Priority:             task.Priority,
+		Status:               task.Status,
+		Tags:                 task.Tags,
+		DueDate:              copyTime(task.DueDate),
+		IsQuickTask:          task.IsQuickTask,
+		Quadrant:             task.Quadrant,
+		TaskType:             task.TaskType,
+		IsPayment:            task.IsPayment,
+		Amount:               task.Amount,
+		Currency:             task.Currency,
+		IsPaid:               task.IsPaid,
+		PaidAt:               copyTime(task.PaidAt),
+		PaymentNotes:         task.PaymentNotes,
+		CalendarSubtype:      task.CalendarSubtype,
+		Recurrence:           task.Recurrence,
+		RecurrenceExceptions: task.RecurrenceExceptions,
+	}
+}
+
+// sameTime reports whether two optional times are both unset or equal.
+func sameTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+func copyTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	c := *t
+	return &c
+}
+
+func (req *TaskRequest) apply(task *models.Task) {
+	task.Title = req.Title
+	task.Description = req.Description
+	task.Priority = req.Priority
+	task.Status = req.Status
+	task.Tags = req.Tags
+	task.DueDate = req.DueDate
+	task.IsQuickTask = req.IsQuickTask
+	task.Quadrant = req.Quadrant
+	task.TaskType = req.TaskType
+	task.IsPayment = req.IsPayment
+	task.Amount = req.Amount
+	task.Currency = strings.ToUpper(req.Currency)
+	task.IsPaid = req.IsPaid
+	task.PaidAt = req.PaidAt
+	task.PaymentNotes = req.PaymentNotes
+	task.CalendarSubtype = req.CalendarSubtype
+	task.Recurrence = req.Recurrence
+	task.RecurrenceExceptions = req.RecurrenceExceptions
+}
+
 func applyTaskTypeDefaults(task *models.Task) {
 	if task == nil {
 		return
@@ -23,62 +112,66 @@ func applyTaskTypeDefaults(task *models.Task) {
 	}
 }
 
-// GetAllTasks retrieves all tasks for a user
-func GetAllTasks(c *fiber.Ctx) error {
-	var tasks []models.Task
-
-	// For now, get all tasks (will add user filtering with auth later)
-	database.DB.Where("is_archived = ?", false).Find(&tasks)
-
-	return c.JSON(tasks)
-}
-
 // GetTask retrieves a single task by ID
 func GetTask(c *fiber.Ctx) error {
 	id := c.Params("id")
 	var task models.Task
 
-	if err := database.DB.First(&task, id).Error; err != nil {
-		return c.Status(404).JSON(fiber.Map{
-			"error": "Task not found",
-		})
+	if err := findUserTask(c, id, &task, false); err != nil {
+		return apierror.NotFound("Task not found")
 	}
 
+	setETag(c, task.Version)
 	return c.JSON(task)
 }
 
 // CreateTask creates a new task
 func CreateTask(c *fiber.Ctx) error {
-	task := new(models.Task)
-
-	if err := c.BodyParser(task); err != nil {
-		// Log the actual error for debugging
-		println("Body parser error:", err.Error())
-		println("Request body:", string(c.Body()))
-		return c.Status(400).JSON(fiber.Map{
-			"error": "Invalid request body: " + err.Error(),
-		})
+	req := new(TaskRequest)
+	if err := parseBody(c, req); err != nil {
+		return err
 	}
 
-	// Get user_id from auth middleware context
-	userID := c.Locals("user_id")
-	if userID != nil {
-		task.UserID = userID.(uint)
+	task := &models.Task{UserID: currentUserID(c), Version: 1, Blockers: []models.TaskBlocker{}}
+	req.apply(task)
+
+	if task.Currency == "" {
+		task.Currency = loadUserSettings(task.UserID).DefaultCurrency
 	}
 
 	applyTaskTypeDefaults(task)
+	normalizeDueDate(task)
 	setCompletionTimestamp(task, false)
+	if err := checkRecurrence(task); err != nil {
+		return err
+	}
 
-	if err := database.DB.Create(&task).Error; err != nil {
-		println("Database error:", err.Error())
-		return c.Status(500).JSON(fiber.Map{"error": "Failed to create task: " + err.Error()})
+	if err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(task).Error; err != nil {
+			return err
+		}
+		// A recurring task starts its own series
+		if task.Recurrence != "" {
+			task.SeriesID = &task.ID
+			if err := tx.Model(task).Update("series_id", task.ID).Error; err != nil {
+				return err
+			}
+		}
+		if err := addDefaultReminder(tx, task); err != nil {
+			return err
+		}
+		return database.SyncTags(tx, task.UserID, database.TaggedTasks, task.ID, task.Tags)
+	}); err != nil {
+		return apierror.Internal("Failed to create task").WithCause(err)
 	}
+	reminders.Wake()
 
 	// Broadcast task creation to all connected clients
 	if ws.GlobalHub != nil {
 		ws.GlobalHub.BroadcastToUser(task.UserID, ws.MessageTypeTaskCreate, task)
 	}
 
+	setETag(c, task.Version)
 	return c.Status(201).JSON(task)
 }
 
@@ -87,40 +180,98 @@ func UpdateTask(c *fiber.Ctx) error {
 	id := c.Params("id")
 	var task models.Task
 
-	if err := database.DB.First(&task, id).Error; err != nil {
-		return c.Status(404).JSON(fiber.Map{
-			"error": "Task not found",
-		})
+	if err := findUserTask(c, id, &task, false); err != nil {
+		return apierror.NotFound("Task not found")
+	}
+
+	if ifMatchFails(c, task.Version) {
+		return preconditionFailed(c, task.Version, task)
 	}
 
 	previousStatus := task.Status
-	preservedUserID := task.UserID  // Preserve the original user_id
 
-	if err := c.BodyParser(&task); err != nil {
-		println("Update body parser error:", err.Error())
-		return c.Status(400).JSON(fiber.Map{
-			"error": "Invalid request body: " + err.Error(),
+	settings := loadUserSettings(task.UserID)
+	loc := settings.Location()
+	edit, err := parseOccurrenceEdit(c, &task, loc)
+	if err != nil {
+		return err
+	}
+	if edit != nil && edit.index > 0 {
+		return updateOccurrence(c, &task, edit, func(t *models.Task) error {
+			req := newTaskRequest(t)
+			if err := parseBody(c, req); err != nil {
+				return err
+			}
+			req.apply(t)
+			applyTaskTypeDefaults(t)
+			return nil
 		})
 	}
 
-	// Restore the user_id if it was cleared by body parser
-	if task.UserID == 0 {
-		task.UserID = preservedUserID
+	original := task
+	req := newTaskRequest(&task)
+	if err := parseBody(c, req); err != nil {
+		return err
+	}
+	req.apply(&task)
+	if err := checkStartable(c, &task, previousStatus); err != nil {
+		return err
 	}
 
 	applyTaskTypeDefaults(&task)
+	normalizeDueDate(&task)
 	setCompletionTimestamp(&task, previousStatus == "done")
-
-	if err := database.DB.Save(&task).Error; err != nil {
-		println("Update database error:", err.Error())
-		return c.Status(500).JSON(fiber.Map{"error": "Failed to update task: " + err.Error()})
+	if err := checkRecurrence(&task); err != nil {
+		return err
+	}
+	next := splitSeries(&task, &original, edit, previousStatus == "done", loc)
+	cascade := cascadeCompletion(c, &task, previousStatus == "done")
+	moved := !sameTime(task.DueDate, original.DueDate)
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if next != nil {
+			if err := createInstance(tx, next, task.ID); err != nil {
+				return err
+			}
+		}
+		if cascade {
+			if err := completeChecklist(tx, &task); err != nil {
+				return err
+			}
+		}
+		if moved {
+			if err := rescheduleReminders(tx, &task); err != nil {
+				return err
+			}
+		}
+		if err := saveVersioned(tx.Model(&task).Select("*"), &task, &task.Version); err != nil {
+			return err
+		}
+		return database.SyncTags(tx, task.UserID, database.TaggedTasks, task.ID, task.Tags)
+	})
+	if errors.Is(err, errVersionConflict) {
+		return staleTask(c, id)
+	}
+	if err != nil {
+		return apierror.Internal("Failed to update task").WithCause(err)
 	}
 
 	// Broadcast task update to all connected clients
 	if ws.GlobalHub != nil {
 		ws.GlobalHub.BroadcastToUser(task.UserID, ws.MessageTypeTaskUpdate, task)
 	}
+	if cascade {
+		broadcastChecklistItems(task.UserID, task.ID)
+	}
+	if moved {
+		reminders.Wake()
+	}
+	if (previousStatus == "done") != (task.Status == "done") {
+		broadcastTaskUpdates(task.UserID, dependentsOf(task.ID))
+	}
+	broadcastInstance(next)
 
+	setETag(c, task.Version)
 	return c.JSON(task)
 }
 
@@ -129,63 +280,70 @@ func DeleteTask(c *fiber.Ctx) error {
 	id := c.Params("id")
 	var task models.Task
 
-	if err := database.DB.First(&task, id).Error; err != nil {
-		return c.Status(404).JSON(fiber.Map{
-			"error": "Task not found",
-		})
+	if err := findUserTask(c, id, &task, false); err != nil {
+		return apierror.NotFound("Task not found")
+	}
+
+	if ifMatchFails(c, task.Version) {
+		return preconditionFailed(c, task.Version, task)
 	}
 
 	userID := task.UserID
 
-	if err := database.DB.Delete(&task).Error; err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": "Failed to delete task"})
+	settings := loadUserSettings(userID)
+	loc := settings.Location()
+	edit, err := parseOccurrenceEdit(c, &task, loc)
+	if err != nil {
+		return err
+	}
+	if edit != nil && edit.index > 0 {
+		return deleteOccurrence(c, &task, edit)
+	}
+	// Deleting only this occurrence moves the rule on to the next one
+	var next *models.Task
+	if edit != nil && edit.scope == scopeThis {
+		next = nextInstance(&task, loc)
+	}
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := deleteVersioned(tx, &task, task.Version); err != nil {
+			return err
+		}
+		if next != nil {
+			return createInstance(tx, next, task.ID)
+		}
+		return nil
+	})
+	if errors.Is(err, errVersionConflict) {
+		return staleTask(c, id)
+	}
+	if err != nil {
+		return apierror.Internal("Failed to delete task").WithCause(err)
 	}
+	recordChange(c, models.AuditTaskDelete, "task", task.ID, task, nil)
 
 	// Broadcast task deletion to all connected clients
 	if ws.GlobalHub != nil {
 		ws.GlobalHub.BroadcastToUser(userID, ws.MessageTypeTaskDelete, fiber.Map{"id": id})
 	}
+	broadcastTaskUpdates(userID, dependentsOf(task.ID))
+	broadcastInstance(next)
 
 	return c.Status(204).SendString("")
 }
 
-// GetTasksByStatus retrieves tasks filtered by status
-func GetTasksByStatus(c *fiber.Ctx) error {
-	status := c.Query("status")
-	var tasks []models.Task
-
-	query := database.DB.Where("is_archived = ?", false)
-
-	if status != "" {
-		query = query.Where("status = ?", status)
-	}
-
-	query.Find(&tasks)
-
-	return c.JSON(tasks)
-}
-
 // GetTasksByQuadrant retrieves tasks filtered by Eisenhower Matrix quadrant
 func GetTasksByQuadrant(c *fiber.Ctx) error {
 	quadrant := c.Params("quadrant")
-	var tasks []models.Task
-
-	database.DB.Where("quadrant = ? AND is_archived = ?", quadrant, false).Find(&tasks)
-
-	return c.JSON(tasks)
-}
+	if !containsString(models.TaskQuadrants, quadrant) {
+		return apierror.Validation(map[string]string{"quadrant": "must be one of " + strings.Join(models.TaskQuadrants, ", ")})
+	}
 
-func GetArchivedTasks(c *fiber.Ctx) error {
 	var tasks []models.Task
-
-	if err := database.DB.Unscoped().
-		Where("is_archived = ?", true).
-		Or("completed_at IS NOT NULL").
-		Or("deleted_at IS NOT NULL").
-		Order("COALESCE(deleted_at, completed_at, updated_at) DESC").
-		Find(&tasks).Error; err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": "Failed to load archived tasks"})
+	if err := userTasks(c).Where("quadrant = ? AND is_archived = ?", quadrant, false).Find(&tasks).Error; err != nil {
+		return apierror.Internal("Failed to load tasks").WithCause(err)
 	}
+	attachBlockers(tasks)
 
 	return c.JSON(tasks)
 }
@@ -194,14 +352,21 @@ func ArchiveTask(c *fiber.Ctx) error {
 	id := c.Params("id")
 	var task models.Task
 
-	if err := database.DB.First(&task, id).Error; err != nil {
-		return c.Status(404).JSON(fiber.Map{"error": "Task not found"})
+	if err := findUserTask(c, id, &task, false); err != nil {
+		return apierror.NotFound("Task not found")
+	}
+
+	if ifMatchFails(c, task.Version) {
+		return preconditionFailed(c, task.Version, task)
 	}
 
 	task.IsArchived = true
 
-	if err := database.DB.Save(&task).Error; err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": "Failed to archive task"})
+	if err := saveVersioned(database.DB.Model(&task).Select("*"), &task, &task.Version); err != nil {
+		if errors.Is(err, errVersionConflict) {
+			return staleTask(c, id)
+		}
+		return apierror.Internal("Failed to archive task").WithCause(err)
 	}
 
 	// Broadcast task update to all connected clients
@@ -209,6 +374,7 @@ func ArchiveTask(c *fiber.Ctx) error {
 		ws.GlobalHub.BroadcastToUser(task.UserID, ws.MessageTypeTaskUpdate, task)
 	}
 
+	setETag(c, task.Version)
 	return c.JSON(task)
 }
 
@@ -216,11 +382,16 @@ func RestoreTask(c *fiber.Ctx) error {
 	id := c.Params("id")
 	var task models.Task
 
-	if err := database.DB.Unscoped().First(&task, id).Error; err != nil {
-		return c.Status(404).JSON(fiber.Map{"error": "Task not found"})
+	if err := findUserTask(c, id, &task, true); err != nil {
+		return apierror.NotFound("Task not found")
+	}
+
+	if ifMatchFails(c, task.Version) {
+		return preconditionFailed(c, task.Version, task)
 	}
 
 	task.IsArchived = false
+	wasDeleted, wasDone := task.DeletedAt.Valid, task.Status == "done"
 	if task.DeletedAt.Valid {
 		task.DeletedAt = gorm.DeletedAt{}
 	}
@@ -229,15 +400,22 @@ func RestoreTask(c *fiber.Ctx) error {
 		task.Status = "todo"
 	}
 
-	if err := database.DB.Unscoped().Save(&task).Error; err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": "Failed to restore task"})
+	if err := saveVersioned(database.DB.Unscoped().Model(&task).Select("*"), &task, &task.Version); err != nil {
+		if errors.Is(err, errVersionConflict) {
+			return staleTask(c, id)
+		}
+		return apierror.Internal("Failed to restore task").WithCause(err)
 	}
 
 	// Broadcast task update to all connected clients
 	if ws.GlobalHub != nil {
 		ws.GlobalHub.BroadcastToUser(task.UserID, ws.MessageTypeTaskUpdate, task)
 	}
+	if wasDeleted || wasDone != (task.Status == "done") {
+		broadcastTaskUpdates(task.UserID, dependentsOf(task.ID))
+	}
 
+	setETag(c, task.Version)
 	return c.JSON(task)
 }
 
@@ -246,20 +424,45 @@ func PermanentDeleteTask(c *fiber.Ctx) error {
 	var task models.Task
 
 	// Get task before deletion to get user_id
-	if err := database.DB.Unscoped().First(&task, id).Error; err != nil {
-		return c.Status(404).JSON(fiber.Map{"error": "Task not found"})
+	if err := findUserTask(c, id, &task, true); err != nil {
+		return apierror.NotFound("Task not found")
+	}
+
+	if ifMatchFails(c, task.Version) {
+		return preconditionFailed(c, task.Version, task)
 	}
 
 	userID := task.UserID
+	dependents := dependentsOf(task.ID)
 
-	if err := database.DB.Unscoped().Delete(&models.Task{}, id).Error; err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": "Failed to permanently delete task"})
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := deleteVersioned(tx.Unscoped().Where("id = ?", task.ID), &models.Task{}, task.Version); err != nil {
+			return err
+		}
+		if err := tx.Where("task_id = ?", task.ID).Delete(&models.ChecklistItem{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("task_id = ? OR blocker_id = ?", task.ID, task.ID).Delete(&models.TaskDependency{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("task_id = ?", task.ID).Delete(&models.Reminder{}).Error; err != nil {
+			return err
+		}
+		return database.DeleteTagLinks(tx, database.TaggedTasks, []uint{task.ID})
+	})
+	if errors.Is(err, errVersionConflict) {
+		return staleTask(c, id)
+	}
+	if err != nil {
+		return apierror.Internal("Failed to permanently delete task").WithCause(err)
 	}
+	recordChange(c, models.AuditTaskPurge, "task", task.ID, task, nil)
 
 	// Broadcast task deletion to all connected clients
 	if ws.GlobalHub != nil {
 		ws.GlobalHub.BroadcastToUser(userID, ws.MessageTypeTaskDelete, fiber.Map{"id": id})
 	}
+	broadcastTaskUpdates(userID, dependents)
 
 	return c.Status(204).SendString("")
 }
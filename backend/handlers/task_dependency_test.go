@@ -0,0 +1,101 @@
+package handlers_test
+
+import (
+	"fmt"
+	"testing"
+
+	"tonish/backend/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// conflictResponse is the body of a 409.
+type conflictResponse struct {
+	Code     string               `json:"code"`
+	Blockers []models.TaskBlocker `json:"blockers"`
+}
+
+// link makes task wait for blocker and returns the response status.
+func link(t *testing.T, app *fiber.App, token string, task, blocker uint, out interface{}) int {
+	t.Helper()
+	return doJSON(t, app, "POST", fmt.Sprintf("/api/tasks/%d/blockers", task), token, map[string]uint{"blocker_id": blocker}, out)
+}
+
+func TestDependencyCycleRejected(t *testing.T) {
+	app := newTestApp(t)
+	_, token := createUser(t, app, "alice@example.com")
+	a := createTask(t, app, token, map[string]interface{}{"title": "Paint"})
+	b := createTask(t, app, token, map[string]interface{}{"title": "Sand"})
+	c := createTask(t, app, token, map[string]interface{}{"title": "Buy sandpaper"})
+
+	// a waits for b, which waits for c
+	if status := link(t, app, token, a.ID, b.ID, nil); status != 200 {
+		t.Fatalf("link a to b: status %d", status)
+	}
+	if status := link(t, app, token, b.ID, c.ID, nil); status != 200 {
+		t.Fatalf("link b to c: status %d", status)
+	}
+
+	// Closing the loop directly or through the chain is refused
+	for _, pair := range [][2]models.Task{{b, a}, {c, a}, {c, b}} {
+		var resp conflictResponse
+		if status := link(t, app, token, pair[0].ID, pair[1].ID, &resp); status != 409 || resp.Code != "dependency_cycle" {
+			t.Errorf("%s waiting for %s: status %d, code %q, want 409 dependency_cycle", pair[0].Title, pair[1].Title, status, resp.Code)
+		}
+	}
+	var resp validationError
+	if status := link(t, app, token, a.ID, a.ID, &resp); status != 422 || resp.Errors["blocker_id"] == "" {
+		t.Fatalf("self link: status %d, errors %v", status, resp.Errors)
+	}
+
+	// A shortcut along the chain is not a cycle, and linking twice is a no-op
+	var linked models.Task
+	if status := link(t, app, token, a.ID, c.ID, &linked); status != 200 {
+		t.Fatalf("link a to c: status %d", status)
+	}
+	if status := link(t, app, token, a.ID, c.ID, &linked); status != 200 || len(linked.Blockers) != 2 {
+		t.Fatalf("link a to c again: status %d, blockers %+v", status, linked.Blockers)
+	}
+}
+
+func TestBlockedTaskStart(t *testing.T) {
+	app := newTestApp(t)
+	_, token := createUser(t, app, "alice@example.com")
+	paint := createTask(t, app, token, map[string]interface{}{"title": "Paint"})
+	sand := createTask(t, app, token, map[string]interface{}{"title": "Sand"})
+	link(t, app, token, paint.ID, sand.ID, nil)
+	path := fmt.Sprintf("/api/tasks/%d", paint.ID)
+
+	var resp conflictResponse
+	if status := doJSON(t, app, "PATCH", path, token, map[string]string{"status": "in-progress"}, &resp); status != 409 || resp.Code != "task_blocked" {
+		t.Fatalf("start a blocked task: status %d, code %q, want 409 task_blocked", status, resp.Code)
+	}
+	if len(resp.Blockers) != 1 || resp.Blockers[0].ID != sand.ID || resp.Blockers[0].Status != "todo" {
+		t.Fatalf("409 lists blockers %+v, want the sanding task", resp.Blockers)
+	}
+	var stored models.Task
+	doJSON(t, app, "GET", path, token, nil, &stored)
+	if stored.Status != "todo" || !stored.Blocked {
+		t.Fatalf("after the refused start: status %q, blocked %v", stored.Status, stored.Blocked)
+	}
+
+	// Other edits of a blocked task go through
+	if status := doJSON(t, app, "PATCH", path, token, map[string]string{"title": "Paint the fence"}, nil); status != 200 {
+		t.Fatalf("rename a blocked task: status %d", status)
+	}
+
+	var forced models.Task
+	if status := doJSON(t, app, "PATCH", path+"?force=true", token, map[string]string{"status": "in-progress"}, &forced); status != 200 {
+		t.Fatalf("forced start: status %d", status)
+	}
+	if forced.Status != "in-progress" || !forced.Blocked {
+		t.Fatalf("after the forced start: status %q, blocked %v", forced.Status, forced.Blocked)
+	}
+
+	// Once the blocker is done the task starts without force
+	doJSON(t, app, "PATCH", path, token, map[string]string{"status": "todo"}, nil)
+	doJSON(t, app, "PATCH", fmt.Sprintf("/api/tasks/%d", sand.ID), token, map[string]string{"status": "done"}, nil)
+	if status := doJSON(t, app, "PATCH", path, token, map[string]string{"status": "in-progress"}, nil); status != 200 {
+		t.Fatalf("start after the blocker is done: status %d", status)
+	}
+}
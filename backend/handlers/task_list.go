@@ -0,0 +1,380 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"tonish/backend/apierror"
+	"tonish/backend/database"
+	"tonish/backend/models"
+	"tonish/backend/taskquery"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// noDueDate sorts tasks without a due date after every real date.
+const noDueDate = "9999-12-31"
+
+// taskSort is one order GET /tasks can return. Ties are broken by id, so the
+// order is stable and a cursor can resume after any row.
+type taskSort struct {
+	column string                                         // SQL expression to order by
+	value  func(t *models.Task) interface{}               // the same value from a loaded task
+	decode func(raw json.RawMessage) (interface{}, error) // a cursor value back into a query argument
+}
+
+var taskSorts = map[string]taskSort{
+	"created_at": {"tasks.created_at", func(t *models.Task) interface{} { return t.CreatedAt }, decodeTimeValue},
+	"updated_at": {"tasks.updated_at", func(t *models.Task) interface{} { return t.UpdatedAt }, decodeTimeValue},
+	"due_date": {"COALESCE(tasks.due_date, '" + noDueDate + "')", func(t *models.Task) interface{} {
+		return t.DueDate
+	}, decodeTimeValue},
+	"priority": {"CASE tasks.priority WHEN 'low' THEN 1 WHEN 'medium' THEN 2 WHEN 'high' THEN 3 ELSE 0 END", func(t *models.Task) interface{} {
+		return priorityRank(t.Priority)
+	}, decodeIntValue},
+	"title": {"tasks.title", func(t *models.Task) interface{} { return t.Title }, decodeStringValue},
+	// When a task left the board: deleted, completed, or last archived
+	"archived_at": {"COALESCE(tasks.deleted_at, tasks.completed_at, tasks.updated_at)", func(t *models.Task) interface{} {
+		if t.DeletedAt.Valid {
+			return t.DeletedAt.Time
+		}
+		if t.CompletedAt != nil {
+			return *t.CompletedAt
+		}
+		return t.UpdatedAt
+	}, decodeTimeValue},
+}
+
+func priorityRank(priority string) int {
+	switch priority {
+	case "low":
+		return 1
+	case "medium":
+		return 2
+	case "high":
+		return 3
+	}
+	return 0
+}
+
+// decodeTimeValue reads a cursor timestamp. null stands for a missing due
+// date.
+func decodeTimeValue(raw json.RawMessage) (interface{}, error) {
+	var t *time.Time
+	if err := json.Unmarshal(raw, &t); err != nil {
+		return nil, err
+	}
+	if t == nil {
+		return noDueDate, nil
+	}
+	return t.UTC(), nil
+}
+
+func decodeIntValue(raw json.RawMessage) (interface{}, error) {
+	var v int
+	err := json.Unmarshal(raw, &v)
+	return v, err
+}
+
+func decodeStringValue(raw json.RawMessage) (interface{}, error) {
+	var v string
+	err := json.Unmarshal(raw, &v)
+	return v, err
+}
+
+// errCursorSort rejects a cursor from a listing with another sort.
+var errCursorSort = errors.New("cursor was issued for another sort")
+
+// taskCursor marks the last task of a page. It is opaque to clients.
+type taskCursor struct {
+	Sort  string          `json:"s"`
+	Value json.RawMessage `json:"v"`
+	ID    uint            `json:"id"`
+}
+
+func encodeTaskCursor(sort string, task *models.Task) (string, error) {
+	value, err := json.Marshal(taskSorts[strings.TrimPrefix(sort, "-")].value(task))
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(taskCursor{Sort: sort, Value: value, ID: task.ID})
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func decodeTaskCursor(value, sort string) (*taskCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	var cursor taskCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, err
+	}
+	if cursor.Sort != sort {
+		return nil, errCursorSort
+	}
+	return &cursor, nil
+}
+
+// queryList splits a comma-separated query parameter.
+func queryList(c *fiber.Ctx, name string) []string {
+	var values []string
+	for _, value := range strings.Split(c.Query(name), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// parseDueBound accepts an RFC 3339 time or a YYYY-MM-DD date in the user's
+// timezone. A date as upper bound includes that whole day.
+func parseDueBound(value string, loc *time.Location, upper bool) (time.Time, bool) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.UTC(), true
+	}
+	day, err := time.ParseInLocation(dateLayout, value, loc)
+	if err != nil {
+		return time.Time{}, false
+	}
+	if upper {
+		day = day.AddDate(0, 0, 1)
+	}
+	return day.UTC(), true
+}
+
+// filterTasks applies the filter parameters of GET /tasks to query and
+// collects the invalid ones in errs. Dates are days in loc.
+func filterTasks(c *fiber.Ctx, query *gorm.DB, loc *time.Location, errs map[string]string) *gorm.DB {
+	enums := []struct {
+		param, column string
+		allowed       []string
+	}{
+		{"status", "tasks.status", models.TaskStatuses},
+		{"priority", "tasks.priority", models.TaskPriorities},
+		{"quadrant", "tasks.quadrant", models.TaskQuadrants},
+		{"task_type", "tasks.task_type", models.TaskTypes},
+		{"calendar_subtype", "tasks.calendar_subtype", models.TaskCalendarSubtypes},
+	}
+	for _, enum := range enums {
+		values := queryList(c, enum.param)
+		if len(values) == 0 {
+			continue
+		}
+		for _, value := range values {
+			if !containsString(enum.allowed, value) {
+				errs[enum.param] = "must be one or more of " + strings.Join(enum.allowed, ", ")
+			}
+		}
+		query = query.Where(enum.column+" IN ?", values)
+	}
+
+	// Every listed tag must be present
+	for _, tag := range queryList(c, "tags") {
+		query = query.Where(taskquery.TagCondition(tag))
+	}
+
+	if from := c.Query("due_from"); from != "" {
+		if t, ok := parseDueBound(from, loc, false); ok {
+			query = query.Where("tasks.due_date >= ?", t)
+		} else {
+			errs["due_from"] = "must be an RFC 3339 time or YYYY-MM-DD"
+		}
+	}
+	if to := c.Query("due_to"); to != "" {
+		if t, ok := parseDueBound(to, loc, true); ok {
+			query = query.Where("tasks.due_date < ?", t)
+		} else {
+			errs["due_to"] = "must be an RFC 3339 time or YYYY-MM-DD"
+		}
+	}
+
+	for _, flag := range []string{"is_payment", "is_paid"} {
+		switch c.Query(flag) {
+		case "":
+		case "true":
+			query = query.Where("tasks."+flag+" = ?", true)
+		case "false":
+			query = query.Where("tasks."+flag+" = ?", false)
+		default:
+			errs[flag] = "must be true or false"
+		}
+	}
+	return query
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// appendMissing appends the values not yet in values.
+func appendMissing(values []string, more ...string) []string {
+	for _, v := range more {
+		if !containsString(values, v) {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// ListTasks lists the current user's tasks a page at a time. It accepts the
+// filters status, priority, quadrant, task_type and calendar_subtype (each a
+// comma-separated list), tags (all must match), due_from / due_to, is_payment
+// and is_paid; q, a search in the taskquery language; sort (a taskSorts key,
+// "-" prefix for descending); limit and the cursor from the previous page.
+// archived=true lists LookBack instead: archived, completed and deleted tasks.
+func ListTasks(c *fiber.Ctx) error {
+	archived := c.Query("archived")
+	if archived != "" && archived != "true" && archived != "false" {
+		return apierror.Validation(map[string]string{"archived": "must be true or false"})
+	}
+	return listTasks(c, archived == "true")
+}
+
+// GetArchivedTasks lists the LookBack tasks; it is ListTasks with
+// archived=true.
+func GetArchivedTasks(c *fiber.Ctx) error {
+	return listTasks(c, true)
+}
+
+// taskSortError is the validation message for an unknown sort.
+const taskSortError = "must be one of created_at, updated_at, due_date, priority, title, archived_at, optionally prefixed with -"
+
+func validTaskSort(sort string) bool {
+	_, ok := taskSorts[strings.TrimPrefix(sort, "-")]
+	return ok
+}
+
+// invalidSearch answers a q that does not parse, pointing at the offending
+// token.
+func invalidSearch(parseErr *taskquery.ParseError) error {
+	return apierror.New(fiber.StatusBadRequest, "invalid_query", parseErr.Msg).
+		With("position", parseErr.Pos).
+		With("token", parseErr.Token)
+}
+
+// taskListing is what a listing of tasks shows: the board or LookBack,
+// narrowed by a taskquery search and in a taskSorts order ("" for the
+// default).
+type taskListing struct {
+	archived bool
+	search   string
+	sort     string
+}
+
+func listTasks(c *fiber.Ctx, archived bool) error {
+	query, sort, err := taskListing{archived, c.Query("q"), c.Query("sort")}.query(c)
+	if err != nil {
+		return err
+	}
+	page, err := taskPage(c, query, sort)
+	if err != nil {
+		return err
+	}
+	return c.JSON(page)
+}
+
+// query selects the listed tasks, further narrowed by the filter parameters
+// of the request, and returns it with the sort to page it by.
+func (l taskListing) query(c *fiber.Ctx) (*gorm.DB, string, error) {
+	query := userTasks(c).Model(&models.Task{})
+	sort := "created_at"
+	if l.archived {
+		query = query.Unscoped().Where(database.DB.
+			Where("tasks.is_archived = ?", true).
+			Or("tasks.completed_at IS NOT NULL").
+			Or("tasks.deleted_at IS NOT NULL"))
+		sort = "-archived_at"
+	} else {
+		query = query.Where("tasks.is_archived = ?", false)
+	}
+
+	settings := loadUserSettings(currentUserID(c))
+	loc := settings.Location()
+	if l.search != "" {
+		parsed, err := taskquery.Parse(l.search)
+		var parseErr *taskquery.ParseError
+		if errors.As(err, &parseErr) {
+			return nil, "", invalidSearch(parseErr)
+		}
+		query = parsed.Apply(query, time.Now(), loc)
+	}
+
+	errs := map[string]string{}
+	query = filterTasks(c, query, loc, errs)
+
+	if l.sort != "" {
+		sort = l.sort
+	}
+	if !validTaskSort(sort) {
+		errs["sort"] = taskSortError
+	}
+	if len(errs) > 0 {
+		return nil, "", apierror.Validation(errs)
+	}
+	return query, sort, nil
+}
+
+// taskPage loads the page of query the request's limit and cursor ask for.
+func taskPage(c *fiber.Ctx, query *gorm.DB, sort string) (fiber.Map, error) {
+	var total int64
+	if err := query.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		return nil, apierror.Internal("Failed to load tasks").WithCause(err)
+	}
+
+	order := taskSorts[strings.TrimPrefix(sort, "-")]
+	direction, compare := "ASC", ">"
+	if strings.HasPrefix(sort, "-") {
+		direction, compare = "DESC", "<"
+	}
+
+	if value := c.Query("cursor"); value != "" {
+		cursor, err := decodeTaskCursor(value, sort)
+		if err != nil {
+			return nil, apierror.BadRequest("cursor is invalid or was issued for another sort")
+		}
+		after, err := order.decode(cursor.Value)
+		if err != nil {
+			return nil, apierror.BadRequest("cursor is invalid or was issued for another sort")
+		}
+		query = query.Where("("+order.column+" "+compare+" ? OR ("+order.column+" = ? AND tasks.id "+compare+" ?))",
+			after, after, cursor.ID)
+	}
+
+	limit, _ := pageParams(c)
+	tasks := []models.Task{}
+	if err := query.Order(order.column + " " + direction + ", tasks.id " + direction).
+		Limit(limit + 1).Find(&tasks).Error; err != nil {
+		return nil, apierror.Internal("Failed to load tasks").WithCause(err)
+	}
+
+	// One row more than asked for means there is another page
+	var next *string
+	if len(tasks) > limit {
+		tasks = tasks[:limit]
+		cursor, err := encodeTaskCursor(sort, &tasks[limit-1])
+		if err != nil {
+			return nil, apierror.Internal("Failed to load tasks").WithCause(err)
+		}
+		next = &cursor
+	}
+	attachBlockers(tasks)
+
+	return fiber.Map{
+		"tasks":       tasks,
+		"next_cursor": next,
+		"total":       total,
+	}, nil
+}
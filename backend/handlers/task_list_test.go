@@ -0,0 +1,138 @@
+package handlers_test
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"testing"
+	"time"
+
+	"tonish/backend/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// listAll pages through GET /api/tasks with query and returns the ids in
+// the order they arrived.
+func listAll(t *testing.T, app *fiber.App, token, query string) []uint {
+	t.Helper()
+	var ids []uint
+	cursor := ""
+	for pages := 0; ; pages++ {
+		if pages > 20 {
+			t.Fatalf("%s: paging does not end", query)
+		}
+		path := "/api/tasks?limit=2&" + query
+		if cursor != "" {
+			path += "&cursor=" + url.QueryEscape(cursor)
+		}
+		var page taskPage
+		if status := doJSON(t, app, "GET", path, token, nil, &page); status != 200 {
+			t.Fatalf("GET %s: status %d", path, status)
+		}
+		for _, task := range page.Tasks {
+			ids = append(ids, task.ID)
+		}
+		if page.NextCursor == nil {
+			return ids
+		}
+		cursor = *page.NextCursor
+	}
+}
+
+func TestTaskCursorPagingWithTies(t *testing.T) {
+	app := newTestApp(t)
+	_, token := createUser(t, app, "alice@example.com")
+
+	due := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	// Most tasks share a priority, a due date or no due date at all, so
+	// every page boundary falls inside a run of equal sort keys
+	specs := []map[string]interface{}{
+		{"title": "a", "priority": "high", "due_date": due},
+		{"title": "b", "priority": "medium", "due_date": due},
+		{"title": "c", "priority": "medium"},
+		{"title": "d", "priority": "medium", "due_date": due},
+		{"title": "e", "priority": "low"},
+		{"title": "f", "priority": "medium"},
+		{"title": "g", "priority": "high", "due_date": due.Add(time.Hour)},
+	}
+	for _, spec := range specs {
+		createTask(t, app, token, spec)
+	}
+
+	for _, sort := range []string{"priority", "-priority", "due_date", "-due_date", "created_at"} {
+		var whole taskPage
+		doJSON(t, app, "GET", "/api/tasks?limit=200&sort="+sort, token, nil, &whole)
+		if whole.Total != int64(len(specs)) || len(whole.Tasks) != len(specs) {
+			t.Fatalf("sort %s: %d of %d tasks", sort, len(whole.Tasks), whole.Total)
+		}
+
+		paged := listAll(t, app, token, "sort="+sort)
+		if len(paged) != len(whole.Tasks) {
+			t.Fatalf("sort %s: paging returned %v", sort, paged)
+		}
+		for i, task := range whole.Tasks {
+			if paged[i] != task.ID {
+				t.Fatalf("sort %s: paging returned %v, one page has them in another order", sort, paged)
+			}
+		}
+	}
+}
+
+func TestTaskCursorRejectsTampering(t *testing.T) {
+	app := newTestApp(t)
+	_, token := createUser(t, app, "alice@example.com")
+	for _, title := range []string{"a", "b", "c"} {
+		createTask(t, app, token, map[string]interface{}{"title": title})
+	}
+
+	var first taskPage
+	doJSON(t, app, "GET", "/api/tasks?limit=1&sort=priority", token, nil, &first)
+	if first.NextCursor == nil {
+		t.Fatal("no next cursor")
+	}
+
+	encode := func(raw string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(raw))
+	}
+	bad := []struct {
+		name, sort, cursor string
+	}{
+		{"not base64", "priority", "%%%"},
+		{"not JSON", "priority", encode("priority:1")},
+		{"another sort", "title", *first.NextCursor},
+		{"value of the wrong type", "priority", encode(`{"s":"priority","v":"high","id":1}`)},
+		{"malformed time", "due_date", encode(`{"s":"due_date","v":"yesterday","id":1}`)},
+	}
+	for _, b := range bad {
+		path := fmt.Sprintf("/api/tasks?limit=1&sort=%s&cursor=%s", b.sort, url.QueryEscape(b.cursor))
+		if status := doJSON(t, app, "GET", path, token, nil, nil); status != 400 {
+			t.Errorf("%s: status %d, want 400", b.name, status)
+		}
+	}
+
+	if status := doJSON(t, app, "GET", "/api/tasks?limit=1&sort=priority&cursor="+url.QueryEscape(*first.NextCursor), token, nil, nil); status != 200 {
+		t.Fatalf("untouched cursor: status %d", status)
+	}
+}
+
+func TestTasksByQuadrant(t *testing.T) {
+	app := newTestApp(t)
+	_, token := createUser(t, app, "alice@example.com")
+	urgent := createTask(t, app, token, map[string]interface{}{"title": "Pay rent", "quadrant": "urgent-important"})
+	createTask(t, app, token, map[string]interface{}{"title": "Plan holiday", "quadrant": "not-urgent-important"})
+	createTask(t, app, token, map[string]interface{}{"title": "Water plants"})
+
+	var tasks []models.Task
+	if status := doJSON(t, app, "GET", "/api/tasks/quadrant/urgent-important", token, nil, &tasks); status != 200 {
+		t.Fatalf("quadrant: status %d", status)
+	}
+	if len(tasks) != 1 || tasks[0].ID != urgent.ID {
+		t.Fatalf("urgent-important: %+v", tasks)
+	}
+
+	var resp validationError
+	if status := doJSON(t, app, "GET", "/api/tasks/quadrant/urgent", token, nil, &resp); status != 422 || resp.Errors["quadrant"] == "" {
+		t.Fatalf("unknown quadrant: status %d, errors %v", status, resp.Errors)
+	}
+}
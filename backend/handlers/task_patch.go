@@ -0,0 +1,304 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"tonish/backend/apierror"
+	"tonish/backend/database"
+	"tonish/backend/models"
+	ws "tonish/backend/websocket"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// taskPatchField applies one JSON field of a PATCH body to a task and
+// returns an error message when the value is invalid.
+type taskPatchField struct {
+	column string
+	apply  func(task *models.Task, raw json.RawMessage) string
+}
+
+// taskReadOnlyFields are set by the server and can never be patched.
+var taskReadOnlyFields = map[string]string{
+	"id":           "is read-only",
+	"user_id":      "is read-only",
+	"created_at":   "is read-only",
+	"updated_at":   "is read-only",
+	"deleted_at":   "is read-only",
+	"completed_at": "is set from status",
+	"is_archived":  "use the archive and restore endpoints",
+	"version":      "is read-only; send If-Match instead",
+	"progress":     "is derived from the checklist",
+	"blocked":      "is derived from the blockers",
+	"blockers":     "use the blockers endpoints",
+	"series_id":    "is read-only",
+}
+
+var taskPatchFields = map[string]taskPatchField{
+	"title": {"title", stringPatch(func(t *models.Task) *string { return &t.Title }, func(v string) string {
+		if strings.TrimSpace(v) == "" {
+			return "must not be empty"
+		}
+		return ""
+	})},
+	"description":      {"description", stringPatch(func(t *models.Task) *string { return &t.Description }, nil)},
+	"tags":             {"tags", stringPatch(func(t *models.Task) *string { return &t.Tags }, nil)},
+	"payment_notes":    {"payment_notes", stringPatch(func(t *models.Task) *string { return &t.PaymentNotes }, nil)},
+	"priority":         {"priority", enumPatch(func(t *models.Task) *string { return &t.Priority }, models.TaskPriorities, false)},
+	"status":           {"status", enumPatch(func(t *models.Task) *string { return &t.Status }, models.TaskStatuses, false)},
+	"quadrant":         {"quadrant", enumPatch(func(t *models.Task) *string { return &t.Quadrant }, models.TaskQuadrants, true)},
+	"task_type":        {"task_type", enumPatch(func(t *models.Task) *string { return &t.TaskType }, models.TaskTypes, false)},
+	"calendar_subtype": {"calendar_subtype", enumPatch(func(t *models.Task) *string { return &t.CalendarSubtype }, models.TaskCalendarSubtypes, false)},
+	"currency": {"currency", stringPatch(func(t *models.Task) *string { return &t.Currency }, func(v string) string {
+		if v == "" {
+			return "must be a three-letter ISO 4217 code"
+		}
+		return ""
+	})},
+	"recurrence":            {"recurrence", stringPatch(func(t *models.Task) *string { return &t.Recurrence }, nil)},
+	"recurrence_exceptions": {"recurrence_exceptions", stringPatch(func(t *models.Task) *string { return &t.RecurrenceExceptions }, nil)},
+	"due_date":              {"due_date", timePatch(func(t *models.Task) **time.Time { return &t.DueDate })},
+	"paid_at":               {"paid_at", timePatch(func(t *models.Task) **time.Time { return &t.PaidAt })},
+	"is_quick_task":         {"is_quick_task", boolPatch(func(t *models.Task) *bool { return &t.IsQuickTask })},
+	"is_payment":            {"is_payment", boolPatch(func(t *models.Task) *bool { return &t.IsPayment })},
+	"is_paid":               {"is_paid", boolPatch(func(t *models.Task) *bool { return &t.IsPaid })},
+	"amount": {"amount", func(t *models.Task, raw json.RawMessage) string {
+		var v float64
+		if err := json.Unmarshal(raw, &v); err != nil || string(raw) == "null" {
+			return "must be a number"
+		}
+		if v < 0 {
+			return "must not be negative"
+		}
+		t.Amount = v
+		return ""
+	}},
+}
+
+func stringPatch(field func(*models.Task) *string, validate func(string) string) func(*models.Task, json.RawMessage) string {
+	return func(t *models.Task, raw json.RawMessage) string {
+		var v string
+		if err := json.Unmarshal(raw, &v); err != nil || string(raw) == "null" {
+			return "must be a string"
+		}
+		if validate != nil {
+			if msg := validate(v); msg != "" {
+				return msg
+			}
+		}
+		*field(t) = v
+		return ""
+	}
+}
+
+// enumPatch accepts one of allowed, or "" when the field may be cleared.
+func enumPatch(field func(*models.Task) *string, allowed []string, clearable bool) func(*models.Task, json.RawMessage) string {
+	return stringPatch(field, func(v string) string {
+		if v == "" && clearable {
+			return ""
+		}
+		for _, a := range allowed {
+			if v == a {
+				return ""
+			}
+		}
+		return "must be one of " + strings.Join(allowed, ", ")
+	})
+}
+
+func boolPatch(field func(*models.Task) *bool) func(*models.Task, json.RawMessage) string {
+	return func(t *models.Task, raw json.RawMessage) string {
+		var v bool
+		if err := json.Unmarshal(raw, &v); err != nil || string(raw) == "null" {
+			return "must be true or false"
+		}
+		*field(t) = v
+		return ""
+	}
+}
+
+// timePatch accepts an RFC 3339 timestamp, or null to clear the field.
+func timePatch(field func(*models.Task) **time.Time) func(*models.Task, json.RawMessage) string {
+	return func(t *models.Task, raw json.RawMessage) string {
+		var v *time.Time
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return "must be an RFC 3339 timestamp or null"
+		}
+		*field(t) = v
+		return ""
+	}
+}
+
+// applyTaskPatch applies the fields of body to task. It returns the columns
+// that changed, or a map of field errors when any field is unknown,
+// read-only or invalid.
+func applyTaskPatch(task *models.Task, body map[string]json.RawMessage) ([]string, map[string]string) {
+	errs := map[string]string{}
+	var columns []string
+	_, quadrantPatched := body["quadrant"]
+	_, typePatched := body["task_type"]
+
+	for name, raw := range body {
+		if msg, ok := taskReadOnlyFields[name]; ok {
+			errs[name] = msg
+			continue
+		}
+		field, ok := taskPatchFields[name]
+		if !ok {
+			errs[name] = "is not a task field"
+			continue
+		}
+		if msg := field.apply(task, raw); msg != "" {
+			errs[name] = msg
+			continue
+		}
+		columns = append(columns, field.column)
+	}
+
+	// Patched fields obey the same rules as a full TaskRequest; fields the
+	// body leaves alone are not checked again
+	req := newTaskRequest(task)
+	for name, msg := range validateStruct(req) {
+		if _, patched := body[name]; patched && errs[name] == "" {
+			errs[name] = msg
+		}
+	}
+	if typePatched && errs["task_type"] == "" && task.TaskType != "matrix" && task.Quadrant != "" {
+		errs["task_type"] = "must be matrix while the task has a quadrant"
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	req.apply(task)
+
+	// A quadrant makes a task a matrix task, as on create, and clearing it
+	// moves the task back to the board
+	if quadrantPatched && !typePatched {
+		if task.Quadrant == "" && task.TaskType == "matrix" {
+			task.TaskType = "kanban"
+		}
+		applyTaskTypeDefaults(task)
+		columns = append(columns, "task_type")
+	}
+	return columns, nil
+}
+
+// PatchTask applies a partial update: only fields present in the body are
+// changed. Unknown, read-only and invalid fields are rejected together with
+// a 422 and a map of field errors.
+func PatchTask(c *fiber.Ctx) error {
+	id := c.Params("id")
+	var task models.Task
+
+	if err := findUserTask(c, id, &task, false); err != nil {
+		return apierror.NotFound("Task not found")
+	}
+
+	var body map[string]json.RawMessage
+	if err := json.Unmarshal(c.Body(), &body); err != nil || body == nil {
+		return apierror.InvalidBody()
+	}
+
+	if ifMatchFails(c, task.Version) {
+		return preconditionFailed(c, task.Version, task)
+	}
+
+	previousStatus := task.Status
+	previouslyCompleted := previousStatus == "done"
+
+	settings := loadUserSettings(task.UserID)
+	loc := settings.Location()
+	edit, err := parseOccurrenceEdit(c, &task, loc)
+	if err != nil {
+		return err
+	}
+	if edit != nil && edit.index > 0 {
+		return updateOccurrence(c, &task, edit, func(t *models.Task) error {
+			if _, errs := applyTaskPatch(t, body); errs != nil {
+				return apierror.Validation(errs)
+			}
+			return nil
+		})
+	}
+
+	original := task
+	columns, errs := applyTaskPatch(&task, body)
+	if errs != nil {
+		return apierror.Validation(errs)
+	}
+	if err := checkStartable(c, &task, previousStatus); err != nil {
+		return err
+	}
+	if len(columns) == 0 {
+		setETag(c, task.Version)
+		return c.JSON(task)
+	}
+
+	normalizeDueDate(&task)
+	setCompletionTimestamp(&task, previouslyCompleted)
+	columns = append(columns, "completed_at", "version")
+	if err := checkRecurrence(&task); err != nil {
+		return err
+	}
+	next := splitSeries(&task, &original, edit, previouslyCompleted, loc)
+	if next != nil || task.Recurrence != original.Recurrence || task.RecurrenceExceptions != original.RecurrenceExceptions {
+		columns = appendMissing(columns, "recurrence", "recurrence_exceptions", "series_id")
+	}
+	cascade := cascadeCompletion(c, &task, previouslyCompleted)
+	if cascade {
+		columns = append(columns, "checklist_done")
+	}
+	moved := !sameTime(task.DueDate, original.DueDate)
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if next != nil {
+			if err := createInstance(tx, next, task.ID); err != nil {
+				return err
+			}
+		}
+		if cascade {
+			if err := completeChecklist(tx, &task); err != nil {
+				return err
+			}
+		}
+		if moved {
+			if err := rescheduleReminders(tx, &task); err != nil {
+				return err
+			}
+		}
+		if err := saveVersioned(tx.Model(&task).Select(columns), &task, &task.Version); err != nil {
+			return err
+		}
+		if containsString(columns, "tags") {
+			return database.SyncTags(tx, task.UserID, database.TaggedTasks, task.ID, task.Tags)
+		}
+		return nil
+	})
+	if errors.Is(err, errVersionConflict) {
+		return staleTask(c, id)
+	}
+	if err != nil {
+		return apierror.Internal("Failed to update task").WithCause(err)
+	}
+
+	if ws.GlobalHub != nil {
+		ws.GlobalHub.BroadcastToUser(task.UserID, ws.MessageTypeTaskUpdate, task)
+	}
+	if cascade {
+		broadcastChecklistItems(task.UserID, task.ID)
+	}
+	if moved {
+		reminders.Wake()
+	}
+	if previouslyCompleted != (task.Status == "done") {
+		broadcastTaskUpdates(task.UserID, dependentsOf(task.ID))
+	}
+	broadcastInstance(next)
+
+	setETag(c, task.Version)
+	return c.JSON(task)
+}
@@ -0,0 +1,152 @@
+package handlers_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"tonish/backend/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// validationError is the body of a 422 response.
+type validationError struct {
+	Code   string            `json:"code"`
+	Errors map[string]string `json:"errors"`
+}
+
+func createTask(t *testing.T, app *fiber.App, token string, body map[string]interface{}) models.Task {
+	t.Helper()
+	var task models.Task
+	if status := doJSON(t, app, "POST", "/api/tasks", token, body, &task); status != 201 {
+		t.Fatalf("create task: status %d", status)
+	}
+	return task
+}
+
+func TestPatchTaskRejectsReadOnlyFields(t *testing.T) {
+	app := newTestApp(t)
+	_, token := createUser(t, app, "alice@example.com")
+	task := createTask(t, app, token, map[string]interface{}{"title": "Water plants"})
+
+	var resp validationError
+	if status := doJSON(t, app, "PATCH", fmt.Sprintf("/api/tasks/%d", task.ID), token, map[string]interface{}{
+		"id":      999,
+		"user_id": 2,
+		"version": 7,
+		"title":   "Water all plants",
+	}, &resp); status != 422 {
+		t.Fatalf("patch read-only fields: status %d, want 422", status)
+	}
+	for _, field := range []string{"id", "user_id", "version"} {
+		if resp.Errors[field] == "" {
+			t.Errorf("no error for read-only %s: %v", field, resp.Errors)
+		}
+	}
+	if _, ok := resp.Errors["title"]; ok {
+		t.Errorf("valid title reported: %v", resp.Errors)
+	}
+
+	// Nothing is applied when any field is rejected
+	var stored models.Task
+	doJSON(t, app, "GET", fmt.Sprintf("/api/tasks/%d", task.ID), token, nil, &stored)
+	if stored.Title != "Water plants" || stored.Version != task.Version {
+		t.Fatalf("task changed by a rejected patch: %+v", stored)
+	}
+}
+
+func TestPatchTaskFieldErrors(t *testing.T) {
+	app := newTestApp(t)
+	_, token := createUser(t, app, "alice@example.com")
+	task := createTask(t, app, token, map[string]interface{}{"title": "Water plants"})
+
+	var resp validationError
+	if status := doJSON(t, app, "PATCH", fmt.Sprintf("/api/tasks/%d", task.ID), token, map[string]interface{}{
+		"title":         strings.Repeat("a", 501),
+		"description":   strings.Repeat("a", 50001),
+		"tags":          strings.Repeat("a", 2001),
+		"payment_notes": strings.Repeat("a", 5001),
+		"priority":      "urgent",
+		"currency":      "dollars",
+		"amount":        -1,
+		"recurrence":    "FREQ=SOMETIMES",
+		"is_paid":       "yes",
+		"colour":        "red",
+	}, &resp); status != 422 {
+		t.Fatalf("invalid patch: status %d, want 422", status)
+	}
+	if resp.Code != "validation_failed" {
+		t.Fatalf("code %q, want validation_failed", resp.Code)
+	}
+	want := map[string]string{
+		"title":         "must be at most 500 characters",
+		"description":   "must be at most 50000 characters",
+		"tags":          "must be at most 2000 characters",
+		"payment_notes": "must be at most 5000 characters",
+		"priority":      "must be one of low, medium, high",
+		"currency":      "must be a three-letter ISO 4217 code",
+		"amount":        "must not be negative",
+		"is_paid":       "must be true or false",
+		"colour":        "is not a task field",
+	}
+	for field, msg := range want {
+		if resp.Errors[field] != msg {
+			t.Errorf("%s: got %q, want %q", field, resp.Errors[field], msg)
+		}
+	}
+	if resp.Errors["recurrence"] == "" {
+		t.Errorf("no error for an invalid recurrence: %v", resp.Errors)
+	}
+	if len(resp.Errors) != len(want)+1 {
+		t.Errorf("unexpected errors: %v", resp.Errors)
+	}
+}
+
+func TestPatchTaskPartialUpdate(t *testing.T) {
+	app := newTestApp(t)
+	_, token := createUser(t, app, "alice@example.com")
+	task := createTask(t, app, token, map[string]interface{}{
+		"title":       "Pay rent",
+		"description": "Transfer to landlord",
+		"priority":    "high",
+		"quadrant":    "urgent-important",
+		"is_payment":  true,
+		"amount":      900,
+	})
+	if task.TaskType != "matrix" {
+		t.Fatalf("task with a quadrant has type %q", task.TaskType)
+	}
+	path := fmt.Sprintf("/api/tasks/%d", task.ID)
+
+	var patched models.Task
+	if status := doJSON(t, app, "PATCH", path, token, map[string]interface{}{
+		"description": "Transfer to the new landlord",
+		"currency":    "eur",
+	}, &patched); status != 200 {
+		t.Fatalf("patch: status %d", status)
+	}
+	if patched.Description != "Transfer to the new landlord" || patched.Currency != "EUR" {
+		t.Fatalf("patched fields: description %q, currency %q", patched.Description, patched.Currency)
+	}
+	if patched.Title != "Pay rent" || patched.Priority != "high" || patched.Amount != 900 || !patched.IsPayment {
+		t.Fatalf("untouched fields changed: %+v", patched)
+	}
+	if patched.Version != task.Version+1 {
+		t.Fatalf("version %d, want %d", patched.Version, task.Version+1)
+	}
+
+	// Clearing the quadrant takes the task off the matrix
+	if status := doJSON(t, app, "PATCH", path, token, map[string]interface{}{"quadrant": ""}, &patched); status != 200 {
+		t.Fatalf("clear quadrant: status %d", status)
+	}
+	if patched.Quadrant != "" || patched.TaskType != "kanban" {
+		t.Fatalf("after clearing the quadrant: quadrant %q, type %q", patched.Quadrant, patched.TaskType)
+	}
+
+	var stored models.Task
+	doJSON(t, app, "GET", path, token, nil, &stored)
+	if stored.Currency != "EUR" || stored.TaskType != "kanban" || stored.Title != "Pay rent" {
+		t.Fatalf("stored task: %+v", stored)
+	}
+}
@@ -0,0 +1,297 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"strings"
+	"time"
+
+	"tonish/backend/apierror"
+	"tonish/backend/database"
+	"tonish/backend/middleware"
+	"tonish/backend/models"
+
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+const (
+	// loginChallengeTTL bounds the time between the password step and the
+	// TOTP step of a login.
+	loginChallengeTTL     = 5 * time.Minute
+	loginChallengePurpose = "login_challenge"
+	recoveryCodeCount     = 10
+)
+
+var recoveryCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// totpNow is the clock TOTP codes are checked against; tests fix it.
+var totpNow = time.Now
+
+type TwoFactorCodeRequest struct {
+	Code string `json:"code" validate:"required,max=10"`
+}
+
+type DisableTwoFactorRequest struct {
+	Password     string `json:"password" validate:"required"`
+	Code         string `json:"code" validate:"max=10"`
+	RecoveryCode string `json:"recovery_code" validate:"max=64"`
+}
+
+type VerifyLoginRequest struct {
+	ChallengeToken string `json:"challenge_token" validate:"required"`
+	Code           string `json:"code" validate:"max=10"`
+	RecoveryCode   string `json:"recovery_code" validate:"max=64"`
+}
+
+// signLoginChallenge issues the short-lived token that proves the password
+// step of a two-factor login succeeded. It has no session and is rejected
+// everywhere except VerifyLogin.
+func signLoginChallenge(user *models.User) (string, error) {
+	return signPurposeToken(user.ID, loginChallengePurpose, loginChallengeTTL)
+}
+
+// parseLoginChallenge returns the user ID a challenge token was issued for.
+func parseLoginChallenge(tokenString string) (uint, bool) {
+	return parsePurposeToken(tokenString, loginChallengePurpose)
+}
+
+// normalizeRecoveryCode makes recovery codes case- and dash-insensitive.
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+}
+
+// replaceRecoveryCodes discards the user's recovery codes and returns a fresh
+// set. The plain codes are only ever shown once.
+func replaceRecoveryCodes(tx *gorm.DB, userID uint) ([]string, error) {
+	if err := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		buf := make([]byte, 7)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, err
+		}
+		raw := strings.ToLower(recoveryCodeEncoding.EncodeToString(buf))[:10]
+		code := raw[:5] + "-" + raw[5:]
+
+		if err := tx.Create(&models.RecoveryCode{
+			UserID:   userID,
+			CodeHash: middleware.HashSecret(normalizeRecoveryCode(code)),
+		}).Error; err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+
+	return codes, nil
+}
+
+// checkSecondFactor verifies a TOTP code or, failing that, consumes a
+// recovery code. TOTP time steps and recovery codes are each accepted once.
+func checkSecondFactor(user *models.User, code, recoveryCode string) bool {
+	if code != "" {
+		step, ok := verifyTOTP(user.TOTPSecret, code, totpNow())
+		if !ok {
+			return false
+		}
+		result := database.DB.Model(&models.User{}).
+			Where("id = ? AND totp_last_step < ?", user.ID, step).
+			Update("totp_last_step", step)
+		return result.Error == nil && result.RowsAffected == 1
+	}
+
+	if recoveryCode != "" {
+		result := database.DB.Model(&models.RecoveryCode{}).
+			Where("user_id = ? AND code_hash = ? AND used_at IS NULL", user.ID, middleware.HashSecret(normalizeRecoveryCode(recoveryCode))).
+			Update("used_at", time.Now())
+		return result.Error == nil && result.RowsAffected == 1
+	}
+
+	return false
+}
+
+// SetupTwoFactor starts TOTP enrollment by generating a secret. It is not
+// enforced until confirmed with EnableTwoFactor.
+func SetupTwoFactor(c *fiber.Ctx) error {
+	var user models.User
+	if err := database.DB.First(&user, currentUserID(c)).Error; err != nil {
+		return apierror.NotFound("User not found")
+	}
+	if user.TOTPEnabled {
+		return apierror.Conflict("Two-factor authentication is already enabled")
+	}
+
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		return apierror.Internal("Failed to start two-factor setup").WithCause(err)
+	}
+
+	if err := database.DB.Model(&user).Updates(map[string]interface{}{
+		"totp_secret":    secret,
+		"totp_last_step": 0,
+	}).Error; err != nil {
+		return apierror.Internal("Failed to start two-factor setup").WithCause(err)
+	}
+
+	return c.JSON(fiber.Map{
+		"secret":      secret,
+		"otpauth_uri": totpURI(secret, user.Email),
+	})
+}
+
+// EnableTwoFactor confirms enrollment with a first code from the
+// authenticator and returns the user's recovery codes
+func EnableTwoFactor(c *fiber.Ctx) error {
+	req := new(TwoFactorCodeRequest)
+	if err := parseBody(c, req); err != nil {
+		return err
+	}
+
+	var user models.User
+	if err := database.DB.First(&user, currentUserID(c)).Error; err != nil {
+		return apierror.NotFound("User not found")
+	}
+	if user.TOTPEnabled {
+		return apierror.Conflict("Two-factor authentication is already enabled")
+	}
+	if user.TOTPSecret == "" {
+		return apierror.BadRequest("Start two-factor setup first")
+	}
+	if !checkSecondFactor(&user, req.Code, "") {
+		return apierror.BadRequest("Invalid code")
+	}
+
+	var codes []string
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Update("totp_enabled", true).Error; err != nil {
+			return err
+		}
+		var err error
+		codes, err = replaceRecoveryCodes(tx, user.ID)
+		return err
+	})
+	if err != nil {
+		return apierror.Internal("Failed to enable two-factor authentication").WithCause(err)
+	}
+	recordAudit(c, &user.ID, models.AuditTwoFactorEnable, nil)
+
+	return c.JSON(fiber.Map{
+		"recovery_codes": codes,
+	})
+}
+
+// DisableTwoFactor turns TOTP off. It requires the password and a current
+// code or recovery code, so a stolen session alone cannot remove it.
+func DisableTwoFactor(c *fiber.Ctx) error {
+	req := new(DisableTwoFactorRequest)
+	if err := parseBody(c, req); err != nil {
+		return err
+	}
+
+	var user models.User
+	if err := database.DB.First(&user, currentUserID(c)).Error; err != nil {
+		return apierror.NotFound("User not found")
+	}
+	if !user.TOTPEnabled {
+		return apierror.Conflict("Two-factor authentication is not enabled")
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
+		return apierror.Unauthorized("Invalid credentials")
+	}
+	if !checkSecondFactor(&user, req.Code, req.RecoveryCode) {
+		return apierror.Unauthorized("Invalid code")
+	}
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Updates(map[string]interface{}{
+			"totp_enabled":   false,
+			"totp_secret":    "",
+			"totp_last_step": 0,
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", user.ID).Delete(&models.RecoveryCode{}).Error
+	})
+	if err != nil {
+		return apierror.Internal("Failed to disable two-factor authentication").WithCause(err)
+	}
+	recordAudit(c, &user.ID, models.AuditTwoFactorDisable, nil)
+
+	return c.Status(204).SendString("")
+}
+
+// RegenerateRecoveryCodes replaces the user's recovery codes. A current TOTP
+// code is required.
+func RegenerateRecoveryCodes(c *fiber.Ctx) error {
+	req := new(TwoFactorCodeRequest)
+	if err := parseBody(c, req); err != nil {
+		return err
+	}
+
+	var user models.User
+	if err := database.DB.First(&user, currentUserID(c)).Error; err != nil {
+		return apierror.NotFound("User not found")
+	}
+	if !user.TOTPEnabled {
+		return apierror.Conflict("Two-factor authentication is not enabled")
+	}
+	if !checkSecondFactor(&user, req.Code, "") {
+		return apierror.Unauthorized("Invalid code")
+	}
+
+	var codes []string
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		codes, err = replaceRecoveryCodes(tx, user.ID)
+		return err
+	})
+	if err != nil {
+		return apierror.Internal("Failed to regenerate recovery codes").WithCause(err)
+	}
+
+	return c.JSON(fiber.Map{
+		"recovery_codes": codes,
+	})
+}
+
+// VerifyLogin completes a two-factor login by exchanging the challenge token
+// from Login and a TOTP or recovery code for a session
+func VerifyLogin(c *fiber.Ctx) error {
+	req := new(VerifyLoginRequest)
+	if err := parseBody(c, req); err != nil {
+		return err
+	}
+
+	userID, ok := parseLoginChallenge(req.ChallengeToken)
+	if !ok {
+		return apierror.Unauthorized("Login challenge is invalid or has expired")
+	}
+
+	var user models.User
+	if err := database.DB.First(&user, userID).Error; err != nil || !user.TOTPEnabled || user.DisabledAt != nil {
+		return apierror.Unauthorized("Login challenge is invalid or has expired")
+	}
+
+	// Codes are short, so guesses count against the same limits as passwords
+	account := accountKey(user.Email)
+	if wait := loginRetryAfter(c, account); wait > 0 {
+		return tooManyAttempts(c, wait)
+	}
+	if !checkSecondFactor(&user, req.Code, req.RecoveryCode) {
+		recordLoginFailure(c, account, &user.ID, "bad_code")
+		return apierror.Unauthorized("Invalid code")
+	}
+	recordLoginSuccess(account)
+	recordAudit(c, &user.ID, models.AuditLogin, fiber.Map{"method": "two_factor"})
+
+	response, err := startSession(c, &user)
+	if err != nil {
+		return apierror.Internal("Failed to generate token").WithCause(err)
+	}
+
+	return c.JSON(response)
+}
@@ -0,0 +1,219 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"tonish/backend/apierror"
+	"tonish/backend/database"
+	"tonish/backend/middleware"
+	"tonish/backend/models"
+
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newTwoFactorApp serves the login and two-factor routes on a fresh
+// in-memory database, with TOTP codes checked against clock.
+func newTwoFactorApp(t *testing.T, clock *fakeClock) *fiber.App {
+	t.Helper()
+	t.Setenv("JWT_SECRET", "test-secret")
+
+	dsn := fmt.Sprintf("file:%s?mode=memory&cache=shared", strings.ReplaceAll(t.Name(), "/", "_"))
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	sqlDB, _ := db.DB()
+	t.Cleanup(func() { sqlDB.Close() })
+	database.DB = db
+	database.Migrate()
+	ResetLoginThrottles()
+
+	saved := totpNow
+	totpNow = clock.Now
+	t.Cleanup(func() { totpNow = saved })
+
+	app := fiber.New(fiber.Config{ErrorHandler: apierror.Handler})
+	app.Post("/login", Login)
+	app.Post("/login/verify", VerifyLogin)
+	app.Get("/me", middleware.AuthRequired, GetCurrentUser)
+	app.Post("/2fa/setup", middleware.AuthRequired, SetupTwoFactor)
+	app.Post("/2fa/enable", middleware.AuthRequired, EnableTwoFactor)
+	app.Post("/2fa/disable", middleware.AuthRequired, DisableTwoFactor)
+	return app
+}
+
+// call sends a JSON request and decodes the response into out when given.
+func call(t *testing.T, app *fiber.App, path, token string, body, out interface{}) int {
+	t.Helper()
+	method := "POST"
+	if body == nil {
+		method = "GET"
+	}
+	payload, _ := json.Marshal(body)
+	req := httptest.NewRequest(method, path, bytes.NewReader(payload))
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	if out != nil {
+		json.NewDecoder(resp.Body).Decode(out)
+	}
+	return resp.StatusCode
+}
+
+// codeAt is what an authenticator app shows for secret at now.
+func codeAt(t *testing.T, secret string, now time.Time) string {
+	t.Helper()
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil {
+		t.Fatalf("decode secret: %v", err)
+	}
+	return totpCode(key, now.Unix()/totpPeriod)
+}
+
+type loginResponse struct {
+	Token             string `json:"token"`
+	TwoFactorRequired bool   `json:"two_factor_required"`
+	ChallengeToken    string `json:"challenge_token"`
+}
+
+// enrollTwoFactor creates a user, turns TOTP on for them and returns the
+// secret and recovery codes.
+func enrollTwoFactor(t *testing.T, app *fiber.App, clock *fakeClock, email string) (string, []string) {
+	t.Helper()
+	hash, _ := bcrypt.GenerateFromPassword([]byte("correct-horse-battery"), bcrypt.MinCost)
+	if err := database.DB.Create(&models.User{Email: email, Password: string(hash), Name: email}).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	var session loginResponse
+	if status := call(t, app, "/login", "", map[string]string{"email": email, "password": "correct-horse-battery"}, &session); status != 200 || session.Token == "" {
+		t.Fatalf("login: status %d", status)
+	}
+
+	var setup struct {
+		Secret     string `json:"secret"`
+		OTPAuthURI string `json:"otpauth_uri"`
+	}
+	if status := call(t, app, "/2fa/setup", session.Token, map[string]string{}, &setup); status != 200 {
+		t.Fatalf("setup: status %d", status)
+	}
+	if !strings.HasPrefix(setup.OTPAuthURI, "otpauth://totp/") || !strings.Contains(setup.OTPAuthURI, setup.Secret) {
+		t.Fatalf("unexpected otpauth URI %q", setup.OTPAuthURI)
+	}
+
+	if status := call(t, app, "/2fa/enable", session.Token, map[string]string{"code": "000000"}, nil); status != 400 {
+		t.Fatalf("enable with a wrong code: status %d, want 400", status)
+	}
+	var enabled struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+	if status := call(t, app, "/2fa/enable", session.Token, map[string]string{"code": codeAt(t, setup.Secret, clock.now)}, &enabled); status != 200 {
+		t.Fatalf("enable: status %d", status)
+	}
+	if len(enabled.RecoveryCodes) != recoveryCodeCount {
+		t.Fatalf("got %d recovery codes, want %d", len(enabled.RecoveryCodes), recoveryCodeCount)
+	}
+	return setup.Secret, enabled.RecoveryCodes
+}
+
+// challenge runs the password step of a two-factor login.
+func challenge(t *testing.T, app *fiber.App, email string) string {
+	t.Helper()
+	var response loginResponse
+	if status := call(t, app, "/login", "", map[string]string{"email": email, "password": "correct-horse-battery"}, &response); status != 200 {
+		t.Fatalf("login: status %d", status)
+	}
+	if !response.TwoFactorRequired || response.ChallengeToken == "" || response.Token != "" {
+		t.Fatalf("login with 2FA on: %+v, want only a challenge", response)
+	}
+	return response.ChallengeToken
+}
+
+func TestTwoFactorLogin(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)}
+	app := newTwoFactorApp(t, clock)
+	secret, _ := enrollTwoFactor(t, app, clock, "totp@example.com")
+
+	token := challenge(t, app, "totp@example.com")
+	if status := call(t, app, "/me", token, nil, nil); status != 401 {
+		t.Fatalf("challenge token used as access token: status %d, want 401", status)
+	}
+
+	// The code that confirmed enrollment cannot be replayed
+	if status := call(t, app, "/login/verify", "", map[string]string{"challenge_token": token, "code": codeAt(t, secret, clock.now)}, nil); status != 401 {
+		t.Fatalf("replayed code: status %d, want 401", status)
+	}
+	// Codes more than one step away are refused
+	if status := call(t, app, "/login/verify", "", map[string]string{"challenge_token": token, "code": codeAt(t, secret, clock.now.Add(5*totpPeriod*time.Second))}, nil); status != 401 {
+		t.Fatalf("code from the future: status %d, want 401", status)
+	}
+
+	clock.Advance(totpPeriod * time.Second)
+	var session loginResponse
+	if status := call(t, app, "/login/verify", "", map[string]string{"challenge_token": token, "code": codeAt(t, secret, clock.now)}, &session); status != 200 {
+		t.Fatalf("verify: status %d", status)
+	}
+	if status := call(t, app, "/me", session.Token, nil, nil); status != 200 {
+		t.Fatalf("session after verify: status %d", status)
+	}
+
+	if status := call(t, app, "/login/verify", "", map[string]string{"challenge_token": "garbage", "code": codeAt(t, secret, clock.now)}, nil); status != 401 {
+		t.Fatalf("invalid challenge: status %d, want 401", status)
+	}
+}
+
+func TestTwoFactorRecoveryCodes(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)}
+	app := newTwoFactorApp(t, clock)
+	_, codes := enrollTwoFactor(t, app, clock, "recovery@example.com")
+
+	// Recovery codes ignore case and dashes
+	loose := strings.ToUpper(strings.ReplaceAll(codes[0], "-", ""))
+	token := challenge(t, app, "recovery@example.com")
+	var session loginResponse
+	if status := call(t, app, "/login/verify", "", map[string]string{"challenge_token": token, "recovery_code": loose}, &session); status != 200 {
+		t.Fatalf("verify with recovery code: status %d", status)
+	}
+
+	// Each code works once
+	token = challenge(t, app, "recovery@example.com")
+	if status := call(t, app, "/login/verify", "", map[string]string{"challenge_token": token, "recovery_code": codes[0]}, nil); status != 401 {
+		t.Fatalf("reused recovery code: status %d, want 401", status)
+	}
+	if status := call(t, app, "/login/verify", "", map[string]string{"challenge_token": token, "recovery_code": codes[1]}, nil); status != 200 {
+		t.Fatalf("second recovery code: status %d", status)
+	}
+
+	// Disabling takes the password and a second factor, and drops the codes
+	if status := call(t, app, "/2fa/disable", session.Token, map[string]string{"password": "correct-horse-battery"}, nil); status != 401 {
+		t.Fatalf("disable without a code: status %d, want 401", status)
+	}
+	if status := call(t, app, "/2fa/disable", session.Token, map[string]string{"password": "correct-horse-battery", "recovery_code": codes[2]}, nil); status != 204 {
+		t.Fatalf("disable: status %d", status)
+	}
+	var left int64
+	database.DB.Model(&models.RecoveryCode{}).Count(&left)
+	if left != 0 {
+		t.Fatalf("%d recovery codes left after disabling", left)
+	}
+	var plain loginResponse
+	call(t, app, "/login", "", map[string]string{"email": "recovery@example.com", "password": "correct-horse-battery"}, &plain)
+	if plain.TwoFactorRequired || plain.Token == "" {
+		t.Fatalf("login after disabling: %+v, want a session", plain)
+	}
+}
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"regexp"
+	"strings"
+
+	"tonish/backend/apierror"
+	"tonish/backend/database"
+	"tonish/backend/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+var (
+	localePattern   = regexp.MustCompile(`^[a-z]{2,3}(-[A-Z]{2})?$`)
+	currencyPattern = regexp.MustCompile(`^[A-Z]{3}$`)
+)
+
+// UpdateProfileRequest is a partial update: only fields present in the body
+// are changed.
+type UpdateProfileRequest struct {
+	Name            *string `json:"name" validate:"max=100"`
+	Timezone        *string `json:"timezone" validate:"notblank,timezone"`
+	Locale          *string `json:"locale" validate:"notblank,locale"`
+	WeekStart       *int    `json:"week_start" validate:"min=0,max=6"` // 0 is Sunday
+	DefaultCurrency *string `json:"default_currency" validate:"notblank,currency"`
+	DefaultTaskView *string `json:"default_task_view" validate:"notblank,oneof=kanban matrix"`
+}
+
+// loadUserSettings returns the user's stored settings, or the defaults when
+// they have never changed any.
+func loadUserSettings(userID uint) models.UserSettings {
+	var settings models.UserSettings
+	if err := database.DB.Where("user_id = ?", userID).First(&settings).Error; err != nil {
+		return models.DefaultUserSettings(userID)
+	}
+	return settings
+}
+
+func profileResponse(user *models.User, settings *models.UserSettings) fiber.Map {
+	return fiber.Map{
+		"id":           user.ID,
+		"email":        user.Email,
+		"name":         user.Name,
+		"role":         user.Role,
+		"totp_enabled": user.TOTPEnabled,
+		"settings":     settings,
+	}
+}
+
+// GetCurrentUser retrieves the current user's information and settings
+func GetCurrentUser(c *fiber.Ctx) error {
+	var user models.User
+	if err := database.DB.First(&user, currentUserID(c)).Error; err != nil {
+		return apierror.NotFound("User not found")
+	}
+
+	settings := loadUserSettings(user.ID)
+	return c.JSON(profileResponse(&user, &settings))
+}
+
+// UpdateCurrentUser changes the current user's name and settings
+func UpdateCurrentUser(c *fiber.Ctx) error {
+	req := new(UpdateProfileRequest)
+	if err := parseBody(c, req); err != nil {
+		return err
+	}
+
+	var user models.User
+	if err := database.DB.First(&user, currentUserID(c)).Error; err != nil {
+		return apierror.NotFound("User not found")
+	}
+	settings := loadUserSettings(user.ID)
+
+	if req.Name != nil {
+		user.Name = strings.TrimSpace(*req.Name)
+	}
+	if req.Timezone != nil {
+		settings.Timezone = *req.Timezone
+	}
+	if req.Locale != nil {
+		settings.Locale = *req.Locale
+	}
+	if req.WeekStart != nil {
+		settings.WeekStart = *req.WeekStart
+	}
+	if req.DefaultCurrency != nil {
+		settings.DefaultCurrency = strings.ToUpper(*req.DefaultCurrency)
+	}
+	if req.DefaultTaskView != nil {
+		settings.DefaultTaskView = *req.DefaultTaskView
+	}
+
+	if err := database.DB.Model(&user).Update("name", user.Name).Error; err != nil {
+		return apierror.Internal("Failed to update profile").WithCause(err)
+	}
+	if err := database.DB.Save(&settings).Error; err != nil {
+		return apierror.Internal("Failed to update settings").WithCause(err)
+	}
+
+	return c.JSON(profileResponse(&user, &settings))
+}
@@ -0,0 +1,234 @@
+package handlers
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"tonish/backend/apierror"
+	"tonish/backend/recurrence"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Request DTOs declare their constraints in a `validate` struct tag, a
+// comma-separated list of rules:
+//
+//	required      present and non-empty (non-nil for pointers)
+//	notblank      non-empty if present; for optional pointer fields
+//	min=N, max=N  length of strings and slices, value of numbers
+//	oneof=a b c   one of the listed strings
+//	email, password, currency, locale, timezone, scopes, color, tagname,
+//	rrule, datelist
+//	              the named checks in validationRules
+//
+// Empty optional fields skip every other rule, so "" keeps a default.
+
+// validationRules are the named rules. Each returns an error message for an
+// invalid value, or "".
+var validationRules = map[string]func(v reflect.Value) string{
+	"email": func(v reflect.Value) string {
+		if !validateEmail(strings.TrimSpace(v.String())) {
+			return "must be a valid email address"
+		}
+		return ""
+	},
+	"password": func(v reflect.Value) string {
+		return ValidatePassword(v.String())
+	},
+	"currency": func(v reflect.Value) string {
+		if !currencyPattern.MatchString(strings.ToUpper(v.String())) {
+			return "must be a three-letter ISO 4217 code"
+		}
+		return ""
+	},
+	"locale": func(v reflect.Value) string {
+		if !localePattern.MatchString(v.String()) {
+			return "must look like en or en-US"
+		}
+		return ""
+	},
+	"timezone": func(v reflect.Value) string {
+		name := v.String()
+		if _, err := time.LoadLocation(name); err != nil || name == "Local" {
+			return "must be an IANA timezone such as Europe/Berlin"
+		}
+		return ""
+	},
+	"color": func(v reflect.Value) string {
+		if !colorPattern.MatchString(v.String()) {
+			return "must be a color such as #1e90ff"
+		}
+		return ""
+	},
+	"tagname": func(v reflect.Value) string {
+		if strings.Contains(v.String(), ",") {
+			return "must not contain a comma"
+		}
+		return ""
+	},
+	"rrule": func(v reflect.Value) string {
+		if _, err := recurrence.Parse(v.String()); err != nil {
+			return err.Error()
+		}
+		return ""
+	},
+	"datelist": func(v reflect.Value) string {
+		if _, err := parseDateList(v.String()); err != nil {
+			return "must be comma-separated YYYY-MM-DD dates"
+		}
+		return ""
+	},
+	"scopes": func(v reflect.Value) string {
+		for i := 0; i < v.Len(); i++ {
+			if scope := v.Index(i).String(); !isTokenScope(scope) {
+				return "unknown scope " + scope
+			}
+		}
+		return ""
+	},
+}
+
+// parseBody decodes the request body into req, a pointer to a DTO, and
+// validates it. The error is ready to be returned from a handler.
+func parseBody(c *fiber.Ctx, req interface{}) error {
+	if err := c.BodyParser(req); err != nil {
+		return apierror.InvalidBody()
+	}
+	if fields := validateStruct(req); len(fields) > 0 {
+		return apierror.Validation(fields)
+	}
+	return nil
+}
+
+// validateStruct checks the validate tags of a struct (or pointer to one)
+// and returns the messages of invalid fields keyed by their JSON names.
+func validateStruct(s interface{}) map[string]string {
+	v := reflect.Indirect(reflect.ValueOf(s))
+	t := v.Type()
+	fields := map[string]string{}
+
+	for i := 0; i < t.NumField(); i++ {
+		tag := t.Field(i).Tag.Get("validate")
+		if tag == "" {
+			continue
+		}
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if name == "" {
+			name = t.Field(i).Name
+		}
+		if msg := validateField(v.Field(i), strings.Split(tag, ",")); msg != "" {
+			fields[name] = msg
+		}
+	}
+	return fields
+}
+
+func validateField(v reflect.Value, rules []string) string {
+	required, notBlank := false, false
+	for _, rule := range rules {
+		switch rule {
+		case "required":
+			required = true
+		case "notblank":
+			notBlank = true
+		}
+	}
+
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			if required {
+				return "is required"
+			}
+			return ""
+		}
+		v = v.Elem()
+	}
+	if isEmptyValue(v) {
+		if required {
+			return "is required"
+		}
+		if notBlank {
+			return "must not be empty"
+		}
+		return ""
+	}
+
+	for _, rule := range rules {
+		name, arg, _ := strings.Cut(rule, "=")
+		var msg string
+		switch name {
+		case "required", "notblank":
+		case "min", "max":
+			msg = checkBound(v, name, arg)
+		case "oneof":
+			msg = checkOneOf(v, strings.Fields(arg))
+		default:
+			check, ok := validationRules[name]
+			if !ok {
+				panic("unknown validation rule " + name)
+			}
+			msg = check(v)
+		}
+		if msg != "" {
+			return msg
+		}
+	}
+	return ""
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.String:
+		return strings.TrimSpace(v.String()) == ""
+	case reflect.Slice, reflect.Map:
+		return v.Len() == 0
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint64, reflect.Float64:
+		return v.IsZero()
+	}
+	return false
+}
+
+func checkBound(v reflect.Value, rule, arg string) string {
+	limit, err := strconv.ParseFloat(arg, 64)
+	if err != nil {
+		panic("bad validation bound " + arg)
+	}
+
+	var size float64
+	unit := ""
+	switch v.Kind() {
+	case reflect.String:
+		size, unit = float64(utf8.RuneCountInString(v.String())), " characters"
+	case reflect.Slice, reflect.Map:
+		size, unit = float64(v.Len()), " items"
+	case reflect.Int, reflect.Int64:
+		size = float64(v.Int())
+	case reflect.Uint, reflect.Uint64:
+		size = float64(v.Uint())
+	case reflect.Float64:
+		size = v.Float()
+	default:
+		panic("min/max on unsupported kind " + v.Kind().String())
+	}
+
+	if rule == "min" && size < limit {
+		return fmt.Sprintf("must be at least %s%s", arg, unit)
+	}
+	if rule == "max" && size > limit {
+		return fmt.Sprintf("must be at most %s%s", arg, unit)
+	}
+	return ""
+}
+
+func checkOneOf(v reflect.Value, allowed []string) string {
+	for _, a := range allowed {
+		if v.String() == a {
+			return ""
+		}
+	}
+	return "must be one of " + strings.Join(allowed, ", ")
+}
@@ -0,0 +1,152 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	ws "tonish/backend/websocket"
+
+	fastws "github.com/fasthttp/websocket"
+	"github.com/gofiber/fiber/v2"
+)
+
+// serveWebSocket serves app on a local port with a fresh hub and returns
+// the URL of its WebSocket endpoint.
+func serveWebSocket(t *testing.T, app *fiber.App) string {
+	t.Helper()
+	hub := ws.NewHub()
+	go hub.Run()
+	ws.GlobalHub = hub
+	t.Cleanup(func() { ws.GlobalHub = nil })
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	go app.Listener(ln)
+	t.Cleanup(func() { app.Shutdown() })
+	return "ws://" + ln.Addr().String() + "/ws"
+}
+
+// dial opens a WebSocket with token in the query string and returns the
+// connection, or nil and the handshake status when it is refused.
+func dial(t *testing.T, endpoint, token string, header http.Header) (*fastws.Conn, int) {
+	t.Helper()
+	if token != "" {
+		endpoint += "?token=" + url.QueryEscape(token)
+	}
+	conn, resp, err := fastws.DefaultDialer.Dial(endpoint, header)
+	if err != nil {
+		if resp == nil {
+			t.Fatalf("dial: %v", err)
+		}
+		return nil, resp.StatusCode
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn, resp.StatusCode
+}
+
+// readMessage returns the next message on conn, or "" after wait.
+func readMessage(t *testing.T, conn *fastws.Conn, wait time.Duration) (string, []byte) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(wait))
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		return "", nil
+	}
+	var message struct {
+		Type string `json:"type"`
+	}
+	json.Unmarshal(data, &message)
+	return message.Type, data
+}
+
+// awaitRegistered waits until the hub delivers to conn, which registers
+// with the hub after the handshake has completed.
+func awaitRegistered(t *testing.T, conn *fastws.Conn, userID uint) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		ws.GlobalHub.BroadcastToUser(userID, "probe", nil)
+		if kind, _ := readMessage(t, conn, 50*time.Millisecond); kind == "probe" {
+			return
+		}
+	}
+	t.Fatal("client never registered with the hub")
+}
+
+func TestWebSocketHandshakeAuth(t *testing.T) {
+	app := newTestApp(t)
+	alice, token := createUser(t, app, "alice@example.com")
+	endpoint := serveWebSocket(t, app)
+
+	if _, status := dial(t, endpoint, "", nil); status != 401 {
+		t.Fatalf("upgrade without a token: status %d, want 401", status)
+	}
+	if _, status := dial(t, endpoint, "garbage", nil); status != 401 {
+		t.Fatalf("upgrade with a garbage token: status %d, want 401", status)
+	}
+
+	// A purpose-bound token, here the account deletion confirmation, is not
+	// an access token
+	var confirm struct {
+		ConfirmationToken string `json:"confirmation_token"`
+	}
+	if status := doJSON(t, app, "POST", "/api/user/delete", token, map[string]string{"password": testPassword}, &confirm); status != 200 {
+		t.Fatalf("request deletion: status %d", status)
+	}
+	if _, status := dial(t, endpoint, confirm.ConfirmationToken, nil); status != 401 {
+		t.Fatalf("upgrade with a purpose token: status %d, want 401", status)
+	}
+
+	// Personal access tokens do not open sockets either
+	pat, _ := createToken(t, app, token, "tasks:read")
+	if _, status := dial(t, endpoint, pat, nil); status != 401 {
+		t.Fatalf("upgrade with a personal access token: status %d, want 401", status)
+	}
+
+	conn, status := dial(t, endpoint, token, nil)
+	if status != 101 {
+		t.Fatalf("upgrade with the query token: status %d, want 101", status)
+	}
+	awaitRegistered(t, conn, alice.ID)
+	header := http.Header{"Sec-WebSocket-Protocol": {"bearer, " + token}}
+	conn, status = dial(t, endpoint, "", header)
+	if status != 101 {
+		t.Fatalf("upgrade with the subprotocol token: status %d, want 101", status)
+	}
+	awaitRegistered(t, conn, alice.ID)
+}
+
+func TestWebSocketEventsReachOnlyTheirOwner(t *testing.T) {
+	app := newTestApp(t)
+	alice, aliceToken := createUser(t, app, "alice@example.com")
+	bob, bobToken := createUser(t, app, "bob@example.com")
+	endpoint := serveWebSocket(t, app)
+
+	aliceConn, _ := dial(t, endpoint, aliceToken, nil)
+	bobConn, _ := dial(t, endpoint, bobToken, nil)
+	if aliceConn == nil || bobConn == nil {
+		t.Fatal("upgrade refused")
+	}
+	awaitRegistered(t, aliceConn, alice.ID)
+	awaitRegistered(t, bobConn, bob.ID)
+
+	if status := doJSON(t, app, "POST", "/api/notebooks", aliceToken, map[string]string{"name": "Diary"}, nil); status != 201 {
+		t.Fatalf("create notebook: status %d", status)
+	}
+	if kind, data := readMessage(t, aliceConn, time.Second); kind != ws.MessageTypeNotebookCreate {
+		t.Fatalf("alice got %q (%s), want notebook_create", kind, data)
+	}
+
+	// The hub handles messages in order, so if bob's next message is a probe
+	// sent after the event, the event never went to him
+	ws.GlobalHub.BroadcastToUser(bob.ID, "probe", nil)
+	if kind, data := readMessage(t, bobConn, time.Second); kind != "probe" {
+		t.Fatalf("bob got %q (%s), want only the probe", kind, data)
+	}
+}
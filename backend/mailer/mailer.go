@@ -0,0 +1,120 @@
+package mailer
+
+import (
+	"fmt"
+	"log"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message is a plain-text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers messages. Implementations must be safe for concurrent use.
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// Default is the mailer used by the handlers. It is set by Initialize and may
+// be replaced in tests.
+var Default Mailer = &LogMailer{}
+
+// Initialize selects the mailer from the environment. MAIL_TRANSPORT is
+// "smtp", "file" (writes .eml files to MAIL_DIR) or "log" (the default).
+func Initialize() {
+	switch strings.ToLower(os.Getenv("MAIL_TRANSPORT")) {
+	case "smtp":
+		Default = &SMTPMailer{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     envOr("SMTP_PORT", "587"),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     envOr("MAIL_FROM", "tonish@localhost"),
+		}
+		log.Println("Mailer: SMTP via", os.Getenv("SMTP_HOST"))
+	case "file":
+		Default = &LogMailer{Dir: envOr("MAIL_DIR", "mail")}
+		log.Println("Mailer: writing messages to", envOr("MAIL_DIR", "mail"))
+	default:
+		Default = &LogMailer{}
+		log.Println("Mailer: logging messages (set MAIL_TRANSPORT=smtp to send email)")
+	}
+}
+
+func envOr(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// SMTPMailer sends messages through an SMTP server, authenticating with
+// PLAIN auth when a username is configured.
+type SMTPMailer struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+func (m *SMTPMailer) Send(msg Message) error {
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+
+	return smtp.SendMail(m.Host+":"+m.Port, auth, m.From, []string{msg.To}, format(m.From, msg))
+}
+
+// LogMailer is the offline mailer. With a Dir it writes each message to an
+// .eml file there; without one it logs that a message was sent. The body is
+// left out of the log because it can hold secrets such as reset tokens.
+type LogMailer struct {
+	Dir string
+
+	mu  sync.Mutex
+	seq int
+}
+
+func (m *LogMailer) Send(msg Message) error {
+	if m.Dir == "" {
+		log.Printf("Mail to %s: %s (body not logged; set MAIL_TRANSPORT=file to keep messages)\n", msg.To, msg.Subject)
+		return nil
+	}
+
+	m.mu.Lock()
+	m.seq++
+	name := fmt.Sprintf("%s-%03d.eml", time.Now().Format("20060102-150405"), m.seq)
+	m.mu.Unlock()
+
+	if err := os.MkdirAll(m.Dir, 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(m.Dir, name), format("tonish@localhost", msg), 0o600)
+}
+
+// format renders msg as an RFC 5322 message. Header values are stripped of
+// line breaks so user input cannot inject headers.
+func format(from string, msg Message) []byte {
+	clean := strings.NewReplacer("\r", "", "\n", "")
+
+	var b strings.Builder
+	b.WriteString("From: " + clean.Replace(from) + "\r\n")
+	b.WriteString("To: " + clean.Replace(msg.To) + "\r\n")
+	b.WriteString("Subject: " + clean.Replace(msg.Subject) + "\r\n")
+	b.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	return []byte(b.String())
+}
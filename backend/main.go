@@ -3,7 +3,11 @@ package main
 import (
 	"log"
 	"os"
+	_ "time/tzdata" // User timezones must resolve even without system zoneinfo
+	"tonish/backend/apierror"
 	"tonish/backend/database"
+	"tonish/backend/handlers"
+	"tonish/backend/mailer"
 	"tonish/backend/middleware"
 	"tonish/backend/routes"
 	ws "tonish/backend/websocket"
@@ -17,14 +21,27 @@ func main() {
 	database.Connect()
 	database.Migrate()
 	database.NormalizeTaskTypes()
-	database.SeedDefaultUser()
+	database.SeedDefaultUser(handlers.ValidatePassword)
 
 	// Initialize WebSocket hub
 	ws.Initialize()
 
+	// Initialize outgoing mail
+	mailer.Initialize()
+
+	// Finish data exports interrupted by the last shutdown
+	handlers.ResumeDataExports()
+
+	// Prune audit events past AUDIT_RETENTION_DAYS
+	handlers.StartAuditRetention()
+
+	// Fire task reminders, including those missed while the server was down
+	handlers.StartReminderScheduler()
+
 	// Create Fiber app
 	app := fiber.New(fiber.Config{
-		AppName: "Tonish API v1.0",
+		AppName:      "Tonish API v1.0",
+		ErrorHandler: apierror.Handler,
 	})
 
 	// Middleware
@@ -34,7 +51,7 @@ func main() {
 	// Health check
 	app.Get("/", func(c *fiber.Ctx) error {
 		return c.JSON(fiber.Map{
-			"message": "Tonish API is running - REGISTRATION DISABLED",
+			"message": "Tonish API is running",
 			"version": "1.0.1",
 		})
 	})
@@ -0,0 +1,23 @@
+package middleware
+
+import (
+	"tonish/backend/apierror"
+	"tonish/backend/database"
+	"tonish/backend/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// AdminRequired rejects users without the admin role. It must run after
+// AuthRequired. The role is read from the database so that demotions take
+// effect immediately rather than when the token expires.
+func AdminRequired(c *fiber.Ctx) error {
+	userID, _ := c.Locals("user_id").(uint)
+
+	var user models.User
+	if err := database.DB.Select("id", "role").First(&user, userID).Error; err != nil || user.Role != models.RoleAdmin {
+		return apierror.Forbidden("Admin access required")
+	}
+
+	return c.Next()
+}
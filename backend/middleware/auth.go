@@ -1,13 +1,40 @@
 package middleware
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"os"
 	"strings"
+	"time"
+
+	"tonish/backend/apierror"
+	"tonish/backend/database"
+	"tonish/backend/models"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/golang-jwt/jwt/v5"
 )
 
+// ErrInvalidToken is returned by ParseToken for any token that fails
+// signature, expiry or claim validation.
+var ErrInvalidToken = errors.New("invalid or expired token")
+
+// HashSecret returns the hex-encoded SHA-256 digest of a bearer secret such as
+// a refresh token or personal access token. High-entropy random secrets do
+// not need a slow password hash.
+func HashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// Claims is the identity carried by a validated token.
+type Claims struct {
+	UserID    uint
+	Email     string
+	SessionID uint
+}
+
 func getJWTSecret() []byte {
 	secret := os.Getenv("JWT_SECRET")
 	if secret == "" {
@@ -16,50 +43,164 @@ func getJWTSecret() []byte {
 	return []byte(secret)
 }
 
+// ParseToken validates a signed JWT and extracts its claims without
+// consulting the session store.
+func ParseToken(tokenString string) (*Claims, error) {
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fiber.ErrUnauthorized
+		}
+		return getJWTSecret(), nil
+	})
+	if err != nil || !token.Valid {
+		return nil, ErrInvalidToken
+	}
+
+	// Purpose-bound tokens, such as login challenges, are not access tokens
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || claims["purpose"] != nil {
+		return nil, ErrInvalidToken
+	}
+
+	userID, ok := claims["user_id"].(float64)
+	if !ok || userID <= 0 {
+		return nil, ErrInvalidToken
+	}
+	sessionID, ok := claims["sid"].(float64)
+	if !ok || sessionID <= 0 {
+		return nil, ErrInvalidToken
+	}
+	email, _ := claims["email"].(string)
+
+	return &Claims{UserID: uint(userID), Email: email, SessionID: uint(sessionID)}, nil
+}
+
+// Authenticate validates a token and checks that the session it was issued
+// for is still active and its user not disabled, so that logout, revocation
+// and disabling take effect before the token itself expires.
+func Authenticate(tokenString string) (*Claims, error) {
+	claims, err := ParseToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+
+	var session models.Session
+	err = database.DB.
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL AND expires_at > ?", claims.SessionID, claims.UserID, time.Now()).
+		First(&session).Error
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	var user models.User
+	if err := database.DB.Select("id", "disabled_at").First(&user, claims.UserID).Error; err != nil || user.DisabledAt != nil {
+		return nil, ErrInvalidToken
+	}
+
+	touchSession(&session)
+
+	return claims, nil
+}
+
+// lastSeenResolution limits how often a session's last-seen time is written.
+const lastSeenResolution = time.Minute
+
+// touchSession records activity on a session, at most once per
+// lastSeenResolution to keep authenticated reads from turning into writes.
+func touchSession(session *models.Session) {
+	now := time.Now()
+	if session.LastSeenAt != nil && now.Sub(*session.LastSeenAt) < lastSeenResolution {
+		return
+	}
+	database.DB.Model(&models.Session{}).Where("id = ?", session.ID).UpdateColumn("last_seen_at", now)
+}
+
 func AuthRequired(c *fiber.Ctx) error {
 	authHeader := c.Get("Authorization")
 	
 	if authHeader == "" {
-		return c.Status(401).JSON(fiber.Map{
-			"error": "Authorization header required",
-		})
+		return apierror.Unauthorized("Authorization header required")
 	}
 	
 	// Extract token from "Bearer <token>"
 	parts := strings.Split(authHeader, " ")
 	if len(parts) != 2 || parts[0] != "Bearer" {
-		return c.Status(401).JSON(fiber.Map{
-			"error": "Invalid authorization header format",
-		})
+		return apierror.Unauthorized("Invalid authorization header format")
 	}
 	
-	tokenString := parts[1]
-	
-	// Parse and validate token
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fiber.ErrUnauthorized
-		}
-		return getJWTSecret(), nil
-	})
-	
-	if err != nil || !token.Valid {
-		return c.Status(401).JSON(fiber.Map{
-			"error": "Invalid or expired token",
-		})
+	if strings.HasPrefix(parts[1], models.PersonalAccessTokenPrefix) {
+		return authenticatePersonalAccessToken(c, parts[1])
 	}
 	
-	// Extract claims
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok {
-		return c.Status(401).JSON(fiber.Map{
-			"error": "Invalid token claims",
-		})
+	claims, err := Authenticate(parts[1])
+	if err != nil {
+		return apierror.Unauthorized("Invalid or expired token")
 	}
 	
 	// Store user ID in context
-	c.Locals("user_id", uint(claims["user_id"].(float64)))
-	c.Locals("email", claims["email"].(string))
+	c.Locals("user_id", claims.UserID)
+	c.Locals("email", claims.Email)
+	c.Locals("session_id", claims.SessionID)
 	
 	return c.Next()
 }
+
+// authenticatePersonalAccessToken authenticates a request made with a
+// personal access token. The token is stored in the context for
+// ScopeRequired to check its scopes.
+func authenticatePersonalAccessToken(c *fiber.Ctx, tokenString string) error {
+	var token models.PersonalAccessToken
+	if err := database.DB.Where("token_hash = ?", HashSecret(tokenString)).First(&token).Error; err != nil ||
+		(token.ExpiresAt != nil && time.Now().After(*token.ExpiresAt)) {
+		return apierror.Unauthorized("Invalid or expired token")
+	}
+
+	var user models.User
+	if err := database.DB.First(&user, token.UserID).Error; err != nil || user.DisabledAt != nil {
+		return apierror.Unauthorized("Invalid or expired token")
+	}
+
+	now := time.Now()
+	if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) >= lastSeenResolution {
+		database.DB.Model(&token).UpdateColumn("last_used_at", now)
+	}
+
+	c.Locals("user_id", user.ID)
+	c.Locals("email", user.Email)
+	c.Locals("personal_access_token", &token)
+
+	return c.Next()
+}
+
+// ScopeRequired restricts personal access tokens to routes covered by their
+// scopes: reads need "<resource>:read" and everything else
+// "<resource>:write". Session tokens are not restricted.
+func ScopeRequired(resource string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		token, ok := c.Locals("personal_access_token").(*models.PersonalAccessToken)
+		if !ok {
+			return c.Next()
+		}
+
+		required := resource + ":write"
+		if c.Method() == fiber.MethodGet || c.Method() == fiber.MethodHead {
+			required = resource + ":read"
+		}
+
+		if token.HasScope(required) {
+			return c.Next()
+		}
+
+		return apierror.Forbidden("Token is missing the " + required + " scope")
+	}
+}
+
+// SessionRequired rejects personal access tokens on account-management
+// routes, which are only available to signed-in sessions.
+func SessionRequired(c *fiber.Ctx) error {
+	if _, ok := c.Locals("personal_access_token").(*models.PersonalAccessToken); ok {
+		return apierror.Forbidden("Personal access tokens cannot be used for this endpoint")
+	}
+
+	return c.Next()
+}
@@ -14,8 +14,9 @@ func CORS() fiber.Handler {
 		// Default to allow all in development (no credentials with wildcard)
 		return cors.New(cors.Config{
 			AllowOrigins:     "*",
-			AllowHeaders:     "Origin, Content-Type, Accept, Authorization",
-			AllowMethods:     "GET, POST, PUT, DELETE, OPTIONS",
+			AllowHeaders:     "Origin, Content-Type, Accept, Authorization, If-Match",
+			ExposeHeaders:    "ETag",
+			AllowMethods:     "GET, POST, PUT, PATCH, DELETE, OPTIONS",
 			AllowCredentials: false,
 		})
 	}
@@ -23,8 +24,9 @@ func CORS() fiber.Handler {
 	// Production: specific origins with credentials
 	return cors.New(cors.Config{
 		AllowOrigins:     allowedOrigins,
-		AllowHeaders:     "Origin, Content-Type, Accept, Authorization",
-		AllowMethods:     "GET, POST, PUT, DELETE, OPTIONS",
+		AllowHeaders:     "Origin, Content-Type, Accept, Authorization, If-Match",
+		ExposeHeaders:    "ETag",
+		AllowMethods:     "GET, POST, PUT, PATCH, DELETE, OPTIONS",
 		AllowCredentials: true,
 	})
 }
@@ -0,0 +1,50 @@
+package models
+
+import (
+	"time"
+)
+
+// Audit actions
+const (
+	AuditAuthLockout      = "auth.lockout"
+	AuditLogin            = "auth.login"
+	AuditLoginFailed      = "auth.login_failed"
+	AuditLogout           = "auth.logout"
+	AuditPasswordChange   = "auth.password_change"
+	AuditPasswordReset    = "auth.password_reset"
+	AuditTwoFactorEnable  = "auth.2fa_enable"
+	AuditTwoFactorDisable = "auth.2fa_disable"
+	AuditTokenCreate      = "token.create"
+	AuditTokenRevoke      = "token.revoke"
+	AuditTaskDelete       = "task.delete"
+	AuditTaskPurge        = "task.permanent_delete"
+	AuditNotebookDelete   = "notebook.delete"
+	AuditPageDelete       = "page.delete"
+	AuditChecklistDelete  = "checklist_item.delete"
+	AuditTagMerge         = "tag.merge"
+	AuditTagDelete        = "tag.delete"
+	AuditViewDelete       = "saved_view.delete"
+	AuditAccountDelete    = "account.delete"
+	AuditUserCreate       = "user.create"
+	AuditUserUpdate       = "user.update"
+	AuditUserDisable      = "user.disable"
+	AuditUserEnable       = "user.enable"
+	AuditUserResetForced  = "user.password_reset"
+	AuditUserDelete       = "user.delete"
+)
+
+// AuditEvent is an append-only record of a security-relevant or destructive
+// action. Destructive actions keep JSON snapshots of the resource.
+type AuditEvent struct {
+	ID           uint      `json:"id" gorm:"primaryKey"`
+	UserID       *uint     `json:"user_id" gorm:"index"` // Acting user, if known
+	Action       string    `json:"action" gorm:"index;not null"`
+	ResourceType string    `json:"resource_type,omitempty" gorm:"index:idx_audit_resource"`
+	ResourceID   *uint     `json:"resource_id,omitempty" gorm:"index:idx_audit_resource"`
+	Before       string    `json:"before,omitempty"` // JSON snapshot before the action
+	After        string    `json:"after,omitempty"`  // JSON snapshot after the action
+	IP           string    `json:"ip"`
+	UserAgent    string    `json:"user_agent"`
+	Details      string    `json:"details"` // JSON object stored as string
+	CreatedAt    time.Time `json:"created_at" gorm:"index"`
+}
@@ -0,0 +1,18 @@
+package models
+
+import (
+	"time"
+)
+
+// ChecklistItem is one step of a task, such as "Collect receipts" on
+// "Prepare tax return". Items are ordered by Position within their task.
+type ChecklistItem struct {
+	ID          uint       `json:"id" gorm:"primaryKey"`
+	TaskID      uint       `json:"task_id" gorm:"index;not null"`
+	Title       string     `json:"title" gorm:"not null"`
+	Done        bool       `json:"done" gorm:"default:false"`
+	Position    int        `json:"position" gorm:"not null"`
+	CompletedAt *time.Time `json:"completed_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
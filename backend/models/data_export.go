@@ -0,0 +1,28 @@
+package models
+
+import (
+	"time"
+)
+
+// Data export states
+const (
+	ExportPending = "pending"
+	ExportRunning = "running"
+	ExportReady   = "ready"
+	ExportFailed  = "failed"
+)
+
+// DataExport is a background job that builds a zip archive of everything a
+// user owns. The archive is kept in the database until it expires.
+type DataExport struct {
+	ID          uint       `json:"id" gorm:"primaryKey"`
+	UserID      uint       `json:"user_id" gorm:"index;not null"`
+	Status      string     `json:"status" gorm:"not null"`
+	Error       string     `json:"error,omitempty"`
+	Archive     []byte     `json:"-"`
+	Size        int        `json:"size"`
+	CompletedAt *time.Time `json:"completed_at"`
+	ExpiresAt   *time.Time `json:"expires_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
@@ -0,0 +1,18 @@
+package models
+
+import (
+	"time"
+)
+
+// Invite is a single-use registration code issued by an admin. Only the
+// SHA-256 hash of the code is stored; the plain code is shown once on creation.
+type Invite struct {
+	ID          uint       `json:"id" gorm:"primaryKey"`
+	CodeHash    string     `json:"-" gorm:"uniqueIndex;not null"`
+	Email       string     `json:"email"` // Optional: restricts the invite to one address
+	CreatedByID uint       `json:"created_by_id"`
+	ExpiresAt   time.Time  `json:"expires_at"`
+	UsedAt      *time.Time `json:"used_at"`
+	UsedByID    *uint      `json:"used_by_id"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
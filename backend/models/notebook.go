@@ -7,11 +7,12 @@ import (
 type Notebook struct {
 	ID        uint      `json:"id" gorm:"primaryKey"`
 	Name      string    `json:"name" gorm:"not null"`
-	Tags      string    `json:"tags"` // JSON array stored as string
+	Tags      string    `json:"tags"` // "a, b" or a JSON array; mirrored by Tag links
 	IsPinned  bool      `json:"is_pinned" gorm:"default:false"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 	UserID    uint      `json:"user_id"`
+	Version   uint      `json:"version" gorm:"not null;default:1"` // Bumped on every write; served as the ETag
 	Pages     []Page    `json:"pages" gorm:"foreignKey:NotebookID"`
 }
 
@@ -20,8 +21,9 @@ type Page struct {
 	NotebookID uint      `json:"notebook_id"`
 	Title      string    `json:"title" gorm:"not null"`
 	Content    string    `json:"content" gorm:"type:text"` // Rich-text JSON content from TipTap
-	Tags       string    `json:"tags"`                     // JSON array stored as string
+	Tags       string    `json:"tags"`                     // "a, b" or a JSON array; mirrored by Tag links
 	IsPinned   bool      `json:"is_pinned" gorm:"default:false"`
 	CreatedAt  time.Time `json:"created_at"`
 	UpdatedAt  time.Time `json:"updated_at"`
+	Version    uint      `json:"version" gorm:"not null;default:1"` // Bumped on every write; served as the ETag
 }
@@ -0,0 +1,28 @@
+package models
+
+import (
+	"time"
+)
+
+// Notification types
+const (
+	NotificationReminder   = "reminder"
+	NotificationOverdue    = "task_overdue"
+	NotificationPaymentDue = "payment_due"
+)
+
+// Notification is an entry in a user's inbox. It stays unread until ReadAt
+// is set.
+type Notification struct {
+	ID     uint   `json:"id" gorm:"primaryKey"`
+	UserID uint   `json:"user_id" gorm:"index:idx_notifications_inbox;not null"`
+	Type   string `json:"type" gorm:"not null;uniqueIndex:idx_notifications_due"`
+	Title  string `json:"title" gorm:"not null"`
+	Body   string `json:"body"`
+	TaskID *uint  `json:"task_id" gorm:"uniqueIndex:idx_notifications_due"`
+	// DueDate is the task's due date an overdue or payment notification is
+	// about; a task gets one of each per due date. Reminders leave it unset.
+	DueDate   *time.Time `json:"due_date,omitempty" gorm:"uniqueIndex:idx_notifications_due"`
+	ReadAt    *time.Time `json:"read_at" gorm:"index:idx_notifications_inbox"`
+	CreatedAt time.Time  `json:"created_at"`
+}
@@ -0,0 +1,50 @@
+package models
+
+import (
+	"time"
+)
+
+// PersonalAccessTokenPrefix marks bearer tokens that are personal access
+// tokens rather than session JWTs.
+const PersonalAccessTokenPrefix = "tnp_"
+
+// Personal access token scopes
+const (
+	ScopeTasksRead      = "tasks:read"
+	ScopeTasksWrite     = "tasks:write"
+	ScopeNotebooksRead  = "notebooks:read"
+	ScopeNotebooksWrite = "notebooks:write"
+)
+
+// TokenScopes lists every scope a personal access token may be granted.
+var TokenScopes = []string{
+	ScopeTasksRead,
+	ScopeTasksWrite,
+	ScopeNotebooksRead,
+	ScopeNotebooksWrite,
+}
+
+// PersonalAccessToken is a long-lived, scoped credential for scripts and
+// integrations. Only the SHA-256 hash of the token is stored.
+type PersonalAccessToken struct {
+	ID         uint       `json:"id" gorm:"primaryKey"`
+	UserID     uint       `json:"user_id" gorm:"index;not null"`
+	Name       string     `json:"name" gorm:"not null"`
+	TokenHash  string     `json:"-" gorm:"uniqueIndex;not null"`
+	Hint       string     `json:"hint"` // Last characters of the token, to tell tokens apart
+	Scopes     []string   `json:"scopes" gorm:"serializer:json"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+}
+
+// HasScope reports whether the token was granted scope.
+func (t *PersonalAccessToken) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,21 @@
+package models
+
+import (
+	"time"
+)
+
+// Reminder fires a notification about a task, either a number of minutes
+// before its due date or at a fixed time. FireAt is worked out when the
+// reminder or the task's due date changes, so the scheduler only has to
+// look for rows that are due and not yet fired.
+type Reminder struct {
+	ID            uint       `json:"id" gorm:"primaryKey"`
+	UserID        uint       `json:"user_id" gorm:"index;not null"`
+	TaskID        uint       `json:"task_id" gorm:"index;not null"`
+	BeforeMinutes *int       `json:"before_minutes"`       // Relative to the task's due date
+	At            *time.Time `json:"at"`                   // A fixed time instead
+	FireAt        *time.Time `json:"fire_at" gorm:"index"` // Empty while a relative reminder's task has no due date
+	FiredAt       *time.Time `json:"fired_at"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
@@ -0,0 +1,29 @@
+package models
+
+import (
+	"time"
+)
+
+// Further display modes of a saved view, besides TaskViewKanban and
+// TaskViewMatrix
+const (
+	TaskViewList     = "list"
+	TaskViewCalendar = "calendar"
+)
+
+// SavedViewModes lists every display mode a saved view may use.
+var SavedViewModes = []string{TaskViewKanban, TaskViewMatrix, TaskViewList, TaskViewCalendar}
+
+// SavedView is a named task listing, such as "Overdue payments". The filter
+// is kept as taskquery text so it is evaluated afresh every time.
+type SavedView struct {
+	ID          uint      `json:"id" gorm:"primaryKey"`
+	UserID      uint      `json:"user_id" gorm:"index;not null"`
+	Name        string    `json:"name" gorm:"not null"`
+	Query       string    `json:"query"`                                // Search in the GET /tasks?q= language
+	Sort        string    `json:"sort"`                                 // GET /tasks sort; empty for the default
+	DisplayMode string    `json:"display_mode" gorm:"default:'kanban'"` // kanban, matrix, list, calendar
+	IsPinned    bool      `json:"is_pinned" gorm:"default:false"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
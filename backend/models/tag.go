@@ -0,0 +1,79 @@
+package models
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// Tag is one of a user's tags. Tasks, notebooks and pages keep their tags as
+// a string for clients, and the join tables below mirror that string so tags
+// can be listed, counted and renamed.
+type Tag struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	UserID    uint      `json:"user_id" gorm:"uniqueIndex:idx_tags_user_key;not null"`
+	Name      string    `json:"name" gorm:"not null"`
+	Key       string    `json:"-" gorm:"uniqueIndex:idx_tags_user_key;not null"` // TagKey(Name); tags differing in case are one tag
+	Color     string    `json:"color"`                                           // #rrggbb, or empty for the default
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// TaskTag, NotebookTag and PageTag link tags to the rows carrying them.
+type TaskTag struct {
+	TaskID uint `gorm:"primaryKey"`
+	TagID  uint `gorm:"primaryKey;index"`
+}
+
+type NotebookTag struct {
+	NotebookID uint `gorm:"primaryKey"`
+	TagID      uint `gorm:"primaryKey;index"`
+}
+
+type PageTag struct {
+	PageID uint `gorm:"primaryKey"`
+	TagID  uint `gorm:"primaryKey;index"`
+}
+
+// TagKey is the case-insensitive identity of a tag name.
+func TagKey(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+// ParseTags reads a tags string, either a JSON array or a comma-separated
+// list such as "work, home". Blank names and repeats are dropped.
+func ParseTags(tags string) []string {
+	var names []string
+	if err := json.Unmarshal([]byte(tags), &names); err != nil {
+		names = strings.Split(tags, ",")
+	}
+	return uniqueTags(names)
+}
+
+// FormatTags writes names back in the style of the tags string they came
+// from, so clients keep reading what they wrote. Repeats are dropped.
+func FormatTags(original string, names []string) string {
+	names = uniqueTags(names)
+	if strings.HasPrefix(strings.TrimSpace(original), "[") {
+		if names == nil {
+			names = []string{}
+		}
+		data, _ := json.Marshal(names)
+		return string(data)
+	}
+	return strings.Join(names, ", ")
+}
+
+func uniqueTags(names []string) []string {
+	seen := map[string]bool{}
+	var unique []string
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" || seen[TagKey(name)] {
+			continue
+		}
+		seen[TagKey(name)] = true
+		unique = append(unique, name)
+	}
+	return unique
+}
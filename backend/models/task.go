@@ -6,13 +6,22 @@ import (
 	"gorm.io/gorm"
 )
 
+// Allowed values of the task enum fields
+var (
+	TaskPriorities       = []string{"low", "medium", "high"}
+	TaskStatuses         = []string{"todo", "in-progress", "done"}
+	TaskQuadrants        = []string{"urgent-important", "not-urgent-important", "urgent-not-important", "not-urgent-not-important"}
+	TaskTypes            = []string{"kanban", "matrix", "calendar"}
+	TaskCalendarSubtypes = []string{"regular", "payment", "reminder", "event"}
+)
+
 type Task struct {
 	ID          uint       `json:"id" gorm:"primaryKey"`
 	Title       string     `json:"title" gorm:"not null"`
 	Description string     `json:"description"`
 	Priority    string     `json:"priority" gorm:"default:'medium'"` // low, medium, high
 	Status      string     `json:"status" gorm:"default:'todo'"`     // todo, in-progress, done
-	Tags        string     `json:"tags"`                             // JSON array stored as string
+	Tags        string     `json:"tags"`                             // "a, b" or a JSON array; mirrored by Tag links
 	DueDate     *time.Time `json:"due_date"`
 	IsQuickTask bool       `json:"is_quick_task" gorm:"default:false"`
 	Quadrant    string     `json:"quadrant"` // urgent-important, not-urgent-important, urgent-not-important, not-urgent-not-important
@@ -23,6 +32,7 @@ type Task struct {
 	CreatedAt   time.Time  `json:"created_at"`
 	UpdatedAt   time.Time  `json:"updated_at"`
 	UserID      uint       `json:"user_id"`
+	Version     uint       `json:"version" gorm:"not null;default:1"` // Bumped on every write; served as the ETag
 
 	// Payment tracking fields
 	IsPayment    bool       `json:"is_payment" gorm:"default:false"`
@@ -34,4 +44,25 @@ type Task struct {
 
 	// Calendar subtype: regular, payment, reminder, event
 	CalendarSubtype string `json:"calendar_subtype" gorm:"default:'regular'"`
+
+	// Recurrence is an RRULE such as "FREQ=WEEKLY;BYDAY=MO" whose first
+	// occurrence is the due date. When the task is done the rule moves on to
+	// a new task due at the next occurrence.
+	Recurrence           string `json:"recurrence"`
+	RecurrenceExceptions string `json:"recurrence_exceptions"` // Comma-separated YYYY-MM-DD dates the rule skips
+	SeriesID             *uint  `json:"series_id" gorm:"index"` // First task of the recurring series this task belongs to
+
+	// Checklist summary, kept in step with the task's ChecklistItems
+	Progress TaskProgress `json:"progress" gorm:"embedded;embeddedPrefix:checklist_"`
+
+	// Computed from TaskDependency rows when the task is served; a task is
+	// blocked while any of its blockers is not done
+	Blocked  bool          `json:"blocked" gorm:"-"`
+	Blockers []TaskBlocker `json:"blockers" gorm:"-"`
+}
+
+// TaskProgress counts a task's checklist items.
+type TaskProgress struct {
+	Done  int `json:"done" gorm:"not null;default:0"`
+	Total int `json:"total" gorm:"not null;default:0"`
 }
@@ -0,0 +1,19 @@
+package models
+
+import (
+	"time"
+)
+
+// TaskDependency records that a task cannot start until its blocker is done.
+type TaskDependency struct {
+	TaskID    uint      `json:"task_id" gorm:"primaryKey;autoIncrement:false"`
+	BlockerID uint      `json:"blocker_id" gorm:"primaryKey;autoIncrement:false;index"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// TaskBlocker is a task another task waits for, as listed in its blockers.
+type TaskBlocker struct {
+	ID     uint   `json:"id"`
+	Title  string `json:"title"`
+	Status string `json:"status"`
+}
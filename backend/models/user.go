@@ -4,11 +4,25 @@ import (
 	"time"
 )
 
+// User roles
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
 type User struct {
-	ID        uint      `json:"id" gorm:"primaryKey"`
-	Email     string    `json:"email" gorm:"unique;not null"`
-	Password  string    `json:"-" gorm:"not null"` // Password hash, hidden in JSON
-	Name      string    `json:"name"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID         uint       `json:"id" gorm:"primaryKey"`
+	Email      string     `json:"email" gorm:"unique;not null"`
+	Password   string     `json:"-" gorm:"not null"` // Password hash, hidden in JSON
+	Name       string     `json:"name"`
+	Role       string     `json:"role" gorm:"default:'user'"` // user, admin
+	DisabledAt *time.Time `json:"disabled_at"`                // Disabled users cannot sign in or use existing tokens
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+
+	// Two-factor authentication. TOTPSecret is set during enrollment and only
+	// enforced once TOTPEnabled is true; TOTPLastStep blocks code replays.
+	TOTPSecret   string `json:"-"`
+	TOTPEnabled  bool   `json:"totp_enabled" gorm:"default:false"`
+	TOTPLastStep int64  `json:"-"`
 }
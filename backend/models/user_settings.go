@@ -0,0 +1,46 @@
+package models
+
+import (
+	"time"
+)
+
+// Task views a user can open by default
+const (
+	TaskViewKanban = "kanban"
+	TaskViewMatrix = "matrix"
+)
+
+// UserSettings holds a user's preferences. The timezone and week start
+// decide what "today", "overdue" and calendar ranges mean for that user.
+type UserSettings struct {
+	ID              uint      `json:"-" gorm:"primaryKey"`
+	UserID          uint      `json:"-" gorm:"uniqueIndex;not null"`
+	Timezone        string    `json:"timezone" gorm:"default:'UTC'"` // IANA name, e.g. Europe/Berlin
+	Locale          string    `json:"locale" gorm:"default:'en-US'"`
+	WeekStart       int       `json:"week_start"` // 0 = Sunday, 1 = Monday, ... 6 = Saturday
+	DefaultCurrency string    `json:"default_currency" gorm:"default:'USD'"`
+	DefaultTaskView string    `json:"default_task_view" gorm:"default:'kanban'"` // kanban, matrix
+	CreatedAt       time.Time `json:"-"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+// DefaultUserSettings returns the settings used until a user changes them.
+func DefaultUserSettings(userID uint) UserSettings {
+	return UserSettings{
+		UserID:          userID,
+		Timezone:        "UTC",
+		Locale:          "en-US",
+		WeekStart:       int(time.Monday),
+		DefaultCurrency: "USD",
+		DefaultTaskView: TaskViewKanban,
+	}
+}
+
+// Location returns the settings' timezone, falling back to UTC.
+func (s *UserSettings) Location() *time.Location {
+	loc, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
@@ -0,0 +1,347 @@
+// Package recurrence reads and expands the subset of iCalendar recurrence
+// rules (RFC 5545 RRULE) that tasks use, such as
+//
+//	FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,WE;UNTIL=20241231
+//
+// FREQ is DAILY, WEEKLY, MONTHLY or YEARLY. INTERVAL, BYDAY, COUNT and UNTIL
+// are optional, and COUNT and UNTIL exclude each other. BYDAY lists weekdays
+// (MO to SU); with FREQ=MONTHLY a day may be numbered, so 1MO is the first
+// Monday of the month and -1FR the last Friday. Weeks start on Monday.
+//
+// Occurrences are wall-clock times in the location of the start, so a task
+// due at 09:00 stays at 09:00 across daylight saving changes. The start is
+// always the first occurrence, as with DTSTART. Months without the start's
+// day, such as February for the 30th, are skipped rather than clamped.
+package recurrence
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Frequency is the FREQ of a rule.
+type Frequency string
+
+const (
+	Daily   Frequency = "DAILY"
+	Weekly  Frequency = "WEEKLY"
+	Monthly Frequency = "MONTHLY"
+	Yearly  Frequency = "YEARLY"
+)
+
+// MaxInterval bounds INTERVAL; MaxCount bounds COUNT.
+const (
+	MaxInterval = 1000
+	MaxCount    = 1000
+)
+
+// maxPeriods bounds how many periods Iterate looks at, so a rule that
+// rarely matches cannot spin for long.
+const maxPeriods = 100000
+
+// Until layouts: a date, which includes that whole day, or a UTC time.
+const (
+	untilDateLayout = "20060102"
+	untilTimeLayout = "20060102T150405Z"
+)
+
+var weekdayCodes = []string{"SU", "MO", "TU", "WE", "TH", "FR", "SA"}
+
+// Day is a BYDAY entry. N is 0 for every such weekday, or the position of
+// the weekday within the month: 1 for the first, -1 for the last.
+type Day struct {
+	Weekday time.Weekday
+	N       int
+}
+
+func (d Day) String() string {
+	if d.N == 0 {
+		return weekdayCodes[d.Weekday]
+	}
+	return strconv.Itoa(d.N) + weekdayCodes[d.Weekday]
+}
+
+// Rule is a parsed recurrence rule.
+type Rule struct {
+	Freq     Frequency
+	Interval int // At least 1
+	ByDay    []Day
+	Count    int       // Number of occurrences, or 0 for no limit
+	Until    time.Time // Last possible occurrence, or zero for no limit
+	// UntilDate is set when UNTIL was a date. It then includes the whole
+	// day in the location of the start.
+	UntilDate bool
+}
+
+// Parse reads a rule, with or without the "RRULE:" prefix. Parts are
+// separated by semicolons and may come in any order.
+func Parse(text string) (*Rule, error) {
+	text = strings.TrimSpace(text)
+	if len(text) >= 6 && strings.EqualFold(text[:6], "RRULE:") {
+		text = text[6:]
+	}
+	if text == "" {
+		return nil, fmt.Errorf("rule is empty")
+	}
+
+	rule := &Rule{Interval: 1}
+	seen := map[string]bool{}
+	for _, part := range strings.Split(text, ";") {
+		name, value, ok := strings.Cut(part, "=")
+		name = strings.ToUpper(strings.TrimSpace(name))
+		value = strings.ToUpper(strings.TrimSpace(value))
+		if !ok || name == "" || value == "" {
+			return nil, fmt.Errorf("%q is not NAME=VALUE", part)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("%s is given twice", name)
+		}
+		seen[name] = true
+
+		switch name {
+		case "FREQ":
+			switch freq := Frequency(value); freq {
+			case Daily, Weekly, Monthly, Yearly:
+				rule.Freq = freq
+			default:
+				return nil, fmt.Errorf("FREQ must be DAILY, WEEKLY, MONTHLY or YEARLY")
+			}
+		case "INTERVAL":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 || n > MaxInterval {
+				return nil, fmt.Errorf("INTERVAL must be a number from 1 to %d", MaxInterval)
+			}
+			rule.Interval = n
+		case "COUNT":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 || n > MaxCount {
+				return nil, fmt.Errorf("COUNT must be a number from 1 to %d", MaxCount)
+			}
+			rule.Count = n
+		case "UNTIL":
+			if until, err := time.Parse(untilDateLayout, value); err == nil {
+				rule.Until, rule.UntilDate = until, true
+			} else if until, err := time.Parse(untilTimeLayout, value); err == nil {
+				rule.Until = until
+			} else {
+				return nil, fmt.Errorf("UNTIL must look like 20241231 or 20241231T170000Z")
+			}
+		case "BYDAY":
+			for _, code := range strings.Split(value, ",") {
+				day, err := parseDay(code)
+				if err != nil {
+					return nil, err
+				}
+				rule.ByDay = append(rule.ByDay, day)
+			}
+		default:
+			return nil, fmt.Errorf("%s is not supported", name)
+		}
+	}
+
+	if rule.Freq == "" {
+		return nil, fmt.Errorf("FREQ is required")
+	}
+	if rule.Count > 0 && !rule.Until.IsZero() {
+		return nil, fmt.Errorf("COUNT and UNTIL cannot be combined")
+	}
+	for _, day := range rule.ByDay {
+		if rule.Freq == Yearly {
+			return nil, fmt.Errorf("BYDAY is not supported with FREQ=YEARLY")
+		}
+		if day.N != 0 && rule.Freq != Monthly {
+			return nil, fmt.Errorf("numbered days such as %s need FREQ=MONTHLY", day)
+		}
+	}
+	return rule, nil
+}
+
+func parseDay(code string) (Day, error) {
+	code = strings.TrimSpace(code)
+	if len(code) < 2 {
+		return Day{}, fmt.Errorf("BYDAY has an invalid day %q", code)
+	}
+	prefix, name := code[:len(code)-2], code[len(code)-2:]
+	for weekday, c := range weekdayCodes {
+		if c != name {
+			continue
+		}
+		day := Day{Weekday: time.Weekday(weekday)}
+		if prefix != "" {
+			n, err := strconv.Atoi(prefix)
+			if err != nil || n == 0 || n < -5 || n > 5 {
+				return Day{}, fmt.Errorf("BYDAY has an invalid day %q", code)
+			}
+			day.N = n
+		}
+		return day, nil
+	}
+	return Day{}, fmt.Errorf("BYDAY has an invalid day %q", code)
+}
+
+// String formats the rule in its canonical form, without the prefix.
+func (r *Rule) String() string {
+	parts := []string{"FREQ=" + string(r.Freq)}
+	if r.Interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(r.Interval))
+	}
+	if len(r.ByDay) > 0 {
+		days := make([]string, len(r.ByDay))
+		for i, day := range r.ByDay {
+			days[i] = day.String()
+		}
+		parts = append(parts, "BYDAY="+strings.Join(days, ","))
+	}
+	if r.Count > 0 {
+		parts = append(parts, "COUNT="+strconv.Itoa(r.Count))
+	}
+	if !r.Until.IsZero() {
+		if r.UntilDate {
+			parts = append(parts, "UNTIL="+r.Until.Format(untilDateLayout))
+		} else {
+			parts = append(parts, "UNTIL="+r.Until.UTC().Format(untilTimeLayout))
+		}
+	}
+	return strings.Join(parts, ";")
+}
+
+// Iterate calls fn with each occurrence from start on, in order, together
+// with its index; start itself has index 0. It stops when fn returns false
+// or the rule ends.
+func (r *Rule) Iterate(start time.Time, fn func(t time.Time, n int) bool) {
+	limit := r.Until
+	if r.UntilDate {
+		y, m, d := r.Until.Date()
+		limit = time.Date(y, m, d+1, 0, 0, 0, 0, start.Location()).Add(-time.Nanosecond)
+	}
+
+	n := 0
+	emit := func(t time.Time) bool {
+		if (r.Count > 0 && n >= r.Count) || (!limit.IsZero() && t.After(limit)) {
+			return false
+		}
+		n++
+		return fn(t, n-1)
+	}
+
+	if !emit(start) {
+		return
+	}
+	for period := 0; period < maxPeriods; period++ {
+		for _, t := range r.candidates(start, period*r.Interval) {
+			if t.After(start) && !emit(t) {
+				return
+			}
+		}
+	}
+}
+
+// Between returns the occurrences in [from, to).
+func (r *Rule) Between(start, from, to time.Time) []time.Time {
+	var times []time.Time
+	r.Iterate(start, func(t time.Time, n int) bool {
+		if !t.Before(to) {
+			return false
+		}
+		if !t.Before(from) {
+			times = append(times, t)
+		}
+		return true
+	})
+	return times
+}
+
+// candidates returns the times the rule allows in the period step
+// frequency units after the one containing start, in order.
+func (r *Rule) candidates(start time.Time, step int) []time.Time {
+	y, m, d := start.Date()
+	hour, min, sec := start.Clock()
+	at := func(y int, m time.Month, d int) time.Time {
+		return time.Date(y, m, d, hour, min, sec, 0, start.Location())
+	}
+
+	switch r.Freq {
+	case Daily:
+		t := at(y, m, d+step)
+		if len(r.ByDay) > 0 && !r.onWeekday(t) {
+			return nil
+		}
+		return []time.Time{t}
+
+	case Weekly:
+		monday := d - mondayOffset(start.Weekday()) + 7*step
+		offsets := []int{mondayOffset(start.Weekday())}
+		if len(r.ByDay) > 0 {
+			offsets = offsets[:0]
+			for _, day := range r.ByDay {
+				offsets = append(offsets, mondayOffset(day.Weekday))
+			}
+			sort.Ints(offsets)
+		}
+		var times []time.Time
+		for i, offset := range offsets {
+			if i > 0 && offset == offsets[i-1] {
+				continue
+			}
+			times = append(times, at(y, m, monday+offset))
+		}
+		return times
+
+	case Monthly:
+		first := at(y, m+time.Month(step), 1)
+		if len(r.ByDay) == 0 {
+			t := at(first.Year(), first.Month(), d)
+			if t.Month() != first.Month() {
+				return nil
+			}
+			return []time.Time{t}
+		}
+		var times []time.Time
+		for t := first; t.Month() == first.Month(); t = at(t.Year(), t.Month(), t.Day()+1) {
+			if r.onMonthDay(t) {
+				times = append(times, t)
+			}
+		}
+		return times
+
+	case Yearly:
+		t := at(y+step, m, d)
+		if t.Month() != m {
+			return nil
+		}
+		return []time.Time{t}
+	}
+	return nil
+}
+
+// onWeekday reports whether t falls on one of the BYDAY weekdays.
+func (r *Rule) onWeekday(t time.Time) bool {
+	for _, day := range r.ByDay {
+		if day.Weekday == t.Weekday() {
+			return true
+		}
+	}
+	return false
+}
+
+// onMonthDay reports whether t matches a BYDAY entry, numbered entries
+// counting weekdays from the start or the end of t's month.
+func (r *Rule) onMonthDay(t time.Time) bool {
+	daysInMonth := time.Date(t.Year(), t.Month()+1, 0, 0, 0, 0, 0, time.UTC).Day()
+	fromStart := (t.Day()-1)/7 + 1
+	fromEnd := -((daysInMonth-t.Day())/7 + 1)
+	for _, day := range r.ByDay {
+		if day.Weekday == t.Weekday() && (day.N == 0 || day.N == fromStart || day.N == fromEnd) {
+			return true
+		}
+	}
+	return false
+}
+
+// mondayOffset is the number of days from Monday to weekday.
+func mondayOffset(weekday time.Weekday) int {
+	return (int(weekday) + 6) % 7
+}
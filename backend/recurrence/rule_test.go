@@ -0,0 +1,213 @@
+package recurrence
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		text string
+		want string // Canonical form
+	}{
+		{"FREQ=DAILY", "FREQ=DAILY"},
+		{"RRULE:FREQ=DAILY", "FREQ=DAILY"},
+		{"freq=weekly;byday=mo,we", "FREQ=WEEKLY;BYDAY=MO,WE"},
+		{"BYDAY=FR;FREQ=WEEKLY;INTERVAL=2", "FREQ=WEEKLY;INTERVAL=2;BYDAY=FR"},
+		{"FREQ=WEEKLY;INTERVAL=1", "FREQ=WEEKLY"},
+		{"FREQ=MONTHLY;BYDAY=1MO,-1FR", "FREQ=MONTHLY;BYDAY=1MO,-1FR"},
+		{"FREQ=MONTHLY;BYDAY=+2TU", "FREQ=MONTHLY;BYDAY=2TU"},
+		{"FREQ=YEARLY;COUNT=5", "FREQ=YEARLY;COUNT=5"},
+		{"FREQ=DAILY;UNTIL=20241231", "FREQ=DAILY;UNTIL=20241231"},
+		{"FREQ=DAILY;UNTIL=20241231T170000Z", "FREQ=DAILY;UNTIL=20241231T170000Z"},
+		{" FREQ = DAILY ; COUNT = 3 ", "FREQ=DAILY;COUNT=3"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			rule, err := Parse(tt.text)
+			if err != nil {
+				t.Fatalf("Parse(%q): %v", tt.text, err)
+			}
+			if got := rule.String(); got != tt.want {
+				t.Fatalf("Parse(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		text string
+		want string // Part of the error message
+	}{
+		{"", "empty"},
+		{"RRULE:", "empty"},
+		{"INTERVAL=2", "FREQ is required"},
+		{"FREQ=HOURLY", "FREQ must be"},
+		{"FREQ=DAILY;FREQ=WEEKLY", "given twice"},
+		{"FREQ=DAILY;", "NAME=VALUE"},
+		{"FREQ=DAILY;COUNT", "NAME=VALUE"},
+		{"FREQ=DAILY;INTERVAL=0", "INTERVAL must be"},
+		{"FREQ=DAILY;INTERVAL=x", "INTERVAL must be"},
+		{"FREQ=DAILY;COUNT=1001", "COUNT must be"},
+		{"FREQ=DAILY;UNTIL=2024-12-31", "UNTIL must"},
+		{"FREQ=DAILY;COUNT=3;UNTIL=20241231", "cannot be combined"},
+		{"FREQ=WEEKLY;BYDAY=XX", "invalid day"},
+		{"FREQ=WEEKLY;BYDAY=M", "invalid day"},
+		{"FREQ=MONTHLY;BYDAY=6MO", "invalid day"},
+		{"FREQ=MONTHLY;BYDAY=0MO", "invalid day"},
+		{"FREQ=WEEKLY;BYDAY=1MO", "need FREQ=MONTHLY"},
+		{"FREQ=YEARLY;BYDAY=MO", "not supported with FREQ=YEARLY"},
+		{"FREQ=DAILY;BYMONTH=3", "BYMONTH is not supported"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			_, err := Parse(tt.text)
+			if err == nil {
+				t.Fatalf("Parse(%q) succeeded, want an error", tt.text)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("Parse(%q) error %q, want it to mention %q", tt.text, err, tt.want)
+			}
+		})
+	}
+}
+
+// dates expands rule from start and returns up to limit occurrences as
+// dates with times.
+func dates(t *testing.T, text string, start time.Time, limit int) []string {
+	t.Helper()
+	rule, err := Parse(text)
+	if err != nil {
+		t.Fatalf("Parse(%q): %v", text, err)
+	}
+	got := []string{}
+	rule.Iterate(start, func(occurrence time.Time, n int) bool {
+		if n != len(got) {
+			t.Fatalf("occurrence %d has index %d", len(got), n)
+		}
+		got = append(got, occurrence.Format("Mon 2006-01-02 15:04"))
+		return len(got) < limit
+	})
+	return got
+}
+
+func TestIterate(t *testing.T) {
+	// Wednesday 10 January 2024, 09:30
+	start := time.Date(2024, 1, 10, 9, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		rule  string
+		start time.Time
+		want  []string
+	}{
+		{"FREQ=DAILY", start, []string{"Wed 2024-01-10 09:30", "Thu 2024-01-11 09:30", "Fri 2024-01-12 09:30"}},
+		{"FREQ=DAILY;INTERVAL=10", start, []string{"Wed 2024-01-10 09:30", "Sat 2024-01-20 09:30", "Tue 2024-01-30 09:30"}},
+		{"FREQ=DAILY;BYDAY=MO,FR", start, []string{"Wed 2024-01-10 09:30", "Fri 2024-01-12 09:30", "Mon 2024-01-15 09:30"}},
+
+		// Weekly rules repeat on the start's weekday unless BYDAY says
+		// otherwise; the start always comes first
+		{"FREQ=WEEKLY", start, []string{"Wed 2024-01-10 09:30", "Wed 2024-01-17 09:30", "Wed 2024-01-24 09:30"}},
+		{"FREQ=WEEKLY;BYDAY=MO,WE,FR", start, []string{"Wed 2024-01-10 09:30", "Fri 2024-01-12 09:30", "Mon 2024-01-15 09:30"}},
+		{"FREQ=WEEKLY;BYDAY=TU", start, []string{"Wed 2024-01-10 09:30", "Tue 2024-01-16 09:30", "Tue 2024-01-23 09:30"}},
+		{"FREQ=WEEKLY;INTERVAL=2;BYDAY=SU,MO", start, []string{"Wed 2024-01-10 09:30", "Sun 2024-01-14 09:30", "Mon 2024-01-22 09:30", "Sun 2024-01-28 09:30"}},
+
+		// Months without the start's day are skipped
+		{"FREQ=MONTHLY", time.Date(2024, 1, 31, 8, 0, 0, 0, time.UTC), []string{"Wed 2024-01-31 08:00", "Sun 2024-03-31 08:00", "Fri 2024-05-31 08:00"}},
+		{"FREQ=MONTHLY;INTERVAL=3", start, []string{"Wed 2024-01-10 09:30", "Wed 2024-04-10 09:30", "Wed 2024-07-10 09:30"}},
+		{"FREQ=MONTHLY;BYDAY=1MO", start, []string{"Wed 2024-01-10 09:30", "Mon 2024-02-05 09:30", "Mon 2024-03-04 09:30"}},
+		{"FREQ=MONTHLY;BYDAY=-1FR", start, []string{"Wed 2024-01-10 09:30", "Fri 2024-01-26 09:30", "Fri 2024-02-23 09:30"}},
+		{"FREQ=MONTHLY;BYDAY=5TH", start, []string{"Wed 2024-01-10 09:30", "Thu 2024-02-29 09:30", "Thu 2024-05-30 09:30"}},
+		{"FREQ=MONTHLY;BYDAY=SA", start, []string{"Wed 2024-01-10 09:30", "Sat 2024-01-13 09:30", "Sat 2024-01-20 09:30", "Sat 2024-01-27 09:30", "Sat 2024-02-03 09:30"}},
+
+		// Leap days only come back in leap years
+		{"FREQ=YEARLY", time.Date(2024, 2, 29, 12, 0, 0, 0, time.UTC), []string{"Thu 2024-02-29 12:00", "Tue 2028-02-29 12:00", "Sun 2032-02-29 12:00"}},
+		{"FREQ=YEARLY;INTERVAL=2", start, []string{"Wed 2024-01-10 09:30", "Sat 2026-01-10 09:30", "Mon 2028-01-10 09:30"}},
+
+		// Limits
+		{"FREQ=DAILY;COUNT=2", start, []string{"Wed 2024-01-10 09:30", "Thu 2024-01-11 09:30"}},
+		{"FREQ=WEEKLY;BYDAY=MO,TU;COUNT=3", start, []string{"Wed 2024-01-10 09:30", "Mon 2024-01-15 09:30", "Tue 2024-01-16 09:30"}},
+		{"FREQ=DAILY;UNTIL=20240112", start, []string{"Wed 2024-01-10 09:30", "Thu 2024-01-11 09:30", "Fri 2024-01-12 09:30"}},
+		{"FREQ=DAILY;UNTIL=20240112T093000Z", start, []string{"Wed 2024-01-10 09:30", "Thu 2024-01-11 09:30", "Fri 2024-01-12 09:30"}},
+		{"FREQ=DAILY;UNTIL=20240112T092959Z", start, []string{"Wed 2024-01-10 09:30", "Thu 2024-01-11 09:30"}},
+		{"FREQ=DAILY;UNTIL=20240101", start, []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.rule, func(t *testing.T) {
+			// Bounded rules must stop by themselves
+			limit := len(tt.want)
+			if strings.Contains(tt.rule, "COUNT") || strings.Contains(tt.rule, "UNTIL") {
+				limit = 10
+			}
+			got := dates(t, tt.rule, tt.start, limit)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("%s from %s = %q, want %q", tt.rule, tt.start.Format(time.RFC3339), got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIterateKeepsWallClockAcrossDST(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip("timezone data unavailable:", err)
+	}
+	// Clocks went forward on 31 March 2024
+	start := time.Date(2024, 3, 30, 9, 0, 0, 0, berlin)
+	got := dates(t, "FREQ=DAILY", start, 3)
+	want := []string{"Sat 2024-03-30 09:00", "Sun 2024-03-31 09:00", "Mon 2024-04-01 09:00"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+
+	// A date UNTIL covers the whole day where the task lives, not in UTC
+	late := time.Date(2024, 6, 1, 23, 30, 0, 0, berlin)
+	got = dates(t, "FREQ=DAILY;UNTIL=20240602", late, 5)
+	want = []string{"Sat 2024-06-01 23:30", "Sun 2024-06-02 23:30"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestBetween(t *testing.T) {
+	rule, err := Parse("FREQ=WEEKLY;BYDAY=MO,TH")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2024, 1, 1, 7, 0, 0, 0, time.UTC)
+	from := time.Date(2024, 1, 4, 7, 0, 0, 0, time.UTC)
+	to := time.Date(2024, 1, 15, 7, 0, 0, 0, time.UTC)
+
+	var got []string
+	for _, t := range rule.Between(start, from, to) {
+		got = append(got, t.Format("2006-01-02"))
+	}
+	// from is inclusive and to exclusive
+	want := []string{"2024-01-04", "2024-01-08", "2024-01-11"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Between = %q, want %q", got, want)
+	}
+}
+
+func TestIterateStopsOnRareRules(t *testing.T) {
+	rule, err := Parse("FREQ=MONTHLY")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Only seven months have a 31st, so the rule keeps finding
+	// occurrences; an unbounded caller must stop it
+	start := time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC)
+	n := 0
+	rule.Iterate(start, func(time.Time, int) bool {
+		n++
+		return n < 100
+	})
+	if n != 100 {
+		t.Fatalf("got %d occurrences, want 100", n)
+	}
+}
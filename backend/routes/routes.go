@@ -2,6 +2,7 @@ package routes
 
 import (
 	"tonish/backend/handlers"
+	"tonish/backend/middleware"
 	ws "tonish/backend/websocket"
 
 	"github.com/gofiber/fiber/v2"
@@ -18,37 +19,133 @@ func Setup(app *fiber.App) {
 	auth := api.Group("/auth")
 	auth.Post("/register", handlers.Register)
 	auth.Post("/login", handlers.Login)
+	auth.Post("/login/verify", handlers.VerifyLogin)
+	auth.Post("/refresh", handlers.Refresh)
+	auth.Post("/password/forgot", handlers.ForgotPassword)
+	auth.Post("/password/reset", handlers.ResetPassword)
+	auth.Post("/logout", middleware.AuthRequired, middleware.SessionRequired, handlers.Logout)
 	
-	// Skip authentication for local use
-	// api.Use(middleware.AuthRequired)
+	// Everything below requires a valid token
+	api.Use(middleware.AuthRequired)
 	
 	// User routes
 	api.Get("/user/me", handlers.GetCurrentUser)
+	api.Patch("/user/me", middleware.SessionRequired, handlers.UpdateCurrentUser)
+	api.Delete("/user/me", middleware.SessionRequired, handlers.DeleteAccount)
+	api.Post("/user/delete", middleware.SessionRequired, handlers.RequestAccountDeletion)
+	
+	api.Post("/user/password", middleware.SessionRequired, handlers.ChangePassword)
+	
+	// Data export routes
+	exports := api.Group("/user/exports", middleware.SessionRequired)
+	exports.Get("/", handlers.GetDataExports)
+	exports.Post("/", handlers.StartDataExport)
+	exports.Get("/:id", handlers.GetDataExport)
+	exports.Get("/:id/download", handlers.DownloadDataExport)
+
+	// Two-factor authentication routes
+	twoFactor := api.Group("/user/2fa", middleware.SessionRequired)
+	twoFactor.Post("/setup", handlers.SetupTwoFactor)
+	twoFactor.Post("/enable", handlers.EnableTwoFactor)
+	twoFactor.Post("/disable", handlers.DisableTwoFactor)
+	twoFactor.Post("/recovery-codes", handlers.RegenerateRecoveryCodes)
+	
+	// Session routes
+	sessions := api.Group("/sessions", middleware.SessionRequired)
+	sessions.Get("/", handlers.GetSessions)
+	sessions.Delete("/", handlers.RevokeOtherSessions)
+	sessions.Delete("/:id", handlers.RevokeSession)
+	
+	// Personal access token routes
+	tokens := api.Group("/tokens", middleware.SessionRequired)
+	tokens.Get("/", handlers.GetPersonalAccessTokens)
+	tokens.Post("/", handlers.CreatePersonalAccessToken)
+	tokens.Put("/:id", handlers.UpdatePersonalAccessToken)
+	tokens.Delete("/:id", handlers.DeletePersonalAccessToken)
+	
+	// Invite routes (admin only)
+	invites := api.Group("/invites", middleware.SessionRequired, middleware.AdminRequired)
+	invites.Get("/", handlers.GetInvites)
+	invites.Post("/", handlers.CreateInvite)
+	invites.Delete("/:id", handlers.DeleteInvite)
+
+	// Admin user management
+	adminUsers := api.Group("/admin/users", middleware.SessionRequired, middleware.AdminRequired)
+	adminUsers.Get("/", handlers.AdminListUsers)
+	adminUsers.Post("/", handlers.AdminCreateUser)
+	adminUsers.Patch("/:id", handlers.AdminUpdateUser)
+	adminUsers.Post("/:id/disable", handlers.AdminDisableUser)
+	adminUsers.Post("/:id/enable", handlers.AdminEnableUser)
+	adminUsers.Post("/:id/reset-password", handlers.AdminForcePasswordReset)
+	adminUsers.Delete("/:id", handlers.AdminDeleteUser)
+
+	// Audit log (admin only)
+	api.Get("/admin/audit", middleware.SessionRequired, middleware.AdminRequired, handlers.GetAuditEvents)
 	
 	// Task routes
-	tasks := api.Group("/tasks")
-	tasks.Get("/", handlers.GetAllTasks)
+	tasks := api.Group("/tasks", middleware.ScopeRequired("tasks"))
+	tasks.Get("/", handlers.ListTasks)
 	tasks.Get("/archived", handlers.GetArchivedTasks)
-	tasks.Get("/status", handlers.GetTasksByStatus)
+	tasks.Get("/today", handlers.GetTodayTasks)
+	tasks.Get("/overdue", handlers.GetOverdueTasks)
+	tasks.Get("/calendar", handlers.GetCalendarTasks)
+	tasks.Get("/occurrences", handlers.GetTaskOccurrences)
+	tasks.Get("/status", handlers.ListTasks) // Older clients; same as GET /tasks
 	tasks.Get("/quadrant/:quadrant", handlers.GetTasksByQuadrant)
 	tasks.Post("/", handlers.CreateTask)
 	tasks.Post("/:id/archive", handlers.ArchiveTask)
 	tasks.Post("/:id/restore", handlers.RestoreTask)
 	tasks.Delete("/:id/permanent", handlers.PermanentDeleteTask)
+	tasks.Get("/:id/items", handlers.GetChecklist)
+	tasks.Post("/:id/items", handlers.AddChecklistItem)
+	tasks.Put("/:id/items/order", handlers.ReorderChecklist)
+	tasks.Patch("/:id/items/:itemId", handlers.UpdateChecklistItem)
+	tasks.Delete("/:id/items/:itemId", handlers.DeleteChecklistItem)
+	tasks.Post("/:id/blockers", handlers.LinkTask)
+	tasks.Delete("/:id/blockers/:blockerId", handlers.UnlinkTask)
+	tasks.Get("/:id/reminders", handlers.GetReminders)
+	tasks.Post("/:id/reminders", handlers.CreateReminder)
+	tasks.Delete("/:id/reminders/:reminderId", handlers.DeleteReminder)
 	tasks.Get("/:id", handlers.GetTask)
 	tasks.Put("/:id", handlers.UpdateTask)
+	tasks.Patch("/:id", handlers.PatchTask)
 	tasks.Delete("/:id", handlers.DeleteTask)
 	
+	// Notification inbox routes (covered by the tasks scopes)
+	notifications := api.Group("/notifications", middleware.ScopeRequired("tasks"))
+	notifications.Get("/", handlers.GetNotifications)
+	notifications.Get("/unread-count", handlers.GetUnreadNotificationCount)
+	notifications.Post("/read-all", handlers.MarkAllNotificationsRead)
+	notifications.Post("/:id/read", handlers.MarkNotificationRead)
+	notifications.Delete("/:id", handlers.DeleteNotification)
+	
+	// Saved view routes (covered by the tasks scopes)
+	views := api.Group("/views", middleware.ScopeRequired("tasks"))
+	views.Get("/", handlers.GetSavedViews)
+	views.Post("/", handlers.CreateSavedView)
+	views.Get("/:id", handlers.GetSavedView)
+	views.Get("/:id/tasks", handlers.GetSavedViewTasks)
+	views.Put("/:id", handlers.UpdateSavedView)
+	views.Delete("/:id", handlers.DeleteSavedView)
+	
+	// Tag routes; tags span tasks, notebooks and pages, so both scopes apply
+	tags := api.Group("/tags", middleware.ScopeRequired("tasks"), middleware.ScopeRequired("notebooks"))
+	tags.Get("/", handlers.GetTags)
+	tags.Post("/", handlers.CreateTag)
+	tags.Patch("/:id", handlers.UpdateTag)
+	tags.Post("/:id/merge", handlers.MergeTag)
+	tags.Delete("/:id", handlers.DeleteTag)
+	
 	// Notebook routes
-	notebooks := api.Group("/notebooks")
+	notebooks := api.Group("/notebooks", middleware.ScopeRequired("notebooks"))
 	notebooks.Get("/", handlers.GetAllNotebooks)
 	notebooks.Get("/:id", handlers.GetNotebook)
 	notebooks.Post("/", handlers.CreateNotebook)
 	notebooks.Put("/:id", handlers.UpdateNotebook)
 	notebooks.Delete("/:id", handlers.DeleteNotebook)
 	
-	// Page routes
-	pages := api.Group("/pages")
+	// Page routes (covered by the notebooks scopes)
+	pages := api.Group("/pages", middleware.ScopeRequired("notebooks"))
 	pages.Get("/search", handlers.SearchPages)
 	pages.Get("/:id", handlers.GetPage)
 	pages.Post("/", handlers.CreatePage)
@@ -0,0 +1,126 @@
+package taskquery
+
+import (
+	"strings"
+	"time"
+
+	"tonish/backend/models"
+
+	"gorm.io/gorm"
+)
+
+// TagCondition returns a condition and its argument that match tasks
+// carrying tag, ignoring case.
+func TagCondition(tag string) (string, string) {
+	return "tasks.id IN (SELECT task_tags.task_id FROM task_tags JOIN tags ON tags.id = task_tags.tag_id WHERE tags.key = ?)",
+		models.TagKey(tag)
+}
+
+// Apply adds the query's conditions to db, which must select from tasks.
+// Relative dates are resolved against now; dates are days in loc. Every
+// value is passed as a parameter, never spliced into the SQL.
+func (q *Query) Apply(db *gorm.DB, now time.Time, loc *time.Location) *gorm.DB {
+	today := startOfDay(now, loc)
+	for _, term := range q.Terms {
+		condition, args := term.condition(today, loc)
+		if term.Negated {
+			// A NULL comparison counts as no match, so it must not
+			// exclude the row once negated
+			condition = "NOT COALESCE((" + condition + "), 0)"
+		}
+		db = db.Where(condition, args...)
+	}
+	return db
+}
+
+// condition is the SQL for one term without its negation.
+func (t *Term) condition(today time.Time, loc *time.Location) (string, []interface{}) {
+	var parts []string
+	var args []interface{}
+	add := func(sql string, values ...interface{}) {
+		parts = append(parts, sql)
+		args = append(args, values...)
+	}
+
+	switch {
+	case t.Field == "":
+		pattern := "%" + escapeLike(t.Values[0]) + "%"
+		add(`tasks.title LIKE ? ESCAPE '\' OR tasks.description LIKE ? ESCAPE '\'`, pattern, pattern)
+	case t.Field == "tag":
+		for _, tag := range t.Values {
+			sql, arg := TagCondition(tag)
+			add(sql, arg)
+		}
+	case t.Field == "is":
+		for _, v := range t.Values {
+			switch v {
+			case "payment":
+				add("tasks.is_payment = ?", true)
+			case "paid":
+				add("tasks.is_paid = ?", true)
+			case "unpaid":
+				add("tasks.is_payment = ? AND tasks.is_paid = ?", true, false)
+			case "quick":
+				add("tasks.is_quick_task = ?", true)
+			case "overdue":
+				add("tasks.due_date < ? AND tasks.status <> ?", today.UTC(), "done")
+			}
+		}
+	case t.Field == "has":
+		for _, v := range t.Values {
+			switch v {
+			case "due":
+				add("tasks.due_date IS NOT NULL")
+			case "tags":
+				add("EXISTS (SELECT 1 FROM task_tags WHERE task_tags.task_id = tasks.id)")
+			}
+		}
+	case t.isDate():
+		column := dateFields[t.Field]
+		for _, date := range t.dates {
+			if date.none {
+				add(column + " IS NULL")
+				continue
+			}
+			start := date.day(today, loc)
+			end := start.AddDate(0, 0, 1)
+			switch t.Op {
+			case "<":
+				add(column+" < ?", start.UTC())
+			case "<=":
+				add(column+" < ?", end.UTC())
+			case ">":
+				add(column+" >= ?", end.UTC())
+			case ">=":
+				add(column+" >= ?", start.UTC())
+			default:
+				add(column+" >= ? AND "+column+" < ?", start.UTC(), end.UTC())
+			}
+		}
+	default:
+		add(enumFields[t.Field].column+" IN ?", t.Values)
+	}
+
+	if len(parts) == 1 {
+		return parts[0], args
+	}
+	return "(" + strings.Join(parts, ") OR (") + ")", args
+}
+
+// day returns the start of the day the value names.
+func (d dateValue) day(today time.Time, loc *time.Location) time.Time {
+	if d.relative {
+		return today.AddDate(0, 0, d.days)
+	}
+	return time.Date(d.date.Year(), d.date.Month(), d.date.Day(), 0, 0, 0, 0, loc)
+}
+
+func startOfDay(t time.Time, loc *time.Location) time.Time {
+	t = t.In(loc)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+}
+
+// escapeLike escapes the LIKE wildcards in s for use with ESCAPE '\'.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
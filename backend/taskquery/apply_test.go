@@ -0,0 +1,201 @@
+package taskquery
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"tonish/backend/database"
+	"tonish/backend/models"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// The tests run at 10:00 on 15 June 2024, five hours behind UTC.
+var (
+	testLoc = time.FixedZone("UTC-5", -5*60*60)
+	testNow = time.Date(2024, 6, 15, 10, 0, 0, 0, testLoc)
+)
+
+// at is an hour in testLoc, stored in UTC as the API stores times.
+func at(year int, month time.Month, day, hour int) *time.Time {
+	t := time.Date(year, month, day, hour, 0, 0, 0, testLoc).UTC()
+	return &t
+}
+
+func openTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	sqlDB, _ := db.DB()
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	if err := db.AutoMigrate(&models.Task{}, &models.Tag{}, &models.TaskTag{}); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	tasks := []models.Task{
+		{Title: "Pay rent", Status: "todo", Priority: "high", Tags: "home, bills", DueDate: at(2024, 6, 15, 23),
+			IsPayment: true, TaskType: "calendar", CalendarSubtype: "payment", Quadrant: "urgent-important"},
+		{Title: "Tax return", Description: "File the 2023 return", Status: "in-progress", Priority: "high",
+			Tags: `["work","Deep Work"]`, DueDate: at(2024, 6, 10, 9), Quadrant: "not-urgent-important"},
+		{Title: "Water plants", Status: "done", Priority: "low", Tags: "home", DueDate: at(2024, 6, 14, 8),
+			CompletedAt: at(2024, 6, 14, 9), IsQuickTask: true},
+		{Title: "Read 100% of the book", Status: "todo", Priority: "medium", DueDate: at(2024, 6, 16, 0)},
+		{Title: "Gym fee", Status: "todo", Priority: "low", Tags: "health", DueDate: at(2024, 6, 22, 12),
+			IsPayment: true, IsPaid: true, PaidAt: at(2024, 6, 1, 12)},
+		{Title: "Someday_maybe", Status: "todo", Priority: "low", Tags: "someday"},
+	}
+	for i := range tasks {
+		tasks[i].UserID = 1
+		tasks[i].CreatedAt = *at(2024, 6, 1+i, 12)
+	}
+	if err := db.Create(&tasks).Error; err != nil {
+		t.Fatalf("create tasks: %v", err)
+	}
+	for _, task := range tasks {
+		if err := database.SyncTags(db, task.UserID, database.TaggedTasks, task.ID, task.Tags); err != nil {
+			t.Fatalf("sync tags: %v", err)
+		}
+	}
+	return db
+}
+
+func TestApply(t *testing.T) {
+	db := openTestDB(t)
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{"Gym fee", "Pay rent", "Read 100% of the book", "Someday_maybe", "Tax return", "Water plants"}},
+
+		// Free text
+		{"rent", []string{"Pay rent"}},
+		{"RETURN", []string{"Tax return"}},
+		{"2023", []string{"Tax return"}},
+		{`"tax return"`, []string{"Tax return"}},
+		{"100%", []string{"Read 100% of the book"}},
+		{"%", []string{"Read 100% of the book"}},
+		{"_", []string{"Someday_maybe"}},
+		{"-e", []string{}},
+		{"pay -rent", []string{}},
+		{"fee -rent", []string{"Gym fee"}},
+
+		// Enums
+		{"status:todo", []string{"Gym fee", "Pay rent", "Read 100% of the book", "Someday_maybe"}},
+		{"status:done,in-progress", []string{"Tax return", "Water plants"}},
+		{"-status:todo", []string{"Tax return", "Water plants"}},
+		{"priority:high status:todo", []string{"Pay rent"}},
+		{"type:calendar", []string{"Pay rent"}},
+		{"subtype:payment", []string{"Pay rent"}},
+		{"quadrant:not-urgent-important", []string{"Tax return"}},
+
+		// Tags, written as "a, b" or as a JSON array
+		{"tag:home", []string{"Pay rent", "Water plants"}},
+		{"tag:HOME tag:bills", []string{"Pay rent"}},
+		{"tag:work", []string{"Tax return"}},
+		{`tag:"deep work"`, []string{"Tax return"}},
+		{"tag:health,someday", []string{"Gym fee", "Someday_maybe"}},
+		{"tag:hom", []string{}},
+		{"tag:%", []string{}},
+		{"-tag:home", []string{"Gym fee", "Read 100% of the book", "Someday_maybe", "Tax return"}},
+
+		// Flags
+		{"is:payment", []string{"Gym fee", "Pay rent"}},
+		{"is:paid", []string{"Gym fee"}},
+		{"is:unpaid", []string{"Pay rent"}},
+		{"is:quick", []string{"Water plants"}},
+		{"is:overdue", []string{"Tax return"}},
+		{"is:paid,quick", []string{"Gym fee", "Water plants"}},
+		{"has:due", []string{"Gym fee", "Pay rent", "Read 100% of the book", "Tax return", "Water plants"}},
+		{"-has:due", []string{"Someday_maybe"}},
+		{"-has:tags", []string{"Read 100% of the book"}},
+
+		// Dates are days in the user's timezone; rent is due 23:00 today,
+		// after midnight UTC
+		{"due:today", []string{"Pay rent"}},
+		{"due:tomorrow", []string{"Read 100% of the book"}},
+		{"due:yesterday", []string{"Water plants"}},
+		{"due:2024-06-10", []string{"Tax return"}},
+		{"due:<today", []string{"Tax return", "Water plants"}},
+		{"due:<=today", []string{"Pay rent", "Tax return", "Water plants"}},
+		{"due:>today", []string{"Gym fee", "Read 100% of the book"}},
+		{"due:>=tomorrow due:<7d", []string{"Read 100% of the book"}},
+		{"due:<=1w", []string{"Gym fee", "Pay rent", "Read 100% of the book", "Tax return", "Water plants"}},
+		{"due:>=-5d due:<0d", []string{"Tax return", "Water plants"}},
+		{"due:none", []string{"Someday_maybe"}},
+		{"due:none,today", []string{"Pay rent", "Someday_maybe"}},
+		{"-due:today", []string{"Gym fee", "Read 100% of the book", "Someday_maybe", "Tax return", "Water plants"}},
+		{"created:2024-06-02", []string{"Tax return"}},
+		{"created:<2024-06-03", []string{"Pay rent", "Tax return"}},
+		{"completed:yesterday", []string{"Water plants"}},
+		{"completed:none status:done", []string{}},
+
+		// Everything together
+		{`status:todo,in-progress -tag:health due:<7d "t"`, []string{"Pay rent", "Read 100% of the book", "Tax return"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			query, err := Parse(tt.query)
+			if err != nil {
+				t.Fatalf("Parse(%q): %v", tt.query, err)
+			}
+			var tasks []models.Task
+			if err := query.Apply(db.Model(&models.Task{}), testNow, testLoc).Find(&tasks).Error; err != nil {
+				t.Fatalf("Apply(%q): %v", tt.query, err)
+			}
+			got := []string{}
+			for _, task := range tasks {
+				got = append(got, task.Title)
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("%q matched %q, want %q", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyKeepsExistingConditions(t *testing.T) {
+	db := openTestDB(t)
+
+	query, err := Parse("-status:done")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var count int64
+	base := db.Model(&models.Task{}).Where("tasks.priority = ?", "low")
+	if err := query.Apply(base, testNow, testLoc).Count(&count).Error; err != nil {
+		t.Fatal(err)
+	}
+	// Gym fee and Someday_maybe; Water plants is done
+	if count != 2 {
+		t.Fatalf("matched %d tasks, want 2", count)
+	}
+}
+
+func TestApplyParameterizesValues(t *testing.T) {
+	db := openTestDB(t)
+
+	query, err := Parse(`"'); DROP TABLE tasks; --"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var count int64
+	if err := query.Apply(db.Model(&models.Task{}), testNow, testLoc).Count(&count).Error; err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Fatalf("matched %d tasks, want 0", count)
+	}
+	if !db.Migrator().HasTable(&models.Task{}) {
+		t.Fatal("tasks table is gone")
+	}
+}
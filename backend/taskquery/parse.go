@@ -0,0 +1,327 @@
+// Package taskquery parses the task search language, such as
+//
+//	status:todo priority:high due:<7d tag:work -tag:someday "tax return"
+//
+// and turns it into parameterized GORM conditions on the tasks table.
+//
+// A query is a list of terms separated by spaces, all of which must match.
+// A term is field:value or a bare word searched for in the title and
+// description; a leading - negates it. Values containing spaces are quoted,
+// and field:a,b matches either value.
+package taskquery
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"tonish/backend/models"
+)
+
+// MaxLength bounds the query text; MaxTerms bounds the number of terms.
+const (
+	MaxLength = 1000
+	MaxTerms  = 50
+)
+
+// ParseError points at the token that could not be parsed. Pos is the
+// character offset of the token in the query, starting at 0.
+type ParseError struct {
+	Pos   int
+	Token string
+	Msg   string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s at position %d: %q", e.Msg, e.Pos, e.Token)
+}
+
+// Query is a parsed query. The zero value matches every task.
+type Query struct {
+	Terms []Term
+}
+
+// Term is one condition of a query.
+type Term struct {
+	Pos     int
+	Negated bool
+	Field   string   // "" for free text
+	Op      string   // Comparison for date fields: "=", "<", "<=", ">" or ">="
+	Values  []string // Alternatives, any of which may match
+
+	dates []dateValue
+}
+
+// enumFields are fields matched against one of a fixed set of values.
+var enumFields = map[string]struct {
+	column  string
+	allowed []string
+}{
+	"status":   {"tasks.status", models.TaskStatuses},
+	"priority": {"tasks.priority", models.TaskPriorities},
+	"quadrant": {"tasks.quadrant", models.TaskQuadrants},
+	"type":     {"tasks.task_type", models.TaskTypes},
+	"subtype":  {"tasks.calendar_subtype", models.TaskCalendarSubtypes},
+}
+
+// dateFields are compared with dates.
+var dateFields = map[string]string{
+	"due":       "tasks.due_date",
+	"created":   "tasks.created_at",
+	"updated":   "tasks.updated_at",
+	"completed": "tasks.completed_at",
+}
+
+// isValues and hasValues are the flags accepted by is: and has:.
+var (
+	isValues  = []string{"payment", "paid", "unpaid", "quick", "overdue"}
+	hasValues = []string{"due", "tags"}
+)
+
+// dateValue is a date in a query: a day relative to today, a calendar
+// date, or none for a missing date.
+type dateValue struct {
+	none     bool
+	relative bool
+	days     int // Offset from today when relative
+	date     time.Time
+}
+
+// Parse parses a query. An empty query matches every task.
+func Parse(text string) (*Query, error) {
+	runes := []rune(text)
+	if len(runes) > MaxLength {
+		return nil, &ParseError{Pos: MaxLength, Token: string(runes[MaxLength:]), Msg: fmt.Sprintf("query is longer than %d characters", MaxLength)}
+	}
+
+	p := &parser{runes: runes}
+	query := &Query{}
+	for {
+		p.skipSpace()
+		if p.done() {
+			return query, nil
+		}
+		if len(query.Terms) == MaxTerms {
+			return nil, p.errorFrom(p.pos, fmt.Sprintf("query has more than %d terms", MaxTerms))
+		}
+		term, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		query.Terms = append(query.Terms, term)
+	}
+}
+
+type parser struct {
+	runes []rune
+	pos   int
+}
+
+func (p *parser) done() bool {
+	return p.pos >= len(p.runes)
+}
+
+func (p *parser) skipSpace() {
+	for !p.done() && unicode.IsSpace(p.runes[p.pos]) {
+		p.pos++
+	}
+}
+
+// errorFrom reports the token starting at start.
+func (p *parser) errorFrom(start int, msg string) *ParseError {
+	end := start
+	for end < len(p.runes) && !unicode.IsSpace(p.runes[end]) {
+		end++
+	}
+	return &ParseError{Pos: start, Token: string(p.runes[start:end]), Msg: msg}
+}
+
+// word reads up to the next space or stop rune. A quoted word may contain
+// spaces.
+func (p *parser) word(stop rune) (string, error) {
+	start := p.pos
+	if !p.done() && p.runes[p.pos] == '"' {
+		p.pos++
+		for !p.done() && p.runes[p.pos] != '"' {
+			p.pos++
+		}
+		if p.done() {
+			return "", p.errorFrom(start, "unterminated quote")
+		}
+		p.pos++
+		if !p.done() && !unicode.IsSpace(p.runes[p.pos]) && p.runes[p.pos] != stop {
+			return "", p.errorFrom(start, "closing quote must end the word")
+		}
+		return string(p.runes[start+1 : p.pos-1]), nil
+	}
+	for !p.done() && !unicode.IsSpace(p.runes[p.pos]) && p.runes[p.pos] != stop {
+		if p.runes[p.pos] == '"' {
+			return "", p.errorFrom(start, "quotes must enclose the whole word")
+		}
+		p.pos++
+	}
+	return string(p.runes[start:p.pos]), nil
+}
+
+func (p *parser) term() (Term, error) {
+	start := p.pos
+	term := Term{Pos: start, Op: "="}
+	if p.runes[p.pos] == '-' {
+		term.Negated = true
+		p.pos++
+		if p.done() || unicode.IsSpace(p.runes[p.pos]) {
+			return term, p.errorFrom(start, "- must be followed by a term")
+		}
+	}
+
+	quoted := p.runes[p.pos] == '"'
+	word, err := p.word(':')
+	if err != nil {
+		return term, err
+	}
+	if quoted || p.done() || p.runes[p.pos] != ':' {
+		if word == "" {
+			return term, p.errorFrom(start, "empty search term")
+		}
+		term.Values = []string{word}
+		return term, nil
+	}
+
+	// field:value
+	if word == "" {
+		return term, p.errorFrom(start, "missing field before :")
+	}
+	term.Field = strings.ToLower(word)
+	p.pos++
+	valueStart := p.pos
+	if !p.done() && term.isDate() {
+		term.Op = p.operator()
+	}
+	value, err := p.word(0)
+	if err != nil {
+		return term, err
+	}
+	if value == "" {
+		return term, p.errorFrom(start, "missing value after "+term.Field+":")
+	}
+	for _, v := range strings.Split(value, ",") {
+		term.Values = append(term.Values, strings.TrimSpace(v))
+	}
+	return term, term.check(p, start, valueStart)
+}
+
+// operator reads an optional comparison operator.
+func (p *parser) operator() string {
+	for _, op := range []string{"<=", ">=", "<", ">", "="} {
+		end := p.pos + len(op)
+		if end <= len(p.runes) && string(p.runes[p.pos:end]) == op {
+			p.pos = end
+			return op
+		}
+	}
+	return "="
+}
+
+func (t *Term) isDate() bool {
+	_, ok := dateFields[t.Field]
+	return ok
+}
+
+// check validates the field and values of a field:value term.
+func (t *Term) check(p *parser, start, valueStart int) error {
+	for _, v := range t.Values {
+		if v == "" {
+			return p.errorFrom(valueStart, "empty value in list")
+		}
+	}
+
+	switch {
+	case t.Field == "tag":
+		return nil
+	case t.isDate():
+		for _, v := range t.Values {
+			date, ok := parseDate(v)
+			if !ok {
+				return p.errorFrom(valueStart, "invalid date; use YYYY-MM-DD, today, tomorrow, yesterday, Nd, Nw or none")
+			}
+			if date.none && t.Op != "=" {
+				return p.errorFrom(valueStart, "none cannot be compared")
+			}
+			t.dates = append(t.dates, date)
+		}
+		if len(t.Values) > 1 && t.Op != "=" {
+			return p.errorFrom(valueStart, "a comparison takes a single date")
+		}
+		return nil
+	}
+
+	allowed, ok := allowedValues(t.Field)
+	if !ok {
+		return p.errorFrom(start, "unknown field "+t.Field)
+	}
+	for i, v := range t.Values {
+		t.Values[i] = strings.ToLower(v)
+		if !contains(allowed, t.Values[i]) {
+			return p.errorFrom(valueStart, fmt.Sprintf("invalid %s; must be one of %s", t.Field, strings.Join(allowed, ", ")))
+		}
+	}
+	return nil
+}
+
+func allowedValues(field string) ([]string, bool) {
+	switch field {
+	case "is":
+		return isValues, true
+	case "has":
+		return hasValues, true
+	}
+	enum, ok := enumFields[field]
+	return enum.allowed, ok
+}
+
+// parseDate reads YYYY-MM-DD, today, tomorrow, yesterday, none, or a day
+// offset such as 7d, -3d or 2w.
+func parseDate(value string) (dateValue, bool) {
+	switch strings.ToLower(value) {
+	case "none":
+		return dateValue{none: true}, true
+	case "today":
+		return dateValue{relative: true}, true
+	case "tomorrow":
+		return dateValue{relative: true, days: 1}, true
+	case "yesterday":
+		return dateValue{relative: true, days: -1}, true
+	}
+
+	if date, err := time.Parse("2006-01-02", value); err == nil {
+		return dateValue{date: date}, true
+	}
+
+	if len(value) < 2 {
+		return dateValue{}, false
+	}
+	unit := 1
+	switch value[len(value)-1] {
+	case 'd':
+	case 'w':
+		unit = 7
+	default:
+		return dateValue{}, false
+	}
+	n, err := strconv.Atoi(value[:len(value)-1])
+	if err != nil || n > 36500 || n < -36500 {
+		return dateValue{}, false
+	}
+	return dateValue{relative: true, days: n * unit}, true
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
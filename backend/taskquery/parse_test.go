@@ -0,0 +1,172 @@
+package taskquery
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		query string
+		want  []Term
+	}{
+		{"", nil},
+		{"   ", nil},
+		{"invoice", []Term{{Pos: 0, Op: "=", Values: []string{"invoice"}}}},
+		{`"tax return"`, []Term{{Pos: 0, Op: "=", Values: []string{"tax return"}}}},
+		{`"status:done"`, []Term{{Pos: 0, Op: "=", Values: []string{"status:done"}}}},
+		{"-draft", []Term{{Pos: 0, Negated: true, Op: "=", Values: []string{"draft"}}}},
+		{"status:todo", []Term{{Pos: 0, Field: "status", Op: "=", Values: []string{"todo"}}}},
+		{"Status:TODO", []Term{{Pos: 0, Field: "status", Op: "=", Values: []string{"todo"}}}},
+		{"status:todo,in-progress", []Term{{Pos: 0, Field: "status", Op: "=", Values: []string{"todo", "in-progress"}}}},
+		{"-priority:low", []Term{{Pos: 0, Negated: true, Field: "priority", Op: "=", Values: []string{"low"}}}},
+		{"type:calendar subtype:event", []Term{
+			{Pos: 0, Field: "type", Op: "=", Values: []string{"calendar"}},
+			{Pos: 14, Field: "subtype", Op: "=", Values: []string{"event"}},
+		}},
+		{"quadrant:urgent-important", []Term{{Pos: 0, Field: "quadrant", Op: "=", Values: []string{"urgent-important"}}}},
+		{`tag:"deep work"`, []Term{{Pos: 0, Field: "tag", Op: "=", Values: []string{"deep work"}}}},
+		{"tag:Work,home", []Term{{Pos: 0, Field: "tag", Op: "=", Values: []string{"Work", "home"}}}},
+		{"is:unpaid has:due", []Term{
+			{Pos: 0, Field: "is", Op: "=", Values: []string{"unpaid"}},
+			{Pos: 10, Field: "has", Op: "=", Values: []string{"due"}},
+		}},
+		{"due:today", []Term{{Pos: 0, Field: "due", Op: "=", Values: []string{"today"}}}},
+		{"due:<7d", []Term{{Pos: 0, Field: "due", Op: "<", Values: []string{"7d"}}}},
+		{"due:<=2w", []Term{{Pos: 0, Field: "due", Op: "<=", Values: []string{"2w"}}}},
+		{"created:>=2024-01-31", []Term{{Pos: 0, Field: "created", Op: ">=", Values: []string{"2024-01-31"}}}},
+		{"completed:>-3d", []Term{{Pos: 0, Field: "completed", Op: ">", Values: []string{"-3d"}}}},
+		{"due:=tomorrow", []Term{{Pos: 0, Field: "due", Op: "=", Values: []string{"tomorrow"}}}},
+		{"due:none,today", []Term{{Pos: 0, Field: "due", Op: "=", Values: []string{"none", "today"}}}},
+		{"  report  -status:done\t", []Term{
+			{Pos: 2, Op: "=", Values: []string{"report"}},
+			{Pos: 10, Negated: true, Field: "status", Op: "=", Values: []string{"done"}},
+		}},
+		// Positions count characters, not bytes
+		{"café status:done", []Term{
+			{Pos: 0, Op: "=", Values: []string{"café"}},
+			{Pos: 5, Field: "status", Op: "=", Values: []string{"done"}},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			query, err := Parse(tt.query)
+			if err != nil {
+				t.Fatalf("Parse(%q): %v", tt.query, err)
+			}
+			for i := range query.Terms {
+				query.Terms[i].dates = nil
+			}
+			if !reflect.DeepEqual(query.Terms, tt.want) {
+				t.Fatalf("Parse(%q) = %+v, want %+v", tt.query, query.Terms, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseDates(t *testing.T) {
+	tests := []struct {
+		value string
+		want  dateValue
+	}{
+		{"none", dateValue{none: true}},
+		{"today", dateValue{relative: true}},
+		{"TOMORROW", dateValue{relative: true, days: 1}},
+		{"yesterday", dateValue{relative: true, days: -1}},
+		{"0d", dateValue{relative: true}},
+		{"7d", dateValue{relative: true, days: 7}},
+		{"-3d", dateValue{relative: true, days: -3}},
+		{"2w", dateValue{relative: true, days: 14}},
+		{"-1w", dateValue{relative: true, days: -7}},
+	}
+	for _, tt := range tests {
+		got, ok := parseDate(tt.value)
+		if !ok || got != tt.want {
+			t.Errorf("parseDate(%q) = %+v, %v; want %+v", tt.value, got, ok, tt.want)
+		}
+	}
+
+	got, ok := parseDate("2024-02-29")
+	if !ok || got.relative || got.none || got.date.Format("2006-01-02") != "2024-02-29" {
+		t.Errorf("parseDate(2024-02-29) = %+v, %v", got, ok)
+	}
+
+	for _, value := range []string{"", "d", "7", "7x", "1.5d", "+d", "2023-02-29", "24-01-01", "99999d", "next week"} {
+		if _, ok := parseDate(value); ok {
+			t.Errorf("parseDate(%q) succeeded, want failure", value)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		query string
+		pos   int
+		token string
+		msg   string
+	}{
+		{"-", 0, "-", "- must be followed by a term"},
+		{"ok - status:done", 3, "-", "- must be followed by a term"},
+		{`""`, 0, `""`, "empty search term"},
+		{":todo", 0, ":todo", "missing field before :"},
+		{"status:", 0, "status:", "missing value after status:"},
+		{"status: todo", 0, "status:", "missing value after status:"},
+		{"owner:me", 0, "owner:me", "unknown field owner"},
+		{"urgent status:later", 14, "later", "invalid status; must be one of todo, in-progress, done"},
+		{"priority:high,", 9, "high,", "empty value in list"},
+		{"status:,todo", 7, ",todo", "empty value in list"},
+		{"is:blocked", 3, "blocked", "invalid is; must be one of payment, paid, unpaid, quick, overdue"},
+		{"has:notes", 4, "notes", "invalid has; must be one of due, tags"},
+		{"due:soon", 4, "soon", "invalid date; use YYYY-MM-DD, today, tomorrow, yesterday, Nd, Nw or none"},
+		{"due:<", 0, "due:<", "missing value after due:"},
+		{"due:<none", 4, "<none", "none cannot be compared"},
+		{"due:<today,7d", 4, "<today,7d", "a comparison takes a single date"},
+		{"due:2024-13-01", 4, "2024-13-01", "invalid date; use YYYY-MM-DD, today, tomorrow, yesterday, Nd, Nw or none"},
+		{`"tax return`, 0, `"tax`, "unterminated quote"},
+		{`tag:"deep work`, 4, `"deep`, "unterminated quote"},
+		{`"tax"return`, 0, `"tax"return`, "closing quote must end the word"},
+		{`tax"return"`, 0, `tax"return"`, "quotes must enclose the whole word"},
+		{`tag:deep"work"`, 4, `deep"work"`, "quotes must enclose the whole word"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			_, err := Parse(tt.query)
+			var parseErr *ParseError
+			if !errors.As(err, &parseErr) {
+				t.Fatalf("Parse(%q) error = %v, want a *ParseError", tt.query, err)
+			}
+			if parseErr.Pos != tt.pos || parseErr.Token != tt.token || parseErr.Msg != tt.msg {
+				t.Fatalf("Parse(%q) = {%d %q %q}, want {%d %q %q}",
+					tt.query, parseErr.Pos, parseErr.Token, parseErr.Msg, tt.pos, tt.token, tt.msg)
+			}
+		})
+	}
+}
+
+func TestParseLimits(t *testing.T) {
+	long := strings.Repeat("a", MaxLength+1)
+	_, err := Parse(long)
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) || parseErr.Pos != MaxLength {
+		t.Fatalf("over-long query: %v", err)
+	}
+
+	if _, err := Parse(strings.Repeat("a ", MaxTerms)); err != nil {
+		t.Fatalf("%d terms: %v", MaxTerms, err)
+	}
+	_, err = Parse(strings.Repeat("a ", MaxTerms+1))
+	if !errors.As(err, &parseErr) || parseErr.Pos != 2*MaxTerms {
+		t.Fatalf("%d terms: %v", MaxTerms+1, err)
+	}
+}
+
+func TestParseErrorMessage(t *testing.T) {
+	err := &ParseError{Pos: 7, Token: "later", Msg: "invalid status"}
+	if got, want := err.Error(), `invalid status at position 7: "later"`; got != want {
+		t.Fatalf("Error() = %q, want %q", got, want)
+	}
+}
@@ -1,13 +1,22 @@
 package websocket
 
 import (
-	"fmt"
 	"log"
+	"strings"
+
+	"tonish/backend/apierror"
+	"tonish/backend/middleware"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/websocket/v2"
 )
 
+// tokenSubprotocol is the Sec-WebSocket-Protocol marker that precedes the
+// token when clients authenticate with "bearer, <token>". Browsers cannot set
+// an Authorization header on the handshake, so this is the header-based
+// alternative to the token query parameter.
+const tokenSubprotocol = "bearer"
+
 var GlobalHub *Hub
 
 // Initialize sets up the WebSocket hub
@@ -19,32 +28,31 @@ func Initialize() {
 
 // HandleWebSocket handles WebSocket connections
 func HandleWebSocket(c *websocket.Conn) {
-	// Get user ID from query params
-	userIDParam := c.Query("user_id")
-	var userID uint
-	if userIDParam != "" {
-		// Convert string to uint
-		var id int
-		if _, err := fmt.Sscanf(userIDParam, "%d", &id); err == nil {
-			userID = uint(id)
-		}
-	}
+	// The user was authenticated by Middleware before the upgrade
+	userID, _ := c.Locals("user_id").(uint)
+	sessionID, _ := c.Locals("session_id").(uint)
 
 	client := &Client{
-		Conn:   c,
-		UserID: userID,
-		Send:   make(chan []byte, 256),
+		Conn:      c,
+		UserID:    userID,
+		SessionID: sessionID,
+		Send:      make(chan []byte, 256),
 	}
 
-	GlobalHub.Register(client)
+	// Keep the hub the client joined, so it leaves the same one
+	hub := GlobalHub
+	hub.Register(client)
 
 	// Start goroutine to send messages
+	writerDone := make(chan struct{})
 	go func() {
+		defer close(writerDone)
 		for {
 			message, ok := <-client.Send
 			if !ok {
-				// Channel closed
+				// Channel closed by the hub; hang up so the read loop ends too
 				c.WriteMessage(websocket.CloseMessage, []byte{})
+				c.Close()
 				return
 			}
 
@@ -66,10 +74,48 @@ func HandleWebSocket(c *websocket.Conn) {
 		}
 	}
 
-	GlobalHub.Unregister(client)
+	hub.Unregister(client)
+
+	// The connection is recycled once this handler returns, so wait for the
+	// writer to stop using it
+	<-writerDone
+}
+
+// handshakeToken extracts the JWT from the "token" query parameter or from a
+// "bearer, <token>" Sec-WebSocket-Protocol header.
+func handshakeToken(c *fiber.Ctx) string {
+	if token := c.Query("token"); token != "" {
+		return token
+	}
+
+	protocols := strings.Split(c.Get("Sec-WebSocket-Protocol"), ",")
+	for i := 0; i+1 < len(protocols); i++ {
+		if strings.TrimSpace(protocols[i]) == tokenSubprotocol {
+			return strings.TrimSpace(protocols[i+1])
+		}
+	}
+	return ""
 }
 
-// Middleware upgrades HTTP to WebSocket
+// Middleware authenticates the handshake with the same JWT accepted by
+// middleware.AuthRequired and upgrades HTTP to WebSocket
 func Middleware() fiber.Handler {
-	return websocket.New(HandleWebSocket)
+	upgrade := websocket.New(HandleWebSocket, websocket.Config{
+		Subprotocols: []string{tokenSubprotocol},
+	})
+
+	return func(c *fiber.Ctx) error {
+		if !websocket.IsWebSocketUpgrade(c) {
+			return fiber.ErrUpgradeRequired
+		}
+
+		claims, err := middleware.Authenticate(handshakeToken(c))
+		if err != nil {
+			return apierror.Unauthorized("Invalid or expired token")
+		}
+
+		c.Locals("user_id", claims.UserID)
+		c.Locals("session_id", claims.SessionID)
+		return upgrade(c)
+	}
 }
@@ -16,6 +16,17 @@ const (
 	MessageTypeNotebookUpdate = "notebook_update"
 	MessageTypeNotebookCreate = "notebook_create"
 	MessageTypeNotebookDelete = "notebook_delete"
+	MessageTypeExportUpdate   = "export_update"
+	MessageTypeViewCreate     = "view_create"
+	MessageTypeViewUpdate     = "view_update"
+	MessageTypeViewDelete     = "view_delete"
+	MessageTypeTagCreate      = "tag_create"
+	MessageTypeTagUpdate      = "tag_update"
+	MessageTypeTagDelete      = "tag_delete"
+	MessageTypeChecklistUpdate = "checklist_update"
+	MessageTypeReminder        = "reminder"
+	MessageTypeNotificationCount = "notification_count"
+	MessageTypeNotification      = "notification"
 )
 
 // Message represents a WebSocket message
@@ -27,9 +38,10 @@ type Message struct {
 
 // Client represents a WebSocket client
 type Client struct {
-	Conn   *websocket.Conn
-	UserID uint
-	Send   chan []byte
+	Conn      *websocket.Conn
+	UserID    uint
+	SessionID uint
+	Send      chan []byte
 }
 
 // Hub maintains the set of active clients and broadcasts messages to the clients
@@ -71,17 +83,18 @@ func (h *Hub) Run() {
 			h.mu.Unlock()
 
 		case message := <-h.broadcast:
-			h.mu.RLock()
+			h.mu.Lock()
 			messageData, err := json.Marshal(message)
 			if err != nil {
 				log.Printf("Error marshaling message: %v", err)
-				h.mu.RUnlock()
+				h.mu.Unlock()
 				continue
 			}
 
 			for client := range h.clients {
-				// Only send to clients with the same UserID or if UserID is 0 (broadcast to all)
-				if message.UserID == 0 || client.UserID == message.UserID {
+				// Every client is authenticated, so messages only ever go to
+				// the connections of the user they belong to
+				if client.UserID == message.UserID {
 					select {
 					case client.Send <- messageData:
 					default:
@@ -90,7 +103,7 @@ func (h *Hub) Run() {
 					}
 				}
 			}
-			h.mu.RUnlock()
+			h.mu.Unlock()
 		}
 	}
 }
@@ -105,7 +118,7 @@ func (h *Hub) Unregister(client *Client) {
 	h.unregister <- client
 }
 
-// Broadcast sends a message to all connected clients
+// Broadcast sends a message to the connected clients of message.UserID
 func (h *Hub) Broadcast(message *Message) {
 	h.broadcast <- message
 }
@@ -119,3 +132,32 @@ func (h *Hub) BroadcastToUser(userID uint, messageType string, data interface{})
 	}
 	h.Broadcast(message)
 }
+
+// DisconnectSession closes every client connected with a token from the given
+// session. Closing Send makes the writer send a close frame and hang up.
+func (h *Hub) DisconnectSession(sessionID uint) {
+	h.disconnectWhere(func(client *Client) bool {
+		return client.SessionID == sessionID
+	})
+}
+
+// DisconnectUser closes every client of a user, e.g. when the account is
+// disabled or deleted.
+func (h *Hub) DisconnectUser(userID uint) {
+	h.disconnectWhere(func(client *Client) bool {
+		return client.UserID == userID
+	})
+}
+
+func (h *Hub) disconnectWhere(match func(*Client) bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for client := range h.clients {
+		if match(client) {
+			delete(h.clients, client)
+			close(client.Send)
+			log.Printf("Client disconnected by server. UserID: %d. Total clients: %d", client.UserID, len(h.clients))
+		}
+	}
+}
@@ -2,26 +2,98 @@
 const API_BASE_URL = '/api';
 
 let authToken: string | null = null;
+let refreshToken: string | null = null;
 
 if (typeof window !== 'undefined') {
 	authToken = localStorage.getItem('authToken');
+	refreshToken = localStorage.getItem('refreshToken');
 }
 
-export function setAuthToken(token: string) {
+export function setAuthToken(token: string, refresh?: string) {
 	authToken = token;
+	if (refresh) {
+		refreshToken = refresh;
+	}
 	if (typeof window !== 'undefined') {
 		localStorage.setItem('authToken', token);
+		if (refresh) {
+			localStorage.setItem('refreshToken', refresh);
+		}
 	}
 }
 
 export function clearAuthToken() {
 	authToken = null;
+	refreshToken = null;
 	if (typeof window !== 'undefined') {
 		localStorage.removeItem('authToken');
+		localStorage.removeItem('refreshToken');
+	}
+}
+
+// Access tokens are short-lived; exchange the refresh token for a new pair.
+// Concurrent callers share one in-flight refresh so the token is used once.
+let refreshInFlight: Promise<boolean> | null = null;
+
+function refreshSession(): Promise<boolean> {
+	if (!refreshToken) {
+		return Promise.resolve(false);
+	}
+	if (!refreshInFlight) {
+		refreshInFlight = fetch(`${API_BASE_URL}/auth/refresh`, {
+			method: 'POST',
+			headers: { 'Content-Type': 'application/json' },
+			body: JSON.stringify({ refresh_token: refreshToken })
+		})
+			.then(async (response) => {
+				if (!response.ok) {
+					clearAuthToken();
+					return false;
+				}
+				const data = await response.json();
+				setAuthToken(data.token, data.refresh_token);
+				return true;
+			})
+			.catch(() => false)
+			.finally(() => {
+				refreshInFlight = null;
+			});
+	}
+	return refreshInFlight;
+}
+
+// Thrown for every error response, built from the API's problem+json body.
+// fields holds per-field messages when validation failed.
+export class ApiError extends Error {
+	status: number;
+	code: string;
+	fields: Record<string, string>;
+
+	constructor(status: number, problem: any) {
+		super(problem.detail || problem.title || 'Request failed');
+		this.status = status;
+		this.code = problem.code || 'error';
+		this.fields = problem.errors || {};
+	}
+}
+
+// Thrown when an If-Match write loses to a newer version; carries the
+// server's current copy.
+export class ConflictError extends ApiError {
+	current: any;
+
+	constructor(problem: any) {
+		super(412, problem);
+		this.current = problem.current;
 	}
 }
 
-async function fetchAPI(endpoint: string, options: RequestInit = {}) {
+// If-Match header for a versioned write
+function ifMatch(version?: number): Record<string, string> {
+	return version ? { 'If-Match': `"${version}"` } : {};
+}
+
+async function fetchAPI(endpoint: string, options: RequestInit = {}, retry = true): Promise<any> {
 	const headers: Record<string, string> = {
 		'Content-Type': 'application/json',
 		...(options.headers as Record<string, string>)
@@ -38,10 +110,16 @@ async function fetchAPI(endpoint: string, options: RequestInit = {}) {
 
 	if (!response.ok) {
 		if (response.status === 401) {
+			if (retry && (await refreshSession())) {
+				return fetchAPI(endpoint, options, false);
+			}
 			throw new Error('Authorization header required');
 		}
-		const error = await response.json().catch(() => ({ error: 'Request failed' }));
-		throw new Error(error.error || 'Request failed');
+		const problem = await response.json().catch(() => ({}));
+		if (response.status === 412) {
+			throw new ConflictError(problem);
+		}
+		throw new ApiError(response.status, problem);
 	}
 
 	if (response.status === 204) {
@@ -53,7 +131,7 @@ async function fetchAPI(endpoint: string, options: RequestInit = {}) {
 
 // Auth API
 export const authAPI = {
-	register: (data: { email: string; password: string; name: string }) =>
+	register: (data: { email: string; password: string; name: string; invite_code?: string }) =>
 		fetchAPI('/auth/register', {
 			method: 'POST',
 			body: JSON.stringify(data)
@@ -65,13 +143,39 @@ export const authAPI = {
 			body: JSON.stringify(data)
 		}),
 
+	verifyLogin: (data: { challenge_token: string; code?: string; recovery_code?: string }) =>
+		fetchAPI('/auth/login/verify', {
+			method: 'POST',
+			body: JSON.stringify(data)
+		}),
+
+	logout: () =>
+		fetchAPI('/auth/logout', {
+			method: 'POST'
+		}),
+
 	getCurrentUser: () => fetchAPI('/user/me')
 };
 
+// Follows next_cursor until every task matching params is loaded
+async function fetchAllTasks(params: Record<string, string>): Promise<any[]> {
+	const tasks: any[] = [];
+	let cursor: string | null = null;
+	do {
+		const query = new URLSearchParams({ ...params, limit: '200', ...(cursor ? { cursor } : {}) });
+		const page = await fetchAPI(`/tasks?${query}`);
+		tasks.push(...page.tasks);
+		cursor = page.next_cursor;
+	} while (cursor);
+	return tasks;
+}
+
 // Task API
 export const taskAPI = {
-	getAll: () => fetchAPI('/tasks'),
-	getArchived: () => fetchAPI('/tasks/archived'),
+	// One page of tasks: { tasks, next_cursor, total }
+	list: (params: Record<string, string> = {}) => fetchAPI(`/tasks?${new URLSearchParams(params)}`),
+	getAll: () => fetchAllTasks({}),
+	getArchived: () => fetchAllTasks({ archived: 'true' }),
 	getOne: (id: number) => fetchAPI(`/tasks/${id}`),
 	create: (data: any) =>
 		fetchAPI('/tasks', {
@@ -83,6 +187,12 @@ export const taskAPI = {
 			method: 'PUT',
 			body: JSON.stringify(data)
 		}),
+	// Only the given fields are changed
+	patch: (id: number, fields: any) =>
+		fetchAPI(`/tasks/${id}`, {
+			method: 'PATCH',
+			body: JSON.stringify(fields)
+		}),
 	delete: (id: number) =>
 		fetchAPI(`/tasks/${id}`, {
 			method: 'DELETE'
@@ -99,10 +209,117 @@ export const taskAPI = {
 		fetchAPI(`/tasks/${id}/archive`, {
 			method: 'POST'
 		}),
-	getByStatus: (status: string) => fetchAPI(`/tasks/status?status=${status}`),
+	// The task waits for blockerId; a link that would close a cycle is refused
+	link: (id: number, blockerId: number) =>
+		fetchAPI(`/tasks/${id}/blockers`, {
+			method: 'POST',
+			body: JSON.stringify({ blocker_id: blockerId })
+		}),
+	unlink: (id: number, blockerId: number) =>
+		fetchAPI(`/tasks/${id}/blockers/${blockerId}`, {
+			method: 'DELETE'
+		}),
+	// Reminders fire { before_minutes } ahead of the due date or { at } a fixed time
+	getReminders: (id: number) => fetchAPI(`/tasks/${id}/reminders`),
+	addReminder: (id: number, reminder: { before_minutes?: number; at?: string }) =>
+		fetchAPI(`/tasks/${id}/reminders`, {
+			method: 'POST',
+			body: JSON.stringify(reminder)
+		}),
+	deleteReminder: (id: number, reminderId: number) =>
+		fetchAPI(`/tasks/${id}/reminders/${reminderId}`, {
+			method: 'DELETE'
+		}),
+	// Recurring tasks expanded over { month } or { start, end }
+	getOccurrences: (params: Record<string, string>) =>
+		fetchAPI(`/tasks/occurrences?${new URLSearchParams(params)}`),
+	// Edit one occurrence, it and the following ones, or the whole series
+	updateOccurrence: (id: number, occurrence: string, scope: 'this' | 'following' | 'all', fields: any) =>
+		fetchAPI(`/tasks/${id}?${new URLSearchParams({ scope, occurrence })}`, {
+			method: 'PATCH',
+			body: JSON.stringify(fields)
+		}),
+	deleteOccurrence: (id: number, occurrence: string, scope: 'this' | 'following' | 'all') =>
+		fetchAPI(`/tasks/${id}?${new URLSearchParams({ scope, occurrence })}`, {
+			method: 'DELETE'
+		}),
+	getByStatus: (status: string) => fetchAllTasks({ status }),
 	getByQuadrant: (quadrant: string) => fetchAPI(`/tasks/quadrant/${quadrant}`)
 };
 
+// Notification inbox API
+export const notificationAPI = {
+	// { notifications, total, unread }
+	list: (params: Record<string, string> = {}) => fetchAPI(`/notifications?${new URLSearchParams(params)}`),
+	unreadCount: () => fetchAPI('/notifications/unread-count'),
+	markRead: (id: number) =>
+		fetchAPI(`/notifications/${id}/read`, {
+			method: 'POST'
+		}),
+	markAllRead: () =>
+		fetchAPI('/notifications/read-all', {
+			method: 'POST'
+		}),
+	delete: (id: number) =>
+		fetchAPI(`/notifications/${id}`, {
+			method: 'DELETE'
+		})
+};
+
+// Saved view API
+export const viewAPI = {
+	getAll: () => fetchAPI('/views'),
+	getOne: (id: number) => fetchAPI(`/views/${id}`),
+	// One page of the view's tasks: { view, tasks, next_cursor, total, counts }
+	evaluate: (id: number, params: Record<string, string> = {}) =>
+		fetchAPI(`/views/${id}/tasks?${new URLSearchParams(params)}`),
+	create: (data: any) =>
+		fetchAPI('/views', {
+			method: 'POST',
+			body: JSON.stringify(data)
+		}),
+	update: (id: number, data: any) =>
+		fetchAPI(`/views/${id}`, {
+			method: 'PUT',
+			body: JSON.stringify(data)
+		}),
+	pin: (id: number, pinned: boolean) =>
+		fetchAPI(`/views/${id}`, {
+			method: 'PUT',
+			body: JSON.stringify({ is_pinned: pinned })
+		}),
+	delete: (id: number) =>
+		fetchAPI(`/views/${id}`, {
+			method: 'DELETE'
+		})
+};
+
+// Tag API
+export const tagAPI = {
+	// Tags with task_count, notebook_count and page_count
+	getAll: () => fetchAPI('/tags'),
+	create: (data: { name: string; color?: string }) =>
+		fetchAPI('/tags', {
+			method: 'POST',
+			body: JSON.stringify(data)
+		}),
+	// Rename everywhere or recolor; color '' resets it
+	update: (id: number, data: { name?: string; color?: string }) =>
+		fetchAPI(`/tags/${id}`, {
+			method: 'PATCH',
+			body: JSON.stringify(data)
+		}),
+	merge: (id: number, into: number) =>
+		fetchAPI(`/tags/${id}/merge`, {
+			method: 'POST',
+			body: JSON.stringify({ into })
+		}),
+	delete: (id: number) =>
+		fetchAPI(`/tags/${id}`, {
+			method: 'DELETE'
+		})
+};
+
 // Notebook API
 export const notebookAPI = {
 	getAll: () => fetchAPI('/notebooks'),
@@ -131,9 +348,10 @@ export const pageAPI = {
 			method: 'POST',
 			body: JSON.stringify(data)
 		}),
-	update: (id: number, data: any) =>
+	update: (id: number, data: any, version?: number) =>
 		fetchAPI(`/pages/${id}`, {
 			method: 'PUT',
+			headers: ifMatch(version),
 			body: JSON.stringify(data)
 		}),
 	delete: (id: number) =>
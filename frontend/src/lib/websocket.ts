@@ -18,16 +18,21 @@ class WebSocketService {
 	
 	public connected: Writable<boolean> = writable(false);
 
-	connect(userId?: number) {
+	connect() {
 		if (this.ws?.readyState === WebSocket.OPEN) {
 			return;
 		}
 
+		// The server authenticates the upgrade with the same JWT as the REST API
+		const token = localStorage.getItem('authToken');
+		if (!token) {
+			return;
+		}
+
 		const protocol = window.location.protocol === 'https:' ? 'wss:' : 'ws:';
 		const host = window.location.hostname;
 		const port = '50002'; // Backend port
-		const userParam = userId ? `?user_id=${userId}` : '';
-		const wsUrl = `${protocol}//${host}:${port}/ws${userParam}`;
+		const wsUrl = `${protocol}//${host}:${port}/ws?token=${encodeURIComponent(token)}`;
 
 		try {
 			this.ws = new WebSocket(wsUrl);
@@ -50,7 +55,7 @@ class WebSocketService {
 			this.ws.onclose = () => {
 				console.log('WebSocket disconnected');
 				this.connected.set(false);
-				this.scheduleReconnect(userId);
+				this.scheduleReconnect();
 			};
 
 			this.ws.onerror = (error) => {
@@ -58,11 +63,11 @@ class WebSocketService {
 			};
 		} catch (error) {
 			console.error('Error creating WebSocket:', error);
-			this.scheduleReconnect(userId);
+			this.scheduleReconnect();
 		}
 	}
 
-	private scheduleReconnect(userId?: number) {
+	private scheduleReconnect() {
 		if (this.reconnectTimeout) {
 			clearTimeout(this.reconnectTimeout);
 		}
@@ -74,7 +79,7 @@ class WebSocketService {
 		console.log(`WebSocket reconnecting in ${delay}ms (attempt ${this.reconnectAttempts})`);
 		
 		this.reconnectTimeout = setTimeout(() => {
-			this.connect(userId);
+			this.connect();
 		}, delay);
 	}
 